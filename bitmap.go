@@ -0,0 +1,468 @@
+package influxdb
+
+import "sort"
+
+// maxArrayContainerN is the largest number of values stored in an array container.
+// Containers holding more values are stored as bitmaps, which use the same 8KB
+// of memory as an array of 4096 values.
+const maxArrayContainerN = 4096
+
+// bitmapContainerWords is the number of words in a bitmap container.
+const bitmapContainerWords = (1 << 16) / 64
+
+// SeriesIDSet represents a set of series ids stored as a compressed bitmap.
+//
+// Ids are grouped into containers by their high 16 bits. Each container stores
+// the low 16 bits as a sorted array when it has few values or as a bitmap when
+// it has many, so both sparse and dense sets stay small.
+//
+// Sets returned by Union, Intersect and Reject don't share memory with their
+// inputs. A set must not be modified while it's being read.
+type SeriesIDSet struct {
+	keys       []uint16     // high bits of each container, sorted
+	containers []*container // containers, in key order
+}
+
+// NewSeriesIDSet returns a new set with the given ids.
+func NewSeriesIDSet(ids ...uint32) *SeriesIDSet {
+	s := &SeriesIDSet{}
+	for _, id := range ids {
+		s.Add(id)
+	}
+	return s
+}
+
+// Add adds an id to the set. Returns false if the id is already in the set.
+func (s *SeriesIDSet) Add(id uint32) bool {
+	key := uint16(id >> 16)
+
+	// Ids are usually added in increasing order so check the last container first.
+	i := len(s.keys)
+	if i == 0 || s.keys[i-1] != key {
+		i = sort.Search(len(s.keys), func(i int) bool { return s.keys[i] >= key })
+		if i == len(s.keys) || s.keys[i] != key {
+			s.keys = append(s.keys, 0)
+			copy(s.keys[i+1:], s.keys[i:])
+			s.keys[i] = key
+
+			s.containers = append(s.containers, nil)
+			copy(s.containers[i+1:], s.containers[i:])
+			s.containers[i] = &container{}
+		}
+	} else {
+		i--
+	}
+	return s.containers[i].add(uint16(id))
+}
+
+// Remove removes an id from the set. Returns false if the id is not in the set.
+func (s *SeriesIDSet) Remove(id uint32) bool {
+	key := uint16(id >> 16)
+	i := sort.Search(len(s.keys), func(i int) bool { return s.keys[i] >= key })
+	if i == len(s.keys) || s.keys[i] != key || !s.containers[i].remove(uint16(id)) {
+		return false
+	}
+
+	// Remove the container once it's empty.
+	if s.containers[i].n == 0 {
+		s.keys = append(s.keys[:i], s.keys[i+1:]...)
+		s.containers = append(s.containers[:i], s.containers[i+1:]...)
+	}
+	return true
+}
+
+// Contains returns true if the id is in the set.
+func (s *SeriesIDSet) Contains(id uint32) bool {
+	key := uint16(id >> 16)
+	i := sort.Search(len(s.keys), func(i int) bool { return s.keys[i] >= key })
+	return i < len(s.keys) && s.keys[i] == key && s.containers[i].contains(uint16(id))
+}
+
+// Len returns the number of ids in the set.
+func (s *SeriesIDSet) Len() int {
+	var n int
+	for _, c := range s.containers {
+		n += c.n
+	}
+	return n
+}
+
+// ForEach calls fn with each id in the set in ascending order.
+func (s *SeriesIDSet) ForEach(fn func(id uint32)) {
+	for i, c := range s.containers {
+		high := uint32(s.keys[i]) << 16
+		c.each(func(v uint16) { fn(high | uint32(v)) })
+	}
+}
+
+// Slice returns the ids in the set in sorted order.
+func (s *SeriesIDSet) Slice() SeriesIDs {
+	ids := make(SeriesIDs, 0, s.Len())
+	s.ForEach(func(id uint32) { ids = append(ids, id) })
+	return ids
+}
+
+// Equals returns true if both sets have the same ids.
+func (s *SeriesIDSet) Equals(other *SeriesIDSet) bool {
+	if len(s.keys) != len(other.keys) {
+		return false
+	}
+	for i, key := range s.keys {
+		if key != other.keys[i] || !s.containers[i].equals(other.containers[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Clone returns a copy of the set.
+func (s *SeriesIDSet) Clone() *SeriesIDSet {
+	other := &SeriesIDSet{
+		keys:       append([]uint16(nil), s.keys...),
+		containers: make([]*container, len(s.containers)),
+	}
+	for i, c := range s.containers {
+		other.containers[i] = c.clone()
+	}
+	return other
+}
+
+// Union returns a new set with the ids in either set.
+func (s *SeriesIDSet) Union(other *SeriesIDSet) *SeriesIDSet {
+	result := &SeriesIDSet{}
+	var i, j int
+	for i < len(s.keys) && j < len(other.keys) {
+		if s.keys[i] == other.keys[j] {
+			result.append(s.keys[i], unionContainers(s.containers[i], other.containers[j]))
+			i, j = i+1, j+1
+		} else if s.keys[i] < other.keys[j] {
+			result.append(s.keys[i], s.containers[i].clone())
+			i++
+		} else {
+			result.append(other.keys[j], other.containers[j].clone())
+			j++
+		}
+	}
+	for ; i < len(s.keys); i++ {
+		result.append(s.keys[i], s.containers[i].clone())
+	}
+	for ; j < len(other.keys); j++ {
+		result.append(other.keys[j], other.containers[j].clone())
+	}
+	return result
+}
+
+// Intersect returns a new set with the ids in both sets.
+func (s *SeriesIDSet) Intersect(other *SeriesIDSet) *SeriesIDSet {
+	result := &SeriesIDSet{}
+	var i, j int
+	for i < len(s.keys) && j < len(other.keys) {
+		if s.keys[i] == other.keys[j] {
+			result.append(s.keys[i], intersectContainers(s.containers[i], other.containers[j]))
+			i, j = i+1, j+1
+		} else if s.keys[i] < other.keys[j] {
+			i++
+		} else {
+			j++
+		}
+	}
+	return result
+}
+
+// Reject returns a new set with the ids in s that are not in other.
+// This is useful for the NOT operator.
+func (s *SeriesIDSet) Reject(other *SeriesIDSet) *SeriesIDSet {
+	result := &SeriesIDSet{}
+	var j int
+	for i, key := range s.keys {
+		for j < len(other.keys) && other.keys[j] < key {
+			j++
+		}
+		if j < len(other.keys) && other.keys[j] == key {
+			result.append(key, differenceContainers(s.containers[i], other.containers[j]))
+		} else {
+			result.append(key, s.containers[i].clone())
+		}
+	}
+	return result
+}
+
+// merge adds the ids from other to the set. Only the containers which
+// change are reallocated so many sets can be merged cheaply.
+func (s *SeriesIDSet) merge(other *SeriesIDSet) {
+	for j, key := range other.keys {
+		i := sort.Search(len(s.keys), func(i int) bool { return s.keys[i] >= key })
+		if i < len(s.keys) && s.keys[i] == key {
+			s.containers[i] = unionContainers(s.containers[i], other.containers[j])
+			continue
+		}
+
+		s.keys = append(s.keys, 0)
+		copy(s.keys[i+1:], s.keys[i:])
+		s.keys[i] = key
+
+		s.containers = append(s.containers, nil)
+		copy(s.containers[i+1:], s.containers[i:])
+		s.containers[i] = other.containers[j].clone()
+	}
+}
+
+// append adds a container with a key higher than all others. Empty containers are ignored.
+func (s *SeriesIDSet) append(key uint16, c *container) {
+	if c == nil || c.n == 0 {
+		return
+	}
+	s.keys = append(s.keys, key)
+	s.containers = append(s.containers, c)
+}
+
+// container represents the low 16 bits of the ids in a set that share their high bits.
+type container struct {
+	n      int      // number of values
+	array  []uint16 // sorted values, if bitmap is nil
+	bitmap []uint64 // bit for every value, if set
+}
+
+// newBitmapContainer returns a container from a bitmap.
+// The container is converted to an array if it has few values.
+// Returns nil if the bitmap is empty.
+func newBitmapContainer(bitmap []uint64) *container {
+	var n int
+	for _, w := range bitmap {
+		n += popcount(w)
+	}
+	if n == 0 {
+		return nil
+	}
+
+	c := &container{n: n, bitmap: bitmap}
+	if n <= maxArrayContainerN {
+		c.array = make([]uint16, 0, n)
+		c.each(func(v uint16) { c.array = append(c.array, v) })
+		c.bitmap = nil
+	}
+	return c
+}
+
+// add adds a value to the container. Returns false if the value already exists.
+func (c *container) add(v uint16) bool {
+	if c.bitmap != nil {
+		if c.bitmap[v/64]&(1<<(v%64)) != 0 {
+			return false
+		}
+		c.bitmap[v/64] |= 1 << (v % 64)
+		c.n++
+		return true
+	}
+
+	// Append values higher than all others without searching.
+	i := len(c.array)
+	if i > 0 && c.array[i-1] >= v {
+		i = sort.Search(len(c.array), func(i int) bool { return c.array[i] >= v })
+		if c.array[i] == v {
+			return false
+		}
+	}
+	c.array = append(c.array, 0)
+	copy(c.array[i+1:], c.array[i:])
+	c.array[i] = v
+	c.n++
+
+	// Switch to a bitmap once the array is larger than one.
+	if c.n > maxArrayContainerN {
+		c.bitmap = c.words()
+		c.array = nil
+	}
+	return true
+}
+
+// remove removes a value from the container. Returns false if the value doesn't exist.
+func (c *container) remove(v uint16) bool {
+	if c.bitmap != nil {
+		if c.bitmap[v/64]&(1<<(v%64)) == 0 {
+			return false
+		}
+		c.bitmap[v/64] &^= 1 << (v % 64)
+		c.n--
+
+		// Switch back to an array once it fits in one.
+		if c.n <= maxArrayContainerN {
+			c.array = make([]uint16, 0, c.n)
+			c.each(func(v uint16) { c.array = append(c.array, v) })
+			c.bitmap = nil
+		}
+		return true
+	}
+
+	i := sort.Search(len(c.array), func(i int) bool { return c.array[i] >= v })
+	if i == len(c.array) || c.array[i] != v {
+		return false
+	}
+	c.array = append(c.array[:i], c.array[i+1:]...)
+	c.n--
+	return true
+}
+
+// contains returns true if the value is in the container.
+func (c *container) contains(v uint16) bool {
+	if c.bitmap != nil {
+		return c.bitmap[v/64]&(1<<(v%64)) != 0
+	}
+	i := sort.Search(len(c.array), func(i int) bool { return c.array[i] >= v })
+	return i < len(c.array) && c.array[i] == v
+}
+
+// each calls fn with each value in ascending order.
+func (c *container) each(fn func(v uint16)) {
+	if c.bitmap == nil {
+		for _, v := range c.array {
+			fn(v)
+		}
+		return
+	}
+	for i, w := range c.bitmap {
+		for w != 0 {
+			t := w & -w
+			fn(uint16(i*64 + popcount(t-1)))
+			w ^= t
+		}
+	}
+}
+
+// words returns a new bitmap with the values of the container.
+func (c *container) words() []uint64 {
+	if c.bitmap != nil {
+		return append([]uint64(nil), c.bitmap...)
+	}
+	bitmap := make([]uint64, bitmapContainerWords)
+	for _, v := range c.array {
+		bitmap[v/64] |= 1 << (v % 64)
+	}
+	return bitmap
+}
+
+// clone returns a copy of the container.
+func (c *container) clone() *container {
+	other := &container{n: c.n}
+	if c.bitmap != nil {
+		other.bitmap = append([]uint64(nil), c.bitmap...)
+	} else {
+		other.array = append([]uint16(nil), c.array...)
+	}
+	return other
+}
+
+// equals returns true if both containers have the same values.
+// Containers of the same size always use the same representation.
+func (c *container) equals(other *container) bool {
+	if c.n != other.n {
+		return false
+	}
+	if c.bitmap != nil {
+		for i, w := range c.bitmap {
+			if w != other.bitmap[i] {
+				return false
+			}
+		}
+		return true
+	}
+	for i, v := range c.array {
+		if v != other.array[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// unionContainers returns a new container with the values in either container.
+func unionContainers(a, b *container) *container {
+	if a.bitmap == nil && b.bitmap == nil && a.n+b.n <= maxArrayContainerN {
+		array := make([]uint16, 0, a.n+b.n)
+		var i, j int
+		for i < len(a.array) && j < len(b.array) {
+			if a.array[i] == b.array[j] {
+				array = append(array, a.array[i])
+				i, j = i+1, j+1
+			} else if a.array[i] < b.array[j] {
+				array = append(array, a.array[i])
+				i++
+			} else {
+				array = append(array, b.array[j])
+				j++
+			}
+		}
+		array = append(array, a.array[i:]...)
+		array = append(array, b.array[j:]...)
+		return &container{n: len(array), array: array}
+	}
+
+	bitmap := a.words()
+	if b.bitmap != nil {
+		for i, w := range b.bitmap {
+			bitmap[i] |= w
+		}
+	} else {
+		for _, v := range b.array {
+			bitmap[v/64] |= 1 << (v % 64)
+		}
+	}
+	return newBitmapContainer(bitmap)
+}
+
+// intersectContainers returns a new container with the values in both containers.
+// Returns nil if there are no values in common.
+func intersectContainers(a, b *container) *container {
+	if a.bitmap != nil && b.bitmap != nil {
+		bitmap := make([]uint64, bitmapContainerWords)
+		for i, w := range a.bitmap {
+			bitmap[i] = w & b.bitmap[i]
+		}
+		return newBitmapContainer(bitmap)
+	}
+
+	// Check each value of the array against the other container.
+	if a.bitmap != nil {
+		a, b = b, a
+	}
+	array := make([]uint16, 0, a.n)
+	for _, v := range a.array {
+		if b.contains(v) {
+			array = append(array, v)
+		}
+	}
+	return &container{n: len(array), array: array}
+}
+
+// differenceContainers returns a new container with the values in a that are not in b.
+// Returns nil if there are no values left.
+func differenceContainers(a, b *container) *container {
+	if a.bitmap == nil {
+		array := make([]uint16, 0, a.n)
+		for _, v := range a.array {
+			if !b.contains(v) {
+				array = append(array, v)
+			}
+		}
+		return &container{n: len(array), array: array}
+	}
+
+	bitmap := a.words()
+	if b.bitmap != nil {
+		for i, w := range b.bitmap {
+			bitmap[i] &^= w
+		}
+	} else {
+		for _, v := range b.array {
+			bitmap[v/64] &^= 1 << (v % 64)
+		}
+	}
+	return newBitmapContainer(bitmap)
+}
+
+// popcount returns the number of bits set in x.
+func popcount(x uint64) int {
+	x -= (x >> 1) & 0x5555555555555555
+	x = (x>>2)&0x3333333333333333 + x&0x3333333333333333
+	x = (x + x>>4) & 0x0f0f0f0f0f0f0f0f
+	return int((x * 0x0101010101010101) >> 56)
+}
@@ -0,0 +1,221 @@
+package influxdb
+
+import (
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// Ensure a set can add ids and return them in sorted order.
+func TestSeriesIDSet_Add(t *testing.T) {
+	s := NewSeriesIDSet(5, 1, 70000, 3)
+	if !s.Add(2) {
+		t.Fatal("expected id to be added")
+	} else if s.Add(5) {
+		t.Fatal("expected duplicate id to be ignored")
+	}
+
+	if a := s.Slice(); !reflect.DeepEqual(a, SeriesIDs{1, 2, 3, 5, 70000}) {
+		t.Fatalf("unexpected ids: %v", a)
+	} else if n := s.Len(); n != 5 {
+		t.Fatalf("unexpected len: %d", n)
+	} else if !s.Contains(70000) || s.Contains(4) {
+		t.Fatal("unexpected contains")
+	}
+}
+
+// Ensure a container is converted to a bitmap once it grows past the array limit.
+func TestSeriesIDSet_Add_Bitmap(t *testing.T) {
+	s := NewSeriesIDSet()
+	for id := uint32(0); id < maxArrayContainerN*2; id += 2 {
+		s.Add(id)
+	}
+	if s.containers[0].bitmap != nil {
+		t.Fatal("expected array container")
+	}
+
+	s.Add(1)
+	if c := s.containers[0]; c.bitmap == nil || c.array != nil {
+		t.Fatal("expected bitmap container")
+	} else if n := s.Len(); n != maxArrayContainerN+1 {
+		t.Fatalf("unexpected len: %d", n)
+	} else if !s.Contains(1) || !s.Contains(8190) || s.Contains(3) {
+		t.Fatal("unexpected contains")
+	}
+
+	// Removing most of the ids must convert the bitmap back to an array.
+	if other := s.Reject(NewSeriesIDSet(seq(2, maxArrayContainerN*2, 2)...)); other.containers[0].bitmap != nil {
+		t.Fatal("expected array container")
+	} else if a := other.Slice(); !reflect.DeepEqual(a, SeriesIDs{0, 1}) {
+		t.Fatalf("unexpected ids: %v", a)
+	}
+}
+
+// Ensure ids can be removed from array and bitmap containers.
+func TestSeriesIDSet_Remove(t *testing.T) {
+	s := NewSeriesIDSet(seq(0, maxArrayContainerN+1, 1)...)
+	s.Add(70000)
+	if s.containers[0].bitmap == nil {
+		t.Fatal("expected bitmap container")
+	}
+
+	// Removing an id from the bitmap must convert it back to an array.
+	if !s.Remove(10) {
+		t.Fatal("expected id to be removed")
+	} else if s.Remove(10) {
+		t.Fatal("expected missing id to be ignored")
+	} else if c := s.containers[0]; c.bitmap != nil || c.n != maxArrayContainerN {
+		t.Fatalf("unexpected container: n=%d, bitmap=%v", c.n, c.bitmap != nil)
+	} else if s.Contains(10) || !s.Contains(11) {
+		t.Fatal("unexpected contains")
+	}
+
+	// Removing the last id in a container must remove the container.
+	if !s.Remove(70000) {
+		t.Fatal("expected id to be removed")
+	} else if len(s.containers) != 1 || s.Contains(70000) {
+		t.Fatalf("unexpected containers: %d", len(s.containers))
+	} else if n := s.Len(); n != maxArrayContainerN {
+		t.Fatalf("unexpected len: %d", n)
+	}
+}
+
+// Ensure the set operations return the same ids as the slice implementation.
+func TestSeriesIDSet_Operations(t *testing.T) {
+	rand.Seed(0)
+	for i, tt := range []struct {
+		n, max int
+	}{
+		{n: 0, max: 100},
+		{n: 10, max: 100},
+		{n: 1000, max: 1 << 16},
+		{n: 10000, max: 1 << 16},
+		{n: 10000, max: 1 << 20},
+		{n: 100000, max: 1 << 18},
+	} {
+		a, b := randomSeriesIDs(tt.n, tt.max), randomSeriesIDs(tt.n/2, tt.max)
+		x, y := NewSeriesIDSet(a...), NewSeriesIDSet(b...)
+
+		if exp, got := a.Union(b), x.Union(y).Slice(); !exp.Equals(got) {
+			t.Fatalf("%d. union: unexpected len: exp=%d, got=%d", i, len(exp), len(got))
+		}
+		if exp, got := a.Intersect(b), x.Intersect(y).Slice(); !exp.Equals(got) {
+			t.Fatalf("%d. intersect: unexpected len: exp=%d, got=%d", i, len(exp), len(got))
+		}
+		if exp, got := a.Reject(b), x.Reject(y).Slice(); !exp.Equals(got) {
+			t.Fatalf("%d. reject: unexpected len: exp=%d, got=%d", i, len(exp), len(got))
+		}
+
+		// Merging must not change the other set.
+		z := x.Clone()
+		z.merge(y)
+		if exp, got := a.Union(b), z.Slice(); !exp.Equals(got) {
+			t.Fatalf("%d. merge: unexpected len: exp=%d, got=%d", i, len(exp), len(got))
+		} else if !x.Equals(NewSeriesIDSet(a...)) || !y.Equals(NewSeriesIDSet(b...)) {
+			t.Fatalf("%d. merge: sets changed", i)
+		}
+		if n := z.Len(); n != len(a.Union(b)) {
+			t.Fatalf("%d. unexpected len: %d", i, n)
+		}
+	}
+}
+
+// Ensure sets are only equal if they have the same ids.
+func TestSeriesIDSet_Equals(t *testing.T) {
+	if !NewSeriesIDSet(1, 2, 70000).Equals(NewSeriesIDSet(70000, 2, 1)) {
+		t.Fatal("expected equal")
+	} else if NewSeriesIDSet(1, 2).Equals(NewSeriesIDSet(1, 3)) {
+		t.Fatal("expected not equal")
+	} else if NewSeriesIDSet(1).Equals(NewSeriesIDSet(1, 70000)) {
+		t.Fatal("expected not equal")
+	}
+}
+
+func BenchmarkSeriesIDs_Union(b *testing.B)       { benchmarkSeriesIDs(b, SeriesIDs.Union) }
+func BenchmarkSeriesIDs_Intersect(b *testing.B)   { benchmarkSeriesIDs(b, SeriesIDs.Intersect) }
+func BenchmarkSeriesIDs_Reject(b *testing.B)      { benchmarkSeriesIDs(b, SeriesIDs.Reject) }
+func BenchmarkSeriesIDSet_Union(b *testing.B)     { benchmarkSeriesIDSet(b, (*SeriesIDSet).Union) }
+func BenchmarkSeriesIDSet_Intersect(b *testing.B) { benchmarkSeriesIDSet(b, (*SeriesIDSet).Intersect) }
+func BenchmarkSeriesIDSet_Reject(b *testing.B)    { benchmarkSeriesIDSet(b, (*SeriesIDSet).Reject) }
+
+func benchmarkSeriesIDs(b *testing.B, fn func(a, b SeriesIDs) SeriesIDs) {
+	rand.Seed(0)
+	x, y := randomSeriesIDs(1000000, 4000000), randomSeriesIDs(500000, 4000000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		fn(x, y)
+	}
+}
+
+func benchmarkSeriesIDSet(b *testing.B, fn func(a, b *SeriesIDSet) *SeriesIDSet) {
+	rand.Seed(0)
+	x, y := NewSeriesIDSet(randomSeriesIDs(1000000, 4000000)...), NewSeriesIDSet(randomSeriesIDs(500000, 4000000)...)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		fn(x, y)
+	}
+}
+
+// Benchmarks the union of many small postings lists, as done by regex tag filters.
+func BenchmarkSeriesIDs_UnionPostings(b *testing.B) {
+	postings := randomPostings(1000, 1000000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ids := SeriesIDs(make([]uint32, 0))
+		for _, p := range postings {
+			ids = ids.Union(p)
+		}
+	}
+}
+
+func BenchmarkSeriesIDSet_UnionPostings(b *testing.B) {
+	var postings []*SeriesIDSet
+	for _, p := range randomPostings(1000, 1000000) {
+		postings = append(postings, NewSeriesIDSet(p...))
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ids := NewSeriesIDSet()
+		for _, p := range postings {
+			ids.merge(p)
+		}
+	}
+}
+
+// randomSeriesIDs returns a sorted list of up to n unique ids less than max.
+func randomSeriesIDs(n, max int) SeriesIDs {
+	m := make(map[uint32]struct{}, n)
+	for i := 0; i < n; i++ {
+		m[uint32(rand.Intn(max))] = struct{}{}
+	}
+	ids := make(SeriesIDs, 0, len(m))
+	for id := range m {
+		ids = append(ids, id)
+	}
+	sort.Sort(ids)
+	return ids
+}
+
+// randomPostings splits the ids up to n randomly across k postings lists.
+func randomPostings(k, n int) []SeriesIDs {
+	rand.Seed(0)
+	postings := make([]SeriesIDs, k)
+	for id := 0; id < n; id++ {
+		i := rand.Intn(k)
+		postings[i] = append(postings[i], uint32(id))
+	}
+	return postings
+}
+
+// seq returns the ids from start up to end by step.
+func seq(start, end, step uint32) (ids []uint32) {
+	for id := start; id < end; id += step {
+		ids = append(ids, id)
+	}
+	return
+}
@@ -98,6 +98,8 @@ type (
 			WriteBatchSize       int                       `toml:"write-batch-size"`
 			Engines              map[string]toml.Primitive `toml:"engines"`
 			RetentionSweepPeriod Duration                  `toml:"retention-sweep-period"`
+			SeriesIdleTimeout    Duration                  `toml:"series-idle-timeout"`
+			SeriesSweepPeriod    Duration                  `toml:"series-sweep-period"`
 		} `toml:"data"`
 
 		Cluster struct {
@@ -110,6 +112,13 @@ type (
 			WriteBufferSize           int      `toml:"write-buffer-size"`
 			ConcurrentShardQueryLimit int      `toml:"concurrent-shard-query-limit"`
 			MaxResponseBufferSize     int      `toml:"max-response-buffer-size"`
+			QueryTimeout              Duration `toml:"query-timeout"`
+			MaxSeriesPerQuery         int      `toml:"max-series-per-query"`
+			MaxBucketsPerQuery        int      `toml:"max-buckets-per-query"`
+			MaxPointsPerQuery         int      `toml:"max-points-per-query"`
+			QueryCacheSize            int      `toml:"query-cache-size"`
+			MaxSeriesPerDatabase      int      `toml:"max-series-per-database"`
+			MaxValuesPerTag           int      `toml:"max-values-per-tag"`
 		} `toml:"cluster"`
 
 		Logging struct {
@@ -125,6 +134,8 @@ func NewConfig() *Config {
 
 	c := &Config{}
 	c.Data.RetentionSweepPeriod = Duration(10 * time.Minute)
+	c.Data.SeriesIdleTimeout = Duration(24 * time.Hour)
+	c.Data.SeriesSweepPeriod = Duration(10 * time.Minute)
 	c.Cluster.ConcurrentShardQueryLimit = DefaultConcurrentShardQueryLimit
 	c.Broker.Dir = filepath.Join(u.HomeDir, ".influxdb/broker")
 	c.Broker.Port = DefaultBrokerPort
@@ -112,6 +112,10 @@ func TestParseConfig(t *testing.T) {
 
 	if c.Data.Dir != "/tmp/influxdb/development/db" {
 		t.Fatalf("data dir mismatch: %v", c.Data.Dir)
+	} else if time.Duration(c.Data.SeriesIdleTimeout) != 12*time.Hour {
+		t.Fatalf("series idle timeout mismatch: %v", c.Data.SeriesIdleTimeout)
+	} else if time.Duration(c.Data.SeriesSweepPeriod) != 5*time.Minute {
+		t.Fatalf("series sweep period mismatch: %v", c.Data.SeriesSweepPeriod)
 	}
 
 	if c.Cluster.ProtobufPort != 8099 {
@@ -126,6 +130,20 @@ func TestParseConfig(t *testing.T) {
 		t.Fatalf("max backoff mismatch: %v", c.Cluster.MaxBackoff)
 	} else if c.Cluster.MaxResponseBufferSize != 5 {
 		t.Fatalf("max response buffer size mismatch: %v", c.Cluster.MaxResponseBufferSize)
+	} else if time.Duration(c.Cluster.QueryTimeout) != 30*time.Second {
+		t.Fatalf("query timeout mismatch: %v", c.Cluster.QueryTimeout)
+	} else if c.Cluster.MaxSeriesPerQuery != 1000 {
+		t.Fatalf("max series per query mismatch: %v", c.Cluster.MaxSeriesPerQuery)
+	} else if c.Cluster.MaxBucketsPerQuery != 10000 {
+		t.Fatalf("max buckets per query mismatch: %v", c.Cluster.MaxBucketsPerQuery)
+	} else if c.Cluster.MaxPointsPerQuery != 1000000 {
+		t.Fatalf("max points per query mismatch: %v", c.Cluster.MaxPointsPerQuery)
+	} else if c.Cluster.MaxSeriesPerDatabase != 1000000 {
+		t.Fatalf("max series per database mismatch: %v", c.Cluster.MaxSeriesPerDatabase)
+	} else if c.Cluster.MaxValuesPerTag != 100000 {
+		t.Fatalf("max values per tag mismatch: %v", c.Cluster.MaxValuesPerTag)
+	} else if c.Cluster.QueryCacheSize != 10000 {
+		t.Fatalf("query cache size mismatch: %v", c.Cluster.QueryCacheSize)
 	}
 
 	// TODO: UDP Servers testing.
@@ -219,6 +237,8 @@ write-buffer-size = 10000
 
 # The server will check this often for shards that have expired and should be cleared.
 retention-sweep-period = "10m"
+series-idle-timeout = "12h"
+series-sweep-period = "5m"
 
 [cluster]
 # A comma separated list of servers to seed
@@ -258,6 +278,22 @@ max-response-buffer-size = 5
 # that you don't need to buffer in memory, but you won't get the best performance.
 concurrent-shard-query-limit = 10
 
+# The maximum duration a query can run before it is killed. "0" disables the timeout.
+query-timeout = "30s"
+
+# Limits on the resources used by a single query. "0" disables the limit.
+max-series-per-query = 1000
+max-buckets-per-query = 10000
+max-points-per-query = 1000000
+
+# Limits on the number of series in a database. New series beyond a limit are rejected.
+# All data nodes must use the same limits. "0" disables the limit.
+max-series-per-database = 1000000
+max-values-per-tag = 100000
+
+# The number of GROUP BY time buckets cached for repeated queries. "0" disables the cache.
+query-cache-size = 10000
+
 [leveldb]
 
 # Maximum mmap open files, this will affect the virtual memory used by
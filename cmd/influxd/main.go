@@ -58,6 +58,10 @@ func main() {
 	switch cmd {
 	case "join-cluster":
 		execJoinCluster(args[1:])
+	case "export-metadata":
+		execExportMetadata(args[1:])
+	case "import-metadata":
+		execImportMetadata(args[1:])
 	case "run":
 		execRun(args[1:])
 	case "":
@@ -101,6 +105,8 @@ Usage:
 
 The commands are:
 
+    export-metadata      write the metadata of a cluster as JSON
+    import-metadata      create the metadata of a cluster from JSON
     join-cluster         create a new node that will join an existing cluster
     run                  run node with existing configuration
     version              displays the InfluxDB version
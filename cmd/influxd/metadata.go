@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// DefaultMetadataHost is the default server used by the metadata commands.
+const DefaultMetadataHost = "http://localhost:8086"
+
+// execExportMetadata runs the "export-metadata" command.
+func execExportMetadata(args []string) {
+	// Parse command flags.
+	fs := flag.NewFlagSet("", flag.ExitOnError)
+	var (
+		host     = fs.String("host", DefaultMetadataHost, "")
+		username = fs.String("username", "", "")
+		password = fs.String("password", "", "")
+	)
+	fs.Usage = printExportMetadataUsage
+	fs.Parse(args)
+
+	// Retrieve the metadata from the server.
+	b, err := requestMetadata("GET", *host, *username, *password, nil)
+	if err != nil {
+		log.Fatalf("export-metadata: %s", err)
+	}
+
+	// Write one value per line so exports can be diffed.
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, b, "", "\t"); err != nil {
+		log.Fatalf("export-metadata: %s", err)
+	}
+	if _, err := buf.WriteTo(os.Stdout); err != nil {
+		log.Fatalf("export-metadata: %s", err)
+	}
+}
+
+// execImportMetadata runs the "import-metadata" command.
+func execImportMetadata(args []string) {
+	// Parse command flags.
+	fs := flag.NewFlagSet("", flag.ExitOnError)
+	var (
+		host     = fs.String("host", DefaultMetadataHost, "")
+		username = fs.String("username", "", "")
+		password = fs.String("password", "", "")
+	)
+	fs.Usage = printImportMetadataUsage
+	fs.Parse(args)
+
+	// Read the metadata from a file or from stdin.
+	var r io.Reader = os.Stdin
+	if path := fs.Arg(0); path != "" && path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			log.Fatalf("import-metadata: %s", err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	// Send the metadata to the server.
+	if _, err := requestMetadata("POST", *host, *username, *password, r); err != nil {
+		log.Fatalf("import-metadata: %s", err)
+	}
+	log.Printf("imported metadata into %s", *host)
+}
+
+// requestMetadata sends a request to the metadata endpoint of a server and
+// returns the response body. Returns an error for unsuccessful responses.
+func requestMetadata(method, host, username, password string, body io.Reader) ([]byte, error) {
+	req, err := http.NewRequest(method, strings.TrimSuffix(host, "/")+"/metadata", body)
+	if err != nil {
+		return nil, err
+	}
+	if username != "" {
+		req.SetBasicAuth(username, password)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	} else if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(b))
+	}
+	return b, nil
+}
+
+func printExportMetadataUsage() {
+	log.Printf(`usage: export-metadata [flags]
+
+export-metadata writes the metadata of a cluster to stdout as a JSON document.
+The document contains the data nodes, databases, retention policies, shards,
+measurements, series and users of the cluster. Users are exported with their
+password hashes.
+        -host <url>
+                        Set the URL of a data node. Defaults to %s.
+
+        -username <name>
+                        Set the name of an admin user if authentication is enabled.
+
+        -password <password>
+                        Set the password of the admin user.
+\n`, DefaultMetadataHost)
+}
+
+func printImportMetadataUsage() {
+	log.Printf(`usage: import-metadata [flags] [path]
+
+import-metadata creates the data nodes, databases, retention policies, shards,
+measurements, series and users from a document written by export-metadata. The
+document is read from path or from stdin. The databases and users must not
+exist in the cluster yet.
+        -host <url>
+                        Set the URL of a data node. Defaults to %s.
+
+        -username <name>
+                        Set the name of an admin user if authentication is enabled.
+
+        -password <password>
+                        Set the password of the admin user.
+\n`, DefaultMetadataHost)
+}
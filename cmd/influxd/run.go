@@ -10,9 +10,11 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/influxdb/influxdb"
 	"github.com/influxdb/influxdb/graphite"
+	"github.com/influxdb/influxdb/influxql"
 	"github.com/influxdb/influxdb/messaging"
 )
 
@@ -72,6 +74,17 @@ func execRun(args []string) {
 	var s *influxdb.Server
 	if hasServer || (initializing && (*role == "combined" || *role == "data")) {
 		s = openServer(config.Data.Dir)
+		s.QueryTimeout = time.Duration(config.Cluster.QueryTimeout)
+		s.MaxSeriesPerQuery = config.Cluster.MaxSeriesPerQuery
+		s.MaxBucketsPerQuery = config.Cluster.MaxBucketsPerQuery
+		s.MaxPointsPerQuery = config.Cluster.MaxPointsPerQuery
+		s.MaxSeriesPerDatabase = config.Cluster.MaxSeriesPerDatabase
+		s.MaxValuesPerTag = config.Cluster.MaxValuesPerTag
+		s.SeriesIdleTimeout = time.Duration(config.Data.SeriesIdleTimeout)
+		s.ConcurrentShardQueryLimit = config.Cluster.ConcurrentShardQueryLimit
+		if n := config.Cluster.QueryCacheSize; n > 0 {
+			s.QueryCache = influxql.NewResultCache(n)
+		}
 
 		// If the server is uninitialized then initialize it with the broker.
 		// Otherwise simply create a messaging client with the server id.
@@ -93,6 +106,11 @@ func execRun(args []string) {
 		}
 		log.Printf("DataNode#%d running on %s", s.ID(), config.ApiHTTPListenAddr())
 
+		// Periodically remove idle series from the index.
+		if d := time.Duration(config.Data.SeriesSweepPeriod); d > 0 && s.SeriesIdleTimeout > 0 {
+			go sweepIdleSeries(s, d)
+		}
+
 		// Spin up any Graphite servers
 		for _, c := range config.Graphites {
 			if !c.Enabled {
@@ -130,6 +148,15 @@ func execRun(args []string) {
 }
 
 // write the current process id to a file specified by path.
+// sweepIdleSeries removes idle series from the server's index every interval.
+func sweepIdleSeries(s *influxdb.Server, interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := s.SweepIdleSeries(); err != nil {
+			log.Printf("sweep idle series: %s", err)
+		}
+	}
+}
+
 func writePIDFile(path string) {
 	if path == "" {
 		return
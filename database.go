@@ -1,11 +1,21 @@
 package influxdb
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"net/http"
 	"regexp"
 	"sort"
-	"strings"
+	"sync"
 	"time"
+
+	"github.com/influxdb/influxdb/influxql"
 )
 
 // database is a collection of retention policies and shards. It also has methods
@@ -24,6 +34,15 @@ type database struct {
 	measurements map[string]*Measurement // measurement name to object and index
 	series       map[uint32]*Series      // map series id to the Series object
 	names        []string                // sorted list of the measurement names
+
+	// persistent index. if set, series are read from it instead of the in memory structures.
+	index *tagIndex
+
+	// last write time of each series since the database was loaded. points are
+	// written while holding the server's read lock so the times have their own lock.
+	writeMu    sync.Mutex
+	lastWrites map[uint32]time.Time
+	loadTime   time.Time
 }
 
 // newDatabase returns an instance of database.
@@ -34,16 +53,18 @@ func newDatabase() *database {
 		measurements: make(map[string]*Measurement),
 		series:       make(map[uint32]*Series),
 		names:        make([]string, 0),
+		lastWrites:   make(map[uint32]time.Time),
+		loadTime:     time.Now(),
 	}
 }
 
-// shardByTimestamp returns a shard that owns a given timestamp.
-func (db *database) shardByTimestamp(policy string, seriesID uint32, timestamp time.Time) (*Shard, error) {
+// shardByTimestamp returns the shard that owns a given timestamp for a series.
+func (db *database) shardByTimestamp(policy string, seriesID uint32, tags map[string]string, timestamp time.Time) (*Shard, error) {
 	p := db.policies[policy]
 	if p == nil {
 		return nil, ErrRetentionPolicyNotFound
 	}
-	return p.shardByTimestamp(seriesID, timestamp), nil
+	return p.shardByTimestamp(seriesID, tags, timestamp), nil
 }
 
 // shardsByTimestamp returns all shards that own a given timestamp.
@@ -72,6 +93,15 @@ func (db *database) MarshalJSON() ([]byte, error) {
 	for _, s := range db.shards {
 		o.Shards = append(o.Shards, s)
 	}
+	for _, m := range db.measurements {
+		o.Measurements = append(o.Measurements, &measurementJSON{
+			Name:        m.Name,
+			Fields:      m.Fields,
+			Unit:        m.Unit,
+			Description: m.Description,
+			Owner:       m.Owner,
+		})
+	}
 	return json.Marshal(&o)
 }
 
@@ -99,6 +129,22 @@ func (db *database) UnmarshalJSON(data []byte) error {
 		db.shards[s.ID] = s
 	}
 
+	// Share shard references between the policies and the database.
+	for _, rp := range db.policies {
+		for i, s := range rp.Shards {
+			if sh := db.shards[s.ID]; sh != nil {
+				rp.Shards[i] = sh
+			}
+		}
+	}
+
+	// Copy measurement fields and metadata. The series are indexed separately.
+	for _, o := range o.Measurements {
+		m := db.createMeasurementIfNotExists(o.Name)
+		m.Fields = o.Fields
+		m.Unit, m.Description, m.Owner = o.Unit, o.Description, o.Owner
+	}
+
 	return nil
 }
 
@@ -108,6 +154,16 @@ type databaseJSON struct {
 	DefaultRetentionPolicy string             `json:"defaultRetentionPolicy,omitempty"`
 	Policies               []*RetentionPolicy `json:"policies,omitempty"`
 	Shards                 []*Shard           `json:"shards,omitempty"`
+	Measurements           []*measurementJSON `json:"measurements,omitempty"`
+}
+
+// measurementJSON represents the JSON-serialization format for a measurement.
+type measurementJSON struct {
+	Name        string `json:"name,omitempty"`
+	Fields      Fields `json:"fields,omitempty"`
+	Unit        string `json:"unit,omitempty"`
+	Description string `json:"description,omitempty"`
+	Owner       string `json:"owner,omitempty"`
 }
 
 // Measurement represents a collection of time series in a database. It also contains in memory
@@ -115,26 +171,34 @@ type databaseJSON struct {
 // object. Generally these methods are only accessed from Index, which is responsible for ensuring
 // go routine safe access.
 type Measurement struct {
-	Name   string    `json:"name,omitempty"`
-	Fields []*Fields `json:"fields,omitempty"`
+	Name   string `json:"name,omitempty"`
+	Fields Fields `json:"fields,omitempty"`
+
+	// Optional metadata describing the measurement.
+	Unit        string `json:"unit,omitempty"`
+	Description string `json:"description,omitempty"`
+	Owner       string `json:"owner,omitempty"`
+
+	// persistent index of the database. if set, the in memory index fields are unused.
+	index *tagIndex
 
 	// in memory index fields
 	series              map[string]*Series // sorted tagset string to the series object
 	seriesByID          map[uint32]*Series // lookup table for series by their id
 	measurement         *Measurement
-	seriesByTagKeyValue map[string]map[string]SeriesIDs // map from tag key to value to sorted set of series ids
-	ids                 SeriesIDs                       // sorted list of series IDs in this measurement
+	seriesByTagKeyValue map[string]map[string]*SeriesIDSet // map from tag key to value to set of series ids
+	ids                 *SeriesIDSet                       // set of series IDs in this measurement
 }
 
 func NewMeasurement(name string) *Measurement {
 	return &Measurement{
 		Name:   name,
-		Fields: make([]*Fields, 0),
+		Fields: make(Fields, 0),
 
 		series:              make(map[string]*Series),
 		seriesByID:          make(map[uint32]*Series),
-		seriesByTagKeyValue: make(map[string]map[string]SeriesIDs),
-		ids:                 SeriesIDs(make([]uint32, 0)),
+		seriesByTagKeyValue: make(map[string]map[string]*SeriesIDSet),
+		ids:                 NewSeriesIDSet(),
 	}
 }
 
@@ -144,90 +208,233 @@ func (m *Measurement) addSeries(s *Series) bool {
 		return false
 	}
 	m.seriesByID[s.ID] = s
-	tagset := string(marshalTags(s.Tags))
+	tagset := string(marshalSeriesKey(m.Name, s.Tags))
 	m.series[tagset] = s
-	m.ids = append(m.ids, s.ID)
-	// the series ID should always be higher than all others because it's a new
-	// series. So don't do the sort if we don't have to.
-	if len(m.ids) > 1 && m.ids[len(m.ids)-1] < m.ids[len(m.ids)-2] {
-		sort.Sort(m.ids)
-	}
+	m.ids.Add(s.ID)
 
 	// add this series id to the tag index on the measurement
 	for k, v := range s.Tags {
 		valueMap := m.seriesByTagKeyValue[k]
 		if valueMap == nil {
-			valueMap = make(map[string]SeriesIDs)
+			valueMap = make(map[string]*SeriesIDSet)
 			m.seriesByTagKeyValue[k] = valueMap
 		}
 		ids := valueMap[v]
-		ids = append(ids, s.ID)
-
-		// most of the time the series ID will be higher than all others because it's a new
-		// series. So don't do the sort if we don't have to.
-		if len(ids) > 1 && ids[len(ids)-1] < ids[len(ids)-2] {
-			sort.Sort(ids)
+		if ids == nil {
+			ids = NewSeriesIDSet()
+			valueMap[v] = ids
 		}
-		valueMap[v] = ids
+		ids.Add(s.ID)
 	}
 
 	return true
 }
 
+// dropSeries removes a series from the measurement's in memory index.
+func (m *Measurement) dropSeries(s *Series) {
+	delete(m.seriesByID, s.ID)
+	delete(m.series, string(marshalSeriesKey(m.Name, s.Tags)))
+	m.ids.Remove(s.ID)
+
+	// remove the series id from the tag index and drop values without series
+	for k, v := range s.Tags {
+		valueMap := m.seriesByTagKeyValue[k]
+		if ids := valueMap[v]; ids != nil {
+			ids.Remove(s.ID)
+			if ids.Len() == 0 {
+				delete(valueMap, v)
+			}
+		}
+		if len(valueMap) == 0 {
+			delete(m.seriesByTagKeyValue, k)
+		}
+	}
+}
+
+// field returns a field by name. Returns nil if the field does not exist.
+func (m *Measurement) field(name string) *Field {
+	for _, f := range m.Fields {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// update sets the metadata of the measurement or of one of its fields.
+// Returns ErrFieldNotFound if the field does not exist.
+func (m *Measurement) update(u *MeasurementUpdate) error {
+	unit, description, owner := &m.Unit, &m.Description, &m.Owner
+	if u.Field != "" {
+		f := m.field(u.Field)
+		if f == nil {
+			return ErrFieldNotFound
+		}
+		unit, description, owner = &f.Unit, &f.Description, &f.Owner
+	}
+
+	if u.Unit != nil {
+		*unit = *u.Unit
+	}
+	if u.Description != nil {
+		*description = *u.Description
+	}
+	if u.Owner != nil {
+		*owner = *u.Owner
+	}
+	return nil
+}
+
+// MeasurementUpdate represents a change to the metadata of a measurement or,
+// if Field is set, of one of its fields. Nil values are left unchanged.
+type MeasurementUpdate struct {
+	Field       string  `json:"field,omitempty"`
+	Unit        *string `json:"unit,omitempty"`
+	Description *string `json:"description,omitempty"`
+	Owner       *string `json:"owner,omitempty"`
+}
+
+// fieldByID returns a field by id. Returns nil if the field does not exist.
+func (m *Measurement) fieldByID(id uint8) *Field {
+	for _, f := range m.Fields {
+		if f.ID == id {
+			return f
+		}
+	}
+	return nil
+}
+
+// createFieldIfNotExists creates a new field with an autoincrementing ID.
+// Returns an error if 255 fields have already been created on the measurement or
+// the field already exists with a different type.
+func (m *Measurement) createFieldIfNotExists(name string, typ FieldType) (*Field, error) {
+	// Ignore if the field already exists.
+	if f := m.field(name); f != nil {
+		if f.Type != typ {
+			return nil, ErrFieldTypeConflict
+		}
+		return f, nil
+	}
+
+	// Only 255 fields are allowed. If we go over that then return an error.
+	if len(m.Fields)+1 > math.MaxUint8 {
+		return nil, ErrFieldOverflow
+	}
+
+	// Create and append a new field.
+	f := &Field{
+		ID:   uint8(len(m.Fields) + 1),
+		Name: name,
+		Type: typ,
+	}
+	m.Fields = append(m.Fields, f)
+
+	return f, nil
+}
+
 // seriesByTags returns the Series that matches the given tagset.
 func (m *Measurement) seriesByTags(tags map[string]string) *Series {
-	return m.series[string(marshalTags(tags))]
+	if m.index != nil {
+		return m.index.seriesByTags(m.Name, tags)
+	}
+	return m.series[string(marshalSeriesKey(m.Name, tags))]
+}
+
+// allSeriesIDs returns the ids of all series in the measurement.
+func (m *Measurement) allSeriesIDs() *SeriesIDSet {
+	if m.index != nil {
+		return m.index.seriesIDs(m.Name)
+	}
+	return m.ids
+}
+
+// seriesIDsByTagValue returns the ids of the series with the given tag value.
+func (m *Measurement) seriesIDsByTagValue(key, value string) *SeriesIDSet {
+	if m.index != nil {
+		return m.index.seriesIDsByTagValue(m.Name, key, value)
+	}
+	if ids := m.seriesByTagKeyValue[key][value]; ids != nil {
+		return ids
+	}
+	return NewSeriesIDSet()
 }
 
 // sereisIDs returns the series ids for a given filter
-func (m *Measurement) seriesIDs(filter *TagFilter) (ids SeriesIDs) {
-	values := m.seriesByTagKeyValue[filter.Key]
-	if values == nil {
+func (m *Measurement) seriesIDs(filter *TagFilter) (ids *SeriesIDSet) {
+	ids = NewSeriesIDSet()
+	if !m.hasTagKey(filter.Key) {
 		return
 	}
 
 	// handle regex filters
 	if filter.Regex != nil {
-		for k, v := range values {
+		for k, _ := range m.tagValues(filter.Key) {
 			if filter.Regex.MatchString(k) {
-				if ids == nil {
-					ids = v
-				} else {
-					ids = ids.Union(v)
-				}
+				ids.merge(m.seriesIDsByTagValue(filter.Key, k))
 			}
 		}
 		if filter.Not {
-			ids = m.ids.Reject(ids)
+			ids = m.allSeriesIDs().Reject(ids)
 		}
 		return
 	}
 
 	// this is for the value is not null query
 	if filter.Not && filter.Value == "" {
-		for _, v := range values {
-			if ids == nil {
-				ids = v
-			} else {
-				ids.Intersect(v)
-			}
+		for k := range m.tagValues(filter.Key) {
+			ids = ids.Union(m.seriesIDsByTagValue(filter.Key, k))
 		}
 		return
 	}
 
 	// get the ids that have the given key/value tag pair
-	ids = SeriesIDs(values[filter.Value])
+	// copy them so the caller doesn't modify the index's set
+	ids = m.seriesIDsByTagValue(filter.Key, filter.Value).Clone()
 
 	// filter out these ids from the entire set if it's a not query
 	if filter.Not {
-		ids = m.ids.Reject(ids)
+		ids = m.allSeriesIDs().Reject(ids)
 	}
 
 	return
 }
 
+// hasTagKey returns true if any series in the measurement has the tag key.
+func (m *Measurement) hasTagKey(key string) bool {
+	if m.index != nil {
+		for _, k := range m.index.tagKeys(m.Name) {
+			if k == key {
+				return true
+			}
+		}
+		return false
+	}
+	return m.seriesByTagKeyValue[key] != nil
+}
+
+// tagKeys returns the tag keys of the measurement.
+func (m *Measurement) tagKeys() []string {
+	if m.index != nil {
+		return m.index.tagKeys(m.Name)
+	}
+	keys := make([]string, 0, len(m.seriesByTagKeyValue))
+	for k, _ := range m.seriesByTagKeyValue {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
 // tagValues returns a map of unique tag values for the given key
 func (m *Measurement) tagValues(key string) TagValues {
+	if m.index != nil {
+		a := m.index.tagValues(m.Name, key)
+		values := make(map[string]bool, len(a))
+		for _, v := range a {
+			values[v] = true
+		}
+		return TagValues(values)
+	}
+
 	tags := m.seriesByTagKeyValue[key]
 	values := make(map[string]bool, len(tags))
 	for k, _ := range tags {
@@ -236,6 +443,27 @@ func (m *Measurement) tagValues(key string) TagValues {
 	return TagValues(values)
 }
 
+// tagValueN returns the number of unique values of a tag key.
+func (m *Measurement) tagValueN(key string) int {
+	if m.index != nil {
+		return m.index.tagValueN(m.Name, key)
+	}
+	return len(m.seriesByTagKeyValue[key])
+}
+
+// hasTagValue returns true if any series in the measurement has the tag value.
+func (m *Measurement) hasTagValue(key, value string) bool {
+	if m.index != nil {
+		return m.index.hasTagValue(m.Name, key, value)
+	}
+	return m.seriesByTagKeyValue[key][value] != nil
+}
+
+// seriesN returns the number of series in the measurement.
+func (m *Measurement) seriesN() int {
+	return m.allSeriesIDs().Len()
+}
+
 type Measurements []*Measurement
 
 // Field represents a series field.
@@ -243,6 +471,11 @@ type Field struct {
 	ID   uint8     `json:"id,omitempty"`
 	Name string    `json:"name,omitempty"`
 	Type FieldType `json:"field"`
+
+	// Optional metadata describing the field.
+	Unit        string `json:"unit,omitempty"`
+	Description string `json:"description,omitempty"`
+	Owner       string `json:"owner,omitempty"`
 }
 
 type FieldType int
@@ -255,6 +488,35 @@ const (
 	Binary
 )
 
+// fieldTypeOf returns the field type for a given value.
+// Returns Binary for unsupported types.
+func fieldTypeOf(v interface{}) FieldType {
+	switch influxql.InspectDataType(v) {
+	case influxql.Number:
+		return Float64
+	case influxql.String:
+		return String
+	case influxql.Boolean:
+		return Boolean
+	default:
+		return Binary
+	}
+}
+
+// DataType returns the InfluxQL data type for the field type.
+func (t FieldType) DataType() influxql.DataType {
+	switch t {
+	case Int64, Float64:
+		return influxql.Number
+	case String:
+		return influxql.String
+	case Boolean:
+		return influxql.Boolean
+	default:
+		return influxql.Unknown
+	}
+}
+
 // Fields represents a list of fields.
 type Fields []*Field
 
@@ -266,6 +528,12 @@ type Series struct {
 	measurement *Measurement
 }
 
+type seriesByID []*Series
+
+func (p seriesByID) Len() int           { return len(p) }
+func (p seriesByID) Less(i, j int) bool { return p[i].ID < p[j].ID }
+func (p seriesByID) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
 // RetentionPolicy represents a policy for creating new shards in a database and how long they're kept around for.
 type RetentionPolicy struct {
 	// Unique name within database. Required.
@@ -274,9 +542,17 @@ type RetentionPolicy struct {
 	// Length of time to keep data around
 	Duration time.Duration
 
+	// Length of time covered by each group of shards. If zero then it is
+	// derived from the policy duration.
+	ShardGroupDuration time.Duration
+
 	ReplicaN uint32
 	SplitN   uint32
 
+	// Tag keys hashed to choose the split a series is stored in.
+	// If empty then series are spread over the splits by id.
+	ShardKey []string
+
 	Shards []*Shard
 }
 
@@ -290,16 +566,82 @@ func NewRetentionPolicy(name string) *RetentionPolicy {
 	}
 }
 
-// shardByTimestamp returns the shard in the space that owns a given timestamp for a given series id.
+// shardByTimestamp returns the shard in the space that owns a given timestamp for a given series.
+// A timestamp on the boundary of two time ranges belongs to the splits of the first range.
 // Returns nil if the shard does not exist.
-func (rp *RetentionPolicy) shardByTimestamp(seriesID uint32, timestamp time.Time) *Shard {
+func (rp *RetentionPolicy) shardByTimestamp(seriesID uint32, tags map[string]string, timestamp time.Time) *Shard {
 	shards := rp.shardsByTimestamp(timestamp)
 	if len(shards) > 0 {
-		return shards[int(seriesID)%len(shards)]
+		splits := rp.splits(shards[0].StartTime)
+		return splits[rp.split(seriesID, tags, len(splits))]
 	}
 	return nil
 }
 
+// shardGroupDuration returns the length of time covered by each new group of
+// shards. Policies without a shard group duration use fewer, larger shards the
+// longer they keep data. Policies of an hour or less use one shard group for
+// their whole duration.
+func (rp *RetentionPolicy) shardGroupDuration() time.Duration {
+	switch d := rp.Duration; {
+	case rp.ShardGroupDuration > 0:
+		return rp.ShardGroupDuration
+	case d == 0 || d >= 180*24*time.Hour:
+		return DefaultShardDuration
+	case d >= 2*24*time.Hour:
+		return 24 * time.Hour
+	case d > time.Hour:
+		return time.Hour
+	default:
+		return d
+	}
+}
+
+// shardGroupTimeRange returns the time range of a new group of shards for a
+// timestamp. The range is aligned to the shard group duration but is cut short
+// where it would overlap existing shards, which may have been created with a
+// different shard group duration.
+func (rp *RetentionPolicy) shardGroupTimeRange(timestamp time.Time) (start, end time.Time) {
+	d := rp.shardGroupDuration()
+	start = timestamp.Truncate(d).UTC()
+	end = start.Add(d).UTC()
+	for _, s := range rp.Shards {
+		if s.EndTime.After(start) && s.EndTime.Before(timestamp) {
+			start = s.EndTime
+		}
+		if s.StartTime.Before(end) && s.StartTime.After(timestamp) {
+			end = s.StartTime
+		}
+	}
+	return
+}
+
+// splits returns the shards that start at a given time, in creation order.
+func (rp *RetentionPolicy) splits(start time.Time) []*Shard {
+	var shards []*Shard
+	for _, s := range rp.Shards {
+		if s.StartTime.Equal(start) {
+			shards = append(shards, s)
+		}
+	}
+	return shards
+}
+
+// split returns the index of the split that stores a series out of n splits.
+// Series with the same values for the shard key tags share a split.
+func (rp *RetentionPolicy) split(seriesID uint32, tags map[string]string, n int) int {
+	if len(rp.ShardKey) == 0 {
+		return int(seriesID) % n
+	}
+
+	h := fnv.New32a()
+	for _, key := range rp.ShardKey {
+		_, _ = h.Write([]byte(tags[key]))
+		_, _ = h.Write([]byte{0})
+	}
+	return int(h.Sum32() % uint32(n))
+}
+
 func (rp *RetentionPolicy) shardsByTimestamp(timestamp time.Time) []*Shard {
 	shards := make([]*Shard, 0, rp.SplitN)
 	for _, s := range rp.Shards {
@@ -310,13 +652,43 @@ func (rp *RetentionPolicy) shardsByTimestamp(timestamp time.Time) []*Shard {
 	return shards
 }
 
+// shardsByTimeRange returns all shards that overlap a time range, sorted by start time.
+func (rp *RetentionPolicy) shardsByTimeRange(min, max time.Time) Shards {
+	var shards Shards
+	for _, s := range rp.Shards {
+		if timeBetweenInclusive(s.StartTime, min, max) || timeBetweenInclusive(min, s.StartTime, s.EndTime) {
+			shards = append(shards, s)
+		}
+	}
+	sort.Sort(shards)
+	return shards
+}
+
+// seriesShardsByTimeRange returns the shards that overlap a time range and
+// can store a series, sorted by start time. Only one split of each time range
+// can store a given series so the other splits are not returned.
+func (rp *RetentionPolicy) seriesShardsByTimeRange(s *Series, min, max time.Time) Shards {
+	var shards Shards
+	for _, sh := range rp.shardsByTimeRange(min, max) {
+		if n := len(shards); n > 0 && shards[n-1].StartTime.Equal(sh.StartTime) {
+			continue
+		}
+		splits := rp.splits(sh.StartTime)
+		shards = append(shards, splits[rp.split(s.ID, s.Tags, len(splits))])
+	}
+	return shards
+}
+
 // MarshalJSON encodes a retention policy to a JSON-encoded byte slice.
 func (rp *RetentionPolicy) MarshalJSON() ([]byte, error) {
 	return json.Marshal(&retentionPolicyJSON{
-		Name:     rp.Name,
-		Duration: rp.Duration,
-		ReplicaN: rp.ReplicaN,
-		SplitN:   rp.SplitN,
+		Name:               rp.Name,
+		Duration:           rp.Duration,
+		ShardGroupDuration: rp.ShardGroupDuration,
+		ReplicaN:           rp.ReplicaN,
+		SplitN:             rp.SplitN,
+		ShardKey:           rp.ShardKey,
+		Shards:             rp.Shards,
 	})
 }
 
@@ -332,7 +704,9 @@ func (rp *RetentionPolicy) UnmarshalJSON(data []byte) error {
 	rp.Name = o.Name
 	rp.ReplicaN = o.ReplicaN
 	rp.SplitN = o.SplitN
+	rp.ShardKey = o.ShardKey
 	rp.Duration = o.Duration
+	rp.ShardGroupDuration = o.ShardGroupDuration
 	rp.Shards = o.Shards
 
 	return nil
@@ -340,11 +714,13 @@ func (rp *RetentionPolicy) UnmarshalJSON(data []byte) error {
 
 // retentionPolicyJSON represents an intermediate struct for JSON marshaling.
 type retentionPolicyJSON struct {
-	Name     string        `json:"name"`
-	ReplicaN uint32        `json:"replicaN,omitempty"`
-	SplitN   uint32        `json:"splitN,omitempty"`
-	Duration time.Duration `json:"duration,omitempty"`
-	Shards   []*Shard      `json:"shards,omitempty"`
+	Name               string        `json:"name"`
+	ReplicaN           uint32        `json:"replicaN,omitempty"`
+	SplitN             uint32        `json:"splitN,omitempty"`
+	ShardKey           []string      `json:"shardKey,omitempty"`
+	Duration           time.Duration `json:"duration,omitempty"`
+	ShardGroupDuration time.Duration `json:"shardGroupDuration,omitempty"`
+	Shards             []*Shard      `json:"shards,omitempty"`
 }
 
 // RetentionPolicies represents a list of shard policies.
@@ -405,7 +781,7 @@ func (a SeriesIDs) Intersect(seriesIDs SeriesIDs) SeriesIDs {
 	var i, j int
 
 	ids := make([]uint32, 0, len(l))
-	for i < len(l) {
+	for i < len(l) && j < len(r) {
 		if l[i] == r[j] {
 			ids = append(ids, l[i])
 			i += 1
@@ -476,7 +852,14 @@ func (l SeriesIDs) Reject(r SeriesIDs) SeriesIDs {
 }
 
 // addSeriesToIndex adds the series for the given measurement to the index. Returns false if already present
+// If the database has a persistent index then the series must already be stored in the metastore.
 func (d *database) addSeriesToIndex(measurementName string, s *Series) bool {
+	if d.index != nil {
+		s.measurement = d.createMeasurementIfNotExists(measurementName)
+		d.index.addSeries(measurementName, s)
+		return true
+	}
+
 	// if there is a measurement for this id, it's already been added
 	if d.series[s.ID] != nil {
 		return false
@@ -498,6 +881,7 @@ func (d *database) createMeasurementIfNotExists(name string) *Measurement {
 	idx := d.measurements[name]
 	if idx == nil {
 		idx = NewMeasurement(name)
+		idx.index = d.index
 		d.measurements[name] = idx
 		d.names = append(d.names, name)
 		sort.Strings(d.names)
@@ -505,6 +889,14 @@ func (d *database) createMeasurementIfNotExists(name string) *Measurement {
 	return idx
 }
 
+// setIndex sets the persistent index of the database and its measurements.
+func (d *database) setIndex(index *tagIndex) {
+	d.index = index
+	for _, m := range d.measurements {
+		m.index = index
+	}
+}
+
 // AddField adds a field to the measurement name. Returns false if already present
 func (d *database) AddField(name string, f *Field) bool {
 	panic("not implemented")
@@ -516,7 +908,7 @@ func (d *database) MeasurementsBySeriesIDs(seriesIDs SeriesIDs) []*Measurement {
 	measurements := make(map[*Measurement]bool)
 
 	for _, id := range seriesIDs {
-		m := d.series[id].measurement
+		m := d.SeriesByID(id).measurement
 		measurements[m] = true
 	}
 
@@ -533,20 +925,19 @@ func (d *database) MeasurementsBySeriesIDs(seriesIDs SeriesIDs) []*Measurement {
 // then get the series IDs for another set and use the SeriesIDs.Union to combine the two.
 func (d *database) SeriesIDs(names []string, filters []*TagFilter) SeriesIDs {
 	// they want all ids if no filters are specified
+	ids := NewSeriesIDSet()
 	if len(filters) == 0 {
-		ids := SeriesIDs(make([]uint32, 0))
 		for _, idx := range d.measurements {
-			ids = ids.Union(idx.ids)
+			ids.merge(idx.allSeriesIDs())
 		}
-		return ids
+		return ids.Slice()
 	}
 
-	ids := SeriesIDs(make([]uint32, 0))
 	for _, n := range names {
-		ids = ids.Union(d.seriesIDsByName(n, filters))
+		ids.merge(d.seriesIDsByName(n, filters))
 	}
 
-	return ids
+	return ids.Slice()
 }
 
 // TagKeys returns a sorted array of unique tag keys for the given measurements.
@@ -560,7 +951,7 @@ func (d *database) TagKeys(names []string) []string {
 	for _, n := range names {
 		idx := d.measurements[n]
 		if idx != nil {
-			for k, _ := range idx.seriesByTagKeyValue {
+			for _, k := range idx.tagKeys() {
 				keys[k] = true
 			}
 		}
@@ -602,7 +993,7 @@ func (d *database) TagValues(names []string, key string, filters []*TagFilter) T
 func (d *database) tagValuesBySeries(key string, seriesIDs SeriesIDs) TagValues {
 	values := make(map[string]bool)
 	for _, id := range seriesIDs {
-		s := d.series[id]
+		s := d.SeriesByID(id)
 		if s == nil {
 			continue
 		}
@@ -642,14 +1033,14 @@ func (l TagValues) Intersect(r TagValues) {
 }
 
 //seriesIDsByName is the same as SeriesIDs, but for a specific measurement.
-func (d *database) seriesIDsByName(name string, filters []*TagFilter) SeriesIDs {
+func (d *database) seriesIDsByName(name string, filters []*TagFilter) *SeriesIDSet {
 	idx := d.measurements[name]
 	if idx == nil {
-		return nil
+		return NewSeriesIDSet()
 	}
 
 	// process the filters one at a time to get the list of ids they return
-	idsPerFilter := make([]SeriesIDs, len(filters), len(filters))
+	idsPerFilter := make([]*SeriesIDSet, len(filters), len(filters))
 	for i, filter := range filters {
 		idsPerFilter[i] = idx.seriesIDs(filter)
 	}
@@ -665,7 +1056,7 @@ func (d *database) seriesIDsByName(name string, filters []*TagFilter) SeriesIDs
 
 // MeasurementBySeriesID returns the Measurement that is the parent of the given series id.
 func (d *database) MeasurementBySeriesID(id uint32) *Measurement {
-	if s, ok := d.series[id]; ok {
+	if s := d.SeriesByID(id); s != nil {
 		return s.measurement
 	}
 	return nil
@@ -680,8 +1071,39 @@ func (d *database) MeasurementAndSeries(name string, tags map[string]string) (*M
 	return idx, idx.seriesByTags(tags)
 }
 
+// seriesN returns the number of series in the database.
+func (d *database) seriesN() int {
+	if d.index != nil {
+		return d.index.seriesN()
+	}
+	return len(d.series)
+}
+
+// validateSeries returns an error if creating a series would exceed the
+// maximum number of series in the database or values of a tag key in the
+// measurement. A zero maximum disables the limit.
+func (d *database) validateSeries(name string, tags map[string]string, maxSeriesN, maxTagValueN int) error {
+	if maxSeriesN > 0 && d.seriesN() >= maxSeriesN {
+		return ErrMaxSeriesPerDatabaseExceeded
+	}
+
+	m := d.measurements[name]
+	if maxTagValueN == 0 || m == nil {
+		return nil
+	}
+	for k, v := range tags {
+		if m.tagValueN(k) >= maxTagValueN && !m.hasTagValue(k, v) {
+			return ErrMaxValuesPerTagExceeded
+		}
+	}
+	return nil
+}
+
 // SereiesByID returns the Series that has the given id.
 func (d *database) SeriesByID(id uint32) *Series {
+	if d.index != nil {
+		return d.index.series(id)
+	}
 	return d.series[id]
 }
 
@@ -699,9 +1121,68 @@ func (d *database) Names() []string {
 	return d.names
 }
 
-// DropSeries will clear the index of all references to a series.
-func (d *database) DropSeries(id uint32) {
-	panic("not implemented")
+// measurementNamesBySource returns the sorted names of the measurements
+// matching the names and regular expressions of a source. All measurements
+// match a nil source.
+func (d *database) measurementNamesBySource(src influxql.Source) []string {
+	var measurements influxql.Measurements
+	switch src := src.(type) {
+	case nil:
+		return d.names
+	case *influxql.Measurement:
+		measurements = influxql.Measurements{src}
+	case influxql.Measurements:
+		measurements = src
+	}
+
+	var a []string
+	for _, name := range d.names {
+		for _, m := range measurements {
+			if (m.Regex != nil && m.Regex.MatchString(name)) || (m.Regex == nil && m.Name == name) {
+				a = append(a, name)
+				break
+			}
+		}
+	}
+	return a
+}
+
+// removeSeriesFromIndex removes a series which has been deleted from the
+// metastore from the index.
+func (d *database) removeSeriesFromIndex(measurementName string, s *Series) {
+	d.writeMu.Lock()
+	delete(d.lastWrites, s.ID)
+	d.writeMu.Unlock()
+
+	if d.index != nil {
+		d.index.removeSeries(measurementName, s)
+		return
+	}
+
+	delete(d.series, s.ID)
+	if m := d.measurements[measurementName]; m != nil {
+		m.dropSeries(s)
+	}
+}
+
+// setLastWrite sets the last write time of the series of encoded points.
+func (d *database) setLastWrite(points [][]byte, t time.Time) {
+	d.writeMu.Lock()
+	defer d.writeMu.Unlock()
+	for _, p := range points {
+		d.lastWrites[unmarshalPointSeriesID(p)] = t
+	}
+}
+
+// lastWrite returns the last write time of a series. Series which haven't
+// been written since the database was loaded return the load time.
+func (d *database) lastWrite(id uint32) time.Time {
+	d.writeMu.Lock()
+	defer d.writeMu.Unlock()
+	if t, ok := d.lastWrites[id]; ok {
+		return t
+	}
+	return d.loadTime
 }
 
 // DropMeasurement will clear the index of all references to a measurement and its child series.
@@ -709,18 +1190,515 @@ func (d *database) DropMeasurement(name string) {
 	panic("not implemented")
 }
 
-// used to convert the tag set to bytes for use as a lookup key
-func marshalTags(tags map[string]string) []byte {
-	s := make([]string, 0, len(tags))
-	// pull out keys to sort
-	for k := range tags {
-		s = append(s, k)
+// dbi is an adapter between a database and the influxql.DB interface used by the query planner.
+// Data is read from the shards of a single retention policy that are stored
+// on the server. Shards stored on other data nodes are read by remote mappers.
+type dbi struct {
+	server *Server
+	db     *database
+	policy string // retention policy name
+	shard  *Shard // if set, only this shard is read
+	user   *User  // user that remote mappers are run for
+	query  string // database the query was run against
+
+	// Requests to remote shards by shard id. Only accessed while planning.
+	remotes map[uint64]*remoteShardQuery
+}
+
+// MatchSeries returns a list of series data ids matching a name and tags.
+func (d *dbi) MatchSeries(name string, tags map[string]string) []uint32 {
+	d.server.mu.RLock()
+	defer d.server.mu.RUnlock()
+
+	// Find measurement.
+	m := d.db.measurements[name]
+	if m == nil {
+		return nil
+	}
+
+	// Return all series if no tags are specified.
+	if len(tags) == 0 {
+		return m.allSeriesIDs().Slice()
+	}
+
+	// Otherwise convert the tags to filters and match against the index.
+	filters := make([]*TagFilter, 0, len(tags))
+	for k, v := range tags {
+		filters = append(filters, &TagFilter{Key: k, Value: v})
+	}
+	return d.db.seriesIDsByName(name, filters).Slice()
+}
+
+// SeriesTagValues returns a slice of tag values for a series.
+func (d *dbi) SeriesTagValues(seriesID uint32, keys []string) []string {
+	d.server.mu.RLock()
+	defer d.server.mu.RUnlock()
+
+	// Find series.
+	values := make([]string, len(keys))
+	s := d.db.SeriesByID(seriesID)
+	if s == nil {
+		return values
 	}
-	sort.Strings(s)
 
-	// now append on the key values in key sorted order
-	for _, k := range s {
-		s = append(s, tags[k])
+	// Loop over keys and set values.
+	for i, key := range keys {
+		values[i] = s.Tags[key]
 	}
-	return []byte(strings.Join(s, "|"))
+	return values
 }
+
+// Field returns the id and data type for a series field.
+// Returns id of zero if not a field.
+func (d *dbi) Field(name, field string) (fieldID uint8, typ influxql.DataType) {
+	d.server.mu.RLock()
+	defer d.server.mu.RUnlock()
+
+	// Find measurement.
+	m := d.db.measurements[name]
+	if m == nil {
+		return
+	}
+
+	// Find field.
+	f := m.field(field)
+	if f == nil {
+		return
+	}
+
+	return f.ID, f.Type.DataType()
+}
+
+// CreateIterator returns an iterator given a series data id, field id, & field data type.
+func (d *dbi) CreateIterator(seriesID uint32, fieldID uint8, typ influxql.DataType, min, max time.Time, interval time.Duration) influxql.Iterator {
+	d.server.mu.RLock()
+	defer d.server.mu.RUnlock()
+
+	itr := &seriesIterator{
+		seriesID: seriesID,
+		interval: int64(interval),
+		imin:     -1,
+	}
+	if !min.IsZero() {
+		itr.min = min.UnixNano()
+	}
+	if !max.IsZero() {
+		itr.max = max.UnixNano()
+	}
+
+	// Lookup the field name.
+	if s := d.db.SeriesByID(seriesID); s != nil {
+		if f := s.measurement.fieldByID(fieldID); f != nil {
+			itr.field = f.Name
+		}
+	}
+
+	// Find all local shards in the policy that overlap the time range.
+	if d.shard != nil {
+		itr.shards = []*Shard{d.shard}
+	} else if rp := d.db.policies[d.policy]; rp != nil {
+		for _, sh := range d.shardsByTimeRange(rp, seriesID, min, max) {
+			if sh.HasDataNodeID(d.server.id) {
+				itr.shards = append(itr.shards, sh)
+			}
+		}
+	}
+
+	return itr
+}
+
+// CreateRemoteMappers returns a mapper for each shard in the time range that
+// is stored on other data nodes. Mappers reading from the same shard are run
+// with a single request.
+func (d *dbi) CreateRemoteMappers(seriesID uint32, min, max time.Time) []influxql.RemoteMapper {
+	d.server.mu.RLock()
+	defer d.server.mu.RUnlock()
+
+	rp := d.db.policies[d.policy]
+	if d.shard != nil || rp == nil {
+		return nil
+	}
+
+	var a []influxql.RemoteMapper
+	for _, sh := range d.shardsByTimeRange(rp, seriesID, min, max) {
+		if sh.HasDataNodeID(d.server.id) {
+			continue
+		}
+
+		// Find or create the request to the nodes that own the shard.
+		q := d.remotes[sh.ID]
+		if q == nil {
+			q = &remoteShardQuery{server: d.server, user: d.user, query: d.query, database: d.db.name, shardID: sh.ID}
+			for _, id := range sh.DataNodeIDs {
+				if n := d.server.dataNodes[id]; n != nil {
+					q.nodes = append(q.nodes, n)
+				}
+			}
+			if d.remotes == nil {
+				d.remotes = make(map[uint64]*remoteShardQuery)
+			}
+			d.remotes[sh.ID] = q
+		}
+		a = append(a, q.add())
+	}
+	return a
+}
+
+// shardsByTimeRange returns the shards of a policy that overlap a time range
+// and can store a series. Splits which can't store the series are skipped.
+func (d *dbi) shardsByTimeRange(rp *RetentionPolicy, seriesID uint32, min, max time.Time) Shards {
+	if s := d.db.SeriesByID(seriesID); s != nil {
+		return rp.seriesShardsByTimeRange(s, min, max)
+	}
+	return rp.shardsByTimeRange(min, max)
+}
+
+// remoteShardQuery runs the mappers of a query that read from a shard stored
+// on other data nodes. Each node that owns the shard is tried in order until
+// one accepts the request. The request is sent once every mapper has been run
+// and each chunk of values in the response is queued for its mapper so that
+// a mapper which is read slowly does not block the others.
+type remoteShardQuery struct {
+	server   *Server
+	user     *User
+	query    string // database the query was run against
+	database string
+	shardID  uint64
+	nodes    []*DataNode
+
+	mu      sync.Mutex
+	specs   []*influxql.MapperSpec
+	mappers []*remoteMapper
+	n       int // number of mappers run
+}
+
+// add returns a new mapper which is run as part of the request.
+func (q *remoteShardQuery) add() *remoteMapper {
+	m := &remoteMapper{query: q, index: len(q.mappers), notify: make(chan struct{}, 1)}
+	q.mappers = append(q.mappers, m)
+	q.specs = append(q.specs, nil)
+	return m
+}
+
+// register sets the spec for the mapper at index i. The request is sent once
+// the specs of all mappers are set.
+func (q *remoteShardQuery) register(i int, spec *influxql.MapperSpec, closing <-chan struct{}) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.specs[i] = spec
+	if q.n++; q.n == len(q.specs) {
+		go q.run(closing)
+	}
+}
+
+// run sends the request and queues each chunk of values for its mapper.
+// The request is canceled once closing is closed. The server's shard query
+// slot is held while the response is read but not while values are processed.
+func (q *remoteShardQuery) run(closing <-chan struct{}) {
+	release := q.server.acquireShardQuery()
+	err := q.read(closing)
+	release()
+	if err != nil {
+		err = fmt.Errorf("remote mapper: shard %d: %s", q.shardID, err)
+	}
+	for _, m := range q.mappers {
+		m.close(err)
+	}
+}
+
+// read sends the request to the first available node and reads the response.
+func (q *remoteShardQuery) read(closing <-chan struct{}) error {
+	b, err := json.Marshal(&mapperRequest{Query: q.query, Database: q.database, ShardID: q.shardID, Specs: q.specs})
+	if err != nil {
+		return err
+	}
+
+	// Cancel the request once closing is closed.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-closing:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	// Send the request to each node until one accepts it.
+	var resp *http.Response
+	err = ErrDataNodeNotFound
+	for _, n := range q.nodes {
+		if resp, err = q.send(ctx, n, b); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Read each chunk until the end of the response.
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var c mapperChunk
+		if err := dec.Decode(&c); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		} else if c.Err != "" {
+			return errors.New(c.Err)
+		} else if c.Index < 0 || c.Index >= len(q.mappers) {
+			return fmt.Errorf("invalid mapper index: %d", c.Index)
+		}
+		q.mappers[c.Index].push(c.Values)
+	}
+}
+
+// send sends the mapper request to a data node. The request is signed for
+// the user so that the node can authenticate it.
+func (q *remoteShardQuery) send(ctx context.Context, n *DataNode, body []byte) (*http.Response, error) {
+	u := *n.URL
+	u.Path = "/mappers"
+	req, err := http.NewRequestWithContext(ctx, "POST", u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if q.user != nil {
+		req.Header.Set(nodeUserHeader, q.user.Name)
+		req.Header.Set(nodeSignatureHeader, q.user.sign(body))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	} else if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("data node %d: unexpected status: %d", n.ID, resp.StatusCode)
+	}
+	return resp, nil
+}
+
+// remoteMapper runs a mapper on a shard stored on other data nodes as part
+// of a request for all the query's mappers reading from the shard.
+type remoteMapper struct {
+	query *remoteShardQuery
+	index int // index of the mapper's spec in the request
+
+	mu      sync.Mutex
+	chunks  [][]influxql.MapperValue // chunks not yet read
+	done    bool                     // set once all chunks are queued
+	stopped bool                     // set once the mapper stops reading
+	err     error
+	notify  chan struct{} // signaled when chunks are queued or done
+}
+
+// Run runs the mapper on a data node that owns the shard and calls fn with
+// each chunk of values as it is received.
+func (m *remoteMapper) Run(spec *influxql.MapperSpec, closing <-chan struct{}, fn func([]influxql.MapperValue) bool) error {
+	m.query.register(m.index, spec, closing)
+	defer m.stop()
+
+	for {
+		// Read the next queued chunk. Return once all chunks have been read.
+		m.mu.Lock()
+		if len(m.chunks) > 0 {
+			values := m.chunks[0]
+			m.chunks = m.chunks[1:]
+			m.mu.Unlock()
+
+			if !fn(values) {
+				return nil
+			}
+			continue
+		} else if m.done {
+			err := m.err
+			m.mu.Unlock()
+			return err
+		}
+		m.mu.Unlock()
+
+		// Wait for more chunks.
+		select {
+		case <-m.notify:
+		case <-closing:
+			return nil
+		}
+	}
+}
+
+// push queues a chunk of values. Chunks are dropped once the mapper has stopped.
+func (m *remoteMapper) push(values []influxql.MapperValue) {
+	m.mu.Lock()
+	if !m.stopped {
+		m.chunks = append(m.chunks, values)
+	}
+	m.mu.Unlock()
+	m.signal()
+}
+
+// close marks the end of the mapper's chunks.
+func (m *remoteMapper) close(err error) {
+	m.mu.Lock()
+	m.done, m.err = true, err
+	m.mu.Unlock()
+	m.signal()
+}
+
+// stop drops any queued chunks once the mapper stops reading.
+func (m *remoteMapper) stop() {
+	m.mu.Lock()
+	m.stopped, m.chunks = true, nil
+	m.mu.Unlock()
+}
+
+// signal notifies the reader that the queue has changed.
+func (m *remoteMapper) signal() {
+	select {
+	case m.notify <- struct{}{}:
+	default:
+	}
+}
+
+// mapperRequest represents a request to run mappers on a shard of a data node.
+// The query database is used to check that the user can read the shard.
+type mapperRequest struct {
+	Query    string                 `json:"query"`
+	Database string                 `json:"database"`
+	ShardID  uint64                 `json:"shardID"`
+	Specs    []*influxql.MapperSpec `json:"specs"`
+}
+
+// mapperChunk represents a chunk of values sent by a remote mapper. Index is
+// the position of the mapper's spec in the request. The last chunk holds the
+// error message if a mapper failed.
+type mapperChunk struct {
+	Index  int                    `json:"index"`
+	Values []influxql.MapperValue `json:"values,omitempty"`
+	Err    string                 `json:"error,omitempty"`
+}
+
+// seriesIterator represents an iterator over a single field of a series.
+// Points are read from each shard in time order.
+type seriesIterator struct {
+	seriesID uint32
+	field    string   // field name
+	shards   []*Shard // remaining shards, sorted by start time
+
+	cur       *shardCursor // current shard cursor
+	timestamp int64        // current point timestamp
+	data      []byte       // current point data
+
+	min, max   int64 // time range
+	imin, imax int64 // interval time range
+	interval   int64 // interval duration
+}
+
+// NextIterval moves the iterator to the next available interval.
+// Returns true if another iterval is available.
+func (i *seriesIterator) NextIterval() bool {
+	// Initialize interval start time if not set.
+	// If there's no duration then there's only one interval.
+	// Otherwise increment it by the interval.
+	if i.imin == -1 {
+		i.imin = i.min
+	} else if i.interval == 0 {
+		_ = i.Close()
+		return false
+	} else {
+		// Update interval start time if it's before iterator end time.
+		// Otherwise return false.
+		if imin := i.imin + i.interval; imin < i.max {
+			i.imin = imin
+		} else {
+			_ = i.Close()
+			return false
+		}
+	}
+
+	// Interval end time should be the start time plus interval duration.
+	// If the end time is beyond the iterator end time then shorten it.
+	i.imax = i.imin + i.interval
+	if i.interval == 0 || i.imax > i.max {
+		i.imax = i.max + 1
+	}
+
+	return true
+}
+
+// Next returns the next point's timestamp and field value.
+// Returns a zero key when the end of the interval is reached.
+func (i *seriesIterator) Next() (key int64, value interface{}) {
+	for {
+		// Read the next point from the shards.
+		timestamp, data := i.peek()
+		if data == nil || timestamp >= i.imax {
+			return 0, nil
+		}
+		i.data = nil
+
+		// Skip points before the interval.
+		if timestamp < i.imin {
+			continue
+		}
+
+		// Decode the point and return the field value, if set.
+		var values map[string]interface{}
+		if err := json.Unmarshal(data, &values); err != nil {
+			continue
+		}
+		if v := values[i.field]; v != nil {
+			return timestamp, v
+		}
+	}
+}
+
+// peek returns the current point without moving the iterator.
+// Cursors are opened and closed as each shard is exhausted.
+func (i *seriesIterator) peek() (int64, []byte) {
+	for i.data == nil {
+		// Read the next point from the current cursor.
+		if i.cur != nil {
+			if i.timestamp, i.data = i.cur.next(); i.data == nil {
+				_ = i.cur.close()
+				i.cur = nil
+			}
+			continue
+		}
+
+		// Stop when there are no more shards.
+		if len(i.shards) == 0 {
+			return 0, nil
+		}
+
+		// Open a cursor on the next shard and move to the start of the time range.
+		sh := i.shards[0]
+		i.shards = i.shards[1:]
+		cur, err := sh.cursor(i.seriesID)
+		if err != nil || cur == nil {
+			continue
+		}
+		i.cur = cur
+		if i.timestamp, i.data = cur.seek(i.min); i.data == nil {
+			_ = i.cur.close()
+			i.cur = nil
+		}
+	}
+	return i.timestamp, i.data
+}
+
+// Close releases any open shard cursor.
+func (i *seriesIterator) Close() error {
+	if i.cur != nil {
+		_ = i.cur.close()
+		i.cur = nil
+	}
+	i.shards = nil
+	return nil
+}
+
+// Time returns start time of the current interval.
+func (i *seriesIterator) Time() int64 { return i.imin }
+
+// Interval returns the group by duration.
+func (i *seriesIterator) Interval() time.Duration { return time.Duration(i.interval) }
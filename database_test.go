@@ -1,6 +1,8 @@
 package influxdb
 
 import (
+	"fmt"
+	"math"
 	"reflect"
 	"regexp"
 	"sort"
@@ -244,7 +246,7 @@ func TestDatabase_SeriesIDsWhereTagFilter(t *testing.T) {
 			filters: []*TagFilter{
 				&TagFilter{Key: "app", Value: "", Not: true},
 			},
-			result: []uint32{uint32(6)},
+			result: []uint32{uint32(6), uint32(7)},
 		},
 
 		// query against a tag value and another tag NOT value
@@ -421,8 +423,23 @@ func TestDatabase_TagValuesWhereTagFilter(t *testing.T) {
 	}
 }
 
+// Ensure that a series can be removed from the in memory index.
 func TestDatabase_DropSeries(t *testing.T) {
-	t.Skip("pending")
+	idx := databaseWithFixtureData()
+	s := idx.SeriesByID(4)
+	idx.removeSeriesFromIndex("key_count", s)
+
+	if ids := idx.SeriesIDs([]string{"key_count"}, []*TagFilter{{Key: "service", Value: "redis"}}); !ids.Equals(SeriesIDs{3}) {
+		t.Fatalf("unexpected ids: %v", ids)
+	} else if a := idx.TagValues([]string{"key_count"}, "region", nil).ToSlice(); !reflect.DeepEqual(a, []string{"uswest"}) {
+		t.Fatalf("unexpected tag values: %v", a)
+	} else if n := idx.measurements["key_count"].tagValueN("region"); n != 1 {
+		t.Fatalf("unexpected tag value count: %d", n)
+	} else if _, s := idx.MeasurementAndSeries("key_count", s.Tags); s != nil {
+		t.Fatalf("unexpected series: %#v", s)
+	} else if s := idx.SeriesByID(4); s != nil {
+		t.Fatalf("unexpected series: %#v", s)
+	}
 }
 
 func TestDatabase_DropMeasurement(t *testing.T) {
@@ -558,6 +575,13 @@ func TestDatabase_SeriesIDsIntersect(t *testing.T) {
 			left:     []uint32{uint32(2), uint32(3), uint32(4), uint32(8)},
 			right:    []uint32{uint32(1), uint32(4), uint32(7), uint32(8), uint32(9)},
 		},
+
+		// shorter side has values past the end of the longer side
+		{
+			expected: []uint32{},
+			left:     []uint32{uint32(5), uint32(9)},
+			right:    []uint32{uint32(1), uint32(2), uint32(3)},
+		},
 	}
 
 	for i, tt := range tests {
@@ -681,3 +705,45 @@ func TestDatabase_SeriesIDsReject(t *testing.T) {
 		}
 	}
 }
+
+// Ensure a measurement can create fields with incrementing ids.
+func TestMeasurement_CreateFieldIfNotExists(t *testing.T) {
+	m := NewMeasurement("cpu")
+
+	// Create two fields.
+	if f, err := m.createFieldIfNotExists("value", Float64); err != nil {
+		t.Fatal(err)
+	} else if f.ID != 1 {
+		t.Fatalf("unexpected id: %d", f.ID)
+	}
+	if f, err := m.createFieldIfNotExists("status", String); err != nil {
+		t.Fatal(err)
+	} else if f.ID != 2 {
+		t.Fatalf("unexpected id: %d", f.ID)
+	}
+
+	// Ensure an existing field is returned.
+	if f, err := m.createFieldIfNotExists("value", Float64); err != nil {
+		t.Fatal(err)
+	} else if f.ID != 1 {
+		t.Fatalf("unexpected id: %d", f.ID)
+	}
+
+	// Ensure an existing field cannot change type.
+	if _, err := m.createFieldIfNotExists("value", String); err != ErrFieldTypeConflict {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+// Ensure a measurement returns an error when too many fields are created.
+func TestMeasurement_CreateFieldIfNotExists_ErrFieldOverflow(t *testing.T) {
+	m := NewMeasurement("cpu")
+	for i := 0; i < math.MaxUint8; i++ {
+		if _, err := m.createFieldIfNotExists(fmt.Sprintf("field%d", i), Float64); err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+	}
+	if _, err := m.createFieldIfNotExists("overflow", Float64); err != ErrFieldOverflow {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
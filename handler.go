@@ -1,9 +1,11 @@
 package influxdb
 
 import (
+	"bytes"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -18,6 +20,17 @@ import (
 
 // TODO: Check HTTP response codes: 400, 401, 403, 409.
 
+// DefaultChunkSize is the maximum number of values per row in a chunked
+// query response if the "chunk_size" parameter is not specified.
+const DefaultChunkSize = 10000
+
+// Headers identifying the user that a request between data nodes is sent on
+// behalf of, and the signature of the request body for that user.
+const (
+	nodeUserHeader      = "X-Influxdb-Node-User"
+	nodeSignatureHeader = "X-Influxdb-Node-Signature"
+)
+
 // getUsernameAndPassword returns the username and password encoded in
 // a request. The credentials may be present as URL query params, or as
 // a Basic Authentication header.
@@ -83,6 +96,9 @@ func NewHandler(s *Server) *Handler {
 	h.mux.Get("/db/:db/series", h.makeAuthenticationHandler(h.serveQuery))
 	h.mux.Post("/db/:db/series", h.makeAuthenticationHandler(h.serveWriteSeries))
 
+	// Measurement routes.
+	h.mux.Put("/db/:db/measurements/:name", h.makeAuthenticationHandler(h.serveUpdateMeasurement))
+
 	// Shard routes.
 	h.mux.Get("/db/:db/shards", h.makeAuthenticationHandler(h.serveShards))
 	h.mux.Del("/db/:db/shards/:id", h.makeAuthenticationHandler(h.serveDeleteShard))
@@ -98,6 +114,13 @@ func NewHandler(s *Server) *Handler {
 	h.mux.Post("/data_nodes", h.makeAuthenticationHandler(h.serveCreateDataNode))
 	h.mux.Del("/data_nodes/:id", h.makeAuthenticationHandler(h.serveDeleteDataNode))
 
+	// Metadata routes.
+	h.mux.Get("/metadata", h.makeAuthenticationHandler(h.serveExportMetadata))
+	h.mux.Post("/metadata", h.makeAuthenticationHandler(h.serveImportMetadata))
+
+	// Mapper routes, used by other data nodes to run mappers on local shards.
+	h.mux.Post("/mappers", h.makeNodeAuthenticationHandler(h.serveRunMapper))
+
 	// Utilities
 	h.mux.Get("/ping", h.makeAuthenticationHandler(h.servePing))
 
@@ -151,43 +174,146 @@ func (h *Handler) makeAuthenticationHandler(fn func(http.ResponseWriter, *http.R
 	}
 }
 
+// makeNodeAuthenticationHandler returns a handler for requests sent by other
+// data nodes on behalf of a user. If authentication is enabled, the request
+// body must be signed for the user named in the request headers.
+func (h *Handler) makeNodeAuthenticationHandler(fn func(http.ResponseWriter, *http.Request, *User)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var user *User
+		if h.AuthenticationEnabled {
+			username := r.Header.Get(nodeUserHeader)
+			if username == "" {
+				h.error(w, "username required", http.StatusUnauthorized)
+				return
+			}
+
+			// Read the body so the signature can be checked before it is decoded.
+			body, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				h.error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+			user, err = h.server.authenticateNodeRequest(username, r.Header.Get(nodeSignatureHeader), body)
+			if err != nil {
+				h.error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+		}
+		fn(w, r, user)
+	}
+}
+
 // serveQuery parses an incoming query and returns the results.
 func (h *Handler) serveQuery(w http.ResponseWriter, r *http.Request, u *User) {
 	// TODO: Authentication.
 
 	// Parse query from query string.
 	urlQry := r.URL.Query()
-	_, err := influxql.NewParser(strings.NewReader(urlQry.Get("q"))).ParseQuery()
+	q, err := influxql.NewParser(strings.NewReader(urlQry.Get("q"))).ParseQuery()
 	if err != nil {
 		h.error(w, "parse error: "+err.Error(), http.StatusBadRequest)
 		return
 	}
+	if err := q.Validate(); err != nil {
+		h.error(w, "invalid query: "+err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	// Retrieve database from server.
-	/*
-		db := h.server.Database(urlQry.Get(":db"))
-		if db == nil {
-			h.error(w, ErrDatabaseNotFound.Error(), http.StatusNotFound)
-			return
+	db := urlQry.Get(":db")
+	if !h.server.DatabaseExists(db) {
+		h.error(w, ErrDatabaseNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	// Kill the query if the client disconnects before it completes.
+	closing := make(chan struct{}, 0)
+	if notifier, ok := w.(http.CloseNotifier); ok {
+		notify := notifier.CloseNotify()
+		done := make(chan struct{}, 0)
+		defer close(done)
+		go func() {
+			select {
+			case <-notify:
+				close(closing)
+			case <-done:
+			}
+		}()
+	}
+
+	// Stream results as newline-delimited JSON if the response is chunked.
+	if urlQry.Get("chunked") == "true" {
+		chunkSize := DefaultChunkSize
+		if s := urlQry.Get("chunk_size"); s != "" {
+			if chunkSize, err = strconv.Atoi(s); err != nil || chunkSize <= 0 {
+				h.error(w, "invalid chunk size: "+s, http.StatusBadRequest)
+				return
+			}
 		}
-	*/
+		h.serveChunkedQuery(w, q, db, u, chunkSize, closing)
+		return
+	}
+
+	// Execute query against the database.
+	results := h.server.ExecuteQuery(q, db, u, closing)
 
-	// Parse the time precision from the query params.
-	/*
-		precision, err := parseTimePrecision(urlQry.Get("time_precision"))
+	// Encode the results before writing the status so that encoding errors
+	// can still be reported.
+	b, err := json.Marshal(results)
+	if err != nil {
+		h.error(w, "encode error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Return the statement results. A status of 500 is returned if any statement fails.
+	w.Header().Add("content-type", "application/json")
+	if results.Error() != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	_, _ = w.Write(append(b, '\n'))
+}
+
+// serveChunkedQuery executes a query and writes each result as a separate line
+// of JSON as soon as it is available. Rows are split into chunks of up to
+// chunkSize values. The status code is always 200 since it is written before
+// any statement has executed so errors are only reported in the results.
+func (h *Handler) serveChunkedQuery(w http.ResponseWriter, q *influxql.Query, db string, u *User, chunkSize int, closing <-chan struct{}) {
+	w.Header().Add("content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	// Results are still read once the client can't be written to so that
+	// the query can run to completion.
+	var werr error
+	for res := range h.server.StreamQuery(q, db, u, chunkSize, closing) {
+		var o struct {
+			StatementID int             `json:"statement"`
+			Rows        []*influxql.Row `json:"rows,omitempty"`
+			Err         string          `json:"error,omitempty"`
+		}
+		o.StatementID, o.Rows = res.StatementID, res.Rows
+		if res.Err != nil {
+			o.Err = res.Err.Error()
+		}
+
+		// Report the encoding error in place of the rows if they can't be encoded.
+		b, err := json.Marshal(&o)
 		if err != nil {
-			h.error(w, err.Error(), http.StatusBadRequest)
-			return
+			o.Rows, o.Err = nil, "encode error: "+err.Error()
+			b, _ = json.Marshal(&o)
 		}
-	*/
 
-	// Execute query against the database.
-	/*
-		if err := db.ExecuteQuery(q); err != nil {
-			h.error(w, err.Error(), http.StatusInternalServerError)
-			return
+		// Write the line and flush it to the client.
+		if werr != nil {
+			continue
+		} else if _, werr = w.Write(append(b, '\n')); werr != nil {
+			continue
 		}
-	*/
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
 }
 
 // serveWriteSeries receives incoming series data and writes it to the database.
@@ -450,6 +576,9 @@ func (h *Handler) serveCreateRetentionPolicy(w http.ResponseWriter, r *http.Requ
 	} else if err == ErrRetentionPolicyExists {
 		h.error(w, err.Error(), http.StatusConflict)
 		return
+	} else if err == ErrInvalidShardGroupDuration {
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
 	} else if err != nil {
 		h.error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -473,6 +602,38 @@ func (h *Handler) serveUpdateRetentionPolicy(w http.ResponseWriter, r *http.Requ
 	if err := h.server.UpdateRetentionPolicy(db, name, &policy); err == ErrDatabaseNotFound || err == ErrRetentionPolicyNotFound {
 		h.error(w, err.Error(), http.StatusNotFound)
 		return
+	} else if err == ErrInvalidShardGroupDuration {
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveUpdateMeasurement sets the unit, description or owner of a measurement
+// or one of its fields. Requires an admin user when authentication is enabled.
+func (h *Handler) serveUpdateMeasurement(w http.ResponseWriter, r *http.Request, u *User) {
+	if u != nil && !u.Admin {
+		h.error(w, "admin privileges required", http.StatusUnauthorized)
+		return
+	}
+
+	q := r.URL.Query()
+	db, name := q.Get(":db"), q.Get(":name")
+
+	// Decode the new metadata values from the body.
+	var mu MeasurementUpdate
+	if err := json.NewDecoder(r.Body).Decode(&mu); err != nil {
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Update the measurement.
+	if err := h.server.UpdateMeasurement(db, name, &mu); err == ErrDatabaseNotFound || err == ErrMeasurementNotFound || err == ErrFieldNotFound {
+		h.error(w, err.Error(), http.StatusNotFound)
+		return
 	} else if err != nil {
 		h.error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -511,6 +672,58 @@ func (h *Handler) serveDataNodes(w http.ResponseWriter, r *http.Request, u *User
 	_ = json.NewEncoder(w).Encode(a)
 }
 
+// serveRunMapper runs mappers against a local shard and streams each chunk
+// of values as a separate JSON object tagged with the index of its mapper.
+// Mappers are run in order. Errors which occur once streaming has started
+// are sent as the last object.
+func (h *Handler) serveRunMapper(w http.ResponseWriter, r *http.Request, u *User) {
+	// Read in the mapper request from the request body.
+	var req mapperRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, spec := range req.Specs {
+		if spec == nil {
+			h.error(w, "mapper spec required", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Ensure the user can read from the shard's database.
+	if !h.server.DatabaseExists(req.Database) {
+		h.error(w, ErrDatabaseNotFound.Error(), http.StatusNotFound)
+		return
+	} else if !u.canRead(req.Query, req.Database) {
+		h.error(w, ErrReadAccessDenied.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Add("content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+
+	// Write each chunk as it is emitted. Stop once the client can't be written to.
+	enc := json.NewEncoder(w)
+	for i, spec := range req.Specs {
+		var werr error
+		if err := h.server.RunMapper(req.Database, req.ShardID, spec, func(values []influxql.MapperValue) bool {
+			if werr = enc.Encode(&mapperChunk{Index: i, Values: values}); werr != nil {
+				return false
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+			return true
+		}); err != nil {
+			_ = enc.Encode(&mapperChunk{Index: i, Err: err.Error()})
+			return
+		} else if werr != nil {
+			return
+		}
+	}
+}
+
 // serveCreateDataNode creates a new data node in the cluster.
 func (h *Handler) serveCreateDataNode(w http.ResponseWriter, r *http.Request, u *User) {
 	// Read in data node from request body.
@@ -564,6 +777,54 @@ func (h *Handler) serveDeleteDataNode(w http.ResponseWriter, r *http.Request, u
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// serveExportMetadata returns the metadata of the cluster. Requires an admin
+// user when authentication is enabled since users are exported with their hashes.
+func (h *Handler) serveExportMetadata(w http.ResponseWriter, r *http.Request, u *User) {
+	if u != nil && !u.Admin {
+		h.error(w, "admin privileges required", http.StatusUnauthorized)
+		return
+	}
+
+	md, err := h.server.ExportMetadata()
+	if err != nil {
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(md)
+}
+
+// serveImportMetadata creates the data nodes, users and databases from
+// exported metadata. Requires an admin user when authentication is enabled.
+func (h *Handler) serveImportMetadata(w http.ResponseWriter, r *http.Request, u *User) {
+	if u != nil && !u.Admin {
+		h.error(w, "admin privileges required", http.StatusUnauthorized)
+		return
+	}
+
+	// Read in the metadata from the request body.
+	var md Metadata
+	if err := json.NewDecoder(r.Body).Decode(&md); err != nil {
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Replay the metadata into the cluster.
+	if err := h.server.ImportMetadata(&md); err == ErrMetadataVersionUnsupported {
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err == ErrDatabaseExists || err == ErrUserExists {
+		h.error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
 type dataNodeJSON struct {
 	ID  uint64 `json:"id"`
 	URL string `json:"url"`
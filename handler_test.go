@@ -2,7 +2,10 @@ package influxdb_test
 
 import (
 	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -132,7 +135,7 @@ func TestHandler_Shards(t *testing.T) {
 	status, body := MustHTTP("GET", s.URL+`/db/foo/shards`, "")
 	if status != http.StatusOK {
 		t.Fatalf("unexpected status: %d", status)
-	} else if body != `[{"id":3,"startTime":"0001-01-01T00:00:00Z","endTime":"0001-01-01T00:00:00Z"}]` {
+	} else if body != `[{"id":3,"startTime":"0001-01-01T00:00:00Z","endTime":"0001-01-08T00:00:00Z"}]` {
 		t.Fatalf("unexpected body: %s", body)
 	}
 }
@@ -245,6 +248,22 @@ func TestHandler_CreateRetentionPolicy_BadRequest(t *testing.T) {
 	}
 }
 
+func TestHandler_CreateRetentionPolicy_InvalidShardGroupDuration(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	policy := `{"name": "bar", "duration": 3600000000000, "shardGroupDuration": 7200000000000, "replicaN": 1, "splitN": 1}`
+	status, body := MustHTTP("POST", s.URL+`/db/foo/retention_policies`, policy)
+
+	if status != http.StatusBadRequest {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != "invalid shard group duration" {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
 func TestHandler_UpdateRetentionPolicy(t *testing.T) {
 	srvr := OpenServer(NewMessagingClient())
 	srvr.CreateDatabase("foo")
@@ -315,6 +334,65 @@ func TestHandler_UpdateRetentionPolicy_NotFound(t *testing.T) {
 	}
 }
 
+func TestHandler_UpdateMeasurement(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", influxdb.NewRetentionPolicy("bar"))
+	srvr.MustWriteSeries("foo", "bar", "cpu", nil, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(1)})
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("PUT", s.URL+`/db/foo/measurements/cpu`, `{"field": "value", "unit": "percent"}`)
+	if status != http.StatusNoContent {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != "" {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	// Verify the field metadata.
+	results := srvr.ExecuteQuery(mustParseQuery(`LIST FIELD KEYS FROM cpu`), "foo", nil, nil)
+	if s := mustMarshalJSON(results[0]); s != `{"rows":[{"name":"cpu","columns":["fieldKey","unit","description","owner"],"values":[["value","percent","",""]]}]}` {
+		t.Fatalf("unexpected result: %s", s)
+	}
+}
+
+func TestHandler_UpdateMeasurement_NotFound(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("PUT", s.URL+`/db/foo/measurements/cpu`, `{"unit": "percent"}`)
+	if status != http.StatusNotFound {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != "measurement not found" {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
+func TestHandler_UpdateMeasurement_Unauthorized(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", influxdb.NewRetentionPolicy("bar"))
+	srvr.MustWriteSeries("foo", "bar", "cpu", nil, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(1)})
+	srvr.CreateUser("lisa", "password", false)
+	s := NewAuthenticatedHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("PUT", s.URL+`/db/foo/measurements/cpu?u=lisa&p=password`, `{"field": "value", "unit": "percent"}`)
+	if status != http.StatusUnauthorized {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `admin privileges required` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	// Verify the field metadata was not changed.
+	results := srvr.ExecuteQuery(mustParseQuery(`LIST FIELD KEYS FROM cpu`), "foo", nil, nil)
+	if s := mustMarshalJSON(results[0]); s != `{"rows":[{"name":"cpu","columns":["fieldKey","unit","description","owner"],"values":[["value","","",""]]}]}` {
+		t.Fatalf("unexpected result: %s", s)
+	}
+}
+
 func TestHandler_DeleteRetentionPolicy(t *testing.T) {
 	srvr := OpenServer(NewMessagingClient())
 	srvr.CreateDatabase("foo")
@@ -601,6 +679,255 @@ func TestHandler_DeleteUser_DataNodeNotFound(t *testing.T) {
 	}
 }
 
+func TestHandler_RunMapper_Unauthorized(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateDatabase("bar")
+	srvr.CreateUser("lisa", "password", false)
+	s := NewAuthenticatedHTTPServer(srvr)
+	defer s.Close()
+
+	// Sign the request body with the user's password hash.
+	body := `{"query":"foo","database":"bar","shardID":1,"specs":[]}`
+	mac := hmac.New(sha256.New, []byte(srvr.User("lisa").Hash))
+	mac.Write([]byte(body))
+	signature := hex.EncodeToString(mac.Sum(nil))
+
+	for i, tt := range []struct {
+		headers map[string]string
+		body    string
+	}{
+		{headers: nil, body: `username required`},
+		{headers: map[string]string{"X-Influxdb-Node-User": "lisa", "X-Influxdb-Node-Signature": "bad"}, body: `invalid signature`},
+		{headers: map[string]string{"X-Influxdb-Node-User": "lisa", "X-Influxdb-Node-Signature": signature}, body: `read access denied`},
+	} {
+		status, b := MustHTTPWithHeaders("POST", s.URL+`/mappers`, tt.headers, body)
+		if status != http.StatusUnauthorized {
+			t.Fatalf("%d. unexpected status: %d", i, status)
+		} else if b != tt.body {
+			t.Fatalf("%d. unexpected body: %s", i, b)
+		}
+	}
+}
+
+func TestHandler_ExportMetadata(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: time.Hour})
+	srvr.SetDefaultRetentionPolicy("foo", "raw")
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("GET", s.URL+`/metadata`, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `{"version":1,"databases":[{"name":"foo","defaultRetentionPolicy":"raw","policies":[{"name":"raw","duration":3600000000000}]}]}` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
+func TestHandler_ExportMetadata_Unauthorized(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateUser("lisa", "password", false)
+	s := NewAuthenticatedHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("GET", s.URL+`/metadata?u=lisa&p=password`, "")
+	if status != http.StatusUnauthorized {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `admin privileges required` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
+func TestHandler_ImportMetadata(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("POST", s.URL+`/metadata`, `{"version":1,"databases":[{"name":"foo","defaultRetentionPolicy":"raw","policies":[{"name":"raw","duration":3600000000000}]}]}`)
+	if status != http.StatusNoContent {
+		t.Fatalf("unexpected status: %d, %s", status, body)
+	} else if rp, _ := srvr.DefaultRetentionPolicy("foo"); rp == nil || rp.Name != "raw" || rp.Duration != time.Hour {
+		t.Fatalf("unexpected retention policy: %#v", rp)
+	}
+}
+
+func TestHandler_ImportMetadata_BadRequest(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("POST", s.URL+`/metadata`, `{"version":1000}`)
+	if status != http.StatusBadRequest {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `metadata version unsupported` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
+func TestHandler_ImportMetadata_Conflict(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("POST", s.URL+`/metadata`, `{"version":1,"databases":[{"name":"foo"}]}`)
+	if status != http.StatusConflict {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `database exists` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
+func TestHandler_Query(t *testing.T) {
+	c := NewMessagingClient()
+	srvr := OpenServer(c)
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	srvr.SetDefaultRetentionPolicy("foo", "raw")
+	if err := srvr.WriteSeries("foo", "raw", "cpu", nil, mustParseTime("2000-01-01T00:00:00Z"), map[string]interface{}{"value": float64(10)}); err != nil {
+		t.Fatal(err)
+	} else if err := srvr.Sync(c.index); err != nil {
+		t.Fatalf("sync error: %s", err)
+	}
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("GET", s.URL+`/db/foo/series?q=`+url.QueryEscape(`SELECT sum(value) FROM cpu`), "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `[{"rows":[{"name":"cpu","columns":["time","sum"],"values":[[0,10]]}]}]` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
+func TestHandler_Query_DatabaseNotFound(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("GET", s.URL+`/db/foo/series?q=`+url.QueryEscape(`SELECT sum(value) FROM cpu`), "")
+	if status != http.StatusNotFound {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != "database not found" {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
+func TestHandler_Query_StatementError(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	srvr.SetDefaultRetentionPolicy("foo", "raw")
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("GET", s.URL+`/db/foo/series?q=`+url.QueryEscape(`SELECT sum(value) FROM cpu; SELECT sum(value) FROM cpu`), "")
+	if status != http.StatusInternalServerError {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `[{"error":"field not found: cpu.value"},{"error":"not executed"}]` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
+func TestHandler_Query_ValidationError(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("GET", s.URL+`/db/foo/series?q=`+url.QueryEscape(`SELECT foo(value) FROM cpu`), "")
+	if status != http.StatusBadRequest {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `invalid query: function not found: foo() at line 1, char 8` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
+// Ensure wildcard fields are rejected before a query is planned or streamed.
+func TestHandler_Query_Wildcard(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	for _, path := range []string{`/db/foo/series?q=`, `/db/foo/series?chunked=true&q=`} {
+		status, body := MustHTTP("GET", s.URL+path+url.QueryEscape(`SELECT * FROM cpu`), "")
+		if status != http.StatusBadRequest {
+			t.Fatalf("%s: unexpected status: %d", path, status)
+		} else if body != `invalid query: wildcard fields are not supported at line 1, char 8` {
+			t.Fatalf("%s: unexpected body: %s", path, body)
+		}
+	}
+}
+
+func TestHandler_Query_NonFinite(t *testing.T) {
+	c := NewMessagingClient()
+	srvr := OpenServer(c)
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	srvr.SetDefaultRetentionPolicy("foo", "raw")
+	srvr.MustWriteSeries("foo", "raw", "cpu", map[string]string{}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(-4)})
+	if err := srvr.Sync(c.index); err != nil {
+		t.Fatalf("sync error: %s", err)
+	}
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	q := url.QueryEscape(`SELECT value, sqrt(value) FROM cpu WHERE time >= "2000-01-01 00:00:00"`)
+	status, body := MustHTTP("GET", s.URL+`/db/foo/series?q=`+q, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	} else if body != `[{"rows":[{"name":"cpu","columns":["time","value","sqrt"],"values":[[946684800000000,-4,null]]}]}]` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	status, body = MustHTTP("GET", s.URL+`/db/foo/series?chunked=true&q=`+q, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	} else if body != `{"statement":0,"rows":[{"name":"cpu","columns":["time","value","sqrt"],"values":[[946684800000000,-4,null]]}]}` {
+		t.Fatalf("unexpected chunked body: %s", body)
+	}
+}
+
+func TestHandler_Query_Chunked(t *testing.T) {
+	c := NewMessagingClient()
+	srvr := OpenServer(c)
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	srvr.SetDefaultRetentionPolicy("foo", "raw")
+	srvr.MustWriteSeries("foo", "raw", "cpu", map[string]string{}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(10)})
+	srvr.MustWriteSeries("foo", "raw", "cpu", map[string]string{}, "2000-01-01T00:00:10Z", map[string]interface{}{"value": float64(20)})
+	if err := srvr.Sync(c.index); err != nil {
+		t.Fatalf("sync error: %s", err)
+	}
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("GET", s.URL+`/db/foo/series?chunked=true&chunk_size=1&q=`+url.QueryEscape(`SELECT value FROM cpu`), "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `{"statement":0,"rows":[{"name":"cpu","columns":["time","value"],"values":[[946684800000000,10]]}]}`+"\n"+
+		`{"statement":0,"rows":[{"name":"cpu","columns":["time","value"],"values":[[946684810000000,20]]}]}` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
+func TestHandler_Query_Chunked_InvalidChunkSize(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("GET", s.URL+`/db/foo/series?chunked=true&chunk_size=0&q=`+url.QueryEscape(`SELECT value FROM cpu`), "")
+	if status != http.StatusBadRequest {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `invalid chunk size: 0` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
 // Perform a subset of endpoint testing, with authentication enabled.
 
 func TestHandler_AuthenticatedCreateAdminUser(t *testing.T) {
@@ -0,0 +1,321 @@
+package influxdb
+
+import (
+	"container/list"
+	"sync"
+)
+
+// DefaultIndexCacheSize is the default number of series ids and series that
+// the tag index of a database keeps in memory.
+const DefaultIndexCacheSize = 1000000
+
+// tagIndex represents the persistent index of the series in a database.
+// Series and their tag postings are read lazily from the metastore and
+// the most recently used ones are cached in memory.
+//
+// Reads must hold at least a read lock on the server. Series are only added
+// while holding the write lock so cached postings can't miss a new series.
+type tagIndex struct {
+	mu      sync.Mutex
+	meta    *metastore
+	db      *database
+	maxN    int                           // maximum number of cached ids & series
+	n       int                           // current number of cached ids & series
+	lru     *list.List                    // entries, most recently used first
+	entries map[tagIndexKey]*list.Element // entries by key
+	counts  map[tagIndexKey]int           // series & tag value counts
+}
+
+// newTagIndex returns a new tag index for a database that caches up to maxN ids & series.
+func newTagIndex(meta *metastore, db *database, maxN int) *tagIndex {
+	return &tagIndex{
+		meta:    meta,
+		db:      db,
+		maxN:    maxN,
+		lru:     list.New(),
+		entries: make(map[tagIndexKey]*list.Element),
+		counts:  make(map[tagIndexKey]int),
+	}
+}
+
+// tagIndexKey identifies a cached postings list or series.
+type tagIndexKey struct {
+	measurement string
+	key, value  string // tag key & value, empty for all series in the measurement
+	seriesID    uint32 // series id, only set for a series
+}
+
+// tagIndexEntry represents a cached postings list or series.
+type tagIndexEntry struct {
+	key    tagIndexKey
+	ids    *SeriesIDSet
+	series *Series
+}
+
+// size returns the number of ids or series held by the entry.
+func (e *tagIndexEntry) size() int {
+	if e.series != nil {
+		return 1
+	}
+	return e.ids.Len()
+}
+
+// seriesIDs returns the ids of all series in a measurement.
+func (i *tagIndex) seriesIDs(name string) *SeriesIDSet {
+	return i.postings(tagIndexKey{measurement: name}, []byte("s"))
+}
+
+// seriesIDsByTagValue returns the ids of the series in a measurement with a tag value.
+func (i *tagIndex) seriesIDsByTagValue(name, key, value string) *SeriesIDSet {
+	return i.postings(tagIndexKey{measurement: name, key: key, value: value}, tagIndexPrefix(key, value))
+}
+
+// postings returns a cached postings list or reads it from the metastore.
+// The list must only be read while holding the server lock since new series
+// are added to it.
+func (i *tagIndex) postings(key tagIndexKey, prefix []byte) *SeriesIDSet {
+	i.mu.Lock()
+	e := i.get(key)
+	i.mu.Unlock()
+	if e != nil {
+		return e.ids
+	}
+
+	var ids *SeriesIDSet
+	i.meta.mustView(func(tx *metatx) error {
+		ids = NewSeriesIDSet(tx.seriesIDs(i.db.name, key.measurement, prefix)...)
+		return nil
+	})
+
+	i.mu.Lock()
+	i.put(&tagIndexEntry{key: key, ids: ids})
+	i.mu.Unlock()
+	return ids
+}
+
+// series returns a series by id. Returns nil if the series does not exist.
+func (i *tagIndex) series(id uint32) *Series {
+	i.mu.Lock()
+	e := i.get(tagIndexKey{seriesID: id})
+	i.mu.Unlock()
+	if e != nil {
+		return e.series
+	}
+
+	var name string
+	var s *Series
+	i.meta.mustView(func(tx *metatx) error {
+		name, s = tx.series(i.db.name, id)
+		return nil
+	})
+	if s == nil {
+		return nil
+	}
+	s.measurement = i.db.measurements[name]
+
+	i.mu.Lock()
+	i.put(&tagIndexEntry{key: tagIndexKey{seriesID: id}, series: s})
+	i.mu.Unlock()
+	return s
+}
+
+// seriesByTags returns the series in a measurement with an exact tagset.
+// Returns nil if the series does not exist.
+func (i *tagIndex) seriesByTags(name string, tags map[string]string) *Series {
+	var id uint32
+	i.meta.mustView(func(tx *metatx) error {
+		id = tx.seriesID(i.db.name, name, tags)
+		return nil
+	})
+	if id == 0 {
+		return nil
+	}
+	return i.series(id)
+}
+
+// tagKeys returns the tag keys of a measurement in sorted order.
+func (i *tagIndex) tagKeys(name string) (a []string) {
+	i.meta.mustView(func(tx *metatx) error {
+		a = tx.tagKeys(i.db.name, name)
+		return nil
+	})
+	return
+}
+
+// tagValues returns the values of a tag key in a measurement in sorted order.
+func (i *tagIndex) tagValues(name, key string) (a []string) {
+	i.meta.mustView(func(tx *metatx) error {
+		a = tx.tagValues(i.db.name, name, key)
+		return nil
+	})
+	return
+}
+
+// seriesN returns the number of series in the database.
+func (i *tagIndex) seriesN() int {
+	return i.count(tagIndexKey{}, func(tx *metatx) int { return tx.seriesN(i.db.name) })
+}
+
+// tagValueN returns the number of values of a tag key in a measurement.
+func (i *tagIndex) tagValueN(name, key string) int {
+	return i.count(tagIndexKey{measurement: name, key: key}, func(tx *metatx) int {
+		return len(tx.tagValues(i.db.name, name, key))
+	})
+}
+
+// hasTagValue returns true if a series in a measurement has the tag value.
+func (i *tagIndex) hasTagValue(name, key, value string) (v bool) {
+	i.meta.mustView(func(tx *metatx) error {
+		v = tx.tagValueSeriesN(i.db.name, name, key, value, 1) > 0
+		return nil
+	})
+	return
+}
+
+// count returns a cached count or reads it from the metastore.
+// Counts are only read once since new series update them.
+func (i *tagIndex) count(key tagIndexKey, fn func(*metatx) int) int {
+	i.mu.Lock()
+	n, ok := i.counts[key]
+	i.mu.Unlock()
+	if ok {
+		return n
+	}
+
+	i.meta.mustView(func(tx *metatx) error {
+		n = fn(tx)
+		return nil
+	})
+
+	i.mu.Lock()
+	i.counts[key] = n
+	i.mu.Unlock()
+	return n
+}
+
+// addSeries adds a series which has been stored in the metastore to the
+// cached postings lists and counts.
+func (i *tagIndex) addSeries(name string, s *Series) {
+	// Find the tag keys with a value which is new to the measurement.
+	var keys []string
+	i.meta.mustView(func(tx *metatx) error {
+		for k, v := range s.Tags {
+			if tx.tagValueSeriesN(i.db.name, name, k, v, 2) == 1 {
+				keys = append(keys, k)
+			}
+		}
+		return nil
+	})
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if _, ok := i.counts[tagIndexKey{}]; ok {
+		i.counts[tagIndexKey{}]++
+	}
+	for _, k := range keys {
+		if _, ok := i.counts[tagIndexKey{measurement: name, key: k}]; ok {
+			i.counts[tagIndexKey{measurement: name, key: k}]++
+		}
+	}
+
+	i.put(&tagIndexEntry{key: tagIndexKey{seriesID: s.ID}, series: s})
+	i.append(tagIndexKey{measurement: name}, s.ID)
+	for k, v := range s.Tags {
+		i.append(tagIndexKey{measurement: name, key: k, value: v}, s.ID)
+	}
+	i.evict()
+}
+
+// removeSeries removes a series which has been deleted from the metastore
+// from the cached postings lists and counts.
+func (i *tagIndex) removeSeries(name string, s *Series) {
+	// Find the tag keys with a value which no longer has any series.
+	var keys []string
+	i.meta.mustView(func(tx *metatx) error {
+		for k, v := range s.Tags {
+			if tx.tagValueSeriesN(i.db.name, name, k, v, 1) == 0 {
+				keys = append(keys, k)
+			}
+		}
+		return nil
+	})
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if _, ok := i.counts[tagIndexKey{}]; ok {
+		i.counts[tagIndexKey{}]--
+	}
+	for _, k := range keys {
+		if _, ok := i.counts[tagIndexKey{measurement: name, key: k}]; ok {
+			i.counts[tagIndexKey{measurement: name, key: k}]--
+		}
+	}
+
+	if elem := i.entries[tagIndexKey{seriesID: s.ID}]; elem != nil {
+		i.remove(elem)
+	}
+	i.removeID(tagIndexKey{measurement: name}, s.ID)
+	for k, v := range s.Tags {
+		i.removeID(tagIndexKey{measurement: name, key: k, value: v}, s.ID)
+	}
+}
+
+// removeID removes an id from a postings list if it is cached.
+func (i *tagIndex) removeID(key tagIndexKey, id uint32) {
+	elem := i.entries[key]
+	if elem == nil {
+		return
+	}
+	if e := elem.Value.(*tagIndexEntry); e.ids.Remove(id) {
+		i.n--
+	}
+}
+
+// append adds an id to a postings list if it is cached.
+func (i *tagIndex) append(key tagIndexKey, id uint32) {
+	elem := i.entries[key]
+	if elem == nil {
+		return
+	}
+	if e := elem.Value.(*tagIndexEntry); e.ids.Add(id) {
+		i.n++
+	}
+}
+
+// get returns a cached entry and marks it as recently used.
+func (i *tagIndex) get(key tagIndexKey) *tagIndexEntry {
+	elem := i.entries[key]
+	if elem == nil {
+		return nil
+	}
+	i.lru.MoveToFront(elem)
+	return elem.Value.(*tagIndexEntry)
+}
+
+// put adds an entry to the cache, replacing any entry with the same key.
+func (i *tagIndex) put(e *tagIndexEntry) {
+	if elem := i.entries[e.key]; elem != nil {
+		i.remove(elem)
+	}
+	i.entries[e.key] = i.lru.PushFront(e)
+	i.n += e.size()
+	i.evict()
+}
+
+// evict removes the least recently used entries until the cache fits its size.
+// The most recently used entry is always kept.
+func (i *tagIndex) evict() {
+	for i.n > i.maxN && i.lru.Len() > 1 {
+		i.remove(i.lru.Back())
+	}
+}
+
+// remove removes an entry from the cache.
+func (i *tagIndex) remove(elem *list.Element) {
+	e := elem.Value.(*tagIndexEntry)
+	i.lru.Remove(elem)
+	delete(i.entries, e.key)
+	i.n -= e.size()
+}
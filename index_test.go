@@ -0,0 +1,236 @@
+package influxdb
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+// Ensure the tag index can find series by tags after being reopened.
+func TestTagIndex(t *testing.T) {
+	m := mustOpenMetastore()
+	defer mustCloseMetastore(m)
+
+	db := mustCreateIndexedDatabase(m, "db0", DefaultIndexCacheSize)
+	mustCreateIndexedSeries(m, db, "cpu", map[string]string{"host": "servera", "region": "uswest"})
+	mustCreateIndexedSeries(m, db, "cpu", map[string]string{"host": "serverb", "region": "uswest"})
+	mustCreateIndexedSeries(m, db, "cpu", map[string]string{"host": "serverc", "region": "useast"})
+	mustCreateIndexedSeries(m, db, "mem", map[string]string{"host": "servera", "service": "redis"})
+	mustCreateIndexedSeries(m, db, "mem", nil)
+
+	// Verify the index before and after reading it from a new database.
+	for i, db := range []*database{db, mustReopenIndexedDatabase(m, "db0", DefaultIndexCacheSize)} {
+		if a := db.Names(); !reflect.DeepEqual(a, []string{"cpu", "mem"}) {
+			t.Fatalf("%d. unexpected names: %v", i, a)
+		}
+		if ids := db.SeriesIDs([]string{"cpu"}, []*TagFilter{{Key: "region", Value: "uswest"}}); !ids.Equals(SeriesIDs{1, 2}) {
+			t.Fatalf("%d. unexpected ids: %v", i, ids)
+		}
+		if ids := db.SeriesIDs([]string{"cpu"}, []*TagFilter{{Key: "host", Regex: regexp.MustCompile(`a|c`)}}); !ids.Equals(SeriesIDs{1, 3}) {
+			t.Fatalf("%d. unexpected regex ids: %v", i, ids)
+		}
+		if ids := db.SeriesIDs([]string{"cpu"}, []*TagFilter{{Key: "host", Value: "servera", Not: true}}); !ids.Equals(SeriesIDs{2, 3}) {
+			t.Fatalf("%d. unexpected not ids: %v", i, ids)
+		}
+		if ids := db.SeriesIDs(nil, nil); !ids.Equals(SeriesIDs{1, 2, 3, 4, 5}) {
+			t.Fatalf("%d. unexpected all ids: %v", i, ids)
+		}
+		if a := db.TagKeys(nil); !reflect.DeepEqual(a, []string{"host", "region", "service"}) {
+			t.Fatalf("%d. unexpected tag keys: %v", i, a)
+		}
+		if a := db.TagValues([]string{"cpu"}, "host", nil).ToSlice(); !reflect.DeepEqual(a, []string{"servera", "serverb", "serverc"}) {
+			t.Fatalf("%d. unexpected tag values: %v", i, a)
+		}
+
+		// Series must only match their exact tagset.
+		if _, s := db.MeasurementAndSeries("cpu", map[string]string{"host": "serverb", "region": "uswest"}); s == nil || s.ID != 2 {
+			t.Fatalf("%d. unexpected series: %#v", i, s)
+		} else if _, s := db.MeasurementAndSeries("cpu", map[string]string{"host": "serverb"}); s != nil {
+			t.Fatalf("%d. unexpected series: %#v", i, s)
+		} else if _, s := db.MeasurementAndSeries("mem", nil); s == nil || s.ID != 5 {
+			t.Fatalf("%d. unexpected series: %#v", i, s)
+		}
+		if m := db.MeasurementBySeriesID(4); m == nil || m.Name != "mem" {
+			t.Fatalf("%d. unexpected measurement: %#v", i, m)
+		}
+	}
+}
+
+// Ensure the tag index only caches up to its size and reads evicted postings again.
+func TestTagIndex_Evict(t *testing.T) {
+	m := mustOpenMetastore()
+	defer mustCloseMetastore(m)
+
+	db := mustCreateIndexedDatabase(m, "db0", 4)
+	for _, host := range []string{"servera", "serverb", "serverc"} {
+		mustCreateIndexedSeries(m, db, "cpu", map[string]string{"host": host, "region": "uswest"})
+	}
+
+	// Reading the postings lists must not exceed the cache size.
+	for i := 0; i < 2; i++ {
+		if ids := db.SeriesIDs([]string{"cpu"}, []*TagFilter{{Key: "region", Value: "uswest"}}); !ids.Equals(SeriesIDs{1, 2, 3}) {
+			t.Fatalf("unexpected ids: %v", ids)
+		}
+		if ids := db.SeriesIDs([]string{"cpu"}, []*TagFilter{{Key: "host", Value: "serverb"}}); !ids.Equals(SeriesIDs{2}) {
+			t.Fatalf("unexpected ids: %v", ids)
+		}
+		if n := db.index.n; n > 4 {
+			t.Fatalf("unexpected cache size: %d", n)
+		}
+	}
+
+	// New series must be added to cached postings lists.
+	mustCreateIndexedSeries(m, db, "cpu", map[string]string{"host": "serverb", "region": "useast"})
+	if ids := db.SeriesIDs([]string{"cpu"}, []*TagFilter{{Key: "host", Value: "serverb"}}); !ids.Equals(SeriesIDs{2, 4}) {
+		t.Fatalf("unexpected ids: %v", ids)
+	}
+}
+
+// Ensure the tag index is built for databases which were stored without one.
+func TestMetatx_IndexDatabases(t *testing.T) {
+	m := mustOpenMetastore()
+	defer mustCloseMetastore(m)
+
+	db := mustCreateIndexedDatabase(m, "db0", DefaultIndexCacheSize)
+	mustCreateIndexedSeries(m, db, "cpu", map[string]string{"host": "servera"})
+	mustCreateIndexedSeries(m, db, "cpu", map[string]string{"host": "serverb"})
+
+	// Remove the index and rebuild it.
+	if err := m.update(func(tx *metatx) error {
+		b := tx.Bucket([]byte("Databases")).Bucket([]byte("db0"))
+		if err := b.DeleteBucket([]byte("TagIndex")); err != nil {
+			return err
+		}
+		if err := b.DeleteBucket([]byte("IDToMeasurement")); err != nil {
+			return err
+		}
+		if err := b.DeleteBucket([]byte("TagBytesToID")); err != nil {
+			return err
+		}
+		if _, err := b.CreateBucket([]byte("TagBytesToID")); err != nil {
+			return err
+		}
+		return tx.indexDatabases()
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	db = mustReopenIndexedDatabase(m, "db0", DefaultIndexCacheSize)
+	if ids := db.SeriesIDs([]string{"cpu"}, []*TagFilter{{Key: "host", Value: "serverb"}}); !ids.Equals(SeriesIDs{2}) {
+		t.Fatalf("unexpected ids: %v", ids)
+	} else if s := db.SeriesByID(1); s == nil || s.Tags["host"] != "servera" || s.measurement.Name != "cpu" {
+		t.Fatalf("unexpected series: %#v", s)
+	} else if _, s := db.MeasurementAndSeries("cpu", map[string]string{"host": "serverb"}); s == nil || s.ID != 2 {
+		t.Fatalf("unexpected series: %#v", s)
+	}
+}
+
+// Ensure series which only differ by escaped characters have different series ids.
+func TestTagIndex_SeriesKey(t *testing.T) {
+	m := mustOpenMetastore()
+	defer mustCloseMetastore(m)
+
+	db := mustCreateIndexedDatabase(m, "db0", DefaultIndexCacheSize)
+	tagsets := []map[string]string{
+		{"host": "a,region=b"},
+		{"host": "a", "region": "b"},
+		{"host": "a|b"},
+		{"host": "a", "b": ""},
+	}
+	for _, tags := range tagsets {
+		mustCreateIndexedSeries(m, db, "cpu", tags)
+	}
+
+	// Look up each series by its tags, with and without the cached series.
+	for i, db := range []*database{db, mustReopenIndexedDatabase(m, "db0", DefaultIndexCacheSize)} {
+		for j, tags := range tagsets {
+			if _, s := db.MeasurementAndSeries("cpu", tags); s == nil || s.ID != uint32(j+1) {
+				t.Fatalf("%d.%d. unexpected series: %#v", i, j, s)
+			}
+		}
+		if _, s := db.MeasurementAndSeries("cpu", map[string]string{"host": "a"}); s != nil {
+			t.Fatalf("%d. unexpected series: %#v", i, s)
+		}
+	}
+}
+
+// Ensure series keys are sorted by tag key and escaped.
+func TestMarshalSeriesKey(t *testing.T) {
+	for i, tt := range []struct {
+		name string
+		tags map[string]string
+		key  string
+	}{
+		{name: "cpu", key: `cpu`},
+		{name: "cpu", tags: map[string]string{"region": "uswest", "host": "servera"}, key: `cpu,host=servera,region=uswest`},
+		{name: "cpu,load", tags: map[string]string{"host": "a,region=b"}, key: `cpu\,load,host=a\,region\=b`},
+		{name: `c\pu`, tags: map[string]string{`k=`: `v\`}, key: `c\\pu,k\==v\\`},
+	} {
+		if key := string(marshalSeriesKey(tt.name, tt.tags)); key != tt.key {
+			t.Errorf("%d. unexpected key: %s", i, key)
+		}
+	}
+}
+
+// mustOpenMetastore opens a metastore at a temporary path. Panic on error.
+func mustOpenMetastore() *metastore {
+	f, err := ioutil.TempFile("", "influxdb-meta-")
+	if err != nil {
+		panic(err.Error())
+	}
+	f.Close()
+	os.Remove(f.Name())
+
+	m := &metastore{}
+	if err := m.open(f.Name()); err != nil {
+		panic(err.Error())
+	}
+	return m
+}
+
+// mustCloseMetastore closes a metastore and removes its file.
+func mustCloseMetastore(m *metastore) {
+	path := m.db.Path()
+	_ = m.close()
+	os.Remove(path)
+}
+
+// mustCreateIndexedDatabase creates a database with a tag index. Panic on error.
+func mustCreateIndexedDatabase(m *metastore, name string, cacheSize int) *database {
+	db := newDatabase()
+	db.name = name
+	db.setIndex(newTagIndex(m, db, cacheSize))
+	if err := m.update(func(tx *metatx) error { return tx.saveDatabase(db) }); err != nil {
+		panic(err.Error())
+	}
+	return db
+}
+
+// mustReopenIndexedDatabase reads a database and its tag index from the metastore.
+func mustReopenIndexedDatabase(m *metastore, name string, cacheSize int) *database {
+	db := newDatabase()
+	db.name = name
+	db.setIndex(newTagIndex(m, db, cacheSize))
+	m.view(func(tx *metatx) error {
+		for _, name := range tx.measurementNames(db.name) {
+			db.createMeasurementIfNotExists(name)
+		}
+		return nil
+	})
+	return db
+}
+
+// mustCreateIndexedSeries creates a series in the metastore and adds it to the database.
+func mustCreateIndexedSeries(m *metastore, db *database, name string, tags map[string]string) *Series {
+	var s *Series
+	if err := m.update(func(tx *metatx) (err error) {
+		s, err = tx.createSeries(db.name, name, tags)
+		return
+	}); err != nil {
+		panic(err.Error())
+	}
+	db.addSeriesToIndex(name, s)
+	return s
+}
@@ -17,6 +17,14 @@ var (
 	// ErrPathRequired is returned when opening a server without a path.
 	ErrPathRequired = errors.New("path required")
 
+	// ErrMetastoreVersionUnsupported is returned when opening a metastore
+	// written by a newer version of the server.
+	ErrMetastoreVersionUnsupported = errors.New("metastore version newer than supported")
+
+	// ErrMetadataVersionUnsupported is returned when importing metadata
+	// exported in an unknown format version.
+	ErrMetadataVersionUnsupported = errors.New("metadata version unsupported")
+
 	// ErrDataNodeURLRequired is returned when creating a data node without a URL.
 	ErrDataNodeURLRequired = errors.New("data node url required")
 
@@ -68,6 +76,10 @@ var (
 	// ErrRetentionPolicyNameRequired is returned using a blank shard space name.
 	ErrRetentionPolicyNameRequired = errors.New("retention policy name required")
 
+	// ErrInvalidShardGroupDuration is returned when a shard group duration is
+	// negative or longer than the retention policy duration.
+	ErrInvalidShardGroupDuration = errors.New("invalid shard group duration")
+
 	// ErrShardNotFound is returned writing to a non-existent shard.
 	ErrShardNotFound = errors.New("shard not found")
 
@@ -75,6 +87,10 @@ var (
 	// data that he or she does not have permission to read.
 	ErrReadAccessDenied = errors.New("read access denied")
 
+	// ErrWriteAccessDenied is returned when a user attempts to write
+	// data that he or she does not have permission to write.
+	ErrWriteAccessDenied = errors.New("write access denied")
+
 	// ErrReadWritePermissionsRequired is returned when required read/write permissions aren't provided.
 	ErrReadWritePermissionsRequired = errors.New("read/write permissions required")
 
@@ -86,6 +102,47 @@ var (
 
 	// ErrSeriesExists is returned when attempting to set the id of a series by database, name and tags that already exists
 	ErrSeriesExists = errors.New("series already exists")
+
+	// ErrMaxSeriesPerDatabaseExceeded is returned when creating a series
+	// would exceed the maximum number of series in a database.
+	ErrMaxSeriesPerDatabaseExceeded = errors.New("max series per database exceeded")
+
+	// ErrMaxValuesPerTagExceeded is returned when creating a series would
+	// exceed the maximum number of values of a tag key in a measurement.
+	ErrMaxValuesPerTagExceeded = errors.New("max values per tag exceeded")
+
+	// ErrMeasurementNotFound is returned when updating a non-existent measurement.
+	ErrMeasurementNotFound = errors.New("measurement not found")
+
+	// ErrFieldNotFound is returned when updating a non-existent field.
+	ErrFieldNotFound = errors.New("field not found")
+
+	// ErrFieldOverflow is returned when too many fields are created on a measurement.
+	ErrFieldOverflow = errors.New("field overflow")
+
+	// ErrFieldTypeConflict is returned when a new field already exists with a different type.
+	ErrFieldTypeConflict = errors.New("field type conflict")
+
+	// ErrConditionNotSupported is returned when executing a statement with a
+	// WHERE clause which is not supported by the statement.
+	ErrConditionNotSupported = errors.New("condition not supported")
+
+	// ErrNotExecuted is returned when a statement is not executed in a query.
+	// This can occur when a previous statement in the same query has errored.
+	ErrNotExecuted = errors.New("not executed")
+
+	// ErrSelectIntoTimeRequired is returned when a SELECT INTO statement returns
+	// points without a timestamp and the query has no lower time bound.
+	ErrSelectIntoTimeRequired = errors.New("select into requires a lower time bound or group by time()")
+
+	// ErrQueryNotFound is returned when killing a non-existent query.
+	ErrQueryNotFound = errors.New("query not found")
+
+	// ErrQueryKilled is returned when a query is killed or its client disconnects.
+	ErrQueryKilled = errors.New("query killed")
+
+	// ErrQueryTimeout is returned when a query runs longer than the query timeout.
+	ErrQueryTimeout = errors.New("query timeout")
 )
 
 // mustMarshal encodes a value to JSON.
@@ -3,6 +3,7 @@ package influxql
 import (
 	"bytes"
 	"fmt"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -47,26 +48,32 @@ type Node interface {
 func (_ *Query) node()     {}
 func (_ Statements) node() {}
 
-func (_ *SelectStatement) node()                {}
-func (_ *DeleteStatement) node()                {}
-func (_ *ListSeriesStatement) node()            {}
-func (_ *ListMeasurementsStatement) node()      {}
-func (_ *ListTagKeysStatement) node()           {}
-func (_ *ListTagValuesStatement) node()         {}
-func (_ *ListFieldKeysStatement) node()         {}
-func (_ *ListFieldValuesStatement) node()       {}
-func (_ *ListContinuousQueriesStatement) node() {}
-func (_ *DropSeriesStatement) node()            {}
-func (_ *DropContinuousQueryStatement) node()   {}
-func (_ *DropDatabaseStatement) node()          {}
-func (_ *DropUserStatement) node()              {}
-func (_ *CreateContinuousQueryStatement) node() {}
-func (_ *CreateDatabaseStatement) node()        {}
-func (_ *CreateUserStatement) node()            {}
-func (_ *CreateRetentionPolicyStatement) node() {}
-func (_ *GrantStatement) node()                 {}
-func (_ *RevokeStatement) node()                {}
-func (_ *AlterRetentionPolicyStatement) node()  {}
+func (_ *SelectStatement) node()                   {}
+func (_ *DeleteStatement) node()                   {}
+func (_ *ExplainStatement) node()                  {}
+func (_ *KillQueryStatement) node()                {}
+func (_ *ShowQueriesStatement) node()              {}
+func (_ *ShowSeriesCardinalityStatement) node()    {}
+func (_ *ShowTagValuesCardinalityStatement) node() {}
+func (_ *ListSeriesStatement) node()               {}
+func (_ *ListMeasurementsStatement) node()         {}
+func (_ *ListTagKeysStatement) node()              {}
+func (_ *ListTagValuesStatement) node()            {}
+func (_ *ListFieldKeysStatement) node()            {}
+func (_ *ListFieldValuesStatement) node()          {}
+func (_ *ListContinuousQueriesStatement) node()    {}
+func (_ *DropSeriesStatement) node()               {}
+func (_ *DropContinuousQueryStatement) node()      {}
+func (_ *DropDatabaseStatement) node()             {}
+func (_ *DropUserStatement) node()                 {}
+func (_ *CreateContinuousQueryStatement) node()    {}
+func (_ *CreateDatabaseStatement) node()           {}
+func (_ *CreateUserStatement) node()               {}
+func (_ *CreateRetentionPolicyStatement) node()    {}
+func (_ *GrantStatement) node()                    {}
+func (_ *RevokeStatement) node()                   {}
+func (_ *AlterRetentionPolicyStatement) node()     {}
+func (_ *AlterMeasurementStatement) node()         {}
 
 func (_ Fields) node()           {}
 func (_ *Field) node()           {}
@@ -74,6 +81,7 @@ func (_ Dimensions) node()       {}
 func (_ *Dimension) node()       {}
 func (_ *Measurement) node()     {}
 func (_ Measurements) node()     {}
+func (_ *Target) node()          {}
 func (_ *Join) node()            {}
 func (_ *Merge) node()           {}
 func (_ *VarRef) node()          {}
@@ -97,6 +105,25 @@ type Query struct {
 // String returns a string representation of the query.
 func (q *Query) String() string { return q.Statements.String() }
 
+// Validate returns the first semantic error in the query's select statements.
+func (q *Query) Validate() error {
+	for _, stmt := range q.Statements {
+		var err error
+		switch stmt := stmt.(type) {
+		case *SelectStatement:
+			err = stmt.Validate()
+		case *ExplainStatement:
+			err = stmt.Statement.Validate()
+		case *CreateContinuousQueryStatement:
+			err = stmt.Source.Validate()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Statements represents a list of statements.
 type Statements []Statement
 
@@ -115,26 +142,32 @@ type Statement interface {
 	stmt()
 }
 
-func (_ *SelectStatement) stmt()                {}
-func (_ *DeleteStatement) stmt()                {}
-func (_ *ListSeriesStatement) stmt()            {}
-func (_ *DropSeriesStatement) stmt()            {}
-func (_ *ListContinuousQueriesStatement) stmt() {}
-func (_ *CreateContinuousQueryStatement) stmt() {}
-func (_ *DropContinuousQueryStatement) stmt()   {}
-func (_ *ListMeasurementsStatement) stmt()      {}
-func (_ *ListTagKeysStatement) stmt()           {}
-func (_ *ListTagValuesStatement) stmt()         {}
-func (_ *ListFieldKeysStatement) stmt()         {}
-func (_ *ListFieldValuesStatement) stmt()       {}
-func (_ *CreateDatabaseStatement) stmt()        {}
-func (_ *CreateUserStatement) stmt()            {}
-func (_ *GrantStatement) stmt()                 {}
-func (_ *RevokeStatement) stmt()                {}
-func (_ *CreateRetentionPolicyStatement) stmt() {}
-func (_ *DropDatabaseStatement) stmt()          {}
-func (_ *DropUserStatement) stmt()              {}
-func (_ *AlterRetentionPolicyStatement) stmt()  {}
+func (_ *SelectStatement) stmt()                   {}
+func (_ *DeleteStatement) stmt()                   {}
+func (_ *ExplainStatement) stmt()                  {}
+func (_ *KillQueryStatement) stmt()                {}
+func (_ *ShowQueriesStatement) stmt()              {}
+func (_ *ShowSeriesCardinalityStatement) stmt()    {}
+func (_ *ShowTagValuesCardinalityStatement) stmt() {}
+func (_ *ListSeriesStatement) stmt()               {}
+func (_ *DropSeriesStatement) stmt()               {}
+func (_ *ListContinuousQueriesStatement) stmt()    {}
+func (_ *CreateContinuousQueryStatement) stmt()    {}
+func (_ *DropContinuousQueryStatement) stmt()      {}
+func (_ *ListMeasurementsStatement) stmt()         {}
+func (_ *ListTagKeysStatement) stmt()              {}
+func (_ *ListTagValuesStatement) stmt()            {}
+func (_ *ListFieldKeysStatement) stmt()            {}
+func (_ *ListFieldValuesStatement) stmt()          {}
+func (_ *CreateDatabaseStatement) stmt()           {}
+func (_ *CreateUserStatement) stmt()               {}
+func (_ *GrantStatement) stmt()                    {}
+func (_ *RevokeStatement) stmt()                   {}
+func (_ *CreateRetentionPolicyStatement) stmt()    {}
+func (_ *DropDatabaseStatement) stmt()             {}
+func (_ *DropUserStatement) stmt()                 {}
+func (_ *AlterRetentionPolicyStatement) stmt()     {}
+func (_ *AlterMeasurementStatement) stmt()         {}
 
 // Expr represents an expression that can be evaluated to a value.
 type Expr interface {
@@ -160,6 +193,7 @@ type Source interface {
 }
 
 func (_ *Measurement) source() {}
+func (_ Measurements) source() {}
 func (_ *Join) source()        {}
 func (_ *Merge) source()       {}
 
@@ -342,6 +376,12 @@ type CreateRetentionPolicyStatement struct {
 	// Replication factor for data written to this policy.
 	Replication int
 
+	// Time range covered by each group of shards. Derived from the duration if zero.
+	ShardGroupDuration time.Duration
+
+	// Tag keys used to choose the split a series is stored in.
+	ShardKey []string
+
 	// Should this policy be set as default for the database?
 	Default bool
 }
@@ -357,6 +397,20 @@ func (s *CreateRetentionPolicyStatement) String() string {
 	_, _ = buf.WriteString(FormatDuration(s.Duration))
 	_, _ = buf.WriteString(" REPLICATION ")
 	_, _ = buf.WriteString(strconv.Itoa(s.Replication))
+	if s.ShardGroupDuration != 0 {
+		_, _ = buf.WriteString(" SHARD DURATION ")
+		_, _ = buf.WriteString(FormatDuration(s.ShardGroupDuration))
+	}
+	if len(s.ShardKey) > 0 {
+		_, _ = buf.WriteString(" SHARD KEY (")
+		for i, key := range s.ShardKey {
+			if i > 0 {
+				_, _ = buf.WriteString(", ")
+			}
+			_, _ = buf.WriteString(QuoteIdent(key))
+		}
+		_, _ = buf.WriteString(")")
+	}
 	if s.Default {
 		_, _ = buf.WriteString(" DEFAULT")
 	}
@@ -377,6 +431,9 @@ type AlterRetentionPolicyStatement struct {
 	// Replication factor for data written to this policy.
 	Replication *int
 
+	// Time range covered by each new group of shards.
+	ShardGroupDuration *time.Duration
+
 	// Should this policy be set as defalut for the database?
 	Default bool
 }
@@ -399,6 +456,11 @@ func (s *AlterRetentionPolicyStatement) String() string {
 		_, _ = buf.WriteString(strconv.Itoa(*s.Replication))
 	}
 
+	if s.ShardGroupDuration != nil {
+		_, _ = buf.WriteString(" SHARD DURATION ")
+		_, _ = buf.WriteString(FormatDuration(*s.ShardGroupDuration))
+	}
+
 	if s.Default {
 		_, _ = buf.WriteString(" DEFAULT")
 	}
@@ -406,11 +468,56 @@ func (s *AlterRetentionPolicyStatement) String() string {
 	return buf.String()
 }
 
+// AlterMeasurementStatement represents a command to set the metadata of a
+// measurement or one of its fields.
+type AlterMeasurementStatement struct {
+	// Name of the measurement to alter.
+	Name string
+
+	// Name of the field to alter. The measurement is altered if blank.
+	Field string
+
+	// New metadata values. Nil values are left unchanged.
+	Unit        *string
+	Description *string
+	Owner       *string
+}
+
+// String returns a string representation of the alter measurement statement.
+func (s *AlterMeasurementStatement) String() string {
+	var buf bytes.Buffer
+	_, _ = buf.WriteString("ALTER MEASUREMENT ")
+	_, _ = buf.WriteString(QuoteIdent(s.Name))
+	if s.Field != "" {
+		_, _ = buf.WriteString(" FIELD ")
+		_, _ = buf.WriteString(QuoteIdent(s.Field))
+	}
+	_, _ = buf.WriteString(" SET ")
+
+	var a []string
+	if s.Unit != nil {
+		a = append(a, "unit = "+Quote(*s.Unit))
+	}
+	if s.Description != nil {
+		a = append(a, "description = "+Quote(*s.Description))
+	}
+	if s.Owner != nil {
+		a = append(a, "owner = "+Quote(*s.Owner))
+	}
+	_, _ = buf.WriteString(strings.Join(a, ", "))
+
+	return buf.String()
+}
+
 // SelectStatement represents a command for extracting data from the database.
 type SelectStatement struct {
 	// Expressions returned from the selection.
 	Fields Fields
 
+	// Target (destination) for the result of the select.
+	// Results are returned to the client if nil.
+	Target *Target
+
 	// Expressions used for grouping the selection.
 	Dimensions Dimensions
 
@@ -426,6 +533,10 @@ type SelectStatement struct {
 	// Maximum number of rows to be returned.
 	// Unlimited if zero.
 	Limit int
+
+	// Time zone used to align GROUP BY time intervals and format timestamps.
+	// Intervals are aligned in UTC if nil.
+	Location *time.Location
 }
 
 // String returns a string representation of the select statement.
@@ -433,6 +544,10 @@ func (s *SelectStatement) String() string {
 	var buf bytes.Buffer
 	_, _ = buf.WriteString("SELECT ")
 	_, _ = buf.WriteString(s.Fields.String())
+	if s.Target != nil {
+		_, _ = buf.WriteString(" INTO ")
+		_, _ = buf.WriteString(s.Target.String())
+	}
 	_, _ = buf.WriteString(" FROM ")
 	_, _ = buf.WriteString(s.Source.String())
 	if s.Condition != nil {
@@ -450,20 +565,270 @@ func (s *SelectStatement) String() string {
 	if s.Limit > 0 {
 		_, _ = fmt.Fprintf(&buf, " LIMIT %d", s.Limit)
 	}
+	if s.Location != nil {
+		_, _ = fmt.Fprintf(&buf, " tz('%s')", s.Location)
+	}
 	return buf.String()
 }
 
+// ExpandSources returns a copy of the statement for each measurement in its
+// source. Regular expressions are expanded to every matching name returned by
+// names for the measurement's database, which is blank for the query's
+// database. Statements with a single measurement name or a join/merge source
+// are returned as-is.
+func (s *SelectStatement) ExpandSources(names func(database string) []string) []*SelectStatement {
+	var measurements Measurements
+	switch src := s.Source.(type) {
+	case *Measurement:
+		if src.Regex == nil {
+			return []*SelectStatement{s}
+		}
+		measurements = Measurements{src}
+	case Measurements:
+		measurements = src
+	default:
+		return []*SelectStatement{s}
+	}
+
+	// Create a statement for each name, skipping duplicates.
+	var a []*SelectStatement
+	m := make(map[string]bool)
+	add := func(src *Measurement, name string) {
+		other := &Measurement{Database: src.Database, RetentionPolicy: src.RetentionPolicy, Name: name}
+		key := other.String()
+		if m[key] {
+			return
+		}
+		m[key] = true
+
+		stmt := *s
+		stmt.Source = other
+		a = append(a, &stmt)
+	}
+	for _, mm := range measurements {
+		if mm.Regex == nil {
+			add(mm, mm.Name)
+			continue
+		}
+		for _, name := range names(mm.Database) {
+			if mm.Regex.MatchString(name) {
+				add(mm, name)
+			}
+		}
+	}
+	return a
+}
+
 // Aggregated returns true if the statement uses aggregate functions.
 func (s *SelectStatement) Aggregated() bool {
 	var v bool
 	WalkFunc(s.Fields, func(n Node) {
-		if _, ok := n.(*Call); ok {
+		if c, ok := n.(*Call); ok && LookupAggregate(c.Name) != nil {
 			v = true
 		}
 	})
 	return v
 }
 
+// Validate returns an error if the statement is semantically invalid, such as
+// calling an unknown function, mixing aggregate and raw fields, or grouping by
+// an expression other than a tag or time(). The error includes the position of
+// the offending node.
+func (s *SelectStatement) Validate() error {
+	if err := s.validateFields(); err != nil {
+		return err
+	}
+	return s.validateDimensions()
+}
+
+// validateFields ensures every call is a supported function with valid
+// arguments and that aggregate and raw fields are not mixed.
+func (s *SelectStatement) validateFields() error {
+	var aggregated, raw bool
+	for _, f := range s.Fields {
+		// The planner can't expand wildcards into the measurement's fields yet.
+		if _, ok := f.Expr.(*Wildcard); ok {
+			return &ValidationError{Message: "wildcard fields are not supported", Pos: f.Pos}
+		}
+
+		call, ref, err := validateExpr(f.Expr)
+		if err != nil {
+			return err
+		}
+		aggregated, raw = aggregated || call, raw || ref
+
+		if aggregated && raw {
+			return &ValidationError{Message: "mixing aggregate and non-aggregate fields is not supported", Pos: f.Pos}
+		}
+	}
+	return nil
+}
+
+// validateExpr validates the calls within an expression. Returns whether the
+// expression contains calls and variable references outside of calls.
+func validateExpr(expr Expr) (call, ref bool, err error) {
+	switch expr := expr.(type) {
+	case *Call:
+		if fn := scalarFuncs[strings.ToLower(expr.Name)]; fn != nil {
+			return validateScalarCall(expr, fn)
+		}
+		if err := validateAggregateCall(expr); err != nil {
+			return false, false, err
+		}
+		return true, false, nil
+	case *VarRef:
+		return false, true, nil
+	case *BinaryExpr:
+		lcall, lref, err := validateExpr(expr.LHS)
+		if err != nil {
+			return false, false, err
+		}
+		rcall, rref, err := validateExpr(expr.RHS)
+		if err != nil {
+			return false, false, err
+		}
+		return lcall || rcall, lref || rref, nil
+	case *ParenExpr:
+		return validateExpr(expr.Expr)
+	}
+	return false, false, nil
+}
+
+// validateAggregateCall validates that an aggregate exists and is passed a
+// field followed by literals matching the aggregate's argument types.
+func validateAggregateCall(expr *Call) error {
+	a := LookupAggregate(expr.Name)
+	if a == nil {
+		return &ValidationError{Message: fmt.Sprintf("function not found: %s()", expr.Name), Pos: expr.Pos}
+	} else if len(expr.Args) != len(a.Args)+1 {
+		if len(a.Args) == 0 {
+			return &ValidationError{Message: fmt.Sprintf("expected one argument for %s()", expr.Name), Pos: expr.Pos}
+		}
+		return &ValidationError{Message: fmt.Sprintf("expected %d arguments for %s()", len(a.Args)+1, expr.Name), Pos: expr.Pos}
+	} else if _, ok := expr.Args[0].(*VarRef); !ok {
+		return &ValidationError{Message: fmt.Sprintf("expected field argument in %s()", expr.Name), Pos: expr.Pos}
+	}
+
+	for i, arg := range expr.Args[1:] {
+		if typ := exprType(arg); typ != a.Args[i] || !isLiteral(arg) {
+			return &ValidationError{Message: fmt.Sprintf("expected %s argument in %s()", a.Args[i], expr.Name), Pos: expr.Pos}
+		}
+	}
+	return nil
+}
+
+// isLiteral returns true if the expression is a literal value.
+func isLiteral(expr Expr) bool {
+	switch expr.(type) {
+	case *NumberLiteral, *StringLiteral, *BooleanLiteral, *TimeLiteral, *DurationLiteral:
+		return true
+	}
+	return false
+}
+
+// validateScalarCall validates the number and types of arguments passed to a
+// scalar function. Argument types are only checked when known without reading
+// field types.
+func validateScalarCall(expr *Call, fn *scalarFunc) (call, ref bool, err error) {
+	if !fn.validArgN(len(expr.Args)) {
+		if fn.variadic {
+			return false, false, &ValidationError{Message: fmt.Sprintf("expected at least %d arguments for %s()", len(fn.args), expr.Name), Pos: expr.Pos}
+		} else if len(fn.args) == 1 {
+			return false, false, &ValidationError{Message: fmt.Sprintf("expected one argument for %s()", expr.Name), Pos: expr.Pos}
+		}
+		return false, false, &ValidationError{Message: fmt.Sprintf("expected %d arguments for %s()", len(fn.args), expr.Name), Pos: expr.Pos}
+	}
+
+	for i, arg := range expr.Args {
+		acall, aref, err := validateExpr(arg)
+		if err != nil {
+			return false, false, err
+		}
+		call, ref = call || acall, ref || aref
+
+		if typ := exprType(arg); typ != Unknown && typ != fn.argType(i) {
+			return false, false, &ValidationError{Message: fmt.Sprintf("expected %s argument in %s()", fn.argType(i), expr.Name), Pos: expr.Pos}
+		}
+	}
+	return call, ref, nil
+}
+
+// exprType returns the data type of an expression's value.
+// Returns Unknown for expressions that depend on field types.
+func exprType(expr Expr) DataType {
+	switch expr := expr.(type) {
+	case *NumberLiteral:
+		return Number
+	case *StringLiteral:
+		return String
+	case *BooleanLiteral:
+		return Boolean
+	case *TimeLiteral:
+		return Time
+	case *DurationLiteral:
+		return Duration
+	case *ParenExpr:
+		return exprType(expr.Expr)
+	case *BinaryExpr:
+		switch expr.Op {
+		case ADD, SUB, MUL, DIV:
+			return Number
+		}
+	case *Call:
+		if fn := scalarFuncs[strings.ToLower(expr.Name)]; fn != nil {
+			return fn.ret
+		} else if a := LookupAggregate(expr.Name); a != nil {
+			return a.Type
+		}
+	}
+	return Unknown
+}
+
+// validateDimensions ensures dimensions are tags, with an optional time()
+// interval first which requires an aggregate.
+func (s *SelectStatement) validateDimensions() error {
+	for i, d := range s.Dimensions {
+		switch expr := d.Expr.(type) {
+		case *VarRef:
+		case *Call:
+			if strings.ToLower(expr.Name) != "time" {
+				return &ValidationError{Message: fmt.Sprintf("invalid dimension: %s", d), Pos: d.Pos}
+			} else if i != 0 {
+				return &ValidationError{Message: "time() must be the first dimension", Pos: d.Pos}
+			} else if !s.Aggregated() {
+				return &ValidationError{Message: "GROUP BY time() requires an aggregate function", Pos: d.Pos}
+			}
+
+			// Ensure there is a positive interval and an optional offset.
+			if len(expr.Args) != 1 && len(expr.Args) != 2 {
+				return &ValidationError{Message: "time dimension expected one or two arguments", Pos: d.Pos}
+			}
+			if lit, ok := expr.Args[0].(*DurationLiteral); !ok || lit.Val <= 0 {
+				return &ValidationError{Message: "time dimension must have a positive duration argument", Pos: d.Pos}
+			}
+			if len(expr.Args) == 2 {
+				if _, ok := expr.Args[1].(*DurationLiteral); !ok {
+					return &ValidationError{Message: "time dimension offset must be a duration", Pos: d.Pos}
+				}
+			}
+		default:
+			return &ValidationError{Message: fmt.Sprintf("invalid dimension: %s", d), Pos: d.Pos}
+		}
+	}
+	return nil
+}
+
+// ValidationError represents a semantic error in a statement.
+type ValidationError struct {
+	Message string
+	Pos     Pos
+}
+
+// Error returns the string representation of the error.
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s at line %d, char %d", e.Message, e.Pos.Line+1, e.Pos.Char+1)
+}
+
 /*
 
 BinaryExpr
@@ -504,6 +869,7 @@ func (s *SelectStatement) Substatement(ref *VarRef) (*SelectStatement, error) {
 		Dimensions: s.Dimensions,
 		Limit:      s.Limit,
 		SortFields: s.SortFields,
+		Location:   s.Location,
 	}
 
 	// If there is only one series source then return it with the whole condition.
@@ -514,8 +880,14 @@ func (s *SelectStatement) Substatement(ref *VarRef) (*SelectStatement, error) {
 	}
 
 	// Find the matching source.
+	// Unprefixed fields in a merge apply to every merged measurement.
 	name := MatchSource(s.Source, ref.Val)
 	if name == "" {
+		if _, ok := s.Source.(*Merge); ok {
+			other.Source = s.Source
+			other.Condition = s.Condition
+			return other, nil
+		}
 		return nil, fmt.Errorf("field source not found: %s", ref.Val)
 	}
 	other.Source = &Measurement{Name: name}
@@ -645,6 +1017,71 @@ func (s *ListSeriesStatement) String() string {
 	return buf.String()
 }
 
+// ExplainStatement represents a command for describing the execution plan of a select.
+type ExplainStatement struct {
+	Statement *SelectStatement
+}
+
+// String returns a string representation of the explain statement.
+func (s *ExplainStatement) String() string { return "EXPLAIN " + s.Statement.String() }
+
+// ShowQueriesStatement represents a command for listing running queries.
+type ShowQueriesStatement struct{}
+
+// String returns a string representation of the show queries statement.
+func (s *ShowQueriesStatement) String() string { return "SHOW QUERIES" }
+
+// ShowSeriesCardinalityStatement represents a command for counting the series in measurements.
+type ShowSeriesCardinalityStatement struct {
+	// Measurements to count series in. All measurements if nil.
+	Source Source
+}
+
+// String returns a string representation of the statement.
+func (s *ShowSeriesCardinalityStatement) String() string {
+	var buf bytes.Buffer
+	_, _ = buf.WriteString("SHOW SERIES CARDINALITY")
+
+	if s.Source != nil {
+		_, _ = buf.WriteString(" FROM ")
+		_, _ = buf.WriteString(s.Source.String())
+	}
+	return buf.String()
+}
+
+// ShowTagValuesCardinalityStatement represents a command for counting the values of tag keys.
+type ShowTagValuesCardinalityStatement struct {
+	// Measurements to count tag values in. All measurements if nil.
+	Source Source
+
+	// Tag key to count the values of. All tag keys if blank.
+	TagKey string
+}
+
+// String returns a string representation of the statement.
+func (s *ShowTagValuesCardinalityStatement) String() string {
+	var buf bytes.Buffer
+	_, _ = buf.WriteString("SHOW TAG VALUES CARDINALITY")
+
+	if s.Source != nil {
+		_, _ = buf.WriteString(" FROM ")
+		_, _ = buf.WriteString(s.Source.String())
+	}
+	if s.TagKey != "" {
+		_, _ = buf.WriteString(" WITH KEY = ")
+		_, _ = buf.WriteString(QuoteIdent(s.TagKey))
+	}
+	return buf.String()
+}
+
+// KillQueryStatement represents a command for stopping a running query.
+type KillQueryStatement struct {
+	QueryID uint64
+}
+
+// String returns a string representation of the kill query statement.
+func (s *KillQueryStatement) String() string { return fmt.Sprintf("KILL QUERY %d", s.QueryID) }
+
 // DropSeriesStatement represents a command for removing a series from the database.
 type DropSeriesStatement struct {
 	Name string
@@ -890,6 +1327,7 @@ func (a Fields) String() string {
 type Field struct {
 	Expr  Expr
 	Alias string
+	Pos   Pos // position in the query, set by the parser
 }
 
 // Name returns the name of the field. Returns alias, if set.
@@ -935,6 +1373,7 @@ func (a Dimensions) String() string {
 // Dimension represents an expression that a select statement is grouped by.
 type Dimension struct {
 	Expr Expr
+	Pos  Pos // position in the query, set by the parser
 }
 
 // String returns a string representation of the dimension.
@@ -953,12 +1392,61 @@ func (a Measurements) String() string {
 }
 
 // Measurement represents a single measurement used as a datasource.
+// If Regex is set then the source is every measurement whose name matches.
+// Blank database and retention policy names use the query's database and
+// that database's default retention policy.
 type Measurement struct {
-	Name string
+	Database        string
+	RetentionPolicy string
+	Name            string
+	Regex           *regexp.Regexp
 }
 
 // String returns a string representation of the measurement.
-func (m *Measurement) String() string { return QuoteIdent(m.Name) }
+// The database and retention policy are always quoted since unquoted
+// identifiers can contain dots.
+func (m *Measurement) String() string {
+	var buf bytes.Buffer
+	if m.Database != "" {
+		_, _ = buf.WriteString(Quote(m.Database))
+		_, _ = buf.WriteString(".")
+	}
+	if m.RetentionPolicy != "" || m.Database != "" {
+		_, _ = buf.WriteString(Quote(m.RetentionPolicy))
+		_, _ = buf.WriteString(".")
+	}
+	if m.Regex != nil {
+		_, _ = buf.WriteString("/" + strings.Replace(m.Regex.String(), "/", `\/`, -1) + "/")
+	} else {
+		_, _ = buf.WriteString(QuoteIdent(m.Name))
+	}
+	return buf.String()
+}
+
+// Target represents the destination of a SELECT INTO statement.
+// Blank database and retention policy names use the query's database and
+// that database's default retention policy.
+type Target struct {
+	Database        string
+	RetentionPolicy string
+	Measurement     string
+}
+
+// String returns a string representation of the target.
+// Segments are always quoted since unquoted identifiers can contain dots.
+func (t *Target) String() string {
+	var buf bytes.Buffer
+	if t.Database != "" {
+		_, _ = buf.WriteString(Quote(t.Database))
+		_, _ = buf.WriteString(".")
+	}
+	if t.RetentionPolicy != "" || t.Database != "" {
+		_, _ = buf.WriteString(Quote(t.RetentionPolicy))
+		_, _ = buf.WriteString(".")
+	}
+	_, _ = buf.WriteString(Quote(t.Measurement))
+	return buf.String()
+}
 
 // Join represents two datasources joined together.
 type Join struct {
@@ -992,6 +1480,7 @@ func (r *VarRef) String() string { return QuoteIdent(r.Val) }
 type Call struct {
 	Name string
 	Args []Expr
+	Pos  Pos // position in the query, set by the parser
 }
 
 // String returns a string representation of the call.
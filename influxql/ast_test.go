@@ -1,6 +1,7 @@
 package influxql_test
 
 import (
+	"reflect"
 	"strings"
 	"testing"
 
@@ -23,6 +24,37 @@ func TestInspectDataType(t *testing.T) {
 }
 
 // Ensure the SELECT statement can extract substatements.
+// Ensure an alter measurement statement can be parsed from its string representation.
+func TestAlterMeasurementStatement_String(t *testing.T) {
+	for i, s := range []string{
+		`ALTER MEASUREMENT cpu SET unit = "percent", description = "CPU \"usage\"", owner = ""`,
+		`ALTER MEASUREMENT "disk io" FIELD "read time" SET unit = "ms"`,
+	} {
+		stmt := MustParseStatement(s)
+		if stmt.String() != s {
+			t.Errorf("%d. unexpected string: %s", i, stmt.String())
+		} else if other := MustParseStatement(stmt.String()); !reflect.DeepEqual(stmt, other) {
+			t.Errorf("%d. unexpected statement: %#v", i, other)
+		}
+	}
+}
+
+func TestRetentionPolicyStatement_String(t *testing.T) {
+	for i, s := range []string{
+		`CREATE RETENTION POLICY policy1 ON testdb DURATION 1h REPLICATION 2`,
+		`CREATE RETENTION POLICY policy1 ON testdb DURATION 1d REPLICATION 1 SHARD KEY (customer, "data center") DEFAULT`,
+		`CREATE RETENTION POLICY policy1 ON testdb DURATION 52w REPLICATION 1 SHARD DURATION 1w`,
+		`ALTER RETENTION POLICY policy1 ON testdb DURATION 30d SHARD DURATION 1d`,
+	} {
+		stmt := MustParseStatement(s)
+		if stmt.String() != s {
+			t.Errorf("%d. unexpected string: %s", i, stmt.String())
+		} else if other := MustParseStatement(stmt.String()); !reflect.DeepEqual(stmt, other) {
+			t.Errorf("%d. unexpected statement: %#v", i, other)
+		}
+	}
+}
+
 func TestSelectStatement_Substatement(t *testing.T) {
 	var tests = []struct {
 		stmt string
@@ -71,6 +103,13 @@ func TestSelectStatement_Substatement(t *testing.T) {
 			expr: &influxql.VarRef{Val: "bb.value"},
 			sub:  `SELECT bb.value FROM bb WHERE ((bb.host = "serverb" OR bb.host = "serverc")) AND 1.000 = 2.000`,
 		},
+
+		// 6. Merge with unprefixed field
+		{
+			stmt: `SELECT value FROM merge(aa, bb) WHERE host = "servera"`,
+			expr: &influxql.VarRef{Val: "value"},
+			sub:  `SELECT value FROM merge(aa, bb) WHERE host = "servera"`,
+		},
 	}
 
 	for i, tt := range tests {
@@ -93,6 +132,115 @@ func TestSelectStatement_Substatement(t *testing.T) {
 	}
 }
 
+// Ensure a select statement is expanded for each of its measurements.
+func TestSelectStatement_ExpandSources(t *testing.T) {
+	var tests = []struct {
+		stmt string
+		exp  []string
+	}{
+		// 0. Single measurement
+		{
+			stmt: `SELECT value FROM cpu`,
+			exp:  []string{`SELECT value FROM cpu`},
+		},
+
+		// 1. Regex
+		{
+			stmt: `SELECT value FROM /^cpu/ WHERE host = 'servera'`,
+			exp: []string{
+				`SELECT value FROM cpu.idle WHERE host = "servera"`,
+				`SELECT value FROM cpu.load WHERE host = "servera"`,
+			},
+		},
+
+		// 2. List of names and regexes, without duplicates
+		{
+			stmt: `SELECT value FROM mem, /^cpu/, cpu.load`,
+			exp: []string{
+				`SELECT value FROM mem`,
+				`SELECT value FROM cpu.idle`,
+				`SELECT value FROM cpu.load`,
+			},
+		},
+
+		// 3. No matches
+		{
+			stmt: `SELECT value FROM /^disk/`,
+			exp:  nil,
+		},
+
+		// 4. Regex in another database and retention policy
+		{
+			stmt: `SELECT value FROM "db1"."rp_1y"./^cpu/`,
+			exp:  []string{`SELECT value FROM "db1"."rp_1y"."cpu_1h"`},
+		},
+
+		// 5. Merge
+		{
+			stmt: `SELECT value FROM merge(cpu.idle, cpu.load)`,
+			exp:  []string{`SELECT value FROM merge(cpu.idle, cpu.load)`},
+		},
+	}
+
+	names := func(database string) []string {
+		if database == "db1" {
+			return []string{"cpu_1h", "mem_1h"}
+		}
+		return []string{"cpu.idle", "cpu.load", "mem"}
+	}
+	for i, tt := range tests {
+		var a []string
+		for _, stmt := range MustParseSelectStatement(tt.stmt).ExpandSources(names) {
+			a = append(a, stmt.String())
+		}
+		if !reflect.DeepEqual(tt.exp, a) {
+			t.Errorf("%d. %q: unexpected statements:\n\nexp=%#v\n\ngot=%#v\n\n", i, tt.stmt, tt.exp, a)
+		}
+	}
+}
+
+// Ensure a select statement can be validated.
+func TestSelectStatement_Validate(t *testing.T) {
+	var tests = []struct {
+		s   string
+		err string
+	}{
+		{s: `SELECT value FROM cpu GROUP BY host`},
+		{s: `SELECT sum(value) * 2 FROM cpu GROUP BY time(1m, 30s), host`},
+		{s: `SELECT value, value * 2 FROM cpu`},
+		{s: `SELECT * FROM cpu`, err: `wildcard fields are not supported at line 1, char 8`},
+		{s: `SELECT foo(value) FROM cpu`, err: `function not found: foo() at line 1, char 8`},
+		{s: `SELECT value + sum(value, 2) FROM cpu`, err: `expected one argument for sum() at line 1, char 16`},
+		{s: `SELECT sum(count(value)) FROM cpu`, err: `expected field argument in sum() at line 1, char 8`},
+		{s: `SELECT sum(value), value FROM cpu`, err: `mixing aggregate and non-aggregate fields is not supported at line 1, char 20`},
+		{s: `SELECT sum(value) + value FROM cpu`, err: `mixing aggregate and non-aggregate fields is not supported at line 1, char 8`},
+		{s: `SELECT round(sum(value)) FROM cpu GROUP BY time(1m)`},
+		{s: `SELECT concat(host, "-", lower(region)) FROM cpu`},
+		{s: `SELECT pow(value) FROM cpu`, err: `expected 2 arguments for pow() at line 1, char 8`},
+		{s: `SELECT concat(host) FROM cpu`, err: `expected at least 2 arguments for concat() at line 1, char 8`},
+		{s: `SELECT abs() FROM cpu`, err: `expected one argument for abs() at line 1, char 8`},
+		{s: `SELECT upper(sum(value)) FROM cpu`, err: `expected string argument in upper() at line 1, char 8`},
+		{s: `SELECT round("x") FROM cpu`, err: `expected number argument in round() at line 1, char 8`},
+		{s: `SELECT round(value), sum(value) FROM cpu`, err: `mixing aggregate and non-aggregate fields is not supported at line 1, char 22`},
+		{s: `SELECT round(value) FROM cpu GROUP BY time(1m)`, err: `GROUP BY time() requires an aggregate function at line 1, char 39`},
+		{s: `SELECT value FROM cpu GROUP BY time(1m)`, err: `GROUP BY time() requires an aggregate function at line 1, char 32`},
+		{s: `SELECT sum(value) FROM cpu GROUP BY host, time(1m)`, err: `time() must be the first dimension at line 1, char 43`},
+		{s: `SELECT sum(value) FROM cpu GROUP BY time(host)`, err: `time dimension must have a positive duration argument at line 1, char 37`},
+		{s: `SELECT sum(value) FROM cpu GROUP BY time(1m, host)`, err: `time dimension offset must be a duration at line 1, char 37`},
+		{s: `SELECT sum(value) FROM cpu GROUP BY 10h`, err: `invalid dimension: 10h at line 1, char 37`},
+		{s: `SELECT sum(value) FROM cpu GROUP BY host(1)`, err: `invalid dimension: host(1.000) at line 1, char 37`},
+	}
+
+	for i, tt := range tests {
+		err := MustParseSelectStatement(tt.s).Validate()
+		if errstring(err) != tt.err {
+			t.Errorf("%d. %s: error mismatch:\n  exp=%s\n  got=%s", i, tt.s, tt.err, errstring(err))
+		} else if _, ok := err.(*influxql.ValidationError); err != nil && !ok {
+			t.Errorf("%d. %s: unexpected error type: %T", i, tt.s, err)
+		}
+	}
+}
+
 // Ensure an expression can be folded.
 func TestFold(t *testing.T) {
 	for i, tt := range []struct {
@@ -0,0 +1,252 @@
+package influxql
+
+import (
+	"container/list"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxResultCacheLogN is the number of recent invalidations kept per database.
+// Buckets computed by queries planned before the oldest kept invalidation are
+// not cached because they may be stale.
+const maxResultCacheLogN = 1000
+
+// ResultCache caches the reduced values of GROUP BY time buckets which are
+// fully in the past so that repeated queries only map their newest buckets.
+// Buckets are evicted in least recently used order once the cache is full.
+type ResultCache struct {
+	mu      sync.Mutex
+	maxN    int                              // maximum number of buckets
+	lru     *list.List                       // buckets, most recently used first
+	buckets map[resultCacheKey]*list.Element // buckets by key
+	dbs     map[string]*resultCacheDB        // invalidation state by database
+	stats   ResultCacheStats
+}
+
+// NewResultCache returns a new instance of ResultCache holding up to maxN buckets.
+func NewResultCache(maxN int) *ResultCache {
+	return &ResultCache{
+		maxN:    maxN,
+		lru:     list.New(),
+		buckets: make(map[resultCacheKey]*list.Element),
+		dbs:     make(map[string]*resultCacheDB),
+	}
+}
+
+// ResultCacheStats represents statistics about the use of a ResultCache.
+type ResultCacheStats struct {
+	Hits      int64 `json:"hits"`      // buckets read from the cache
+	Misses    int64 `json:"misses"`    // buckets looked up but not found
+	Evictions int64 `json:"evictions"` // buckets removed to stay within the size
+	N         int   `json:"n"`         // buckets currently cached
+}
+
+// Stats returns the cache statistics.
+func (c *ResultCache) Stats() ResultCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.N = c.lru.Len()
+	return stats
+}
+
+// Invalidate removes the cached buckets of a database which hold timestamp t.
+// This must be called when a point is written so cached buckets are not stale.
+func (c *ResultCache) Invalidate(database string, t time.Time) {
+	c.invalidate(database, t.UnixNano())
+}
+
+// InvalidateDatabase removes all cached buckets of a database.
+// This must be called when data is deleted.
+func (c *ResultCache) InvalidateDatabase(database string) {
+	c.invalidate(database, math.MinInt64)
+}
+
+// invalidate removes the buckets of a database which hold t, or every bucket
+// if t is the minimum timestamp.
+func (c *ResultCache) invalidate(database string, t int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Ignore databases which have never been queried.
+	db := c.dbs[database]
+	if db == nil {
+		return
+	}
+
+	// Record the invalidation for queries which may be computing its bucket.
+	// Queries only cache buckets which end before the time they were planned.
+	if t < db.limit {
+		db.seq++
+		db.log = append(db.log, resultCacheInvalidation{seq: db.seq, t: t})
+		if len(db.log) > maxResultCacheLogN {
+			db.log = db.log[len(db.log)-maxResultCacheLogN:]
+		}
+	}
+
+	// Writes after every cached bucket don't remove anything.
+	if t >= db.max {
+		return
+	}
+	for key, elem := range db.buckets {
+		if t == math.MinInt64 || (key.min <= t && t < key.max) {
+			c.remove(elem)
+		}
+	}
+}
+
+// begin records the start of a query which caches buckets ending before limit.
+// Returns the invalidation sequence to pass to put().
+func (c *ResultCache) begin(database string, limit int64) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	db := c.dbs[database]
+	if db == nil {
+		db = &resultCacheDB{buckets: make(map[resultCacheKey]*list.Element), max: math.MinInt64}
+		c.dbs[database] = db
+	}
+	if limit > db.limit {
+		db.limit = limit
+	}
+	return db.seq
+}
+
+// get returns a cached bucket by key. Returns nil if the bucket is not cached.
+func (c *ResultCache) get(key resultCacheKey) *resultCacheBucket {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem := c.buckets[key]
+	if elem == nil {
+		c.stats.Misses++
+		return nil
+	}
+	c.stats.Hits++
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*resultCacheBucket)
+}
+
+// put adds buckets computed by a query which called begin() with seq and limit.
+// The buckets are discarded if the database was invalidated before limit since
+// the query began.
+func (c *ResultCache) put(database string, seq uint64, limit int64, buckets []*resultCacheBucket) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	db := c.dbs[database]
+	if db == nil {
+		return
+	} else if db.seq != seq {
+		if len(db.log) == 0 || db.log[0].seq > seq+1 {
+			return
+		}
+		for _, inv := range db.log {
+			if inv.seq > seq && inv.t < limit {
+				return
+			}
+		}
+	}
+
+	for _, b := range buckets {
+		if elem := c.buckets[b.key]; elem != nil {
+			c.remove(elem)
+		}
+		elem := c.lru.PushFront(b)
+		c.buckets[b.key] = elem
+		db.buckets[b.key] = elem
+		if b.key.max > db.max {
+			db.max = b.key.max
+		}
+	}
+
+	// Evict the least recently used buckets.
+	for c.maxN > 0 && c.lru.Len() > c.maxN {
+		c.remove(c.lru.Back())
+		c.stats.Evictions++
+	}
+}
+
+// remove removes a bucket from the cache.
+func (c *ResultCache) remove(elem *list.Element) {
+	b := elem.Value.(*resultCacheBucket)
+	c.lru.Remove(elem)
+	delete(c.buckets, b.key)
+	delete(c.dbs[b.key.database].buckets, b.key)
+}
+
+// resultCacheKey identifies a single bucket of a statement.
+type resultCacheKey struct {
+	database string
+	stmt     string // statement without its time range
+	min, max int64  // bucket time range
+}
+
+// resultCacheBucket represents the reduced values of a bucket for each tagset.
+type resultCacheBucket struct {
+	key     resultCacheKey
+	tagsets []string        // encoded tagsets, sorted
+	values  [][]interface{} // values by tagset, indexed by field
+}
+
+// Len implements sort.Interface to sort the bucket by tagset.
+func (b *resultCacheBucket) Len() int           { return len(b.tagsets) }
+func (b *resultCacheBucket) Less(i, j int) bool { return b.tagsets[i] < b.tagsets[j] }
+func (b *resultCacheBucket) Swap(i, j int) {
+	b.tagsets[i], b.tagsets[j] = b.tagsets[j], b.tagsets[i]
+	b.values[i], b.values[j] = b.values[j], b.values[i]
+}
+
+// resultCacheDB represents the cached buckets & invalidations of a database.
+type resultCacheDB struct {
+	buckets map[resultCacheKey]*list.Element // cached buckets
+	max     int64                            // highest cached bucket end time
+	limit   int64                            // highest end time cacheable by a query
+	seq     uint64                           // number of recorded invalidations
+	log     []resultCacheInvalidation        // recent invalidations, oldest first
+}
+
+// resultCacheInvalidation represents an invalidation of the buckets holding t.
+type resultCacheInvalidation struct {
+	seq uint64
+	t   int64
+}
+
+// normalizeStatement returns the statement without its time range so that
+// queries over different time ranges share cached buckets.
+func normalizeStatement(stmt *SelectStatement) string {
+	other := *stmt
+	other.Condition = removeTimeExpr(stmt.Condition)
+	return other.String()
+}
+
+// removeTimeExpr returns expr without the time comparisons joined to it by AND.
+// Returns nil if the entire expression is removed.
+func removeTimeExpr(expr Expr) Expr {
+	switch expr := expr.(type) {
+	case *BinaryExpr:
+		if expr.Op == AND {
+			lhs, rhs := removeTimeExpr(expr.LHS), removeTimeExpr(expr.RHS)
+			if lhs == nil {
+				return rhs
+			} else if rhs == nil {
+				return lhs
+			}
+			return &BinaryExpr{Op: AND, LHS: lhs, RHS: rhs}
+		}
+		for _, e := range []Expr{expr.LHS, expr.RHS} {
+			if ref, ok := e.(*VarRef); ok && strings.ToLower(ref.Val) == "time" {
+				return nil
+			}
+		}
+	case *ParenExpr:
+		e := removeTimeExpr(expr.Expr)
+		if e == nil {
+			return nil
+		}
+		return &ParenExpr{Expr: e}
+	}
+	return expr
+}
@@ -5,8 +5,12 @@ import (
 	"errors"
 	"fmt"
 	"hash/fnv"
+	"io"
+	"math"
 	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -26,6 +30,101 @@ type DB interface {
 	CreateIterator(id uint32, fieldID uint8, typ DataType, min, max time.Time, interval time.Duration) Iterator
 }
 
+// RemoteDB is implemented by a DB that stores some series data on other nodes.
+// Iterators returned by CreateIterator must only read data stored locally.
+type RemoteDB interface {
+	DB
+
+	// Returns a mapper for each part of a series within a time range
+	// that is stored on another node.
+	CreateRemoteMappers(seriesID uint32, min, max time.Time) []RemoteMapper
+}
+
+// RemoteMapper represents a mapper that runs on another node.
+type RemoteMapper interface {
+	// Runs the mapper spec and calls fn with each chunk of values emitted.
+	// Values are in time order. Execution stops if fn returns false or once
+	// closing is closed.
+	Run(spec *MapperSpec, closing <-chan struct{}, fn func([]MapperValue) bool) error
+}
+
+// MapperSpec describes the work of a single mapper so that it can be run
+// on the node which stores the series data.
+type MapperSpec struct {
+	SeriesID uint32        `json:"seriesID"`
+	FieldID  uint8         `json:"fieldID"`
+	Type     DataType      `json:"type"`
+	Min      time.Time     `json:"min"`
+	Max      time.Time     `json:"max"`
+	Interval time.Duration `json:"interval"`
+	Offset   time.Duration `json:"offset,omitempty"`
+	Location string        `json:"location,omitempty"`
+	MapFunc  string        `json:"mapFunc"`
+	MapArgs  []interface{} `json:"mapArgs,omitempty"`
+}
+
+// MapperValue represents a single value emitted by a mapper.
+type MapperValue struct {
+	Key   int64       `json:"key"`
+	Value interface{} `json:"value"`
+}
+
+// RunMapper runs a mapper spec against the local data in db and calls fn
+// with each chunk of values emitted. Values are sent at the end of each
+// interval or once chunkSize values have been emitted, if greater than zero.
+// Execution stops if fn returns false.
+func RunMapper(db DB, spec *MapperSpec, chunkSize int, fn func([]MapperValue) bool) error {
+	mfn := mapFuncs[spec.MapFunc]
+	if a := lookupAggregateByMapName(spec.MapFunc); mfn == nil && a != nil {
+		mfn = a.mapFunc(spec.MapArgs)
+	} else if mfn == nil {
+		return fmt.Errorf("map function not found: %s", spec.MapFunc)
+	}
+
+	// Create a standalone executor to run the mapper.
+	e := &Executor{
+		ChunkSize: chunkSize,
+		db:        db,
+		min:       spec.Min,
+		max:       spec.Max,
+		interval:  spec.Interval,
+		offset:    spec.Offset,
+		tagsets:   make(map[string]*tagsetProgress),
+	}
+	if spec.Location != "" {
+		loc, err := time.LoadLocation(spec.Location)
+		if err != nil {
+			return err
+		}
+		e.location = loc
+	}
+	m := newMapper(e, spec.SeriesID, spec.FieldID, spec.Type)
+	m.fn, m.fnName, m.args = mfn, spec.MapFunc, spec.MapArgs
+	m.key = make([]byte, 8)
+	e.addTagsetMapper("")
+
+	m.start()
+	defer m.stop()
+
+	// Send values in key order.
+	for data := range m.C() {
+		keys := make([]string, 0, len(data))
+		for k := range data {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		values := make([]MapperValue, len(keys))
+		for i, k := range keys {
+			values[i] = MapperValue{Key: int64(binary.BigEndian.Uint64([]byte(k))), Value: data[k]}
+		}
+		if !fn(values) {
+			break
+		}
+	}
+	return e.error()
+}
+
 // Planner represents an object for creating execution plans.
 type Planner struct {
 	// The underlying storage that holds series and field meta data.
@@ -33,6 +132,17 @@ type Planner struct {
 
 	// Returns the current time. Defaults to time.Now().
 	Now func() time.Time
+
+	// Limits on the resources used by a single query.
+	// A zero value disables the limit.
+	MaxSeriesN  int // series read across all fields
+	MaxBucketsN int // GROUP BY time intervals
+	MaxPointsN  int // points scanned across all series
+
+	// Caches the values of GROUP BY time buckets which are fully in the past.
+	// Cached buckets are scoped to Database. A nil cache disables caching.
+	Cache    *ResultCache
+	Database string
 }
 
 // NewPlanner returns a new instance of Planner.
@@ -44,16 +154,27 @@ func NewPlanner(db DB) *Planner {
 }
 
 func (p *Planner) Plan(stmt *SelectStatement) (*Executor, error) {
+	// Ensure the statement is valid before planning it.
+	if err := stmt.Validate(); err != nil {
+		return nil, err
+	}
+
 	// Create the executor.
 	e := &Executor{
 		db:         p.DB,
 		stmt:       stmt,
 		processors: make([]processor, len(stmt.Fields)),
+		closing:    make(chan struct{}, 0),
+		tagsets:    make(map[string]*tagsetProgress),
+		maxPointN:  int64(p.MaxPointsN),
 	}
 
 	// Fold conditional.
 	now := p.Now()
 	stmt.Condition = Fold(stmt.Condition, &now)
+	if stmt.Condition != nil {
+		e.condition = stmt.Condition.String()
+	}
 
 	// Extract the time range.
 	min, max := TimeRange(stmt.Condition)
@@ -66,11 +187,22 @@ func (p *Planner) Plan(stmt *SelectStatement) (*Executor, error) {
 	e.min, e.max = min, max
 
 	// Determine group by interval.
-	interval, tags, err := p.normalizeDimensions(stmt.Dimensions)
+	interval, offset, tags, err := p.normalizeDimensions(stmt.Dimensions)
 	if err != nil {
 		return nil, err
 	}
-	e.interval, e.tags = interval, tags
+	e.interval, e.offset, e.tags = interval, offset, tags
+	e.location = stmt.Location
+
+	// Ensure the number of intervals is within the limit.
+	if n := e.bucketN(); p.MaxBucketsN > 0 && n > int64(p.MaxBucketsN) {
+		return nil, fmt.Errorf("max buckets exceeded: %d buckets, limit is %d", n, p.MaxBucketsN)
+	}
+
+	// Read the closed buckets at the start of the time range from the cache.
+	if p.Cache != nil {
+		e.readCache(p.Cache, p.Database, now)
+	}
 
 	// Generate a processor for each field.
 	for i, f := range stmt.Fields {
@@ -84,30 +216,42 @@ func (p *Planner) Plan(stmt *SelectStatement) (*Executor, error) {
 	return e, nil
 }
 
-// normalizeDimensions extacts the time interval, if specified.
+// normalizeDimensions extacts the time interval and offset, if specified.
 // Returns all remaining dimensions.
-func (p *Planner) normalizeDimensions(dimensions Dimensions) (time.Duration, []string, error) {
+func (p *Planner) normalizeDimensions(dimensions Dimensions) (time.Duration, time.Duration, []string, error) {
 	// Ignore if there are no dimensions.
 	if len(dimensions) == 0 {
-		return 0, nil, nil
+		return 0, 0, nil, nil
 	}
 
-	// If the first dimension is a "time(duration)" then extract the duration.
+	// If the first dimension is a "time(duration[, offset])" then extract the
+	// duration and the optional offset.
 	if call, ok := dimensions[0].Expr.(*Call); ok && strings.ToLower(call.Name) == "time" {
-		// Make sure there is exactly one argument.
-		if len(call.Args) != 1 {
-			return 0, nil, errors.New("time dimension expected one argument")
+		// Make sure there are one or two arguments.
+		if len(call.Args) != 1 && len(call.Args) != 2 {
+			return 0, 0, nil, errors.New("time dimension expected one or two arguments")
 		}
 
-		// Ensure the argument is a duration.
+		// Ensure the arguments are durations.
 		lit, ok := call.Args[0].(*DurationLiteral)
 		if !ok {
-			return 0, nil, errors.New("time dimension must have one duration argument")
+			return 0, 0, nil, errors.New("time dimension must have a duration argument")
+		} else if lit.Val <= 0 {
+			return 0, 0, nil, errors.New("time dimension must have a positive duration")
+		}
+
+		var offset time.Duration
+		if len(call.Args) == 2 {
+			other, ok := call.Args[1].(*DurationLiteral)
+			if !ok {
+				return 0, 0, nil, errors.New("time dimension offset must be a duration")
+			}
+			offset = other.Val
 		}
-		return lit.Val, dimensionKeys(dimensions[1:]), nil
+		return lit.Val, offset, dimensionKeys(dimensions[1:]), nil
 	}
 
-	return 0, dimensionKeys(dimensions), nil
+	return 0, 0, dimensionKeys(dimensions), nil
 }
 
 // planField returns a processor for field.
@@ -119,7 +263,7 @@ func (p *Planner) planField(e *Executor, f *Field) (processor, error) {
 func (p *Planner) planExpr(e *Executor, expr Expr) (processor, error) {
 	switch expr := expr.(type) {
 	case *VarRef:
-		panic("TODO")
+		return p.planVarRef(e, expr)
 	case *Call:
 		return p.planCall(e, expr)
 	case *BinaryExpr:
@@ -140,25 +284,131 @@ func (p *Planner) planExpr(e *Executor, expr Expr) (processor, error) {
 	panic("unreachable")
 }
 
+// planVarRef generates a processor for a raw field reference.
+func (p *Planner) planVarRef(e *Executor, ref *VarRef) (processor, error) {
+	r, err := p.planReducer(e, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	// Raw fields pass each point through unchanged.
+	r.fn, r.fnName = reduceRaw, "reduceRaw"
+	for _, m := range r.mappers {
+		m.fn, m.fnName = mapRaw, "mapRaw"
+	}
+
+	return r, nil
+}
+
 // planCall generates a processor for a function call.
 func (p *Planner) planCall(e *Executor, c *Call) (processor, error) {
-	// Ensure there is a single argument.
-	if len(c.Args) != 1 {
-		return nil, fmt.Errorf("expected one argument for %s()", c.Name)
+	// Scalar functions are applied to the output of their arguments.
+	if fn := scalarFuncs[strings.ToLower(c.Name)]; fn != nil {
+		return p.planScalarCall(e, c, fn)
 	}
 
-	// Ensure the argument is a variable reference.
+	// Lookup the aggregate.
+	a := LookupAggregate(c.Name)
+	if a == nil {
+		return nil, fmt.Errorf("function not found: %q", c.Name)
+	}
+
+	// Ensure there is a field argument followed by the aggregate's arguments.
+	if len(c.Args) != len(a.Args)+1 {
+		return nil, fmt.Errorf("expected %d arguments for %s()", len(a.Args)+1, c.Name)
+	}
 	ref, ok := c.Args[0].(*VarRef)
 	if !ok {
 		return nil, fmt.Errorf("expected field argument in %s()", c.Name)
 	}
+	args := make([]interface{}, len(a.Args))
+	for i, arg := range c.Args[1:] {
+		if args[i] = literalValue(arg); InspectDataType(args[i]) != a.Args[i] {
+			return nil, fmt.Errorf("expected %s argument in %s()", a.Args[i], c.Name)
+		}
+	}
+
+	// Generate a reducer for the field.
+	r, err := p.planReducer(e, ref)
+	if err != nil {
+		return nil, err
+	} else if a.Field != Unknown && r.typ != a.Field {
+		return nil, fmt.Errorf("expected %s field in %s(), got %s", a.Field, c.Name, r.typ)
+	}
+
+	// Set the aggregate's map & reduce functions.
+	r.typ = a.Type
+	r.fn, r.fnName = a.reduceFunc(args), a.reduceName
+	for _, m := range r.mappers {
+		m.fn, m.fnName, m.args = a.mapFunc(args), a.mapName, args
+	}
+
+	return r, nil
+}
+
+// planScalarCall generates a processor for a scalar function call.
+// Each argument is planned separately and must match the function's types.
+func (p *Planner) planScalarCall(e *Executor, c *Call, fn *scalarFunc) (processor, error) {
+	if !fn.validArgN(len(c.Args)) {
+		return nil, fmt.Errorf("invalid number of arguments for %s()", c.Name)
+	}
+
+	args := make([]processor, len(c.Args))
+	for i, arg := range c.Args {
+		a, err := p.planExpr(e, arg)
+		if err != nil {
+			return nil, err
+		}
+		if typ := processorType(a); typ != fn.argType(i) {
+			return nil, fmt.Errorf("expected %s argument in %s(), got %s", fn.argType(i), c.Name, typ)
+		}
+		args[i] = a
+	}
+
+	return newScalarFuncEvaluator(e, c.Name, fn, args), nil
+}
+
+// literalValue returns the value of a literal expression.
+// Returns nil if the expression is not a literal.
+func literalValue(expr Expr) interface{} {
+	switch expr := expr.(type) {
+	case *NumberLiteral:
+		return expr.Val
+	case *StringLiteral:
+		return expr.Val
+	case *BooleanLiteral:
+		return expr.Val
+	case *TimeLiteral:
+		return expr.Val
+	case *DurationLiteral:
+		return expr.Val
+	}
+	return nil
+}
 
-	// Extract the substatement for the call.
+// planReducer generates a reducer with a mapper for each series matching a field.
+// The caller is responsible for setting the map & reduce functions.
+func (p *Planner) planReducer(e *Executor, ref *VarRef) (*reducer, error) {
+	// Extract the substatement for the field.
 	sub, err := e.stmt.Substatement(ref)
 	if err != nil {
 		return nil, err
 	}
-	name := sub.Source.(*Measurement).Name
+
+	// Determine the measurements to read from. A merge reads all of its
+	// measurements into a single unnamed series.
+	var name string
+	var names []string
+	switch src := sub.Source.(type) {
+	case *Measurement:
+		name, names = src.Name, []string{src.Name}
+	case *Merge:
+		for _, m := range src.Measurements {
+			names = append(names, m.Name)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported source: %s", sub.Source)
+	}
 
 	// Extract tags from conditional.
 	tags := make(map[string]string)
@@ -168,44 +418,58 @@ func (p *Planner) planCall(e *Executor, c *Call) (processor, error) {
 	}
 	sub.Condition = condition
 
-	// Find field.
-	fname := strings.TrimPrefix(ref.Val, name+".")
-	fieldID, typ := e.db.Field(name, fname)
-	if fieldID == 0 {
-		return nil, fmt.Errorf("field not found: %s.%s", name, fname)
-	}
-
-	// Generate a reducer for the given function.
+	// Generate a reducer for the field.
 	r := newReducer(e)
 	r.stmt = sub
-
-	// Retrieve a list of series data ids.
-	seriesIDs := p.DB.MatchSeries(name, tags)
-
-	// Generate mappers for each id.
-	r.mappers = make([]*mapper, len(seriesIDs))
-	for i, seriesID := range seriesIDs {
-		m := newMapper(e, seriesID, fieldID, typ)
-		m.min, m.max = e.min.UnixNano(), e.max.UnixNano()
-		m.interval = int64(e.interval)
-		m.key = append(make([]byte, 8), marshalStrings(p.DB.SeriesTagValues(seriesID, e.tags))...)
-		r.mappers[i] = m
-	}
-
-	// Set the appropriate reducer function.
-	switch strings.ToLower(c.Name) {
-	case "count":
-		r.fn = reduceSum
-		for _, m := range r.mappers {
-			m.fn = mapCount
+	r.sourceName = name
+
+	// Generate mappers for each series in each measurement.
+	var found bool
+	for _, name := range names {
+		// Find field. Measurements in a merge without the field are skipped.
+		fname := strings.TrimPrefix(ref.Val, name+".")
+		fieldID, typ := e.db.Field(name, fname)
+		if fieldID == 0 {
+			continue
+		} else if !found {
+			r.typ = typ
 		}
-	case "sum":
-		r.fn = reduceSum
-		for _, m := range r.mappers {
-			m.fn = mapSum
+		found = true
+
+		// Retrieve a list of series data ids.
+		// Ensure the total number of series read by the query is within the limit.
+		// Series are sorted so raw values line up across processors.
+		ids := p.DB.MatchSeries(name, tags)
+		sort.Sort(uint32Slice(ids))
+		e.seriesN += len(ids)
+		if p.MaxSeriesN > 0 && e.seriesN > p.MaxSeriesN {
+			return nil, fmt.Errorf("max series exceeded: %d series, limit is %d", e.seriesN, p.MaxSeriesN)
+		}
+
+		for _, seriesID := range ids {
+			m := newMapper(e, seriesID, fieldID, typ)
+			m.min, m.max = e.min.UnixNano(), e.max.UnixNano()
+			m.interval = int64(e.interval)
+			m.key = append(make([]byte, 8), marshalStrings(p.DB.SeriesTagValues(seriesID, e.tags))...)
+			r.mappers = append(r.mappers, m)
+			e.addTagsetMapper(string(m.key[8:]))
+
+			// Add mappers for parts of the series stored on other nodes.
+			if db, ok := p.DB.(RemoteDB); ok {
+				for _, rm := range db.CreateRemoteMappers(seriesID, e.min, e.max) {
+					other := newMapper(e, seriesID, fieldID, typ)
+					other.key = append(make([]byte, 8), m.key[8:]...)
+					other.remote = rm
+					r.mappers = append(r.mappers, other)
+					e.addTagsetMapper(string(other.key[8:]))
+				}
+			}
 		}
-	default:
-		return nil, fmt.Errorf("function not found: %q", c.Name)
+	}
+	if !found && name != "" {
+		return nil, fmt.Errorf("field not found: %s.%s", name, strings.TrimPrefix(ref.Val, name+"."))
+	} else if !found {
+		return nil, fmt.Errorf("field not found: %s", ref.Val)
 	}
 
 	return r, nil
@@ -226,8 +490,17 @@ func (p *Planner) planBinaryExpr(e *Executor, expr *BinaryExpr) (processor, erro
 		return nil, fmt.Errorf("rhs: %s", err)
 	}
 
-	// Combine processors.
-	return newBinaryExprEvaluator(e, expr.Op, lhs, rhs), nil
+	// Ensure both sides are numeric.
+	if typ := processorType(lhs); typ != Number {
+		return nil, fmt.Errorf("lhs: expected number, got %s", typ)
+	} else if typ := processorType(rhs); typ != Number {
+		return nil, fmt.Errorf("rhs: expected number, got %s", typ)
+	}
+
+	// Combine processors. Only joined sources drop keys missing from one side.
+	ev := newBinaryExprEvaluator(e, expr.Op, lhs, rhs)
+	_, ev.join = e.stmt.Source.(*Join)
+	return ev, nil
 }
 
 // extractTags extracts a tag key/value map from a statement.
@@ -301,12 +574,37 @@ func (p *Planner) extractBinaryExprTags(name string, expr Expr, ref *VarRef, val
 // Executor represents the implementation of Executor.
 // It executes all reducers and combines their result into a row.
 type Executor struct {
-	db         DB               // source database
-	stmt       *SelectStatement // original statement
-	processors []processor      // per-field processors
-	min, max   time.Time        // time range
-	interval   time.Duration    // group by duration
-	tags       []string         // group by tag keys
+	// The maximum number of values sent in a single row. Mappers send values
+	// in chunks of this size and rows are sent as soon as the chunk size is
+	// reached or once no more values can be added to them, so that large
+	// results do not need to be held in memory. A zero value sends each
+	// series as a single row once execution is complete.
+	ChunkSize int
+
+	mu         sync.Mutex
+	closing    chan struct{}              // close notification
+	done       chan struct{}              // execution complete notification
+	db         DB                         // source database
+	stmt       *SelectStatement           // original statement
+	processors []processor                // per-field processors
+	condition  string                     // folded condition
+	min, max   time.Time                  // time range
+	interval   time.Duration              // group by duration
+	offset     time.Duration              // group by interval offset
+	location   *time.Location             // group by time zone, nil if UTC
+	tags       []string                   // group by tag keys
+	tagsets    map[string]*tagsetProgress // mapper progress by encoded tagset
+	seriesN    int                        // number of series read
+	pointN     int64                      // number of points scanned, updated atomically
+	maxPointN  int64                      // points scanned limit, zero if unlimited
+	err        error                      // execution error
+
+	cache      *ResultCache         // result cache, nil if not cached
+	cacheKey   resultCacheKey       // database & statement of cached buckets
+	cacheSeq   uint64               // cache invalidation sequence when planned
+	cacheLimit int64                // end time of the buckets which can be cached
+	cached     []*resultCacheBucket // buckets read from the cache, before min
+	origin     int64                // start of the time range before cached buckets
 }
 
 // Execute begins execution of the query and returns a channel to receive rows.
@@ -318,6 +616,9 @@ func (e *Executor) Execute() (<-chan *Row, error) {
 
 	// Create output channel and stream data in a separate goroutine.
 	out := make(chan *Row, 0)
+	e.mu.Lock()
+	e.done = make(chan struct{}, 0)
+	e.mu.Unlock()
 	go e.execute(out)
 
 	return out, nil
@@ -330,278 +631,989 @@ func (e *Executor) execute(out chan *Row) {
 	// Initialize map of rows by encoded tagset.
 	rows := make(map[string]*Row)
 
-	// Combine values from each processor.
-loop:
-	for {
-		// Retrieve values from processors and write them to the approprite
-		// row based on their tagset.
-		for i, p := range e.processors {
-			// Retrieve data from the processor.
-			m, ok := <-p.C()
-			if !ok {
-				break loop
+	// Stop all processors once execution is complete or stopped.
+	defer close(e.done)
+	defer func() {
+		for _, p := range e.processors {
+			p.stop()
+		}
+	}()
+
+	// Combine values from each processor. Values are merged by key so that
+	// processors can send values at different rates.
+	inputs := make([]<-chan map[string]interface{}, len(e.processors))
+	for i, p := range e.processors {
+		inputs[i] = p.C()
+	}
+	mg := newMerger(inputs)
+	mg.closing = e.closing
+
+	// Write the cached buckets before any mapped values.
+	for _, b := range e.cached {
+		for i, tagset := range b.tagsets {
+			if !e.writeValues(out, rows, b.key.min, []byte(tagset), b.values[i]) {
+				close(out)
+				return
 			}
+		}
+	}
+	buckets := make(map[int64]*resultCacheBucket)
+
+	for {
+		// Retrieve the next set of keys that all processors have moved past.
+		keys, data, ok := mg.next()
+		if !ok {
+			break
+		}
 
-			// Set values on returned row.
-			for k, v := range m {
-				// Extract timestamp and tag values from key.
-				b := []byte(k)
-				timestamp := int64(binary.BigEndian.Uint64(b[0:8]))
+		// Write values to the appropriate row based on their tagset.
+		// Keys are processed in order so rows are populated by time.
+		for _, k := range keys {
+			// Extract timestamp and tag values from key.
+			b := []byte(k)
+			timestamp := int64(binary.BigEndian.Uint64(b[0:8]))
+
+			if !e.writeValues(out, rows, timestamp, b[8:], data[k]) {
+				close(out)
+				return
+			}
 
-				// Lookup row values and populate data.
-				values := e.createRowValuesIfNotExists(rows, e.processors[0].name(), b[8:], timestamp)
-				values[i+1] = v
+			// Collect the values of buckets which can be cached.
+			if e.cache != nil {
+				e.addCacheValues(buckets, timestamp, string(b[8:]), data[k])
 			}
 		}
+
+		// Send the remaining values for tagsets which cannot receive more values.
+		if e.ChunkSize > 0 && !e.flushCompleteRows(out, rows, mg.w) {
+			close(out)
+			return
+		}
+	}
+
+	// Close the output without sending remaining rows if execution was stopped.
+	select {
+	case <-e.closing:
+		close(out)
+		return
+	default:
+	}
+
+	// If execution failed then send the error instead of the rows.
+	if err := e.error(); err != nil {
+		e.send(out, &Row{Err: err})
+		close(out)
+		return
 	}
 
-	// Normalize rows and values.
-	// This converts the timestamps from nanoseconds to microseconds.
+	// Cache the closed buckets that were mapped.
+	if e.cache != nil {
+		e.writeCache(buckets)
+	}
+
+	// Normalize remaining rows and values.
+	// Rows which have already been sent in full are skipped.
 	a := make(Rows, 0, len(rows))
 	for _, row := range rows {
-		for _, values := range row.Values {
-			values[0] = values[0].(int64) / int64(time.Microsecond)
+		if len(row.Values) == 0 {
+			continue
 		}
-		a = append(a, row)
+		a = append(a, e.flushRow(row))
 	}
 	sort.Sort(a)
 
 	// Send rows to the channel.
 	for _, row := range a {
-		out <- row
+		if !e.send(out, row) {
+			close(out)
+			return
+		}
 	}
 
 	// Mark the end of the output channel.
 	close(out)
 }
 
-// creates a new value set if one does not already exist for a given tagset + timestamp.
-func (e *Executor) createRowValuesIfNotExists(rows map[string]*Row, name string, tagset []byte, timestamp int64) []interface{} {
-	// TODO: Add "name" to lookup key.
-
-	// Find row by tagset.
-	var row *Row
-	if row = rows[string(tagset)]; row == nil {
-		row = &Row{Name: name}
-
-		// Create tag map.
-		row.Tags = make(map[string]string)
-		for i, v := range unmarshalStrings(tagset) {
-			row.Tags[e.tags[i]] = v
-		}
-
-		// Create column names.
-		row.Columns = make([]string, 1, len(e.stmt.Fields)+1)
-		row.Columns[0] = "time"
-		for i, f := range e.stmt.Fields {
-			name := f.Name()
-			if name == "" {
-				name = fmt.Sprintf("col%d", i)
-			}
-			row.Columns = append(row.Columns, name)
-		}
-
-		// Save to lookup.
-		rows[string(tagset)] = row
-	}
-
-	// If no values exist or last value doesn't match the timestamp then create new.
-	if len(row.Values) == 0 || row.Values[len(row.Values)-1][0] != timestamp {
+// writeValues appends the values for a timestamp & encoded tagset to its row.
+// Raw values from separate series with the same timestamp & tagset are written
+// as separate value sets. The row is sent once the chunk is full.
+// Returns false if the executor is stopped.
+func (e *Executor) writeValues(out chan *Row, rows map[string]*Row, timestamp int64, tagset []byte, data []interface{}) bool {
+	row := e.createRowIfNotExists(rows, e.processors[0].name(), tagset)
+	for j, n := 0, rowValuesN(data); j < n; j++ {
 		values := make([]interface{}, len(e.processors)+1)
 		values[0] = timestamp
+		for i, v := range data {
+			if v != nil {
+				values[i+1] = rawValueAt(v, j)
+			}
+		}
 		row.Values = append(row.Values, values)
 	}
 
-	return row.Values[len(row.Values)-1]
+	// Send the row values once the chunk is full.
+	if e.ChunkSize > 0 && len(row.Values) >= e.ChunkSize {
+		return e.send(out, e.flushRow(row))
+	}
+	return true
 }
 
-// dimensionKeys returns a list of tag key names for the dimensions.
-// Each dimension must be a VarRef.
-func dimensionKeys(dimensions Dimensions) (a []string) {
-	for _, d := range dimensions {
-		a = append(a, d.Expr.(*VarRef).Val)
+// readCache reads the closed buckets at the start of the time range from the
+// cache and moves the start of the time range past them so they are not
+// mapped. A bucket is closed once it ends before now and within the time range.
+// The last bucket in the time range is always mapped.
+func (e *Executor) readCache(c *ResultCache, database string, now time.Time) {
+	// Only queries grouped by time with a bounded time range are cached.
+	if e.interval <= 0 || e.min.IsZero() {
+		return
 	}
-	return
-}
 
-// mapper represents an object for processing iterators.
-type mapper struct {
-	executor *Executor // parent executor
-	seriesID uint32    // series id
-	fieldID  uint8     // field id
-	typ      DataType  // field data type
-	itr      Iterator  // series iterator
-	min, max int64     // time range
-	interval int64     // group by interval
-	key      []byte    // encoded timestamp + dimensional values
-	fn       mapFunc   // map function
+	e.cache, e.origin = c, e.min.UnixNano()
+	e.cacheKey = resultCacheKey{database: database, stmt: normalizeStatement(e.stmt)}
+	e.cacheLimit = now.UnixNano()
+	if max := e.max.UnixNano() + 1; max < e.cacheLimit {
+		e.cacheLimit = max
+	}
+	e.cacheSeq = c.begin(database, e.cacheLimit)
 
-	c    chan map[string]interface{}
-	done chan chan struct{}
-}
+	// Read buckets until the first one that is not cached.
+	min := e.origin
+	for {
+		start, end := e.bucket(min)
+		if start < e.origin || end > e.cacheLimit || end > e.max.UnixNano() {
+			break
+		}
 
-// newMapper returns a new instance of mapper.
-func newMapper(e *Executor, seriesID uint32, fieldID uint8, typ DataType) *mapper {
-	return &mapper{
-		executor: e,
-		seriesID: seriesID,
-		fieldID:  fieldID,
-		typ:      typ,
-		c:        make(chan map[string]interface{}, 0),
-		done:     make(chan chan struct{}, 0),
+		key := e.cacheKey
+		key.min, key.max = start, end
+		b := c.get(key)
+		if b == nil {
+			break
+		}
+		e.cached = append(e.cached, b)
+		min = end
 	}
+	e.min = time.Unix(0, min).UTC()
 }
 
-// start begins processing the iterator.
-func (m *mapper) start() {
-	m.itr = m.executor.db.CreateIterator(m.seriesID, m.fieldID, m.typ,
-		m.executor.min, m.executor.max, m.executor.interval)
-	go m.run()
+// addCacheValues adds the values of a key to its bucket if it can be cached.
+func (e *Executor) addCacheValues(buckets map[int64]*resultCacheBucket, timestamp int64, tagset string, data []interface{}) {
+	start, end := e.bucket(timestamp)
+	if start < e.origin || end > e.cacheLimit || end > e.max.UnixNano() {
+		return
+	}
+
+	b := buckets[start]
+	if b == nil {
+		b = &resultCacheBucket{key: e.cacheKey}
+		b.key.min, b.key.max = start, end
+		buckets[start] = b
+	}
+	b.tagsets = append(b.tagsets, tagset)
+	b.values = append(b.values, data)
 }
 
-// stop stops the mapper.
-func (m *mapper) stop() { syncClose(m.done) }
+// writeCache adds the closed buckets that were mapped to the cache.
+// Buckets without values are cached as empty buckets.
+func (e *Executor) writeCache(buckets map[int64]*resultCacheBucket) {
+	var a []*resultCacheBucket
+	for min := e.min.UnixNano(); ; {
+		start, end := e.bucket(min)
+		if end > e.cacheLimit || end > e.max.UnixNano() {
+			break
+		}
 
-// C returns the streaming data channel.
-func (m *mapper) C() <-chan map[string]interface{} { return m.c }
+		if start >= e.origin {
+			b := buckets[start]
+			if b == nil {
+				b = &resultCacheBucket{key: e.cacheKey}
+				b.key.min, b.key.max = start, end
+			}
+			sort.Sort(b)
+			a = append(a, b)
+		}
+		min = end
+	}
+	e.cache.put(e.cacheKey.database, e.cacheSeq, e.cacheLimit, a)
+}
 
-// run executes the map function against the iterator.
-func (m *mapper) run() {
-	for m.itr.NextIterval() {
-		m.fn(m.itr, m)
+// bucket returns the start and end time of the GROUP BY interval holding t.
+// Intervals are aligned to the start of the time range unless an offset or
+// time zone is set.
+func (e *Executor) bucket(t int64) (start, end int64) {
+	if e.offset != 0 || e.location != nil {
+		return bucketBounds(t, e.interval, e.offset, e.location)
 	}
-	close(m.c)
+	start = e.origin + floorDiv(t-e.origin, int64(e.interval))*int64(e.interval)
+	return start, start + int64(e.interval)
 }
 
-// emit sends a value to the mapper's output channel.
-func (m *mapper) emit(key int64, value interface{}) {
-	// Encode the timestamp to the beginning of the key.
-	binary.BigEndian.PutUint64(m.key, uint64(key))
+// Stop cancels execution. All processors are stopped and the output channel
+// is closed without sending any remaining rows. Returns once all processors
+// have stopped. Calling it more than once has no effect.
+func (e *Executor) Stop() {
+	e.mu.Lock()
+	select {
+	case <-e.closing:
+	default:
+		close(e.closing)
+	}
+	done := e.done
+	e.mu.Unlock()
 
-	// OPTIMIZE: Collect emit calls and flush all at once.
-	m.c <- map[string]interface{}{string(m.key): value}
+	// Wait for execution to complete, if started.
+	if done != nil {
+		<-done
+	}
 }
 
-// mapFunc represents a function used for mapping iterators.
-type mapFunc func(Iterator, *mapper)
+// bucketN returns the number of GROUP BY intervals in the time range.
+// Returns one if there is no interval.
+func (e *Executor) bucketN() int64 {
+	if e.interval <= 0 {
+		return 1
+	}
 
-// mapCount computes the number of values in an iterator.
-func mapCount(itr Iterator, m *mapper) {
-	n := 0
-	for k, _ := itr.Next(); k != 0; k, _ = itr.Next() {
-		n++
+	var min int64
+	if !e.min.IsZero() {
+		min = e.min.UnixNano()
 	}
-	m.emit(itr.Time(), float64(n))
+	return (e.max.UnixNano() - min + int64(e.interval) - 1) / int64(e.interval)
 }
 
-// mapSum computes the summation of values in an iterator.
-func mapSum(itr Iterator, m *mapper) {
-	n := float64(0)
-	for k, v := itr.Next(); k != 0; k, v = itr.Next() {
-		n += v.(float64)
+// scan records a point scanned by a mapper.
+// Returns false once the points scanned limit has been exceeded.
+func (e *Executor) scan() bool {
+	if e.maxPointN == 0 {
+		return true
+	}
+	if atomic.AddInt64(&e.pointN, 1) > e.maxPointN {
+		e.setError(fmt.Errorf("max points exceeded: limit is %d", e.maxPointN))
+		return false
 	}
-	m.emit(itr.Time(), n)
+	return true
 }
 
-// processor represents an object for joining reducer output.
-type processor interface {
-	start()
-	stop()
-	name() string
-	C() <-chan map[string]interface{}
+// exceeded returns true if the points scanned limit has been exceeded.
+func (e *Executor) exceeded() bool {
+	return e.maxPointN > 0 && atomic.LoadInt64(&e.pointN) > e.maxPointN
 }
 
-// reducer represents an object for processing mapper output.
-// Implements processor.
-type reducer struct {
-	executor *Executor        // parent executor
-	stmt     *SelectStatement // substatement
-	mappers  []*mapper        // child mappers
-	fn       reduceFunc       // reduce function
+// setError sets the execution error, if one is not already set.
+func (e *Executor) setError(err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.err == nil {
+		e.err = err
+	}
+}
 
-	c    chan map[string]interface{}
-	done chan chan struct{}
+// error returns the execution error, if any.
+func (e *Executor) error() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.err
 }
 
-// newReducer returns a new instance of reducer.
-func newReducer(e *Executor) *reducer {
-	return &reducer{
-		executor: e,
-		c:        make(chan map[string]interface{}, 0),
-		done:     make(chan chan struct{}, 0),
+// send sends a row to the output channel.
+// Returns false if the executor is stopped before the row is sent.
+func (e *Executor) send(out chan *Row, row *Row) bool {
+	select {
+	case out <- row:
+		return true
+	case <-e.closing:
+		return false
 	}
 }
 
-// start begins streaming values from the mappers and reducing them.
-func (r *reducer) start() {
-	for _, m := range r.mappers {
-		m.start()
-	}
-	go r.run()
+// tagsetProgress tracks the mappers which write values for a single tagset.
+type tagsetProgress struct {
+	mapperN int   // number of mappers still running
+	last    int64 // highest timestamp emitted by completed mappers
 }
 
-// stop stops the reducer.
-func (r *reducer) stop() {
-	for _, m := range r.mappers {
-		m.stop()
+// addTagsetMapper records a mapper writing values for an encoded tagset.
+func (e *Executor) addTagsetMapper(tagset string) {
+	t := e.tagsets[tagset]
+	if t == nil {
+		t = &tagsetProgress{last: math.MinInt64}
+		e.tagsets[tagset] = t
 	}
-	syncClose(r.done)
+	t.mapperN++
 }
 
-// C returns the streaming data channel.
-func (r *reducer) C() <-chan map[string]interface{} { return r.c }
-
-// name returns the source name.
-func (r *reducer) name() string { return r.stmt.Source.(*Measurement).Name }
+// mapperDone records that a mapper for an encoded tagset has completed
+// and the highest timestamp that it emitted.
+func (e *Executor) mapperDone(tagset string, last int64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	t := e.tagsets[tagset]
+	t.mapperN--
+	if last > t.last {
+		t.last = last
+	}
+}
+
+// flushCompleteRows sends the remaining values of rows whose mappers have all
+// completed and whose values up to the timestamp w have been written.
+// The rows are removed. Returns false if the executor is stopped.
+func (e *Executor) flushCompleteRows(out chan *Row, rows map[string]*Row, w int64) bool {
+	// Find complete tagsets.
+	var tagsets []string
+	e.mu.Lock()
+	for tagset, t := range e.tagsets {
+		if t.mapperN == 0 && t.last <= w {
+			tagsets = append(tagsets, tagset)
+			delete(e.tagsets, tagset)
+		}
+	}
+	e.mu.Unlock()
+
+	// Remove the rows and send the ones with values in order.
+	a := make(Rows, 0, len(tagsets))
+	for _, tagset := range tagsets {
+		if row := rows[tagset]; row != nil {
+			delete(rows, tagset)
+			if len(row.Values) > 0 {
+				a = append(a, e.flushRow(row))
+			}
+		}
+	}
+	sort.Sort(a)
+
+	for _, row := range a {
+		if !e.send(out, row) {
+			return false
+		}
+	}
+	return true
+}
+
+// flushRow returns a copy of row with its current values and removes the
+// values from row. This converts the timestamps from nanoseconds to microseconds,
+// or to RFC3339 strings in the query's time zone if one is set.
+func (e *Executor) flushRow(row *Row) *Row {
+	other := &Row{Name: row.Name, Tags: row.Tags, Columns: row.Columns, Values: row.Values}
+	for _, values := range other.Values {
+		if e.location != nil {
+			values[0] = time.Unix(0, values[0].(int64)).In(e.location).Format(time.RFC3339Nano)
+		} else {
+			values[0] = values[0].(int64) / int64(time.Microsecond)
+		}
+	}
+	row.Values = nil
+	return other
+}
+
+// creates a new row if one does not already exist for a given tagset.
+func (e *Executor) createRowIfNotExists(rows map[string]*Row, name string, tagset []byte) *Row {
+	// TODO: Add "name" to lookup key.
+
+	// Find row by tagset.
+	var row *Row
+	if row = rows[string(tagset)]; row == nil {
+		row = &Row{Name: name}
+
+		// Create tag map.
+		row.Tags = make(map[string]string)
+		for i, v := range unmarshalStrings(tagset) {
+			row.Tags[e.tags[i]] = v
+		}
+
+		// Create column names.
+		row.Columns = make([]string, 1, len(e.stmt.Fields)+1)
+		row.Columns[0] = "time"
+		for i := range e.stmt.Fields {
+			row.Columns = append(row.Columns, e.columnName(i))
+		}
+
+		// Save to lookup.
+		rows[string(tagset)] = row
+	}
+
+	return row
+}
+
+// rowValuesN returns the number of value sets needed for a key's values.
+// This is the largest number of raw values across the values.
+func rowValuesN(a []interface{}) int {
+	n := 1
+	for _, v := range a {
+		if v, ok := v.(rawValues); ok && len(v) > n {
+			n = len(v)
+		}
+	}
+	return n
+}
+
+// rawValueAt returns the i-th value of a set of raw values.
+// Returns nil if there are not enough values. Other values are returned as-is.
+func rawValueAt(v interface{}, i int) interface{} {
+	if a, ok := v.(rawValues); ok {
+		if i < len(a) {
+			return a[i]
+		}
+		return nil
+	}
+	return v
+}
+
+// columnName returns the output column name for the field at index i.
+func (e *Executor) columnName(i int) string {
+	if name := e.stmt.Fields[i].Name(); name != "" {
+		return name
+	}
+	return fmt.Sprintf("col%d", i)
+}
+
+// Explain returns a description of the execution plan without executing it.
+// The first row describes the query and the second row lists each processor
+// used to compute the fields.
+func (e *Executor) Explain() Rows {
+	var min string
+	if !e.min.IsZero() {
+		min = e.min.UTC().Format(DateTimeFormat)
+	}
+	query := &Row{
+		Name:    "query",
+		Columns: []string{"condition", "min", "max", "interval", "tags"},
+		Values: [][]interface{}{{
+			e.condition,
+			min,
+			e.max.UTC().Format(DateTimeFormat),
+			FormatDuration(e.interval),
+			strings.Join(e.tags, ","),
+		}},
+	}
+
+	processors := &Row{
+		Name:    "processors",
+		Columns: []string{"field", "processor", "source", "mapper", "reducer", "series"},
+	}
+	for i, p := range e.processors {
+		processors.Values = append(processors.Values, explainProcessor(e.columnName(i), p)...)
+	}
+
+	return Rows{query, processors}
+}
+
+// TimeRange returns the time range that the plan reads from.
+func (e *Executor) TimeRange() (min, max time.Time) { return e.min, e.max }
+
+// Interval returns the GROUP BY time() interval of the plan.
+// Returns zero if the statement is not grouped by time.
+func (e *Executor) Interval() time.Duration { return e.interval }
+
+// SeriesIDs returns the sorted ids of the series that the plan reads from.
+func (e *Executor) SeriesIDs() []uint32 {
+	set := make(map[uint32]struct{})
+	for _, p := range e.processors {
+		processorSeriesIDs(p, set)
+	}
+
+	ids := make([]uint32, 0, len(set))
+	for id := range set {
+		ids = append(ids, id)
+	}
+	sort.Sort(uint32Slice(ids))
+	return ids
+}
+
+// processorSeriesIDs adds the ids of the series read by a processor and its
+// children to set.
+func processorSeriesIDs(p processor, set map[uint32]struct{}) {
+	switch p := p.(type) {
+	case *reducer:
+		for _, m := range p.mappers {
+			set[m.seriesID] = struct{}{}
+		}
+	case *binaryExprEvaluator:
+		processorSeriesIDs(p.lhs, set)
+		processorSeriesIDs(p.rhs, set)
+	case *scalarFuncEvaluator:
+		for _, arg := range p.args {
+			processorSeriesIDs(arg, set)
+		}
+	}
+}
+
+// explainProcessor returns a description of a processor and its children.
+func explainProcessor(field string, p processor) [][]interface{} {
+	switch p := p.(type) {
+	case *reducer:
+		var mapName string
+		if len(p.mappers) > 0 {
+			mapName = p.mappers[0].fnName
+		}
+		return [][]interface{}{{field, "reducer", p.stmt.Source.String(), mapName, p.fnName, len(p.mappers)}}
+	case *binaryExprEvaluator:
+		a := [][]interface{}{{field, "binary(" + p.op.String() + ")", "", "", "", 0}}
+		a = append(a, explainProcessor(field, p.lhs)...)
+		return append(a, explainProcessor(field, p.rhs)...)
+	case *scalarFuncEvaluator:
+		a := [][]interface{}{{field, "scalar(" + p.fnName + ")", "", "", "", 0}}
+		for _, arg := range p.args {
+			a = append(a, explainProcessor(field, arg)...)
+		}
+		return a
+	case *literalProcessor:
+		return [][]interface{}{{field, fmt.Sprintf("literal(%v)", p.val), "", "", "", 0}}
+	}
+	panic("unreachable")
+}
+
+// uint32Slice attaches the methods of sort.Interface to []uint32.
+type uint32Slice []uint32
+
+func (p uint32Slice) Len() int           { return len(p) }
+func (p uint32Slice) Less(i, j int) bool { return p[i] < p[j] }
+func (p uint32Slice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// dimensionKeys returns a list of tag key names for the dimensions.
+// Each dimension must be a VarRef.
+func dimensionKeys(dimensions Dimensions) (a []string) {
+	for _, d := range dimensions {
+		a = append(a, d.Expr.(*VarRef).Val)
+	}
+	return
+}
+
+// mapper represents an object for processing iterators.
+type mapper struct {
+	executor *Executor     // parent executor
+	seriesID uint32        // series id
+	fieldID  uint8         // field id
+	typ      DataType      // field data type
+	itr      Iterator      // series iterator
+	min, max int64         // time range
+	interval int64         // group by interval
+	key      []byte        // encoded timestamp + dimensional values
+	fn       mapFunc       // map function
+	fnName   string        // map function name, used by Explain()
+	args     []interface{} // map function arguments, sent to remote mappers
+	last     int64         // highest timestamp emitted
+	remote   RemoteMapper  // runs the mapper on another node, if set
+
+	sitr *stoppableIterator     // iterator wrapper, ends once stopped
+	buf  map[string]interface{} // values emitted but not yet sent
+	c    chan map[string]interface{}
+	done chan chan struct{}
+}
+
+// newMapper returns a new instance of mapper.
+func newMapper(e *Executor, seriesID uint32, fieldID uint8, typ DataType) *mapper {
+	return &mapper{
+		executor: e,
+		seriesID: seriesID,
+		fieldID:  fieldID,
+		typ:      typ,
+		last:     math.MinInt64,
+		c:        make(chan map[string]interface{}, 0),
+		done:     make(chan chan struct{}, 0),
+	}
+}
+
+// start begins processing the iterator.
+// Remote mappers are run on their node instead.
+func (m *mapper) start() {
+	if m.remote != nil {
+		go m.runRemote()
+		return
+	}
+
+	// Intervals which are not aligned on the epoch in UTC are split from a
+	// single interval iterator.
+	e := m.executor
+	if e.interval > 0 && (e.offset != 0 || e.location != nil) {
+		itr := e.db.CreateIterator(m.seriesID, m.fieldID, m.typ, e.min, e.max, 0)
+		m.itr = newBucketIterator(itr, e.min, e.max, e.interval, e.offset, e.location)
+	} else {
+		m.itr = e.db.CreateIterator(m.seriesID, m.fieldID, m.typ, e.min, e.max, e.interval)
+	}
+	go m.run()
+}
+
+// stop stops the mapper.
+func (m *mapper) stop() { syncClose(m.done) }
+
+// C returns the streaming data channel.
+func (m *mapper) C() <-chan map[string]interface{} { return m.c }
+
+// run executes the map function against the iterator.
+// All values emitted within an interval are sent as a single map, unless the
+// executor has a chunk size in which case values are sent once a chunk is full.
+// Once complete, the mapper waits to be stopped.
+func (m *mapper) run() {
+	itr := &stoppableIterator{Iterator: m.itr, executor: m.executor, done: m.done}
+	m.sitr = itr
+	for itr.NextIterval() {
+		m.buf = make(map[string]interface{})
+		m.fn(itr, m)
+		if itr.ch != nil || m.executor.exceeded() {
+			break
+		}
+
+		if m.flush(); itr.ch != nil {
+			break
+		}
+	}
+	m.executor.mapperDone(string(m.key[8:]), m.last)
+	close(m.c)
+
+	// Release the iterator's resources, if it holds any.
+	if c, ok := m.itr.(io.Closer); ok {
+		_ = c.Close()
+	}
+
+	// Wait for stop notification, if not already received.
+	if itr.ch == nil {
+		itr.ch = <-m.done
+	}
+	close(itr.ch)
+}
+
+// runRemote runs the mapper on another node and sends each chunk of values
+// it emits. Once complete, the mapper waits to be stopped.
+func (m *mapper) runRemote() {
+	spec := &MapperSpec{
+		SeriesID: m.seriesID,
+		FieldID:  m.fieldID,
+		Type:     m.typ,
+		Min:      m.executor.min,
+		Max:      m.executor.max,
+		Interval: m.executor.interval,
+		Offset:   m.executor.offset,
+		MapFunc:  m.fnName,
+		MapArgs:  m.args,
+	}
+	if loc := m.executor.location; loc != nil {
+		spec.Location = loc.String()
+	}
+
+	var ch chan struct{}
+	if err := m.remote.Run(spec, m.executor.closing, func(values []MapperValue) bool {
+		buf := make(map[string]interface{}, len(values))
+		for _, v := range values {
+			binary.BigEndian.PutUint64(m.key, uint64(v.Key))
+			buf[string(m.key)] = v.Value
+			if v.Key > m.last {
+				m.last = v.Key
+			}
+		}
+
+		select {
+		case m.c <- buf:
+			return true
+		case ch = <-m.done:
+			return false
+		}
+	}); err != nil {
+		m.executor.setError(err)
+	}
+	m.executor.mapperDone(string(m.key[8:]), m.last)
+	close(m.c)
+
+	// Wait for stop notification, if not already received.
+	if ch == nil {
+		ch = <-m.done
+	}
+	close(ch)
+}
+
+// emit adds a value to the mapper's output for the current interval.
+func (m *mapper) emit(key int64, value interface{}) {
+	// Encode the timestamp to the beginning of the key.
+	binary.BigEndian.PutUint64(m.key, uint64(key))
+	m.buf[string(m.key)] = value
+	if key > m.last {
+		m.last = key
+	}
+
+	// Send values early once the chunk is full so that large intervals are
+	// not held in memory.
+	if n := m.executor.ChunkSize; n > 0 && len(m.buf) >= n {
+		m.flush()
+	}
+}
+
+// flush sends the buffered values and resets the buffer.
+// Empty buffers are not sent. Does nothing once stopped.
+func (m *mapper) flush() {
+	if len(m.buf) == 0 || m.sitr.ch != nil {
+		return
+	}
+
+	select {
+	case m.c <- m.buf:
+	case m.sitr.ch = <-m.done:
+	}
+	m.buf = make(map[string]interface{})
+}
+
+// stoppableIterator wraps an iterator so that it ends early once a stop
+// notification has been received on the done channel or once the executor's
+// points scanned limit has been exceeded.
+type stoppableIterator struct {
+	Iterator
+	executor *Executor
+	done     chan chan struct{}
+	ch       chan struct{} // received stop notification
+}
+
+// stopped returns true if a stop notification has been received.
+func (itr *stoppableIterator) stopped() bool {
+	if itr.ch == nil {
+		select {
+		case itr.ch = <-itr.done:
+		default:
+		}
+	}
+	return itr.ch != nil
+}
+
+// NextIterval moves to the next interval. Returns false once stopped.
+func (itr *stoppableIterator) NextIterval() bool {
+	if itr.stopped() || itr.executor.exceeded() {
+		return false
+	}
+	return itr.Iterator.NextIterval()
+}
+
+// Next returns the next value from the iterator. Returns a zero key once stopped.
+func (itr *stoppableIterator) Next() (int64, interface{}) {
+	if itr.stopped() {
+		return 0, nil
+	}
+
+	k, v := itr.Iterator.Next()
+	if k != 0 && !itr.executor.scan() {
+		return 0, nil
+	}
+	return k, v
+}
+
+// mapFunc represents a function used for mapping iterators.
+type mapFunc func(Iterator, *mapper)
+
+// mapFuncs is a lookup of non-aggregate map functions by name, used to run
+// remote mappers. Aggregate map functions are found in the aggregate registry.
+var mapFuncs = map[string]mapFunc{
+	"mapRaw": mapRaw,
+}
+
+func init() {
+	mustRegisterAggregate(&Aggregate{
+		Name:       "count",
+		Type:       Number,
+		Map:        mapCount,
+		Reduce:     reduceSum,
+		mapName:    "mapCount",
+		reduceName: "reduceSum",
+	})
+	mustRegisterAggregate(&Aggregate{
+		Name:       "sum",
+		Field:      Number,
+		Type:       Number,
+		Map:        mapSum,
+		Reduce:     reduceSum,
+		mapName:    "mapSum",
+		reduceName: "reduceSum",
+	})
+	mustRegisterAggregate(&Aggregate{
+		Name:       "approx_count_distinct",
+		Type:       Number,
+		Map:        mapHyperLogLog,
+		Reduce:     reduceHyperLogLog,
+		mapName:    "mapHyperLogLog",
+		reduceName: "reduceHyperLogLog",
+	})
+	mustRegisterAggregate(&Aggregate{
+		Name:       "approx_percentile",
+		Field:      Number,
+		Args:       []DataType{Number},
+		Type:       Number,
+		Map:        mapTDigest,
+		Reduce:     reduceTDigest,
+		mapName:    "mapTDigest",
+		reduceName: "reduceTDigest",
+	})
+}
+
+// mapCount computes the number of values in an iterator.
+func mapCount(itr Iterator, args []interface{}) interface{} {
+	n := 0
+	for k, _ := itr.Next(); k != 0; k, _ = itr.Next() {
+		n++
+	}
+	return float64(n)
+}
+
+// mapSum computes the summation of values in an iterator.
+func mapSum(itr Iterator, args []interface{}) interface{} {
+	n := float64(0)
+	for k, v := itr.Next(); k != 0; k, v = itr.Next() {
+		n += v.(float64)
+	}
+	return n
+}
+
+// mapRaw emits every value in an iterator with its own timestamp.
+func mapRaw(itr Iterator, m *mapper) {
+	for k, v := itr.Next(); k != 0; k, v = itr.Next() {
+		m.emit(k, v)
+	}
+}
+
+// processor represents an object for joining reducer output.
+type processor interface {
+	start()
+	stop()
+	name() string
+	C() <-chan map[string]interface{}
+}
+
+// processorType returns the data type of the values sent by a processor.
+func processorType(p processor) DataType {
+	switch p := p.(type) {
+	case *reducer:
+		return p.typ
+	case *scalarFuncEvaluator:
+		return p.fn.ret
+	case *literalProcessor:
+		return InspectDataType(p.val)
+	}
+	return Number
+}
+
+// reducer represents an object for processing mapper output.
+// Implements processor.
+type reducer struct {
+	executor   *Executor        // parent executor
+	stmt       *SelectStatement // substatement
+	sourceName string           // measurement name, blank if merged
+	typ        DataType         // output data type
+	mappers    []*mapper        // child mappers
+	fn         reduceFunc       // reduce function
+	fnName     string           // reduce function name, used by Explain()
+
+	buf  map[string]interface{} // values emitted for the current interval
+	c    chan map[string]interface{}
+	done chan chan struct{}
+}
+
+// newReducer returns a new instance of reducer.
+func newReducer(e *Executor) *reducer {
+	return &reducer{
+		executor: e,
+		c:        make(chan map[string]interface{}, 0),
+		done:     make(chan chan struct{}, 0),
+	}
+}
+
+// start begins streaming values from the mappers and reducing them.
+func (r *reducer) start() {
+	for _, m := range r.mappers {
+		m.start()
+	}
+	go r.run()
+}
+
+// stop stops the reducer.
+func (r *reducer) stop() {
+	for _, m := range r.mappers {
+		m.stop()
+	}
+	syncClose(r.done)
+}
+
+// C returns the streaming data channel.
+func (r *reducer) C() <-chan map[string]interface{} { return r.c }
+
+// name returns the source name.
+func (r *reducer) name() string { return r.sourceName }
 
 // run runs the reducer loop to read mapper output and reduce it.
+// All values reduced within an interval are sent as a single map.
+// Once complete, the reducer waits to be stopped.
 func (r *reducer) run() {
+	// Combine all data from the mappers.
+	inputs := make([]<-chan map[string]interface{}, len(r.mappers))
+	for i, m := range r.mappers {
+		inputs[i] = m.C()
+	}
+	mg := newMerger(inputs)
+	mg.done = r.done
+
+	var ch chan struct{}
 loop:
 	for {
-		// Combine all data from the mappers.
-		data := make(map[string][]interface{})
-		for _, m := range r.mappers {
-			kv, ok := <-m.C()
-			if !ok {
-				break loop
-			}
-			for k, v := range kv {
-				data[k] = append(data[k], v)
-			}
+		keys, data, ok := mg.next()
+		if !ok {
+			ch = mg.ch
+			break
 		}
 
 		// Reduce each key.
-		for k, v := range data {
-			r.fn(k, v, r)
+		r.buf = make(map[string]interface{})
+		for _, k := range keys {
+			r.fn(k, nonNilValues(data[k]), r)
+		}
+
+		select {
+		case r.c <- r.buf:
+		case ch = <-r.done:
+			break loop
 		}
 	}
 
 	// Mark the channel as complete.
 	close(r.c)
+
+	// Wait for stop notification, if not already received.
+	if ch == nil {
+		ch = <-r.done
+	}
+	close(ch)
 }
 
-// emit sends a value to the reducer's output channel.
+// emit adds a value to the reducer's output for the current interval.
 func (r *reducer) emit(key string, value interface{}) {
-	r.c <- map[string]interface{}{key: value}
+	r.buf[key] = value
 }
 
 // reduceFunc represents a function used for reducing mapper output.
 type reduceFunc func(string, []interface{}, *reducer)
 
-// reduceSum computes the sum of values for each key.
-func reduceSum(key string, values []interface{}, r *reducer) {
+// reduceSum computes the sum of the mapped values.
+func reduceSum(values []interface{}, args []interface{}) interface{} {
 	var n float64
 	for _, v := range values {
 		n += v.(float64)
 	}
-	r.emit(key, n)
+	return n
 }
 
+// reduceRaw passes through the value for each key.
+// Points from separate series with the same timestamp & tagset are passed
+// through together as rawValues, in mapper order.
+func reduceRaw(key string, values []interface{}, r *reducer) {
+	if len(values) == 1 {
+		r.emit(key, values[0])
+		return
+	}
+	r.emit(key, rawValues(values))
+}
+
+// rawValues represents the values of multiple points with the same key.
+// The executor writes each value as a separate value set.
+type rawValues []interface{}
+
 // binaryExprEvaluator represents a processor for combining two processors.
 type binaryExprEvaluator struct {
 	executor *Executor // parent executor
 	lhs, rhs processor // processors
 	op       Token     // operation
+	join     bool      // if true, keys missing from either side are dropped
 
 	c    chan map[string]interface{}
 	done chan chan struct{}
@@ -640,39 +1652,113 @@ func (e *binaryExprEvaluator) C() <-chan map[string]interface{} { return e.c }
 func (e *binaryExprEvaluator) name() string { return "" }
 
 // run runs the processor loop to read subprocessor output and combine it.
+// Once complete, the processor waits to be stopped.
 func (e *binaryExprEvaluator) run() {
-	for {
-		// Read LHS value.
-		lhs, ok := <-e.lhs.C()
-		if !ok {
-			break
-		}
+	mg := newMerger([]<-chan map[string]interface{}{e.lhs.C(), e.rhs.C()})
+	mg.done = e.done
 
-		// Read RHS value.
-		rhs, ok := <-e.rhs.C()
+	var ch chan struct{}
+loop:
+	for {
+		keys, data, ok := mg.next()
 		if !ok {
+			ch = mg.ch
 			break
 		}
 
-		// Merge maps.
+		// Combine values by key. Literal values are combined with every key on
+		// the other side. A value missing from one side is evaluated against
+		// zero, unless the source is a join in which case the key is dropped.
 		m := make(map[string]interface{})
-		for k, v := range lhs {
-			m[k] = e.eval(v, rhs[k])
-		}
-		for k, v := range rhs {
-			// Skip value if already processed in lhs loop.
-			if _, ok := m[k]; ok {
+		for _, k := range keys {
+			lv, ok := e.value(data[k][0], mg.literals[0])
+			if !ok {
 				continue
 			}
-			m[k] = e.eval(float64(0), v)
+			rv, ok := e.value(data[k][1], mg.literals[1])
+			if !ok {
+				continue
+			}
+			m[k] = e.evalValues(lv, rv)
 		}
 
 		// Return value.
-		e.c <- m
+		select {
+		case e.c <- m:
+		case ch = <-e.done:
+			break loop
+		}
+	}
+
+	// If both sides are literals then the result is sent as a literal.
+	if ch == nil && mg.literals[0] != nil && mg.literals[1] != nil {
+		select {
+		case e.c <- map[string]interface{}{"": e.eval(mg.literals[0], mg.literals[1])}:
+		case ch = <-e.done:
+		}
 	}
 
 	// Mark the channel as complete.
 	close(e.c)
+
+	// Wait for stop notification, if not already received.
+	if ch == nil {
+		ch = <-e.done
+	}
+	close(ch)
+}
+
+// value returns the value to combine for one side of a key.
+// Falls back to the side's literal value and then to zero when not joining.
+// Returns false if the key should be dropped.
+func (e *binaryExprEvaluator) value(v, literal interface{}) (interface{}, bool) {
+	if v != nil {
+		return v, true
+	} else if literal != nil {
+		return literal, true
+	} else if e.join {
+		return nil, false
+	}
+	return float64(0), true
+}
+
+// evalValues evaluates two values which may be raw values from multiple points.
+// Raw values are evaluated element-wise and single values are applied to each
+// element. Elements missing from the shorter side are treated like a missing key.
+func (e *binaryExprEvaluator) evalValues(lhs, rhs interface{}) interface{} {
+	la, lok := lhs.(rawValues)
+	ra, rok := rhs.(rawValues)
+	if !lok && !rok {
+		return e.eval(lhs, rhs)
+	}
+
+	n := len(la)
+	if len(ra) > n {
+		n = len(ra)
+	}
+	a := make(rawValues, 0, n)
+	for i := 0; i < n; i++ {
+		lv, rv := lhs, rhs
+		if lok {
+			lv = rawValueAt(la, i)
+		}
+		if rok {
+			rv = rawValueAt(ra, i)
+		}
+
+		// Handle elements past the end of the shorter side.
+		if lv == nil || rv == nil {
+			if e.join {
+				continue
+			} else if lv == nil {
+				lv = float64(0)
+			} else {
+				rv = float64(0)
+			}
+		}
+		a = append(a, e.eval(lv, rv))
+	}
+	return a
 }
 
 // eval evaluates two values using the evaluator's operation.
@@ -696,6 +1782,159 @@ func (e *binaryExprEvaluator) eval(lhs, rhs interface{}) interface{} {
 	}
 }
 
+// scalarFuncEvaluator represents a processor for applying a scalar function to
+// the output of its argument processors.
+type scalarFuncEvaluator struct {
+	executor *Executor   // parent executor
+	fn       *scalarFunc // function
+	fnName   string      // function name, used by Explain()
+	args     []processor // argument processors
+
+	c    chan map[string]interface{}
+	done chan chan struct{}
+}
+
+// newScalarFuncEvaluator returns a new instance of scalarFuncEvaluator.
+func newScalarFuncEvaluator(e *Executor, name string, fn *scalarFunc, args []processor) *scalarFuncEvaluator {
+	return &scalarFuncEvaluator{
+		executor: e,
+		fn:       fn,
+		fnName:   name,
+		args:     args,
+		c:        make(chan map[string]interface{}, 0),
+		done:     make(chan chan struct{}, 0),
+	}
+}
+
+// start begins streaming values from the argument processors.
+func (e *scalarFuncEvaluator) start() {
+	for _, a := range e.args {
+		a.start()
+	}
+	go e.run()
+}
+
+// stop stops the processor.
+func (e *scalarFuncEvaluator) stop() {
+	for _, a := range e.args {
+		a.stop()
+	}
+	syncClose(e.done)
+}
+
+// C returns the streaming data channel.
+func (e *scalarFuncEvaluator) C() <-chan map[string]interface{} { return e.c }
+
+// name returns the source name of the first argument read from a source.
+func (e *scalarFuncEvaluator) name() string {
+	for _, a := range e.args {
+		if name := a.name(); name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+// run runs the processor loop to read argument output and apply the function.
+// Once complete, the processor waits to be stopped.
+func (e *scalarFuncEvaluator) run() {
+	inputs := make([]<-chan map[string]interface{}, len(e.args))
+	for i, a := range e.args {
+		inputs[i] = a.C()
+	}
+	mg := newMerger(inputs)
+	mg.done = e.done
+
+	var ch chan struct{}
+loop:
+	for {
+		keys, data, ok := mg.next()
+		if !ok {
+			ch = mg.ch
+			break
+		}
+
+		// Apply the function to each key. Literal arguments are used for every
+		// key and keys missing an argument are dropped.
+		m := make(map[string]interface{})
+		for _, k := range keys {
+			if v := e.evalValues(data[k], mg.literals); v != nil {
+				m[k] = v
+			}
+		}
+
+		select {
+		case e.c <- m:
+		case ch = <-e.done:
+			break loop
+		}
+	}
+
+	// If every argument is a literal then the result is sent as a literal.
+	if ch == nil && len(nonNilValues(mg.literals)) == len(mg.literals) {
+		if v := e.eval(mg.literals); v != nil {
+			select {
+			case e.c <- map[string]interface{}{"": v}:
+			case ch = <-e.done:
+			}
+		}
+	}
+
+	// Mark the channel as complete.
+	close(e.c)
+
+	// Wait for stop notification, if not already received.
+	if ch == nil {
+		ch = <-e.done
+	}
+	close(ch)
+}
+
+// evalValues applies the function to the argument values for a key. Raw values
+// from multiple points are evaluated element-wise up to the shortest argument.
+// Returns nil if an argument is missing.
+func (e *scalarFuncEvaluator) evalValues(values, literals []interface{}) interface{} {
+	n := -1
+	args := make([]interface{}, len(values))
+	for i, v := range values {
+		if v == nil {
+			v = literals[i]
+		}
+		if v == nil {
+			return nil
+		} else if a, ok := v.(rawValues); ok && (n == -1 || len(a) < n) {
+			n = len(a)
+		}
+		args[i] = v
+	}
+	if n == -1 {
+		return e.eval(args)
+	}
+
+	a := make(rawValues, 0, n)
+	for j := 0; j < n; j++ {
+		elem := make([]interface{}, len(args))
+		for i, arg := range args {
+			elem[i] = rawValueAt(arg, j)
+		}
+		if v := e.eval(elem); v != nil {
+			a = append(a, v)
+		}
+	}
+	return a
+}
+
+// eval applies the function to a set of arguments.
+// Returns nil if an argument does not match the function's types.
+func (e *scalarFuncEvaluator) eval(args []interface{}) interface{} {
+	for i, arg := range args {
+		if InspectDataType(arg) != e.fn.argType(i) {
+			return nil
+		}
+	}
+	return e.fn.fn(args)
+}
+
 // literalProcessor represents a processor that continually sends a literal value.
 type literalProcessor struct {
 	val  interface{}
@@ -723,6 +1962,7 @@ func (p *literalProcessor) run() {
 	for {
 		select {
 		case ch := <-p.done:
+			close(p.c)
 			close(ch)
 			return
 		case p.c <- map[string]interface{}{"": p.val}:
@@ -736,6 +1976,141 @@ func (p *literalProcessor) stop() { syncClose(p.done) }
 // name returns the source name.
 func (p *literalProcessor) name() string { return "" }
 
+// merger merges maps of values read from multiple inputs by key. Each input
+// must send keys in time order. Keys are only released once every input has
+// moved past their timestamp so values for a key from separate inputs are
+// released together and inputs may send values at different rates.
+type merger struct {
+	inputs   []<-chan map[string]interface{}
+	hw       []int64                  // highest timestamp read per input, max once complete
+	literals []interface{}            // literal value per input, if sent
+	pending  map[string][]interface{} // unreleased values by key, indexed by input
+	w        int64                    // timestamp up to which all keys are released
+
+	closing <-chan struct{}    // close notification, if any
+	done    chan chan struct{} // stop notification, if any
+	ch      chan struct{}      // received stop notification
+}
+
+// newMerger returns a new instance of merger for a set of inputs.
+func newMerger(inputs []<-chan map[string]interface{}) *merger {
+	m := &merger{
+		inputs:   inputs,
+		hw:       make([]int64, len(inputs)),
+		literals: make([]interface{}, len(inputs)),
+		pending:  make(map[string][]interface{}),
+		w:        math.MinInt64,
+	}
+	for i := range m.hw {
+		m.hw[i] = math.MinInt64
+	}
+	return m
+}
+
+// next reads from the inputs until keys can be released. Returns the sorted
+// keys and their values by input index. Missing values are nil. Returns false
+// once all inputs are complete and all keys are released, or once stopped.
+func (m *merger) next() ([]string, map[string][]interface{}, bool) {
+	for {
+		// Release the keys that no input can send again.
+		m.w = m.watermark()
+		if keys, data := m.release(); len(keys) > 0 {
+			return keys, data, true
+		} else if m.w == math.MaxInt64 {
+			return nil, nil, false
+		}
+
+		// Read from the input that is furthest behind.
+		i := m.lagging()
+		select {
+		case data, ok := <-m.inputs[i]:
+			if !ok {
+				m.hw[i] = math.MaxInt64
+				continue
+			}
+			m.add(i, data)
+		case <-m.closing:
+			return nil, nil, false
+		case m.ch = <-m.done:
+			return nil, nil, false
+		}
+	}
+}
+
+// add adds a map of values read from an input. Literal values are stored
+// under a blank key and mark the input as complete.
+func (m *merger) add(i int, data map[string]interface{}) {
+	for k, v := range data {
+		if k == "" {
+			m.literals[i] = v
+			m.hw[i] = math.MaxInt64
+			continue
+		}
+
+		values := m.pending[k]
+		if values == nil {
+			values = make([]interface{}, len(m.inputs))
+			m.pending[k] = values
+		}
+		values[i] = v
+
+		if ts := int64(binary.BigEndian.Uint64([]byte(k[0:8]))); ts > m.hw[i] {
+			m.hw[i] = ts
+		}
+	}
+}
+
+// watermark returns the lowest high-water timestamp across the inputs.
+func (m *merger) watermark() int64 {
+	w := int64(math.MaxInt64)
+	for _, hw := range m.hw {
+		if hw < w {
+			w = hw
+		}
+	}
+	return w
+}
+
+// lagging returns the index of the incomplete input that is furthest behind.
+func (m *merger) lagging() int {
+	index := -1
+	for i, hw := range m.hw {
+		if hw != math.MaxInt64 && (index == -1 || hw < m.hw[index]) {
+			index = i
+		}
+	}
+	return index
+}
+
+// release removes and returns the pending keys at or before the watermark.
+func (m *merger) release() ([]string, map[string][]interface{}) {
+	var keys []string
+	for k := range m.pending {
+		if int64(binary.BigEndian.Uint64([]byte(k[0:8]))) <= m.w {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	data := make(map[string][]interface{}, len(keys))
+	for _, k := range keys {
+		data[k] = m.pending[k]
+		delete(m.pending, k)
+	}
+	return keys, data
+}
+
+// nonNilValues returns the non-nil values in order.
+func nonNilValues(a []interface{}) []interface{} {
+	other := make([]interface{}, 0, len(a))
+	for _, v := range a {
+		if v != nil {
+			other = append(other, v)
+		}
+	}
+	return other
+}
+
 // syncClose closes a "done" channel and waits for a response.
 func syncClose(done chan chan struct{}) {
 	ch := make(chan struct{}, 0)
@@ -744,7 +2119,8 @@ func syncClose(done chan chan struct{}) {
 }
 
 // Iterator represents a forward-only iterator over a set of points.
-// The iterator groups points together in interval sets.
+// The iterator groups points together in interval sets. Iterators that hold
+// resources should also implement io.Closer.
 type Iterator interface {
 	// Next returns the next value from the iterator.
 	Next() (key int64, value interface{})
@@ -759,6 +2135,147 @@ type Iterator interface {
 	Interval() time.Duration
 }
 
+// bucketIterator splits the single interval of an iterator into GROUP BY
+// intervals that are shifted by an offset and aligned in a time zone.
+// Intervals of whole days start at local midnight so they follow daylight
+// saving time changes.
+type bucketIterator struct {
+	itr        Iterator       // underlying single interval iterator
+	min, max   int64          // time range
+	interval   time.Duration  // group by duration
+	offset     time.Duration  // interval offset
+	loc        *time.Location // interval time zone
+	imin, imax int64          // current interval time range
+
+	key   int64       // buffered value key, zero if none
+	value interface{} // buffered value
+	eof   bool        // underlying iterator is exhausted
+}
+
+// newBucketIterator returns a new instance of bucketIterator.
+func newBucketIterator(itr Iterator, min, max time.Time, interval, offset time.Duration, loc *time.Location) *bucketIterator {
+	if loc == nil {
+		loc = time.UTC
+	}
+	i := &bucketIterator{itr: itr, max: max.UnixNano(), interval: interval, offset: offset, loc: loc, imin: -1}
+	if !min.IsZero() {
+		i.min = min.UnixNano()
+	}
+	return i
+}
+
+// NextIterval moves to the next interval. Returns false once the time range
+// or the underlying iterator is exhausted.
+func (i *bucketIterator) NextIterval() bool {
+	if i.imin == -1 {
+		if !i.itr.NextIterval() {
+			return false
+		}
+
+		// Start at the interval that holds the start of the time range or,
+		// if the range is unbounded, the interval that holds the first value.
+		start := i.min
+		if start == 0 {
+			if i.peek() == 0 {
+				return false
+			}
+			start = i.key
+		}
+		i.imin, i.imax = i.bucket(start)
+		return true
+	}
+
+	if i.imax > i.max {
+		return false
+	}
+	i.imin, i.imax = i.bucket(i.imax)
+	return true
+}
+
+// Next returns the next value in the current interval.
+// Returns a zero key when the end of the interval is reached.
+func (i *bucketIterator) Next() (key int64, value interface{}) {
+	for {
+		if k := i.peek(); k == 0 || k >= i.imax {
+			return 0, nil
+		}
+		key, value, i.key, i.value = i.key, i.value, 0, nil
+
+		// Skip values before the interval.
+		if key >= i.imin {
+			return key, value
+		}
+	}
+}
+
+// peek returns the key of the next value without moving the iterator.
+func (i *bucketIterator) peek() int64 {
+	if i.key == 0 && !i.eof {
+		if i.key, i.value = i.itr.Next(); i.key == 0 {
+			i.eof = true
+		}
+	}
+	return i.key
+}
+
+// bucket returns the start and end time of the interval that holds t.
+func (i *bucketIterator) bucket(t int64) (start, end int64) {
+	return bucketBounds(t, i.interval, i.offset, i.loc)
+}
+
+// bucketBounds returns the start and end time of the interval holding t for
+// intervals aligned to the epoch in a time zone, plus an offset.
+func bucketBounds(t int64, interval, offset time.Duration, loc *time.Location) (start, end int64) {
+	const day = 24 * time.Hour
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	// Whole day intervals start at local midnight, plus the offset.
+	if interval%day == 0 {
+		days := int64(interval / day)
+		lt := time.Unix(0, t-int64(offset)).In(loc)
+		n := floorDiv(daysSinceEpoch(lt.Year(), lt.Month(), lt.Day()), days) * days
+		start = time.Date(1970, 1, 1+int(n), 0, 0, 0, 0, loc).UnixNano()
+		end = time.Date(1970, 1, 1+int(n+days), 0, 0, 0, 0, loc).UnixNano()
+		return start + int64(offset), end + int64(offset)
+	}
+
+	// Shorter intervals are aligned using the zone offset in effect at t.
+	_, zone := time.Unix(0, t).In(loc).Zone()
+	shift := int64(zone)*int64(time.Second) - int64(offset)
+	start = floorDiv(t+shift, int64(interval))*int64(interval) - shift
+	return start, start + int64(interval)
+}
+
+// Time returns the start time of the current interval.
+func (i *bucketIterator) Time() int64 { return i.imin }
+
+// Interval returns the group by duration.
+func (i *bucketIterator) Interval() time.Duration { return i.interval }
+
+// Close closes the underlying iterator, if it holds any resources.
+func (i *bucketIterator) Close() error {
+	if c, ok := i.itr.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// daysSinceEpoch returns the number of days from 1970-01-01 to a calendar date.
+func daysSinceEpoch(year int, month time.Month, day int) int64 {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC).Unix() / 86400
+}
+
+// floorDiv returns a divided by b, rounded towards negative infinity.
+func floorDiv(a, b int64) int64 {
+	q := a / b
+	if (a%b != 0) && ((a < 0) != (b < 0)) {
+		q--
+	}
+	return q
+}
+
 // Row represents a single row returned from the execution of a statement.
 type Row struct {
 	Name    string            `json:"name,omitempty"`
@@ -8,12 +8,44 @@ import (
 	"reflect"
 	"sort"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/influxdb/influxdb/influxql"
 )
 
+func init() {
+	// Register an aggregate for the ratio of values below a threshold.
+	influxql.RegisterAggregate(&influxql.Aggregate{
+		Name:  "sla_ratio",
+		Field: influxql.Number,
+		Args:  []influxql.DataType{influxql.Number},
+		Type:  influxql.Number,
+		Map: func(itr influxql.Iterator, args []interface{}) interface{} {
+			var n, total float64
+			for k, v := itr.Next(); k != 0; k, v = itr.Next() {
+				if v.(float64) < args[0].(float64) {
+					n++
+				}
+				total++
+			}
+			return []interface{}{n, total}
+		},
+		Reduce: func(values []interface{}, args []interface{}) interface{} {
+			var n, total float64
+			for _, v := range values {
+				n += v.([]interface{})[0].(float64)
+				total += v.([]interface{})[1].(float64)
+			}
+			if total == 0 {
+				return nil
+			}
+			return n / total
+		},
+	})
+}
+
 // Ensure the planner can plan and execute a simple count query.
 func TestPlanner_Plan_Count(t *testing.T) {
 	db := NewDB("2000-01-01T12:00:00Z")
@@ -136,6 +168,73 @@ func TestPlanner_Plan_GroupByIntervalAndTag(t *testing.T) {
 	}
 }
 
+// Ensure the planner can group by intervals shifted by an offset.
+func TestPlanner_Plan_GroupByInterval_Offset(t *testing.T) {
+	db := NewDB("2000-01-03T00:00:00Z")
+	db.WriteSeries("cpu", map[string]string{}, "2000-01-01T05:00:00Z", map[string]interface{}{"value": float64(10)})
+	db.WriteSeries("cpu", map[string]string{}, "2000-01-01T07:00:00Z", map[string]interface{}{"value": float64(20)})
+	db.WriteSeries("cpu", map[string]string{}, "2000-01-02T05:59:59Z", map[string]interface{}{"value": float64(30)})
+	db.WriteSeries("cpu", map[string]string{}, "2000-01-02T06:00:00Z", map[string]interface{}{"value": float64(40)})
+
+	// Query for daily sums starting at 06:00 UTC.
+	rs := db.MustPlanAndExecute(`
+		SELECT sum(value)
+		FROM cpu
+		WHERE time >= '2000-01-01 00:00:00' AND time < '2000-01-03 00:00:00'
+		GROUP BY time(1d, 6h)`)
+
+	// Expected resultset.
+	exp := minify(`[{
+		"name":"cpu",
+		"columns":["time","sum"],
+		"values":[
+			[946620000000000,10],
+			[946706400000000,50],
+			[946792800000000,40]
+		]
+	}]`)
+
+	// Compare resultsets.
+	if act := jsonify(rs); exp != act {
+		t.Fatalf("unexpected resultset: %s", indent(act))
+	}
+}
+
+// Ensure the planner can group by days in a time zone across a DST change.
+func TestPlanner_Plan_GroupByInterval_Location(t *testing.T) {
+	db := NewDB("2000-04-04T00:00:00Z")
+
+	// Daylight saving time starts in Chicago at 2000-04-02 02:00 local time.
+	db.WriteSeries("cpu", map[string]string{}, "2000-04-01T06:00:00Z", map[string]interface{}{"value": float64(1)})
+	db.WriteSeries("cpu", map[string]string{}, "2000-04-02T05:59:59Z", map[string]interface{}{"value": float64(2)})
+	db.WriteSeries("cpu", map[string]string{}, "2000-04-02T06:00:00Z", map[string]interface{}{"value": float64(3)})
+	db.WriteSeries("cpu", map[string]string{}, "2000-04-03T04:59:59Z", map[string]interface{}{"value": float64(4)})
+	db.WriteSeries("cpu", map[string]string{}, "2000-04-03T05:00:00Z", map[string]interface{}{"value": float64(5)})
+
+	// Query for daily sums in local time.
+	rs := db.MustPlanAndExecute(`
+		SELECT sum(value)
+		FROM cpu
+		WHERE time >= '2000-04-01 06:00:00' AND time < '2000-04-04 00:00:00'
+		GROUP BY time(1d) tz('America/Chicago')`)
+
+	// Expected resultset.
+	exp := minify(`[{
+		"name":"cpu",
+		"columns":["time","sum"],
+		"values":[
+			["2000-04-01T00:00:00-06:00",3],
+			["2000-04-02T00:00:00-06:00",7],
+			["2000-04-03T00:00:00-05:00",5]
+		]
+	}]`)
+
+	// Compare resultsets.
+	if act := jsonify(rs); exp != act {
+		t.Fatalf("unexpected resultset: %s", indent(act))
+	}
+}
+
 // Ensure the planner can plan and execute a query filtered by tag.
 func TestPlanner_Plan_FilterByTag(t *testing.T) {
 	db := NewDB("2000-01-01T12:00:00Z")
@@ -222,13 +321,819 @@ func TestPlanner_Plan_Join(t *testing.T) {
 	}
 }
 
+// Ensure the planner can plan and execute a raw field query.
+func TestPlanner_Plan_Raw(t *testing.T) {
+	db := NewDB("2000-01-01T12:00:00Z")
+	db.WriteSeries("cpu", map[string]string{}, "2000-01-01T00:00:20Z", map[string]interface{}{"value": float64(3)})
+	db.WriteSeries("cpu", map[string]string{}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(1)})
+	db.WriteSeries("cpu", map[string]string{}, "2000-01-01T00:00:10Z", map[string]interface{}{"value": float64(2)})
+
+	// Query must return each point in time order.
+	rs := db.MustPlanAndExecute(`SELECT value FROM cpu WHERE time >= "2000-01-01 00:00:00"`)
+
+	// Expected resultset.
+	exp := minify(`[{
+		"name":"cpu",
+		"columns":["time","value"],
+		"values":[
+			[946684800000000,1],
+			[946684810000000,2],
+			[946684820000000,3]
+		]
+	}]`)
+
+	// Compare resultsets.
+	if act := jsonify(rs); exp != act {
+		t.Fatalf("unexpected resultset: %s", indent(act))
+	}
+}
+
+// Ensure the planner returns every point when multiple series have points at the same time.
+func TestPlanner_Plan_Raw_Multiseries_SameTimestamp(t *testing.T) {
+	db := NewDB("2000-01-01T12:00:00Z")
+	db.WriteSeries("cpu", map[string]string{"host": "servera"}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(1)})
+	db.WriteSeries("cpu", map[string]string{"host": "serverb"}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(10)})
+	db.WriteSeries("cpu", map[string]string{"host": "serverb"}, "2000-01-01T00:00:10Z", map[string]interface{}{"value": float64(20)})
+
+	// Query must return a value set for each series and apply expressions to each.
+	rs := db.MustPlanAndExecute(`SELECT value, value * 2 FROM cpu WHERE time >= "2000-01-01 00:00:00"`)
+
+	// Expected resultset.
+	exp := minify(`[{
+		"name":"cpu",
+		"columns":["time","value","col1"],
+		"values":[
+			[946684800000000,1,2],
+			[946684800000000,10,20],
+			[946684810000000,20,40]
+		]
+	}]`)
+
+	// Compare resultsets.
+	if act := jsonify(rs); exp != act {
+		t.Fatalf("unexpected resultset: %s", indent(act))
+	}
+}
+
+// Ensure an expression over a single measurement evaluates a missing value as zero.
+func TestPlanner_Plan_BinaryExpr_MissingValue(t *testing.T) {
+	db := NewDB("2000-01-01T12:00:00Z")
+	db.WriteSeries("cpu", map[string]string{}, "2000-01-01T00:00:00Z", map[string]interface{}{"usr": float64(1), "sys": float64(10)})
+	db.WriteSeries("cpu", map[string]string{}, "2000-01-01T00:00:10Z", map[string]interface{}{"usr": float64(2)})
+	db.WriteSeries("cpu", map[string]string{}, "2000-01-01T00:00:20Z", map[string]interface{}{"sys": float64(30)})
+
+	// Query must add the fields at every timestamp where either exists.
+	rs := db.MustPlanAndExecute(`SELECT usr + sys AS "total" FROM cpu WHERE time >= "2000-01-01 00:00:00"`)
+
+	// Expected resultset.
+	exp := minify(`[{
+		"columns":["time","total"],
+		"values":[
+			[946684800000000,11],
+			[946684810000000,2],
+			[946684820000000,30]
+		]
+	}]`)
+
+	// Compare resultsets.
+	if act := jsonify(rs); exp != act {
+		t.Fatalf("unexpected resultset: %s", indent(act))
+	}
+}
+
+// Ensure the planner can plan and execute a raw query against merged measurements.
+func TestPlanner_Plan_Merge(t *testing.T) {
+	db := NewDB("2000-01-01T12:00:00Z")
+	db.WriteSeries("cpu_a", map[string]string{}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(1)})
+	db.WriteSeries("cpu_a", map[string]string{}, "2000-01-01T00:00:20Z", map[string]interface{}{"value": float64(3)})
+	db.WriteSeries("cpu_b", map[string]string{}, "2000-01-01T00:00:10Z", map[string]interface{}{"value": float64(20)})
+	db.WriteSeries("cpu_b", map[string]string{}, "2000-01-01T00:00:30Z", map[string]interface{}{"value": float64(40)})
+
+	// Query must interleave the points from both measurements.
+	rs := db.MustPlanAndExecute(`SELECT value FROM merge(cpu_a, cpu_b) WHERE time >= "2000-01-01 00:00:00"`)
+
+	// Expected resultset.
+	exp := minify(`[{
+		"columns":["time","value"],
+		"values":[
+			[946684800000000,1],
+			[946684810000000,20],
+			[946684820000000,3],
+			[946684830000000,40]
+		]
+	}]`)
+
+	// Compare resultsets.
+	if act := jsonify(rs); exp != act {
+		t.Fatalf("unexpected resultset: %s", indent(act))
+	}
+}
+
+// Ensure the planner returns every point when merged measurements have points at the same time.
+func TestPlanner_Plan_Merge_SameTimestamp(t *testing.T) {
+	db := NewDB("2000-01-01T12:00:00Z")
+	db.WriteSeries("cpu_a", map[string]string{}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(1)})
+	db.WriteSeries("cpu_a", map[string]string{}, "2000-01-01T00:00:10Z", map[string]interface{}{"value": float64(2)})
+	db.WriteSeries("cpu_b", map[string]string{}, "2000-01-01T00:00:10Z", map[string]interface{}{"value": float64(20)})
+
+	// Query must return both points at the colliding timestamp.
+	rs := db.MustPlanAndExecute(`SELECT value FROM merge(cpu_a, cpu_b) WHERE time >= "2000-01-01 00:00:00"`)
+
+	// Expected resultset.
+	exp := minify(`[{
+		"columns":["time","value"],
+		"values":[
+			[946684800000000,1],
+			[946684810000000,2],
+			[946684810000000,20]
+		]
+	}]`)
+
+	// Compare resultsets.
+	if act := jsonify(rs); exp != act {
+		t.Fatalf("unexpected resultset: %s", indent(act))
+	}
+}
+
+// Ensure the planner can plan and execute an aggregate query against merged measurements.
+func TestPlanner_Plan_Merge_Aggregate(t *testing.T) {
+	db := NewDB("2000-01-01T12:00:00Z")
+	db.WriteSeries("cpu_a", map[string]string{"host": "servera"}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(1)})
+	db.WriteSeries("cpu_a", map[string]string{"host": "serverb"}, "2000-01-01T00:00:10Z", map[string]interface{}{"value": float64(2)})
+	db.WriteSeries("cpu_b", map[string]string{"host": "servera"}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(10)})
+	db.WriteSeries("cpu_b", map[string]string{"host": "serverb"}, "2000-01-01T00:00:10Z", map[string]interface{}{"value": float64(20)})
+
+	// Query must sum values across both measurements for a single host.
+	rs := db.MustPlanAndExecute(`SELECT sum(value) FROM merge(cpu_a, cpu_b) WHERE host = 'servera'`)
+
+	// Expected resultset.
+	exp := minify(`[{"columns":["time","sum"],"values":[[0,11]]}]`)
+
+	// Compare resultsets.
+	if act := jsonify(rs); exp != act {
+		t.Fatalf("unexpected resultset: %s", indent(act))
+	}
+}
+
+// Ensure the planner can plan and execute a raw joined query.
+func TestPlanner_Plan_Join_Raw(t *testing.T) {
+	db := NewDB("2000-01-01T12:00:00Z")
+	db.WriteSeries("cpu", map[string]string{"host": "servera"}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(10)})
+	db.WriteSeries("cpu", map[string]string{"host": "servera"}, "2000-01-01T00:00:10Z", map[string]interface{}{"value": float64(20)})
+	db.WriteSeries("cpu", map[string]string{"host": "servera"}, "2000-01-01T00:00:20Z", map[string]interface{}{"value": float64(30)})
+	db.WriteSeries("cpu", map[string]string{"host": "serverb"}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(100)})
+
+	db.WriteSeries("mem", map[string]string{"host": "servera"}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(2)})
+	db.WriteSeries("mem", map[string]string{"host": "servera"}, "2000-01-01T00:00:20Z", map[string]interface{}{"value": float64(5)})
+	db.WriteSeries("mem", map[string]string{"host": "serverb"}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(4)})
+
+	// Query must align points by timestamp & host and divide the values.
+	rs := db.MustPlanAndExecute(`
+		SELECT cpu.value / mem.value AS "ratio"
+		FROM join(cpu, mem)
+		WHERE time >= "2000-01-01 00:00:00"
+		GROUP BY host`)
+
+	// Expected resultset.
+	exp := minify(`[{
+		"tags":{"host":"servera"},
+		"columns":["time","ratio"],
+		"values":[
+			[946684800000000,5],
+			[946684820000000,6]
+		]
+	},{
+		"tags":{"host":"serverb"},
+		"columns":["time","ratio"],
+		"values":[
+			[946684800000000,25]
+		]
+	}]`)
+
+	// Compare resultsets.
+	if act := jsonify(rs); exp != act {
+		t.Fatalf("unexpected resultset: %s", indent(act))
+	}
+}
+
+// Ensure the planner can plan and execute an expression with a literal.
+func TestPlanner_Plan_Literal(t *testing.T) {
+	db := NewDB("2000-01-01T12:00:00Z")
+	db.WriteSeries("cpu", map[string]string{}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(1)})
+	db.WriteSeries("cpu", map[string]string{}, "2000-01-01T00:00:10Z", map[string]interface{}{"value": float64(2)})
+
+	// Query must apply the literal to each point.
+	rs := db.MustPlanAndExecute(`SELECT value * 10 AS "value" FROM cpu WHERE time >= "2000-01-01 00:00:00"`)
+
+	// Expected resultset.
+	exp := minify(`[{"columns":["time","value"],"values":[[946684800000000,10],[946684810000000,20]]}]`)
+
+	// Compare resultsets.
+	if act := jsonify(rs); exp != act {
+		t.Fatalf("unexpected resultset: %s", indent(act))
+	}
+}
+
+// Ensure the planner can apply scalar functions to aggregates.
+func TestPlanner_Plan_ScalarFunc_Aggregate(t *testing.T) {
+	db := NewDB("2000-01-01T12:00:00Z")
+	db.WriteSeries("cpu", map[string]string{}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(1)})
+	db.WriteSeries("cpu", map[string]string{}, "2000-01-01T00:00:05Z", map[string]interface{}{"value": float64(2)})
+	db.WriteSeries("cpu", map[string]string{}, "2000-01-01T00:00:10Z", map[string]interface{}{"value": float64(4)})
+
+	// Query must apply the functions to each bucket's aggregate.
+	rs := db.MustPlanAndExecute(`
+		SELECT pow(sum(value), 2), round(sum(value) / 4)
+		FROM cpu
+		WHERE time >= "2000-01-01 00:00:00" AND time < "2000-01-01 00:00:20"
+		GROUP BY time(10s)`)
+
+	// Expected resultset.
+	exp := minify(`[{
+		"name":"cpu",
+		"columns":["time","pow","round"],
+		"values":[
+			[946684800000000,9,1],
+			[946684810000000,16,1]
+		]
+	}]`)
+
+	// Compare resultsets.
+	if act := jsonify(rs); exp != act {
+		t.Fatalf("unexpected resultset: %s", indent(act))
+	}
+}
+
+// Ensure the planner can apply scalar functions to raw number and string fields.
+func TestPlanner_Plan_ScalarFunc_Raw(t *testing.T) {
+	db := NewDB("2000-01-01T12:00:00Z")
+	db.WriteSeries("cpu", map[string]string{}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(-2.5), "state": "Idle"})
+	db.WriteSeries("cpu", map[string]string{}, "2000-01-01T00:00:10Z", map[string]interface{}{"value": float64(9), "state": "Busy"})
+
+	// Query must apply the functions to each point.
+	rs := db.MustPlanAndExecute(`
+		SELECT abs(value), sqrt(abs(value)) * 2 AS "double", lower(state), concat(upper(state), "-", state)
+		FROM cpu
+		WHERE time >= "2000-01-01 00:00:00"`)
+
+	// Expected resultset.
+	exp := minify(`[{
+		"name":"cpu",
+		"columns":["time","abs","double","lower","concat"],
+		"values":[
+			[946684800000000,2.5,3.1622776601683795,"idle","IDLE-Idle"],
+			[946684810000000,9,6,"busy","BUSY-Busy"]
+		]
+	}]`)
+
+	// Compare resultsets.
+	if act := jsonify(rs); exp != act {
+		t.Fatalf("unexpected resultset: %s", indent(act))
+	}
+}
+
+// Ensure the planner returns empty values for non-finite scalar function results.
+func TestPlanner_Plan_ScalarFunc_NonFinite(t *testing.T) {
+	db := NewDB("2000-01-01T12:00:00Z")
+	db.WriteSeries("cpu", map[string]string{}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(-4)})
+	db.WriteSeries("cpu", map[string]string{}, "2000-01-01T00:00:10Z", map[string]interface{}{"value": float64(0)})
+	db.WriteSeries("cpu", map[string]string{}, "2000-01-01T00:00:20Z", map[string]interface{}{"value": float64(4)})
+
+	// NaN and infinite results must not be returned.
+	rs := db.MustPlanAndExecute(`
+		SELECT sqrt(value), ln(value), pow(value, -1), log(value, 1)
+		FROM cpu
+		WHERE time >= "2000-01-01 00:00:00"`)
+
+	// Expected resultset.
+	exp := minify(`[{
+		"name":"cpu",
+		"columns":["time","sqrt","ln","pow","log"],
+		"values":[
+			[946684800000000,null,null,-0.25,null],
+			[946684810000000,0,null,null,null],
+			[946684820000000,2,1.3862943611198906,0.25,null]
+		]
+	}]`)
+
+	// Compare resultsets.
+	if act := jsonify(rs); exp != act {
+		t.Fatalf("unexpected resultset: %s", indent(act))
+	}
+}
+
+// Ensure the planner returns an error when a field has the wrong type for a function.
+func TestPlanner_Plan_ScalarFunc_ErrInvalidArgumentType(t *testing.T) {
+	db := NewDB("2000-01-01T12:00:00Z")
+	db.WriteSeries("cpu", map[string]string{}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(1), "state": "idle"})
+
+	for _, tt := range []struct {
+		s   string
+		err string
+	}{
+		{s: `SELECT lower(value) FROM cpu`, err: `expected string argument in lower(), got number`},
+		{s: `SELECT round(state) FROM cpu`, err: `expected number argument in round(), got string`},
+		{s: `SELECT upper(state) * 2 FROM cpu`, err: `lhs: expected number, got string`},
+	} {
+		if _, err := db.PlanAndExecute(tt.s); err == nil || err.Error() != tt.err {
+			t.Errorf("%s: unexpected error: %v", tt.s, err)
+		}
+	}
+}
+
+// Ensure closed buckets are read from the result cache instead of being mapped.
+func TestPlanner_Plan_ResultCache(t *testing.T) {
+	db := NewDB("2000-01-01T00:03:30Z")
+	db.Cache = influxql.NewResultCache(10)
+	db.WriteSeries("cpu", map[string]string{}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(1)})
+	db.WriteSeries("cpu", map[string]string{}, "2000-01-01T00:01:00Z", map[string]interface{}{"value": float64(2)})
+	db.WriteSeries("cpu", map[string]string{}, "2000-01-01T00:02:00Z", map[string]interface{}{"value": float64(3)})
+	db.WriteSeries("cpu", map[string]string{}, "2000-01-01T00:03:00Z", map[string]interface{}{"value": float64(4)})
+	query := `SELECT sum(value) FROM cpu WHERE time >= "2000-01-01 00:00:00" GROUP BY time(1m)`
+
+	// The first query maps every bucket and caches the closed buckets.
+	exp := minify(`[{"name":"cpu","columns":["time","sum"],"values":[[946684800000000,1],[946684860000000,2],[946684920000000,3],[946684980000000,4]]}]`)
+	if act := jsonify(db.MustPlanAndExecute(query)); exp != act {
+		t.Fatalf("unexpected resultset: %s", indent(act))
+	} else if n := db.ScanN(); n != 4 {
+		t.Fatalf("unexpected scan count: %d", n)
+	} else if stats := db.Cache.Stats(); stats != (influxql.ResultCacheStats{Misses: 1, N: 3}) {
+		t.Fatalf("unexpected stats: %#v", stats)
+	}
+
+	// The same query later only maps the open bucket.
+	db.WriteSeries("cpu", map[string]string{}, "2000-01-01T00:03:10Z", map[string]interface{}{"value": float64(5)})
+	db.Now = mustParseTime("2000-01-01T00:03:40Z")
+	exp = minify(`[{"name":"cpu","columns":["time","sum"],"values":[[946684800000000,1],[946684860000000,2],[946684920000000,3],[946684980000000,9]]}]`)
+	if act := jsonify(db.MustPlanAndExecute(query)); exp != act {
+		t.Fatalf("unexpected resultset: %s", indent(act))
+	} else if n := db.ScanN(); n != 6 {
+		t.Fatalf("unexpected scan count: %d", n)
+	} else if stats := db.Cache.Stats(); stats != (influxql.ResultCacheStats{Hits: 3, Misses: 1, N: 3}) {
+		t.Fatalf("unexpected stats: %#v", stats)
+	}
+
+	// Writing to a closed bucket invalidates it.
+	db.WriteSeries("cpu", map[string]string{}, "2000-01-01T00:01:30Z", map[string]interface{}{"value": float64(10)})
+	db.Cache.Invalidate("db0", mustParseTime("2000-01-01T00:01:30Z"))
+	exp = minify(`[{"name":"cpu","columns":["time","sum"],"values":[[946684800000000,1],[946684860000000,12],[946684920000000,3],[946684980000000,9]]}]`)
+	if act := jsonify(db.MustPlanAndExecute(query)); exp != act {
+		t.Fatalf("unexpected resultset: %s", indent(act))
+	} else if stats := db.Cache.Stats(); stats != (influxql.ResultCacheStats{Hits: 4, Misses: 2, N: 3}) {
+		t.Fatalf("unexpected stats: %#v", stats)
+	}
+
+	// Deleting data invalidates every bucket in the database.
+	db.Cache.InvalidateDatabase("db0")
+	if stats := db.Cache.Stats(); stats.N != 0 {
+		t.Fatalf("unexpected cached bucket count: %d", stats.N)
+	}
+}
+
+// Ensure the result cache evicts the least recently used buckets once full.
+func TestPlanner_Plan_ResultCache_Evict(t *testing.T) {
+	db := NewDB("2000-01-01T00:03:30Z")
+	db.Cache = influxql.NewResultCache(2)
+	db.WriteSeries("cpu", map[string]string{}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(1)})
+
+	// Only the two most recently added buckets are kept.
+	db.MustPlanAndExecute(`SELECT count(value) FROM cpu WHERE time >= "2000-01-01 00:00:00" GROUP BY time(1m)`)
+	if stats := db.Cache.Stats(); stats != (influxql.ResultCacheStats{Misses: 1, Evictions: 1, N: 2}) {
+		t.Fatalf("unexpected stats: %#v", stats)
+	}
+
+	// The first bucket was evicted so nothing is read from the cache.
+	db.MustPlanAndExecute(`SELECT count(value) FROM cpu WHERE time >= "2000-01-01 00:00:00" GROUP BY time(1m)`)
+	if stats := db.Cache.Stats(); stats != (influxql.ResultCacheStats{Misses: 2, Evictions: 2, N: 2}) {
+		t.Fatalf("unexpected stats: %#v", stats)
+	}
+}
+
+// Ensure the planner can describe an execution plan without executing it.
+func TestPlanner_Explain(t *testing.T) {
+	db := NewDB("2000-01-01T12:00:00Z")
+	db.WriteSeries("cpu", map[string]string{"host": "servera", "region": "us-west"}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(1)})
+	db.WriteSeries("cpu", map[string]string{"host": "serverb", "region": "us-west"}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(2)})
+	db.WriteSeries("cpu", map[string]string{"host": "serverc", "region": "us-east"}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(3)})
+	db.WriteSeries("mem", map[string]string{"host": "servera", "region": "us-west"}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(4)})
+
+	// Plan the statement.
+	p := influxql.NewPlanner(db)
+	p.Now = func() time.Time { return db.Now }
+	e, err := p.Plan(MustParseSelectStatement(`
+		SELECT count(cpu.value) / sum(mem.value) * 2 AS "ratio"
+		FROM join(cpu, mem)
+		WHERE time >= now() - 1h AND cpu.region = 'us-west'
+		GROUP BY time(10m), host`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Expected plan.
+	exp := minify(`[{
+		"name":"query",
+		"columns":["condition","min","max","interval","tags"],
+		"values":[["time \u003e= \"2000-01-01 11:00:00\" AND cpu.region = \"us-west\"","2000-01-01 11:00:00","2000-01-01 12:00:00","10m","host"]]
+	},{
+		"name":"processors",
+		"columns":["field","processor","source","mapper","reducer","series"],
+		"values":[
+			["ratio","binary(*)","","","",0],
+			["ratio","binary(/)","","","",0],
+			["ratio","reducer","cpu","mapCount","reduceSum",2],
+			["ratio","reducer","mem","mapSum","reduceSum",1],
+			["ratio","literal(2)","","","",0]
+		]
+	}]`)
+
+	// Compare plans.
+	if act := jsonify(e.Explain()); exp != act {
+		t.Fatalf("unexpected plan: %s", indent(act))
+	}
+
+	// Verify the series read by the plan.
+	if ids := e.SeriesIDs(); !reflect.DeepEqual(ids, []uint32{1, 2, 4}) {
+		t.Fatalf("unexpected series ids: %v", ids)
+	}
+}
+
+// Ensure a running execution can be stopped before all rows are sent.
+func TestExecutor_Stop(t *testing.T) {
+	db := NewDB("2000-01-01T12:00:00Z")
+	db.WriteSeries("cpu", map[string]string{"host": "servera"}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(1)})
+	db.WriteSeries("cpu", map[string]string{"host": "serverb"}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(2)})
+
+	// Plan & execute a statement that returns multiple rows.
+	p := influxql.NewPlanner(db)
+	p.Now = func() time.Time { return db.Now }
+	e, err := p.Plan(MustParseSelectStatement(`SELECT sum(value) * 2 FROM cpu WHERE time >= now() - 12h GROUP BY time(1m), host`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch, err := e.Execute()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Stop the execution without reading any rows.
+	e.Stop()
+	e.Stop()
+
+	// Verify that the output channel is closed.
+	select {
+	case row, ok := <-ch:
+		if ok {
+			t.Fatalf("unexpected row: %s", jsonify(row))
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("timeout")
+	}
+}
+
+// Ensure the executor can send rows in chunks.
+func TestExecutor_ChunkSize(t *testing.T) {
+	db := NewDB("2000-01-01T12:00:00Z")
+	db.WriteSeries("cpu", map[string]string{"host": "servera"}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(1)})
+	db.WriteSeries("cpu", map[string]string{"host": "serverb"}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(2)})
+	db.WriteSeries("cpu", map[string]string{"host": "servera"}, "2000-01-01T00:01:00Z", map[string]interface{}{"value": float64(3)})
+	db.WriteSeries("cpu", map[string]string{"host": "servera"}, "2000-01-01T00:02:00Z", map[string]interface{}{"value": float64(4)})
+
+	// Plan & execute with a chunk size of two values per row.
+	p := influxql.NewPlanner(db)
+	p.Now = func() time.Time { return db.Now }
+	e, err := p.Plan(MustParseSelectStatement(`SELECT sum(value) FROM cpu WHERE time >= "2000-01-01 00:00:00" AND time < "2000-01-01 00:03:00" GROUP BY time(1m), host`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.ChunkSize = 2
+	ch, err := e.Execute()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Group chunks by host. Chunks for separate tagsets may be interleaved.
+	rs := make(map[string][]*influxql.Row)
+	for row := range ch {
+		rs[row.Tags["host"]] = append(rs[row.Tags["host"]], row)
+	}
+
+	// Full chunks are sent first and then the remaining values for each tagset.
+	exp := minify(`{
+		"servera":[
+			{"name":"cpu","tags":{"host":"servera"},"columns":["time","sum"],"values":[[946684800000000,1],[946684860000000,3]]},
+			{"name":"cpu","tags":{"host":"servera"},"columns":["time","sum"],"values":[[946684920000000,4]]}
+		],
+		"serverb":[
+			{"name":"cpu","tags":{"host":"serverb"},"columns":["time","sum"],"values":[[946684800000000,2],[946684860000000,0]]},
+			{"name":"cpu","tags":{"host":"serverb"},"columns":["time","sum"],"values":[[946684920000000,0]]}
+		]
+	}`)
+	if act := minify(jsonify(rs)); exp != act {
+		t.Fatalf("unexpected resultset: %s", indent(act))
+	}
+}
+
+// Ensure the executor sends the first chunk before the series is fully scanned.
+func TestExecutor_ChunkSize_Streaming(t *testing.T) {
+	db := NewDB("2000-01-01T12:00:00Z")
+	for i := 0; i < 100; i++ {
+		timestamp := mustParseTime("2000-01-01T00:00:00Z").Add(time.Duration(i) * time.Second)
+		db.WriteSeries("cpu", map[string]string{}, timestamp.Format(time.RFC3339), map[string]interface{}{"value": float64(i)})
+	}
+
+	// Plan & execute a raw query with a chunk size of five values per row.
+	p := influxql.NewPlanner(db)
+	p.Now = func() time.Time { return db.Now }
+	e, err := p.Plan(MustParseSelectStatement(`SELECT value FROM cpu WHERE time >= "2000-01-01 00:00:00"`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.ChunkSize = 5
+	ch, err := e.Execute()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Stop()
+
+	// Read the first chunk.
+	row := <-ch
+	if row == nil || len(row.Values) != 5 {
+		t.Fatalf("unexpected row: %s", jsonify(row))
+	}
+
+	// Give the mapper time to scan ahead and verify that it is blocked after a
+	// few chunks instead of reading the whole series into memory.
+	time.Sleep(10 * time.Millisecond)
+	if n := db.ScanN(); n > 4*e.ChunkSize {
+		t.Fatalf("unexpected points scanned: %d", n)
+	}
+}
+
+// Ensure a mapper spec can be run directly against a database.
+func TestRunMapper(t *testing.T) {
+	db := NewDB("2000-01-01T12:00:00Z")
+	db.WriteSeries("cpu", map[string]string{}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(1)})
+	db.WriteSeries("cpu", map[string]string{}, "2000-01-01T00:00:30Z", map[string]interface{}{"value": float64(2)})
+	db.WriteSeries("cpu", map[string]string{}, "2000-01-01T00:01:00Z", map[string]interface{}{"value": float64(3)})
+	fieldID, typ := db.Field("cpu", "value")
+
+	// Sum each minute of the series.
+	var chunks [][]influxql.MapperValue
+	if err := influxql.RunMapper(db, &influxql.MapperSpec{
+		SeriesID: db.MatchSeries("cpu", nil)[0],
+		FieldID:  fieldID,
+		Type:     typ,
+		Min:      mustParseTime("2000-01-01T00:00:00Z"),
+		Max:      mustParseTime("2000-01-01T00:02:00Z"),
+		Interval: 1 * time.Minute,
+		MapFunc:  "mapSum",
+	}, 0, func(values []influxql.MapperValue) bool {
+		chunks = append(chunks, values)
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	} else if s := jsonify(chunks); s != `[[{"key":946684800000000000,"value":3}],[{"key":946684860000000000,"value":3}]]` {
+		t.Fatalf("unexpected chunks: %s", s)
+	}
+
+	// Registered aggregates are run with their arguments.
+	chunks = nil
+	if err := influxql.RunMapper(db, &influxql.MapperSpec{
+		SeriesID: db.MatchSeries("cpu", nil)[0],
+		FieldID:  fieldID,
+		Type:     typ,
+		Min:      mustParseTime("2000-01-01T00:00:00Z"),
+		Max:      mustParseTime("2000-01-01T00:02:00Z"),
+		Interval: 2 * time.Minute,
+		MapFunc:  "mapSla_ratio",
+		MapArgs:  []interface{}{float64(2)},
+	}, 0, func(values []influxql.MapperValue) bool {
+		chunks = append(chunks, values)
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	} else if s := jsonify(chunks); s != `[[{"key":946684800000000000,"value":[1,3]}]]` {
+		t.Fatalf("unexpected chunks: %s", s)
+	}
+
+	// Unknown map functions return an error.
+	if err := influxql.RunMapper(db, &influxql.MapperSpec{MapFunc: "mapFoo"}, 0, nil); err == nil || err.Error() != "map function not found: mapFoo" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure a registered aggregate can be planned and executed with arguments.
+func TestPlanner_Plan_RegisteredAggregate(t *testing.T) {
+	db := NewDB("2000-01-01T12:00:00Z")
+	db.WriteSeries("cpu", map[string]string{"host": "servera"}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(1)})
+	db.WriteSeries("cpu", map[string]string{"host": "servera"}, "2000-01-01T00:00:10Z", map[string]interface{}{"value": float64(8)})
+	db.WriteSeries("cpu", map[string]string{"host": "serverb"}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(3)})
+	db.WriteSeries("cpu", map[string]string{"host": "serverb"}, "2000-01-01T00:00:10Z", map[string]interface{}{"value": float64(6)})
+
+	// Query must reduce the mapped values from each series.
+	rs := db.MustPlanAndExecute(`SELECT sla_ratio(value, 5) FROM cpu WHERE time >= "2000-01-01 00:00:00"`)
+
+	// Expected resultset.
+	exp := minify(`[{"name":"cpu","columns":["time","sla_ratio"],"values":[[946684800000000,0.5]]}]`)
+
+	// Compare resultsets.
+	if act := jsonify(rs); exp != act {
+		t.Fatalf("unexpected resultset: %s", indent(act))
+	}
+
+	// Arguments must match the aggregate's types.
+	if _, err := db.PlanAndExecute(`SELECT sla_ratio(value, "x") FROM cpu`); err == nil || err.Error() != `expected number argument in sla_ratio() at line 1, char 8` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure aggregates with invalid or existing names cannot be registered.
+func TestRegisterAggregate_Err(t *testing.T) {
+	mapFn := func(itr influxql.Iterator, args []interface{}) interface{} { return nil }
+	reduceFn := func(values []interface{}, args []interface{}) interface{} { return nil }
+
+	for i, tt := range []struct {
+		a   *influxql.Aggregate
+		err string
+	}{
+		{a: &influxql.Aggregate{Type: influxql.Number, Map: mapFn, Reduce: reduceFn}, err: `aggregate name required`},
+		{a: &influxql.Aggregate{Name: "foo", Type: influxql.Number}, err: `map and reduce functions required: foo`},
+		{a: &influxql.Aggregate{Name: "foo", Map: mapFn, Reduce: reduceFn}, err: `output type required: foo`},
+		{a: &influxql.Aggregate{Name: "foo", Args: []influxql.DataType{influxql.Time}, Type: influxql.Number, Map: mapFn, Reduce: reduceFn}, err: `invalid argument type for foo: time`},
+		{a: &influxql.Aggregate{Name: "Count", Type: influxql.Number, Map: mapFn, Reduce: reduceFn}, err: `function already exists: count`},
+		{a: &influxql.Aggregate{Name: "round", Type: influxql.Number, Map: mapFn, Reduce: reduceFn}, err: `function already exists: round`},
+	} {
+		if err := influxql.RegisterAggregate(tt.a); err == nil || err.Error() != tt.err {
+			t.Errorf("%d. unexpected error: %v", i, err)
+		}
+	}
+}
+
+// Ensure the planner can estimate the number of distinct values across series.
+func TestPlanner_Plan_ApproxCountDistinct(t *testing.T) {
+	db := NewDB("2000-01-01T12:00:00Z")
+	db.WriteSeries("cpu", map[string]string{"host": "servera"}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(1)})
+	db.WriteSeries("cpu", map[string]string{"host": "servera"}, "2000-01-01T00:00:10Z", map[string]interface{}{"value": float64(2)})
+	db.WriteSeries("cpu", map[string]string{"host": "servera"}, "2000-01-01T00:00:20Z", map[string]interface{}{"value": float64(2)})
+	db.WriteSeries("cpu", map[string]string{"host": "serverb"}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(2)})
+	db.WriteSeries("cpu", map[string]string{"host": "serverb"}, "2000-01-01T00:00:10Z", map[string]interface{}{"value": float64(3)})
+	db.WriteSeries("cpu", map[string]string{"host": "serverb"}, "2000-01-01T00:01:00Z", map[string]interface{}{"value": float64(4)})
+
+	// Query must merge the sketches from each series.
+	rs := db.MustPlanAndExecute(`SELECT approx_count_distinct(value) FROM cpu WHERE time >= "2000-01-01 00:00:00" AND time < "2000-01-01 00:02:00" GROUP BY time(1m)`)
+
+	// Expected resultset.
+	exp := minify(`[{"name":"cpu","columns":["time","approx_count_distinct"],"values":[[946684800000000,3],[946684860000000,1]]}]`)
+
+	// Compare resultsets.
+	if act := jsonify(rs); exp != act {
+		t.Fatalf("unexpected resultset: %s", indent(act))
+	}
+}
+
+// Ensure the planner can estimate percentiles across series.
+func TestPlanner_Plan_ApproxPercentile(t *testing.T) {
+	db := NewDB("2000-01-01T12:00:00Z")
+	for i := 0; i < 100; i++ {
+		host := []string{"servera", "serverb"}[i%2]
+		db.WriteSeries("cpu", map[string]string{"host": host}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(i + 1)})
+	}
+
+	for i, tt := range []struct {
+		p   string
+		exp string
+	}{
+		{p: "0", exp: "1"},
+		{p: "50", exp: "50.5"},
+		{p: "90", exp: "90.5"},
+		{p: "100", exp: "100"},
+	} {
+		rs := db.MustPlanAndExecute(`SELECT approx_percentile(value, ` + tt.p + `) FROM cpu WHERE time >= "2000-01-01 00:00:00"`)
+		exp := `[{"name":"cpu","columns":["time","approx_percentile"],"values":[[946684800000000,` + tt.exp + `]]}]`
+		if act := jsonify(rs); exp != act {
+			t.Errorf("%d. unexpected resultset: %s", i, indent(act))
+		}
+	}
+
+	// Only numeric fields can be used.
+	db.WriteSeries("cpu", nil, "2000-01-01T00:00:00Z", map[string]interface{}{"name": "foo"})
+	if _, err := db.PlanAndExecute(`SELECT approx_percentile(name, 50) FROM cpu`); err == nil || err.Error() != `expected number field in approx_percentile(), got string` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure sketches from remote mappers are merged after being sent as JSON.
+func TestPlanner_Plan_ApproxAggregate_Remote(t *testing.T) {
+	db := &RemoteDB{DB: NewDB("2000-01-01T12:00:00Z"), Remote: NewDB("2000-01-01T12:00:00Z")}
+	for i := 0; i < 1000; i++ {
+		other := db.DB
+		if i%2 == 1 {
+			other = db.Remote
+		}
+		other.WriteSeries("cpu", nil, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(i % 500)})
+	}
+
+	// Plan against both databases.
+	p := influxql.NewPlanner(db)
+	p.Now = func() time.Time { return db.Now }
+	e, err := p.Plan(MustParseSelectStatement(`SELECT approx_count_distinct(value), approx_percentile(value, 50) FROM cpu WHERE time >= "2000-01-01 00:00:00"`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch, err := e.Execute()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rs []*influxql.Row
+	for row := range ch {
+		rs = append(rs, row)
+	}
+
+	// Estimates must be within the expected error.
+	if len(rs) != 1 || len(rs[0].Values) != 1 {
+		t.Fatalf("unexpected resultset: %s", indent(jsonify(rs)))
+	} else if n := rs[0].Values[0][1].(float64); n < 490 || n > 510 {
+		t.Fatalf("unexpected distinct count: %v", n)
+	} else if v := rs[0].Values[0][2].(float64); v < 245 || v > 255 {
+		t.Fatalf("unexpected median: %v", v)
+	}
+}
+
+// Ensure the planner returns an error when a query reads too many series.
+func TestPlanner_Plan_MaxSeries(t *testing.T) {
+	db := NewDB("2000-01-01T12:00:00Z")
+	db.WriteSeries("cpu", map[string]string{"host": "servera"}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(1)})
+	db.WriteSeries("cpu", map[string]string{"host": "serverb"}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(2)})
+
+	p := influxql.NewPlanner(db)
+	p.MaxSeriesN = 2
+	if _, err := p.Plan(MustParseSelectStatement(`SELECT count(value) FROM cpu`)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// Series are counted across all fields.
+	_, err := p.Plan(MustParseSelectStatement(`SELECT count(value) + sum(value) FROM cpu`))
+	if err == nil || err.Error() != "rhs: max series exceeded: 4 series, limit is 2" {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+// Ensure the planner returns an error when a query has too many GROUP BY intervals.
+func TestPlanner_Plan_MaxBuckets(t *testing.T) {
+	db := NewDB("2000-01-01T12:00:00Z")
+	db.WriteSeries("cpu", map[string]string{}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(1)})
+
+	p := influxql.NewPlanner(db)
+	p.Now = func() time.Time { return db.Now }
+	p.MaxBucketsN = 60
+	if _, err := p.Plan(MustParseSelectStatement(`SELECT count(value) FROM cpu WHERE time >= now() - 1h GROUP BY time(1m)`)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	_, err := p.Plan(MustParseSelectStatement(`SELECT count(value) FROM cpu WHERE time >= now() - 1h GROUP BY time(1s)`))
+	if err == nil || err.Error() != "max buckets exceeded: 3600 buckets, limit is 60" {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+// Ensure the executor returns an error when a query scans too many points.
+func TestPlanner_Plan_MaxPoints(t *testing.T) {
+	db := NewDB("2000-01-01T12:00:00Z")
+	for i := 0; i < 10; i++ {
+		db.WriteSeries("cpu", map[string]string{}, fmt.Sprintf("2000-01-01T00:00:%02dZ", i), map[string]interface{}{"value": float64(i)})
+	}
+
+	p := influxql.NewPlanner(db)
+	p.Now = func() time.Time { return db.Now }
+	p.MaxPointsN = 5
+	e, err := p.Plan(MustParseSelectStatement(`SELECT sum(value) FROM cpu`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch, err := e.Execute()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only a single error row should be returned.
+	var rs []*influxql.Row
+	for row := range ch {
+		rs = append(rs, row)
+	}
+	if len(rs) != 1 || rs[0].Err == nil || rs[0].Err.Error() != "max points exceeded: limit is 5" {
+		t.Fatalf("unexpected resultset: %#v", rs)
+	}
+}
+
 // DB represents an in-memory test database that implements methods for Planner.
 type DB struct {
 	measurements map[string]*Measurement
 	series       map[uint32]*Series
 	maxSeriesID  uint32
+	scanN        int64 // points returned by iterators, updated atomically
 
-	Now time.Time
+	Now   time.Time
+	Cache *influxql.ResultCache
 }
 
 // NewDB returns a new instance of DB at a given time.
@@ -245,6 +1150,7 @@ func (db *DB) PlanAndExecute(querystring string) ([]*influxql.Row, error) {
 	// Plan statement.
 	p := influxql.NewPlanner(db)
 	p.Now = func() time.Time { return db.Now }
+	p.Cache, p.Database = db.Cache, "db0"
 	e, err := p.Plan(MustParseSelectStatement(querystring))
 	if err != nil {
 		return nil, err
@@ -274,6 +1180,9 @@ func (db *DB) MustPlanAndExecute(querystring string) []*influxql.Row {
 	return rs
 }
 
+// ScanN returns the number of points returned by the database's iterators.
+func (db *DB) ScanN() int { return int(atomic.LoadInt64(&db.scanN)) }
+
 // WriteSeries writes a series
 func (db *DB) WriteSeries(name string, tags map[string]string, timestamp string, values map[string]interface{}) {
 	// Find or create measurement & series.
@@ -392,6 +1301,35 @@ func (db *DB) Field(name, field string) (fieldID uint8, typ influxql.DataType) {
 	return f.id, f.typ
 }
 
+// RemoteDB represents a test database that stores part of each series in
+// another database, which is read by remote mappers.
+type RemoteDB struct {
+	*DB
+	Remote *DB
+}
+
+// CreateRemoteMappers returns a mapper that reads the series from the remote database.
+func (db *RemoteDB) CreateRemoteMappers(seriesID uint32, min, max time.Time) []influxql.RemoteMapper {
+	return []influxql.RemoteMapper{&RemoteMapper{db: db.Remote}}
+}
+
+// RemoteMapper represents a test mapper which runs against another database.
+// Values are encoded to JSON & back as they would be between nodes.
+type RemoteMapper struct {
+	db *DB
+}
+
+// Run runs the mapper spec against the remote database.
+func (m *RemoteMapper) Run(spec *influxql.MapperSpec, closing <-chan struct{}, fn func([]influxql.MapperValue) bool) error {
+	return influxql.RunMapper(m.db, spec, 0, func(values []influxql.MapperValue) bool {
+		var other []influxql.MapperValue
+		if err := json.Unmarshal(mustMarshalJSON(values), &other); err != nil {
+			panic(err.Error())
+		}
+		return fn(other)
+	})
+}
+
 // CreateIterator returns a new iterator for a given field.
 func (db *DB) CreateIterator(seriesID uint32, fieldID uint8, typ influxql.DataType, min, max time.Time, interval time.Duration) influxql.Iterator {
 	s := db.series[seriesID]
@@ -402,6 +1340,7 @@ func (db *DB) CreateIterator(seriesID uint32, fieldID uint8, typ influxql.DataTy
 	// Create iterator.
 	i := &iterator{
 		points:   s.points,
+		scanN:    &db.scanN,
 		fieldID:  fieldID,
 		typ:      typ,
 		imin:     -1,
@@ -425,6 +1364,7 @@ type iterator struct {
 
 	index  int
 	points points
+	scanN  *int64 // points returned counter
 
 	min, max   int64 // time range
 	imin, imax int64 // interval time range
@@ -452,9 +1392,10 @@ func (i *iterator) NextIterval() bool {
 	}
 
 	// Interval end time should be the start time plus interval duration.
-	// If the end time is beyond the iterator end time then shorten it.
+	// If the end time is beyond the iterator end time or there is no
+	// interval then shorten it.
 	i.imax = i.imin + i.interval
-	if max := i.max; i.imax > max {
+	if max := i.max; i.interval == 0 || i.imax > max {
 		i.imax = max
 	}
 
@@ -483,9 +1424,15 @@ func (i *iterator) Next() (timestamp int64, value interface{}) {
 			return 0, nil
 		}
 
+		// Skip points before the interval.
+		if timestamp < i.imin {
+			continue
+		}
+
 		// Return value if it is non-nil.
 		// Otherwise loop again and try the next point.
 		if v != nil {
+			atomic.AddInt64(i.scanN, 1)
 			return p.timestamp, v
 		}
 	}
@@ -0,0 +1,221 @@
+package influxql
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+)
+
+// MapFunc computes a value from the points of a single series in an interval.
+// The args are the literal arguments passed after the field. The returned value
+// is passed to the reduce function. Returns nil if the interval has no value.
+type MapFunc func(itr Iterator, args []interface{}) interface{}
+
+// ReduceFunc combines the values mapped from each series for an interval.
+// Returns nil if the interval has no value.
+type ReduceFunc func(values []interface{}, args []interface{}) interface{}
+
+// Aggregate represents a named aggregate function. Each series is mapped
+// separately for every interval and the mapped values are then reduced.
+//
+// Mapped values may be sent to other nodes so they should encode to JSON.
+type Aggregate struct {
+	Name   string     // function name, case-insensitive
+	Field  DataType   // field argument type, Unknown accepts any type
+	Args   []DataType // types of the literal arguments after the field
+	Type   DataType   // output data type
+	Map    MapFunc
+	Reduce ReduceFunc
+
+	mapName    string // map function name, used by Explain() and remote mappers
+	reduceName string // reduce function name, used by Explain()
+}
+
+// mapFunc returns a map function that emits the mapped value for each interval.
+func (a *Aggregate) mapFunc(args []interface{}) mapFunc {
+	return func(itr Iterator, m *mapper) {
+		if v := a.Map(itr, args); v != nil {
+			m.emit(itr.Time(), v)
+		}
+	}
+}
+
+// reduceFunc returns a reduce function that emits the reduced value for each key.
+func (a *Aggregate) reduceFunc(args []interface{}) reduceFunc {
+	return func(key string, values []interface{}, r *reducer) {
+		if v := a.Reduce(values, args); v != nil {
+			r.emit(key, v)
+		}
+	}
+}
+
+// aggregates is the registry of aggregate functions by name and map function name.
+var aggregates = struct {
+	sync.RWMutex
+	m      map[string]*Aggregate
+	mapped map[string]*Aggregate
+}{
+	m:      make(map[string]*Aggregate),
+	mapped: make(map[string]*Aggregate),
+}
+
+// RegisterAggregate adds an aggregate function that can be called in queries.
+// Returns an error if the aggregate is invalid or the name is already in use.
+func RegisterAggregate(a *Aggregate) error {
+	name := strings.ToLower(a.Name)
+	if name == "" {
+		return fmt.Errorf("aggregate name required")
+	} else if a.Map == nil || a.Reduce == nil {
+		return fmt.Errorf("map and reduce functions required: %s", name)
+	} else if a.Type == Unknown {
+		return fmt.Errorf("output type required: %s", name)
+	} else if scalarFuncs[name] != nil || name == "time" || name == "raw" {
+		return fmt.Errorf("function already exists: %s", name)
+	}
+	for _, typ := range a.Args {
+		if typ != Number && typ != String && typ != Boolean {
+			return fmt.Errorf("invalid argument type for %s: %s", name, typ)
+		}
+	}
+
+	// Copy the aggregate so it can't be changed once registered.
+	other := *a
+	other.Name = name
+	other.Args = append([]DataType(nil), a.Args...)
+	if other.mapName == "" {
+		other.mapName = "map" + strings.ToUpper(name[:1]) + name[1:]
+	}
+	if other.reduceName == "" {
+		other.reduceName = "reduce" + strings.ToUpper(name[:1]) + name[1:]
+	}
+
+	aggregates.Lock()
+	defer aggregates.Unlock()
+	if aggregates.m[name] != nil || aggregates.mapped[other.mapName] != nil {
+		return fmt.Errorf("function already exists: %s", name)
+	}
+	aggregates.m[name] = &other
+	aggregates.mapped[other.mapName] = &other
+	return nil
+}
+
+// LookupAggregate returns a registered aggregate by name.
+// Returns nil if the aggregate does not exist.
+func LookupAggregate(name string) *Aggregate {
+	aggregates.RLock()
+	defer aggregates.RUnlock()
+	return aggregates.m[strings.ToLower(name)]
+}
+
+// lookupAggregateByMapName returns a registered aggregate by map function name.
+func lookupAggregateByMapName(name string) *Aggregate {
+	aggregates.RLock()
+	defer aggregates.RUnlock()
+	return aggregates.mapped[name]
+}
+
+// mustRegisterAggregate registers an aggregate and panics on error.
+func mustRegisterAggregate(a *Aggregate) {
+	if err := RegisterAggregate(a); err != nil {
+		panic(err)
+	}
+}
+
+// scalarFunc represents a row-level function applied to each value.
+type scalarFunc struct {
+	args     []DataType // argument types
+	variadic bool       // if true, the last argument type may repeat
+	ret      DataType   // return type
+	fn       func(args []interface{}) interface{}
+}
+
+// validArgN returns true if n arguments can be passed to the function.
+func (f *scalarFunc) validArgN(n int) bool {
+	if f.variadic {
+		return n >= len(f.args)
+	}
+	return n == len(f.args)
+}
+
+// argType returns the expected type of the argument at index i.
+func (f *scalarFunc) argType(i int) DataType {
+	if i >= len(f.args) {
+		return f.args[len(f.args)-1]
+	}
+	return f.args[i]
+}
+
+// scalarFuncs is a lookup of scalar functions by name.
+// Functions added here can be used in any field expression.
+var scalarFuncs = map[string]*scalarFunc{
+	"abs":   newMathFunc(math.Abs),
+	"ceil":  newMathFunc(math.Ceil),
+	"floor": newMathFunc(math.Floor),
+	"round": newMathFunc(round),
+	"sqrt":  newMathFunc(math.Sqrt),
+	"ln":    newMathFunc(math.Log),
+	"pow": {
+		args: []DataType{Number, Number},
+		ret:  Number,
+		fn: func(args []interface{}) interface{} {
+			return finite(math.Pow(args[0].(float64), args[1].(float64)))
+		},
+	},
+	"log": {
+		args: []DataType{Number, Number},
+		ret:  Number,
+		fn: func(args []interface{}) interface{} {
+			return finite(math.Log(args[0].(float64)) / math.Log(args[1].(float64)))
+		},
+	},
+	"lower": newStringFunc(strings.ToLower),
+	"upper": newStringFunc(strings.ToUpper),
+	"concat": {
+		args:     []DataType{String, String},
+		variadic: true,
+		ret:      String,
+		fn: func(args []interface{}) interface{} {
+			var s string
+			for _, arg := range args {
+				s += arg.(string)
+			}
+			return s
+		},
+	},
+}
+
+// newMathFunc returns a scalar function for a single argument math function.
+func newMathFunc(fn func(float64) float64) *scalarFunc {
+	return &scalarFunc{
+		args: []DataType{Number},
+		ret:  Number,
+		fn:   func(args []interface{}) interface{} { return finite(fn(args[0].(float64))) },
+	}
+}
+
+// newStringFunc returns a scalar function for a single argument string function.
+func newStringFunc(fn func(string) string) *scalarFunc {
+	return &scalarFunc{
+		args: []DataType{String},
+		ret:  String,
+		fn:   func(args []interface{}) interface{} { return fn(args[0].(string)) },
+	}
+}
+
+// finite returns v, or nil if v is NaN or infinite. Non-finite values can't
+// be encoded as JSON so they are treated as empty values.
+func finite(v float64) interface{} {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return nil
+	}
+	return v
+}
+
+// round returns the nearest integer, rounding half away from zero.
+func round(v float64) float64 {
+	if v < 0 {
+		return -math.Floor(-v + 0.5)
+	}
+	return math.Floor(v + 0.5)
+}
@@ -68,6 +68,12 @@ func (p *Parser) ParseStatement() (Statement, error) {
 		return p.parseSelectStatement()
 	case DELETE:
 		return p.parseDeleteStatement()
+	case EXPLAIN:
+		return p.parseExplainStatement()
+	case SHOW:
+		return p.parseShowStatement()
+	case KILL:
+		return p.parseKillStatement()
 	case LIST:
 		return p.parseListStatement()
 	case CREATE:
@@ -163,9 +169,11 @@ func (p *Parser) parseAlterStatement() (Statement, error) {
 			return nil, newParseError(tokstr(tok, lit), []string{"POLICY"}, pos)
 		}
 		return p.parseAlterRetentionPolicyStatement()
+	} else if tok == MEASUREMENT {
+		return p.parseAlterMeasurementStatement()
 	}
 
-	return nil, newParseError(tokstr(tok, lit), []string{"RETENTION"}, pos)
+	return nil, newParseError(tokstr(tok, lit), []string{"RETENTION", "MEASUREMENT"}, pos)
 }
 
 // parseCreateRetentionPolicyStatement parses a string and returns a create retention policy statement.
@@ -217,6 +225,27 @@ func (p *Parser) parseCreateRetentionPolicyStatement() (*CreateRetentionPolicySt
 	}
 	stmt.Replication = n
 
+	// Parse optional SHARD DURATION and SHARD KEY clauses.
+	for {
+		if tok, _, _ = p.scanIgnoreWhitespace(); tok != SHARD {
+			p.unscan()
+			break
+		}
+
+		switch tok, pos, lit = p.scanIgnoreWhitespace(); tok {
+		case DURATION:
+			if stmt.ShardGroupDuration, err = p.parseDuration(); err != nil {
+				return nil, err
+			}
+		case KEY:
+			if stmt.ShardKey, err = p.parseShardKey(); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, newParseError(tokstr(tok, lit), []string{"DURATION", "KEY"}, pos)
+		}
+	}
+
 	// Parse optional DEFAULT token.
 	if tok, pos, lit = p.scanIgnoreWhitespace(); tok == DEFAULT {
 		stmt.Default = true
@@ -227,6 +256,30 @@ func (p *Parser) parseCreateRetentionPolicyStatement() (*CreateRetentionPolicySt
 	return stmt, nil
 }
 
+// parseShardKey parses the tag keys of a "SHARD KEY (key, ...)" clause.
+// This function assumes the SHARD KEY tokens have already been consumed.
+func (p *Parser) parseShardKey() ([]string, error) {
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != LPAREN {
+		return nil, newParseError(tokstr(tok, lit), []string{"("}, pos)
+	}
+
+	// Parse one or more tag keys separated by commas.
+	var keys []string
+	for {
+		key, err := p.parseIdentifier()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+
+		if tok, pos, lit := p.scanIgnoreWhitespace(); tok == RPAREN {
+			return keys, nil
+		} else if tok != COMMA {
+			return nil, newParseError(tokstr(tok, lit), []string{",", ")"}, pos)
+		}
+	}
+}
+
 // parseAlterRetentionPolicyStatement parses a string and returns an alter retention policy statement.
 // This function assumes the ALTER RETENTION POLICY tokens have already been consumned.
 func (p *Parser) parseAlterRetentionPolicyStatement() (*AlterRetentionPolicyStatement, error) {
@@ -251,8 +304,8 @@ func (p *Parser) parseAlterRetentionPolicyStatement() (*AlterRetentionPolicyStat
 	}
 	stmt.DB = ident
 
-	// Loop through option tokens (DURATION, RETENTION, DEFAULT, etc.).
-	maxNumOptions := 3
+	// Loop through option tokens (DURATION, REPLICATION, SHARD DURATION, DEFAULT).
+	maxNumOptions := 4
 Loop:
 	for i := 0; i < maxNumOptions; i++ {
 		tok, pos, lit := p.scanIgnoreWhitespace()
@@ -269,11 +322,20 @@ Loop:
 				return nil, err
 			}
 			stmt.Replication = &n
+		case SHARD:
+			if tok, pos, lit := p.scanIgnoreWhitespace(); tok != DURATION {
+				return nil, newParseError(tokstr(tok, lit), []string{"DURATION"}, pos)
+			}
+			d, err := p.parseDuration()
+			if err != nil {
+				return nil, err
+			}
+			stmt.ShardGroupDuration = &d
 		case DEFAULT:
 			stmt.Default = true
 		default:
 			if i < 1 {
-				return nil, newParseError(tokstr(tok, lit), []string{"DURATION", "RETENTION", "DEFAULT"}, pos)
+				return nil, newParseError(tokstr(tok, lit), []string{"DURATION", "REPLICATION", "SHARD", "DEFAULT"}, pos)
 			}
 			p.unscan()
 			break Loop
@@ -283,6 +345,68 @@ Loop:
 	return stmt, nil
 }
 
+// parseAlterMeasurementStatement parses a string and returns an alter measurement statement.
+// This function assumes the ALTER MEASUREMENT tokens have already been consumed.
+func (p *Parser) parseAlterMeasurementStatement() (*AlterMeasurementStatement, error) {
+	stmt := &AlterMeasurementStatement{}
+
+	// Parse the measurement name.
+	ident, err := p.parseIdentifier()
+	if err != nil {
+		return nil, err
+	}
+	stmt.Name = ident
+
+	// Parse the optional field name and the required SET token.
+	tok, pos, lit := p.scanIgnoreWhitespace()
+	if tok == FIELD {
+		if stmt.Field, err = p.parseIdentifier(); err != nil {
+			return nil, err
+		}
+		if tok, pos, lit = p.scanIgnoreWhitespace(); tok != SET {
+			return nil, newParseError(tokstr(tok, lit), []string{"SET"}, pos)
+		}
+	} else if tok != SET {
+		return nil, newParseError(tokstr(tok, lit), []string{"FIELD", "SET"}, pos)
+	}
+
+	// Parse the comma-separated list of "key = 'value'" assignments.
+	for {
+		tok, pos, lit := p.scanIgnoreWhitespace()
+		var v **string
+		if tok == IDENT {
+			switch strings.ToLower(lit) {
+			case "unit":
+				v = &stmt.Unit
+			case "description":
+				v = &stmt.Description
+			case "owner":
+				v = &stmt.Owner
+			}
+		}
+		if v == nil {
+			return nil, newParseError(tokstr(tok, lit), []string{"unit", "description", "owner"}, pos)
+		}
+
+		if tok, pos, lit = p.scanIgnoreWhitespace(); tok != EQ {
+			return nil, newParseError(tokstr(tok, lit), []string{"="}, pos)
+		}
+		if tok, pos, lit = p.scanIgnoreWhitespace(); tok != STRING {
+			return nil, newParseError(tokstr(tok, lit), []string{"string"}, pos)
+		}
+		value := lit
+		*v = &value
+
+		// Continue while there are more assignments.
+		if tok, _, _ := p.scanIgnoreWhitespace(); tok != COMMA {
+			p.unscan()
+			break
+		}
+	}
+
+	return stmt, nil
+}
+
 // parseInt parses a string and returns an integer literal.
 func (p *Parser) parseInt(min, max int) (int, error) {
 	tok, pos, lit := p.scanIgnoreWhitespace()
@@ -452,6 +576,13 @@ func (p *Parser) parseSelectStatement() (*SelectStatement, error) {
 	}
 	stmt.Fields = fields
 
+	// Parse target: "INTO"
+	target, err := p.parseTarget()
+	if err != nil {
+		return nil, err
+	}
+	stmt.Target = target
+
 	// Parse source.
 	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != FROM {
 		return nil, newParseError(tokstr(tok, lit), []string{"FROM"}, pos)
@@ -490,6 +621,13 @@ func (p *Parser) parseSelectStatement() (*SelectStatement, error) {
 	}
 	stmt.Limit = limit
 
+	// Parse time zone: "tz('LOCATION')".
+	loc, err := p.parseLocation()
+	if err != nil {
+		return nil, err
+	}
+	stmt.Location = loc
+
 	return stmt, nil
 }
 
@@ -518,6 +656,109 @@ func (p *Parser) parseDeleteStatement() (*DeleteStatement, error) {
 	return stmt, nil
 }
 
+// parseExplainStatement parses a string and returns an ExplainStatement.
+// This function assumes the EXPLAIN token has already been consumed.
+func (p *Parser) parseExplainStatement() (*ExplainStatement, error) {
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != SELECT {
+		return nil, newParseError(tokstr(tok, lit), []string{"SELECT"}, pos)
+	}
+
+	stmt, err := p.parseSelectStatement()
+	if err != nil {
+		return nil, err
+	}
+	return &ExplainStatement{Statement: stmt}, nil
+}
+
+// parseShowStatement parses a string and returns a show statement.
+// This function assumes the SHOW token has already been consumed.
+func (p *Parser) parseShowStatement() (Statement, error) {
+	tok, pos, lit := p.scanIgnoreWhitespace()
+	switch tok {
+	case QUERIES:
+		return &ShowQueriesStatement{}, nil
+	case SERIES:
+		if tok, pos, lit := p.scanIgnoreWhitespace(); tok != CARDINALITY {
+			return nil, newParseError(tokstr(tok, lit), []string{"CARDINALITY"}, pos)
+		}
+		return p.parseShowSeriesCardinalityStatement()
+	case TAG:
+		if tok, pos, lit := p.scanIgnoreWhitespace(); tok != VALUES {
+			return nil, newParseError(tokstr(tok, lit), []string{"VALUES"}, pos)
+		} else if tok, pos, lit := p.scanIgnoreWhitespace(); tok != CARDINALITY {
+			return nil, newParseError(tokstr(tok, lit), []string{"CARDINALITY"}, pos)
+		}
+		return p.parseShowTagValuesCardinalityStatement()
+	}
+	return nil, newParseError(tokstr(tok, lit), []string{"QUERIES", "SERIES", "TAG"}, pos)
+}
+
+// parseShowSeriesCardinalityStatement parses a string and returns a ShowSeriesCardinalityStatement.
+// This function assumes the "SHOW SERIES CARDINALITY" tokens have already been consumed.
+func (p *Parser) parseShowSeriesCardinalityStatement() (*ShowSeriesCardinalityStatement, error) {
+	stmt := &ShowSeriesCardinalityStatement{}
+
+	// Parse optional source: "FROM SOURCE".
+	source, err := p.parseOptionalSource()
+	if err != nil {
+		return nil, err
+	}
+	stmt.Source = source
+
+	return stmt, nil
+}
+
+// parseShowTagValuesCardinalityStatement parses a string and returns a ShowTagValuesCardinalityStatement.
+// This function assumes the "SHOW TAG VALUES CARDINALITY" tokens have already been consumed.
+func (p *Parser) parseShowTagValuesCardinalityStatement() (*ShowTagValuesCardinalityStatement, error) {
+	stmt := &ShowTagValuesCardinalityStatement{}
+
+	// Parse optional source: "FROM SOURCE".
+	source, err := p.parseOptionalSource()
+	if err != nil {
+		return nil, err
+	}
+	stmt.Source = source
+
+	// Parse optional tag key: "WITH KEY = IDENT".
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok != WITH {
+		p.unscan()
+		return stmt, nil
+	}
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != KEY {
+		return nil, newParseError(tokstr(tok, lit), []string{"KEY"}, pos)
+	} else if tok, pos, lit := p.scanIgnoreWhitespace(); tok != EQ {
+		return nil, newParseError(tokstr(tok, lit), []string{"="}, pos)
+	}
+	key, err := p.parseIdentifier()
+	if err != nil {
+		return nil, err
+	}
+	stmt.TagKey = key
+
+	return stmt, nil
+}
+
+// parseKillStatement parses a string and returns a KillQueryStatement.
+// This function assumes the KILL token has already been consumed.
+func (p *Parser) parseKillStatement() (*KillQueryStatement, error) {
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != QUERY {
+		return nil, newParseError(tokstr(tok, lit), []string{"QUERY"}, pos)
+	}
+
+	// Read the query id.
+	tok, pos, lit := p.scanIgnoreWhitespace()
+	if tok != NUMBER {
+		return nil, newParseError(tokstr(tok, lit), []string{"number"}, pos)
+	}
+	id, err := strconv.ParseUint(lit, 10, 64)
+	if err != nil {
+		return nil, &ParseError{Message: "invalid query id: " + lit, Pos: pos}
+	}
+
+	return &KillQueryStatement{QueryID: id}, nil
+}
+
 // parseListSeriesStatement parses a string and returns a ListSeriesStatement.
 // This function assumes the "LIST SERIES" tokens have already been consumed.
 func (p *Parser) parseListSeriesStatement() (*ListSeriesStatement, error) {
@@ -936,8 +1177,8 @@ func (p *Parser) parseFields() (Fields, error) {
 	var fields Fields
 
 	// Check for "*" (i.e., "all fields")
-	if tok, _, _ := p.scanIgnoreWhitespace(); tok == MUL {
-		fields = append(fields, &Field{&Wildcard{}, ""})
+	if tok, pos, _ := p.scanIgnoreWhitespace(); tok == MUL {
+		fields = append(fields, &Field{Expr: &Wildcard{}, Pos: pos})
 		return fields, nil
 	}
 	p.unscan()
@@ -965,6 +1206,10 @@ func (p *Parser) parseFields() (Fields, error) {
 func (p *Parser) parseField() (*Field, error) {
 	f := &Field{}
 
+	// Save the position of the field's first token.
+	_, f.Pos, _ = p.scanIgnoreWhitespace()
+	p.unscan()
+
 	// Parse the expression first.
 	expr, err := p.ParseExpr()
 	if err != nil {
@@ -1001,20 +1246,188 @@ func (p *Parser) parseAlias() (string, error) {
 	return lit, nil
 }
 
+// parseTarget parses the "INTO" clause of the query, if it exists.
+func (p *Parser) parseTarget() (*Target, error) {
+	// Check if the INTO token exists.
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok != INTO {
+		p.unscan()
+		return nil, nil
+	}
+
+	// Parse the segmented "db.rp.measurement" name.
+	_, pos, _ := p.scanIgnoreWhitespace()
+	p.unscan()
+	idents, err := p.parseSegmentedIdents()
+	if err != nil {
+		return nil, err
+	}
+
+	// Assign segments from right to left.
+	t := &Target{}
+	switch len(idents) {
+	case 1:
+		t.Measurement = idents[0]
+	case 2:
+		t.RetentionPolicy, t.Measurement = idents[0], idents[1]
+	case 3:
+		t.Database, t.RetentionPolicy, t.Measurement = idents[0], idents[1], idents[2]
+	default:
+		return nil, &ParseError{Message: "too many segments in " + strings.Join(idents, "."), Pos: pos}
+	}
+
+	// A measurement name is always required.
+	if t.Measurement == "" {
+		return nil, &ParseError{Message: "target measurement required", Pos: pos}
+	}
+
+	return t, nil
+}
+
+// parseSegmentedIdents parses a list of identifiers separated by dots.
+// Unquoted identifiers are split on their dots. Quoted identifiers are
+// used as-is so that names containing a dot can be referenced.
+// e.g. "db"."rp".cpu returns ["db", "rp", "cpu"].
+func (p *Parser) parseSegmentedIdents() ([]string, error) {
+	var idents []string
+	for {
+		tok, pos, lit := p.scan()
+		if tok == IDENT {
+			// A trailing dot is consumed by the scanner as part of the
+			// identifier so it acts as the separator for the next segment.
+			idents = append(idents, strings.Split(strings.TrimSuffix(lit, "."), ".")...)
+			if strings.HasSuffix(lit, ".") {
+				continue
+			}
+		} else if tok == STRING {
+			idents = append(idents, lit)
+		} else {
+			return nil, newParseError(tokstr(tok, lit), []string{"identifier", "string"}, pos)
+		}
+
+		// If there's not a dot next then stop parsing segments.
+		if tok, _, _ := p.scan(); tok != DOT {
+			p.unscan()
+			break
+		}
+	}
+	return idents, nil
+}
+
+// parseOptionalSource parses a "FROM" clause if one is next.
+// Returns a nil source otherwise.
+func (p *Parser) parseOptionalSource() (Source, error) {
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok != FROM {
+		p.unscan()
+		return nil, nil
+	}
+	return p.parseSource()
+}
+
 // parseSource parses the "FROM" clause of the query.
+// The source is either a join/merge call or a comma-separated list of
+// measurement names and regular expressions.
 func (p *Parser) parseSource() (Source, error) {
-	// The first token can either be the series name or a join/merge call.
+	// If the first token is an identifier followed by an LPAREN then parse a join/merge call.
 	tok, pos, lit := p.scanIgnoreWhitespace()
-	if tok != IDENT && tok != STRING {
-		return nil, newParseError(tokstr(tok, lit), []string{"identifier", "string"}, pos)
+	if tok == IDENT {
+		if next, _, _ := p.scan(); next == LPAREN {
+			return p.parseJoinSource(lit, pos)
+		}
+		p.unscan()
 	}
+	p.unscan()
 
-	// If the token is a string or the next token is not an LPAREN then return a measurement.
-	if next, _, _ := p.scan(); tok == STRING || (tok == IDENT && next != LPAREN) {
-		p.unscan()
-		return &Measurement{Name: lit}, nil
+	// Parse measurement list.
+	var measurements Measurements
+	for {
+		m, err := p.parseMeasurement()
+		if err != nil {
+			return nil, err
+		}
+		measurements = append(measurements, m)
+
+		// If there's not a comma next then stop parsing measurements.
+		if tok, _, _ := p.scanIgnoreWhitespace(); tok != COMMA {
+			p.unscan()
+			break
+		}
 	}
 
+	// Return a single measurement as-is.
+	if len(measurements) == 1 {
+		return measurements[0], nil
+	}
+	return measurements, nil
+}
+
+// parseMeasurement parses a measurement name or a regular expression
+// matching measurement names. The measurement can be qualified with a
+// database and retention policy, e.g. "db"."rp".cpu. Unquoted identifiers
+// are not split on their dots so measurement names containing dots can be
+// used without quotes.
+func (p *Parser) parseMeasurement() (*Measurement, error) {
+	_, pos, _ := p.scanIgnoreWhitespace()
+	p.unscan()
+
+	// Parse each dot separated segment. The last segment is the name.
+	m := &Measurement{}
+	var idents []string
+loop:
+	for {
+		tok, pos0, lit := p.scan()
+		switch tok {
+		case IDENT:
+			// A trailing dot is consumed by the scanner as part of the
+			// identifier so it acts as the separator for the next segment.
+			if strings.HasSuffix(lit, ".") {
+				idents = append(idents, strings.TrimSuffix(lit, "."))
+				continue
+			}
+			idents = append(idents, lit)
+		case STRING:
+			idents = append(idents, lit)
+			if tok, _, _ := p.scan(); tok == DOT {
+				continue
+			}
+			p.unscan()
+		case DIV:
+			// Scan the rest of the regular expression and compile it.
+			tok, pos0, lit = p.s.ScanRegex()
+			if tok != REGEX {
+				return nil, &ParseError{Message: "unterminated regex", Pos: pos0}
+			}
+			re, err := regexp.Compile(lit)
+			if err != nil {
+				return nil, &ParseError{Message: "invalid regex: " + lit, Pos: pos0}
+			}
+			m.Regex = re
+		default:
+			return nil, newParseError(tokstr(tok, lit), []string{"identifier", "string", "regex"}, pos0)
+		}
+		break loop
+	}
+
+	// Assign segments from right to left.
+	qualifiers := idents
+	if m.Regex == nil {
+		m.Name, qualifiers = idents[len(idents)-1], idents[:len(idents)-1]
+	}
+	switch len(qualifiers) {
+	case 0:
+	case 1:
+		m.RetentionPolicy = qualifiers[0]
+	case 2:
+		m.Database, m.RetentionPolicy = qualifiers[0], qualifiers[1]
+	default:
+		return nil, &ParseError{Message: "too many segments in " + strings.Join(idents, "."), Pos: pos}
+	}
+
+	return m, nil
+}
+
+// parseJoinSource parses the measurement list of a join/merge call.
+// This function assumes the call name and LPAREN have already been consumed.
+func (p *Parser) parseJoinSource(lit string, pos Pos) (Source, error) {
 	// Verify the source type is join/merge.
 	sourceType := strings.ToLower(lit)
 	if sourceType != "join" && sourceType != "merge" {
@@ -1103,6 +1516,10 @@ func (p *Parser) parseDimensions() (Dimensions, error) {
 
 // parseDimension parses a single dimension.
 func (p *Parser) parseDimension() (*Dimension, error) {
+	// Save the position of the dimension's first token.
+	_, pos, _ := p.scanIgnoreWhitespace()
+	p.unscan()
+
 	// Parse the expression first.
 	expr, err := p.ParseExpr()
 	if err != nil {
@@ -1112,7 +1529,7 @@ func (p *Parser) parseDimension() (*Dimension, error) {
 	// Consume all trailing whitespace.
 	p.consumeWhitespace()
 
-	return &Dimension{Expr: expr}, nil
+	return &Dimension{Expr: expr, Pos: pos}, nil
 }
 
 // parseLimit parses the "LIMIT" clause of the query, if it exists.
@@ -1144,6 +1561,35 @@ func (p *Parser) parseLimit() (int, error) {
 	return int(n), nil
 }
 
+// parseLocation parses the "tz('LOCATION')" clause of a query, if it exists.
+func (p *Parser) parseLocation() (*time.Location, error) {
+	// Check if the tz() call exists.
+	if tok, _, lit := p.scanIgnoreWhitespace(); tok != IDENT || strings.ToLower(lit) != "tz" {
+		p.unscan()
+		return nil, nil
+	}
+
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != LPAREN {
+		return nil, newParseError(tokstr(tok, lit), []string{"("}, pos)
+	}
+
+	// Scan the location name and load it.
+	tok, pos, lit := p.scanIgnoreWhitespace()
+	if tok != STRING {
+		return nil, newParseError(tokstr(tok, lit), []string{"string"}, pos)
+	}
+	loc, err := time.LoadLocation(lit)
+	if err != nil {
+		return nil, &ParseError{Message: "unknown time zone: " + lit, Pos: pos}
+	}
+
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != RPAREN {
+		return nil, newParseError(tokstr(tok, lit), []string{")"}, pos)
+	}
+
+	return loc, nil
+}
+
 // parseOrderBy parses the "ORDER BY" clause of a query, if it exists.
 func (p *Parser) parseOrderBy() (SortFields, error) {
 	// Return nil result and nil error if no ORDER token at this position.
@@ -1282,7 +1728,7 @@ func (p *Parser) parseUnaryExpr() (Expr, error) {
 		// If the next immediate token is a left parentheses, parse as function call.
 		// Otherwise parse as a variable reference.
 		if tok0, _, _ := p.scan(); tok0 == LPAREN {
-			return p.parseCall(lit)
+			return p.parseCall(lit, pos)
 		} else {
 			p.unscan()
 			return &VarRef{Val: lit}, nil
@@ -1319,12 +1765,12 @@ func (p *Parser) parseUnaryExpr() (Expr, error) {
 	}
 }
 
-// parseCall parses a function call.
+// parseCall parses a function call starting at pos.
 // This function assumes the function name and LPAREN have been consumed.
-func (p *Parser) parseCall(name string) (*Call, error) {
+func (p *Parser) parseCall(name string, pos Pos) (*Call, error) {
 	// If there's a right paren then just return immediately.
 	if tok, _, _ := p.scan(); tok == RPAREN {
-		return &Call{Name: name}, nil
+		return &Call{Name: name, Pos: pos}, nil
 	}
 	p.unscan()
 
@@ -1350,7 +1796,7 @@ func (p *Parser) parseCall(name string) (*Call, error) {
 		return nil, newParseError(tokstr(tok, lit), []string{")"}, pos)
 	}
 
-	return &Call{Name: name, Args: args}, nil
+	return &Call{Name: name, Args: args, Pos: pos}, nil
 }
 
 // scan returns the next token from the underlying scanner.
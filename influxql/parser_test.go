@@ -2,6 +2,7 @@ package influxql_test
 
 import (
 	"reflect"
+	"regexp"
 	"strings"
 	"testing"
 	"time"
@@ -50,7 +51,7 @@ func TestParser_ParseStatement(t *testing.T) {
 			s: `SELECT * FROM myseries`,
 			stmt: &influxql.SelectStatement{
 				Fields: influxql.Fields{
-					&influxql.Field{Expr: &influxql.Wildcard{}},
+					&influxql.Field{Expr: &influxql.Wildcard{}, Pos: influxql.Pos{Line: 0, Char: 7}},
 				},
 				Source: &influxql.Measurement{Name: "myseries"},
 			},
@@ -61,9 +62,9 @@ func TestParser_ParseStatement(t *testing.T) {
 			s: `SELECT field1, field2 ,field3 AS field_x FROM myseries WHERE host = 'hosta.influxdb.org' GROUP BY 10h ORDER BY ASC LIMIT 20;`,
 			stmt: &influxql.SelectStatement{
 				Fields: influxql.Fields{
-					&influxql.Field{Expr: &influxql.VarRef{Val: "field1"}},
-					&influxql.Field{Expr: &influxql.VarRef{Val: "field2"}},
-					&influxql.Field{Expr: &influxql.VarRef{Val: "field3"}, Alias: "field_x"},
+					&influxql.Field{Expr: &influxql.VarRef{Val: "field1"}, Pos: influxql.Pos{Line: 0, Char: 7}},
+					&influxql.Field{Expr: &influxql.VarRef{Val: "field2"}, Pos: influxql.Pos{Line: 0, Char: 15}},
+					&influxql.Field{Expr: &influxql.VarRef{Val: "field3"}, Alias: "field_x", Pos: influxql.Pos{Line: 0, Char: 23}},
 				},
 				Source: &influxql.Measurement{Name: "myseries"},
 				Condition: &influxql.BinaryExpr{
@@ -72,7 +73,7 @@ func TestParser_ParseStatement(t *testing.T) {
 					RHS: &influxql.StringLiteral{Val: "hosta.influxdb.org"},
 				},
 				Dimensions: influxql.Dimensions{
-					&influxql.Dimension{Expr: &influxql.DurationLiteral{Val: 10 * time.Hour}},
+					&influxql.Dimension{Expr: &influxql.DurationLiteral{Val: 10 * time.Hour}, Pos: influxql.Pos{Line: 0, Char: 98}},
 				},
 				Limit: 20,
 				SortFields: influxql.SortFields{
@@ -81,11 +82,55 @@ func TestParser_ParseStatement(t *testing.T) {
 			},
 		},
 
+		// SELECT statement with GROUP BY time offset and time zone
+		{
+			s: `SELECT sum(value) FROM cpu GROUP BY time(1d, 6h) tz('America/Chicago')`,
+			stmt: &influxql.SelectStatement{
+				Fields: influxql.Fields{
+					&influxql.Field{Expr: &influxql.Call{Name: "sum", Args: []influxql.Expr{&influxql.VarRef{Val: "value"}}, Pos: influxql.Pos{Line: 0, Char: 7}}, Pos: influxql.Pos{Line: 0, Char: 7}},
+				},
+				Source: &influxql.Measurement{Name: "cpu"},
+				Dimensions: influxql.Dimensions{
+					&influxql.Dimension{Expr: &influxql.Call{Name: "time", Args: []influxql.Expr{
+						&influxql.DurationLiteral{Val: 24 * time.Hour},
+						&influxql.DurationLiteral{Val: 6 * time.Hour},
+					}, Pos: influxql.Pos{Line: 0, Char: 36}}, Pos: influxql.Pos{Line: 0, Char: 36}},
+				},
+				Location: mustLoadLocation("America/Chicago"),
+			},
+		},
+
+		// SELECT statement with regex and multiple measurement sources
+		{
+			s: `SELECT value FROM /^cpu.*/, mem, /a\/b/`,
+			stmt: &influxql.SelectStatement{
+				Fields: influxql.Fields{&influxql.Field{Expr: &influxql.VarRef{Val: "value"}, Pos: influxql.Pos{Line: 0, Char: 7}}},
+				Source: influxql.Measurements{
+					{Regex: regexp.MustCompile(`^cpu.*`)},
+					{Name: "mem"},
+					{Regex: regexp.MustCompile(`a/b`)},
+				},
+			},
+		},
+
+		// SELECT statement with database and retention policy source
+		{
+			s: `SELECT value FROM "mydb"."rp_1y".cpu.load, "rp_1y"."cpu", mydb."".cpu`,
+			stmt: &influxql.SelectStatement{
+				Fields: influxql.Fields{&influxql.Field{Expr: &influxql.VarRef{Val: "value"}, Pos: influxql.Pos{Line: 0, Char: 7}}},
+				Source: influxql.Measurements{
+					{Database: "mydb", RetentionPolicy: "rp_1y", Name: "cpu.load"},
+					{RetentionPolicy: "rp_1y", Name: "cpu"},
+					{Database: "mydb", Name: "cpu"},
+				},
+			},
+		},
+
 		// SELECT statement with JOIN
 		{
 			s: `SELECT field1 FROM join(aa,"bb", cc) JOIN cc`,
 			stmt: &influxql.SelectStatement{
-				Fields: influxql.Fields{&influxql.Field{Expr: &influxql.VarRef{Val: "field1"}}},
+				Fields: influxql.Fields{&influxql.Field{Expr: &influxql.VarRef{Val: "field1"}, Pos: influxql.Pos{Line: 0, Char: 7}}},
 				Source: &influxql.Join{
 					Measurements: influxql.Measurements{
 						{Name: "aa"},
@@ -100,7 +145,7 @@ func TestParser_ParseStatement(t *testing.T) {
 		{
 			s: `SELECT field1 FROM merge(aa,b.b)`,
 			stmt: &influxql.SelectStatement{
-				Fields: influxql.Fields{&influxql.Field{Expr: &influxql.VarRef{Val: "field1"}}},
+				Fields: influxql.Fields{&influxql.Field{Expr: &influxql.VarRef{Val: "field1"}, Pos: influxql.Pos{Line: 0, Char: 7}}},
 				Source: &influxql.Merge{
 					Measurements: influxql.Measurements{
 						{Name: "aa"},
@@ -110,11 +155,35 @@ func TestParser_ParseStatement(t *testing.T) {
 			},
 		},
 
+		// SELECT INTO statement
+		{
+			s: `SELECT sum(value) INTO "rp_1y"."cpu_1h" FROM cpu GROUP BY time(1h), host`,
+			stmt: &influxql.SelectStatement{
+				Fields: influxql.Fields{&influxql.Field{Expr: &influxql.Call{Name: "sum", Args: []influxql.Expr{&influxql.VarRef{Val: "value"}}, Pos: influxql.Pos{Line: 0, Char: 7}}, Pos: influxql.Pos{Line: 0, Char: 7}}},
+				Target: &influxql.Target{RetentionPolicy: "rp_1y", Measurement: "cpu_1h"},
+				Source: &influxql.Measurement{Name: "cpu"},
+				Dimensions: influxql.Dimensions{
+					&influxql.Dimension{Expr: &influxql.Call{Name: "time", Args: []influxql.Expr{&influxql.DurationLiteral{Val: 1 * time.Hour}}, Pos: influxql.Pos{Line: 0, Char: 58}}, Pos: influxql.Pos{Line: 0, Char: 58}},
+					&influxql.Dimension{Expr: &influxql.VarRef{Val: "host"}, Pos: influxql.Pos{Line: 0, Char: 68}},
+				},
+			},
+		},
+
+		// SELECT INTO statement with database, retention policy & measurement
+		{
+			s: `SELECT value INTO db0."rp.1y".cpu_copy FROM cpu`,
+			stmt: &influxql.SelectStatement{
+				Fields: influxql.Fields{&influxql.Field{Expr: &influxql.VarRef{Val: "value"}, Pos: influxql.Pos{Line: 0, Char: 7}}},
+				Target: &influxql.Target{Database: "db0", RetentionPolicy: "rp.1y", Measurement: "cpu_copy"},
+				Source: &influxql.Measurement{Name: "cpu"},
+			},
+		},
+
 		// SELECT statement (lowercase)
 		{
 			s: `select my_field from myseries`,
 			stmt: &influxql.SelectStatement{
-				Fields: influxql.Fields{&influxql.Field{Expr: &influxql.VarRef{Val: "my_field"}}},
+				Fields: influxql.Fields{&influxql.Field{Expr: &influxql.VarRef{Val: "my_field"}, Pos: influxql.Pos{Line: 0, Char: 7}}},
 				Source: &influxql.Measurement{Name: "myseries"},
 			},
 		},
@@ -123,7 +192,7 @@ func TestParser_ParseStatement(t *testing.T) {
 		{
 			s: `SELECT field1 FROM myseries ORDER BY ASC, field1, field2 DESC LIMIT 10`,
 			stmt: &influxql.SelectStatement{
-				Fields: influxql.Fields{&influxql.Field{Expr: &influxql.VarRef{Val: "field1"}}},
+				Fields: influxql.Fields{&influxql.Field{Expr: &influxql.VarRef{Val: "field1"}, Pos: influxql.Pos{Line: 0, Char: 7}}},
 				Source: &influxql.Measurement{Name: "myseries"},
 				SortFields: influxql.SortFields{
 					&influxql.SortField{Ascending: true},
@@ -147,6 +216,60 @@ func TestParser_ParseStatement(t *testing.T) {
 			},
 		},
 
+		// EXPLAIN statement
+		{
+			s: `EXPLAIN SELECT sum(value) FROM cpu GROUP BY host`,
+			stmt: &influxql.ExplainStatement{
+				Statement: &influxql.SelectStatement{
+					Fields: influxql.Fields{
+						&influxql.Field{Expr: &influxql.Call{Name: "sum", Args: []influxql.Expr{&influxql.VarRef{Val: "value"}}, Pos: influxql.Pos{Line: 0, Char: 15}}, Pos: influxql.Pos{Line: 0, Char: 15}},
+					},
+					Source:     &influxql.Measurement{Name: "cpu"},
+					Dimensions: influxql.Dimensions{&influxql.Dimension{Expr: &influxql.VarRef{Val: "host"}, Pos: influxql.Pos{Line: 0, Char: 44}}},
+				},
+			},
+		},
+
+		// SHOW QUERIES statement
+		{
+			s:    `SHOW QUERIES`,
+			stmt: &influxql.ShowQueriesStatement{},
+		},
+
+		// SHOW SERIES CARDINALITY statement
+		{
+			s:    `SHOW SERIES CARDINALITY`,
+			stmt: &influxql.ShowSeriesCardinalityStatement{},
+		},
+		{
+			s: `SHOW SERIES CARDINALITY FROM cpu, /^mem/`,
+			stmt: &influxql.ShowSeriesCardinalityStatement{
+				Source: influxql.Measurements{
+					{Name: "cpu"},
+					{Regex: regexp.MustCompile(`^mem`)},
+				},
+			},
+		},
+
+		// SHOW TAG VALUES CARDINALITY statement
+		{
+			s:    `SHOW TAG VALUES CARDINALITY`,
+			stmt: &influxql.ShowTagValuesCardinalityStatement{},
+		},
+		{
+			s: `SHOW TAG VALUES CARDINALITY FROM cpu WITH KEY = "host"`,
+			stmt: &influxql.ShowTagValuesCardinalityStatement{
+				Source: &influxql.Measurement{Name: "cpu"},
+				TagKey: "host",
+			},
+		},
+
+		// KILL QUERY statement
+		{
+			s:    `KILL QUERY 12`,
+			stmt: &influxql.KillQueryStatement{QueryID: 12},
+		},
+
 		// LIST SERIES statement
 		{
 			s:    `LIST SERIES`,
@@ -208,6 +331,14 @@ func TestParser_ParseStatement(t *testing.T) {
 			},
 		},
 
+		// LIST TAG KEYS with regex source
+		{
+			s: `LIST TAG KEYS FROM /^cpu/`,
+			stmt: &influxql.ListTagKeysStatement{
+				Source: &influxql.Measurement{Regex: regexp.MustCompile(`^cpu`)},
+			},
+		},
+
 		// LIST TAG VALUES
 		{
 			s: `LIST TAG VALUES FROM src WHERE region = 'uswest' ORDER BY ASC, field1, field2 DESC LIMIT 10`,
@@ -283,7 +414,7 @@ func TestParser_ParseStatement(t *testing.T) {
 			stmt: &influxql.CreateContinuousQueryStatement{
 				Name: "myquery",
 				Source: &influxql.SelectStatement{
-					Fields: influxql.Fields{&influxql.Field{Expr: &influxql.Call{Name: "count"}}},
+					Fields: influxql.Fields{&influxql.Field{Expr: &influxql.Call{Name: "count", Pos: influxql.Pos{Line: 0, Char: 42}}, Pos: influxql.Pos{Line: 0, Char: 42}}},
 					Source: &influxql.Measurement{Name: "myseries"},
 				},
 				Target: "foo",
@@ -446,6 +577,32 @@ func TestParser_ParseStatement(t *testing.T) {
 			},
 		},
 
+		// CREATE RETENTION POLICY ... SHARD KEY
+		{
+			s: `CREATE RETENTION POLICY policy1 ON testdb DURATION 1h REPLICATION 2 SHARD KEY (customer, "region") DEFAULT`,
+			stmt: &influxql.CreateRetentionPolicyStatement{
+				Name:        "policy1",
+				DB:          "testdb",
+				Duration:    time.Hour,
+				Replication: 2,
+				ShardKey:    []string{"customer", "region"},
+				Default:     true,
+			},
+		},
+
+		// CREATE RETENTION POLICY ... SHARD DURATION
+		{
+			s: `CREATE RETENTION POLICY policy1 ON testdb DURATION 52w REPLICATION 1 SHARD KEY (customer) SHARD DURATION 4w`,
+			stmt: &influxql.CreateRetentionPolicyStatement{
+				Name:               "policy1",
+				DB:                 "testdb",
+				Duration:           52 * 7 * 24 * time.Hour,
+				Replication:        1,
+				ShardGroupDuration: 4 * 7 * 24 * time.Hour,
+				ShardKey:           []string{"customer"},
+			},
+		},
+
 		// ALTER RETENTION POLICY
 		{
 			s:    `ALTER RETENTION POLICY policy1 ON testdb DURATION 1m REPLICATION 4 DEFAULT`,
@@ -476,6 +633,38 @@ func TestParser_ParseStatement(t *testing.T) {
 			stmt: newAlterRetentionPolicyStatement("policy1", "testdb", -1, 4, false),
 		},
 
+		// ALTER RETENTION POLICY with SHARD DURATION
+		{
+			s: `ALTER RETENTION POLICY policy1 ON testdb SHARD DURATION 1d DURATION 30d`,
+			stmt: &influxql.AlterRetentionPolicyStatement{
+				Name:               "policy1",
+				DB:                 "testdb",
+				Duration:           durationptr(30 * 24 * time.Hour),
+				ShardGroupDuration: durationptr(24 * time.Hour),
+			},
+		},
+
+		// ALTER MEASUREMENT
+		{
+			s: `ALTER MEASUREMENT cpu SET unit = 'percent', DESCRIPTION = 'CPU usage', owner = ''`,
+			stmt: &influxql.AlterMeasurementStatement{
+				Name:        "cpu",
+				Unit:        stringptr("percent"),
+				Description: stringptr("CPU usage"),
+				Owner:       stringptr(""),
+			},
+		},
+
+		// ALTER MEASUREMENT with field
+		{
+			s: `ALTER MEASUREMENT "disk io" FIELD "read time" SET unit = 'ms'`,
+			stmt: &influxql.AlterMeasurementStatement{
+				Name:  "disk io",
+				Field: "read time",
+				Unit:  stringptr("ms"),
+			},
+		},
+
 		// Errors
 		{s: ``, err: `found EOF, expected SELECT at line 1, char 1`},
 		{s: `SELECT`, err: `found EOF, expected identifier, string, number, bool at line 1, char 8`},
@@ -486,16 +675,35 @@ func TestParser_ParseStatement(t *testing.T) {
 		{s: `SELECT field1 FROM myseries LIMIT`, err: `found EOF, expected number at line 1, char 35`},
 		{s: `SELECT field1 FROM myseries LIMIT 10.5`, err: `fractional parts not allowed in limit at line 1, char 35`},
 		{s: `SELECT field1 FROM myseries LIMIT 0`, err: `LIMIT must be > 0 at line 1, char 35`},
+		{s: `SELECT field1 FROM myseries tz`, err: `found EOF, expected ( at line 1, char 32`},
+		{s: `SELECT field1 FROM myseries tz(1)`, err: `found 1, expected string at line 1, char 32`},
+		{s: `SELECT field1 FROM myseries tz('Nowhere/Nothing')`, err: `unknown time zone: Nowhere/Nothing at line 1, char 32`},
 		{s: `SELECT field1 FROM myseries ORDER`, err: `found EOF, expected BY at line 1, char 35`},
 		{s: `SELECT field1 FROM myseries ORDER BY /`, err: `found /, expected identifier, ASC, or DESC at line 1, char 38`},
 		{s: `SELECT field1 FROM myseries ORDER BY 1`, err: `found 1, expected identifier, ASC, or DESC at line 1, char 38`},
 		{s: `SELECT field1 AS`, err: `found EOF, expected identifier, string at line 1, char 18`},
-		{s: `SELECT field1 FROM 12`, err: `found 12, expected identifier, string at line 1, char 20`},
+		{s: `SELECT field1 FROM 12`, err: `found 12, expected identifier, string, regex at line 1, char 20`},
+		{s: `SELECT field1 FROM "a"."b"."c"."d"`, err: `too many segments in a.b.c.d at line 1, char 20`},
+		{s: `SELECT field1 FROM "a".`, err: `found EOF, expected identifier, string, regex at line 1, char 24`},
+		{s: `SELECT field1 FROM /cpu`, err: `unterminated regex at line 1, char 20`},
+		{s: `SELECT field1 FROM /(cpu/`, err: `invalid regex: (cpu at line 1, char 20`},
+		{s: `SELECT field1 FROM cpu,`, err: `found EOF, expected identifier, string, regex at line 1, char 24`},
 		{s: `SELECT field1 FROM myseries GROUP BY *`, err: `found *, expected identifier, string, number, bool at line 1, char 38`},
 		{s: `SELECT 1000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000 FROM myseries`, err: `unable to parse number at line 1, char 8`},
 		{s: `SELECT 10.5h FROM myseries`, err: `found h, expected FROM at line 1, char 12`},
+		{s: `SELECT field1 INTO FROM myseries`, err: `found FROM, expected identifier, string at line 1, char 20`},
+		{s: `SELECT field1 INTO a.b.c.d FROM myseries`, err: `too many segments in a.b.c.d at line 1, char 20`},
+		{s: `EXPLAIN`, err: `found EOF, expected SELECT at line 1, char 9`},
+		{s: `EXPLAIN DELETE FROM myseries`, err: `found DELETE, expected SELECT at line 1, char 9`},
+		{s: `SHOW`, err: `found EOF, expected QUERIES, SERIES, TAG at line 1, char 6`},
+		{s: `SHOW SERIES`, err: `found EOF, expected CARDINALITY at line 1, char 13`},
+		{s: `SHOW TAG KEYS`, err: `found KEYS, expected VALUES at line 1, char 10`},
+		{s: `SHOW TAG VALUES CARDINALITY WITH host`, err: `found host, expected KEY at line 1, char 34`},
+		{s: `SHOW TAG VALUES CARDINALITY WITH KEY host`, err: `found host, expected = at line 1, char 38`},
+		{s: `KILL QUERY`, err: `found EOF, expected number at line 1, char 12`},
+		{s: `KILL QUERY 1.5`, err: `invalid query id: 1.5 at line 1, char 12`},
 		{s: `DELETE`, err: `found EOF, expected FROM at line 1, char 8`},
-		{s: `DELETE FROM`, err: `found EOF, expected identifier, string at line 1, char 13`},
+		{s: `DELETE FROM`, err: `found EOF, expected identifier, string, regex at line 1, char 13`},
 		{s: `DELETE FROM myseries WHERE`, err: `found EOF, expected identifier, string, number, bool at line 1, char 28`},
 		{s: `DROP SERIES`, err: `found EOF, expected identifier, string at line 1, char 13`},
 		{s: `LIST CONTINUOUS`, err: `found EOF, expected QUERIES at line 1, char 17`},
@@ -531,12 +739,26 @@ func TestParser_ParseStatement(t *testing.T) {
 		{s: `CREATE RETENTION POLICY policy1 ON testdb DURATION 1h REPLICATION 3.14`, err: `number must be an integer at line 1, char 67`},
 		{s: `CREATE RETENTION POLICY policy1 ON testdb DURATION 1h REPLICATION 0`, err: `invalid value 0: must be 1 <= n <= 2147483647 at line 1, char 67`},
 		{s: `CREATE RETENTION POLICY policy1 ON testdb DURATION 1h REPLICATION bad`, err: `found bad, expected number at line 1, char 67`},
-		{s: `ALTER`, err: `found EOF, expected RETENTION at line 1, char 7`},
+		{s: `CREATE RETENTION POLICY policy1 ON testdb DURATION 1h REPLICATION 1 SHARD`, err: `found EOF, expected DURATION, KEY at line 1, char 75`},
+		{s: `CREATE RETENTION POLICY policy1 ON testdb DURATION 1h REPLICATION 1 SHARD KEY customer`, err: `found customer, expected ( at line 1, char 79`},
+		{s: `CREATE RETENTION POLICY policy1 ON testdb DURATION 1h REPLICATION 1 SHARD KEY ()`, err: `found ), expected identifier at line 1, char 80`},
+		{s: `CREATE RETENTION POLICY policy1 ON testdb DURATION 1h REPLICATION 1 SHARD KEY (customer region)`, err: `found region, expected ,, ) at line 1, char 89`},
+		{s: `CREATE RETENTION POLICY policy1 ON testdb DURATION 1h REPLICATION 1 SHARD DURATION bad`, err: `found bad, expected duration at line 1, char 84`},
+		{s: `ALTER`, err: `found EOF, expected RETENTION, MEASUREMENT at line 1, char 7`},
+		{s: `ALTER MEASUREMENT`, err: `found EOF, expected identifier at line 1, char 19`},
+		{s: `ALTER MEASUREMENT cpu`, err: `found EOF, expected FIELD, SET at line 1, char 23`},
+		{s: `ALTER MEASUREMENT cpu FIELD value`, err: `found EOF, expected SET at line 1, char 35`},
+		{s: `ALTER MEASUREMENT cpu SET`, err: `found EOF, expected unit, description, owner at line 1, char 27`},
+		{s: `ALTER MEASUREMENT cpu SET color = 'red'`, err: `found color, expected unit, description, owner at line 1, char 27`},
+		{s: `ALTER MEASUREMENT cpu SET unit 'ms'`, err: `found ms, expected = at line 1, char 32`},
+		{s: `ALTER MEASUREMENT cpu SET unit = ms`, err: `found ms, expected string at line 1, char 34`},
 		{s: `ALTER RETENTION`, err: `found EOF, expected POLICY at line 1, char 17`},
 		{s: `ALTER RETENTION POLICY`, err: `found EOF, expected identifier at line 1, char 24`},
 		{s: `ALTER RETENTION POLICY policy1`, err: `found EOF, expected ON at line 1, char 32`},
 		{s: `ALTER RETENTION POLICY policy1 ON`, err: `found EOF, expected identifier at line 1, char 35`},
-		{s: `ALTER RETENTION POLICY policy1 ON testdb`, err: `found EOF, expected DURATION, RETENTION, DEFAULT at line 1, char 42`},
+		{s: `ALTER RETENTION POLICY policy1 ON testdb`, err: `found EOF, expected DURATION, REPLICATION, SHARD, DEFAULT at line 1, char 42`},
+		{s: `ALTER RETENTION POLICY policy1 ON testdb SHARD`, err: `found EOF, expected DURATION at line 1, char 48`},
+		{s: `ALTER RETENTION POLICY policy1 ON testdb SHARD DURATION`, err: `found EOF, expected duration at line 1, char 57`},
 	}
 
 	for i, tt := range tests {
@@ -813,6 +1035,15 @@ func MustParseSelectStatement(s string) *influxql.SelectStatement {
 	return stmt.(*influxql.SelectStatement)
 }
 
+// MustParseStatement parses a statement. Panic on error.
+func MustParseStatement(s string) influxql.Statement {
+	stmt, err := influxql.NewParser(strings.NewReader(s)).ParseStatement()
+	if err != nil {
+		panic(err.Error())
+	}
+	return stmt
+}
+
 // MustParseExpr parses an expression. Panic on error.
 func MustParseExpr(s string) influxql.Expr {
 	expr, err := influxql.NewParser(strings.NewReader(s)).ParseExpr()
@@ -822,6 +1053,15 @@ func MustParseExpr(s string) influxql.Expr {
 	return expr
 }
 
+// mustLoadLocation loads a time zone by name. Panic on error.
+func mustLoadLocation(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		panic(err.Error())
+	}
+	return loc
+}
+
 // errstring converts an error to its string representation.
 func errstring(err error) string {
 	if err != nil {
@@ -830,6 +1070,12 @@ func errstring(err error) string {
 	return ""
 }
 
+// stringptr returns a pointer to s.
+func stringptr(s string) *string { return &s }
+
+// durationptr returns a pointer to d.
+func durationptr(d time.Duration) *time.Duration { return &d }
+
 // newAlterRetentionPolicyStatement creates an initialized AlterRetentionPolicyStatement.
 func newAlterRetentionPolicyStatement(name string, DB string, d time.Duration, replication int, dfault bool) *influxql.AlterRetentionPolicyStatement {
 	stmt := &influxql.AlterRetentionPolicyStatement{
@@ -161,6 +161,33 @@ func (s *Scanner) scanString() (tok Token, pos Pos, lit string) {
 	}
 }
 
+// ScanRegex consumes a regular expression delimited by forward slashes.
+// This function assumes the opening slash has already been consumed.
+// Escaped slashes are unescaped and all other escapes are left as-is for
+// the regular expression to interpret.
+func (s *Scanner) ScanRegex() (tok Token, pos Pos, lit string) {
+	_, pos = s.r.curr()
+	var buf bytes.Buffer
+	for {
+		ch0, _ := s.r.read()
+		if ch0 == '/' {
+			return REGEX, pos, buf.String()
+		} else if ch0 == eof || ch0 == '\n' {
+			return BADREGEX, pos, buf.String()
+		} else if ch0 == '\\' {
+			ch1, _ := s.r.read()
+			if ch1 == eof || ch1 == '\n' {
+				return BADREGEX, pos, buf.String()
+			} else if ch1 != '/' {
+				_, _ = buf.WriteRune(ch0)
+			}
+			_, _ = buf.WriteRune(ch1)
+		} else {
+			_, _ = buf.WriteRune(ch0)
+		}
+	}
+}
+
 // scanNumber consumes anything that looks like the start of a number.
 // Numbers start with a digit, full stop, plus sign or minus sign.
 // This function can return non-number tokens if a scan is a false positive.
@@ -190,7 +217,7 @@ func (s *Scanner) scanNumber() (tok Token, pos Pos, lit string) {
 		ch1, _ := s.r.read()
 		s.r.unread()
 		if !isDigit(ch1) {
-			return ILLEGAL, pos, "."
+			return DOT, pos, ""
 		}
 
 		// Unread the full stop so we can read it later.
@@ -294,6 +321,16 @@ func (s *bufScanner) Scan() (tok Token, pos Pos, lit string) {
 	return s.curr()
 }
 
+// ScanRegex reads a regular expression from the scanner.
+// The opening slash must be the last token read and must not be unscanned.
+func (s *bufScanner) ScanRegex() (tok Token, pos Pos, lit string) {
+	s.i = (s.i + 1) % len(s.buf)
+	buf := &s.buf[s.i]
+	buf.tok, buf.pos, buf.lit = s.s.ScanRegex()
+
+	return s.curr()
+}
+
 // Unscan pushes the previously token back onto the buffer.
 func (s *bufScanner) Unscan() { s.n++ }
 
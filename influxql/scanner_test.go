@@ -79,7 +79,7 @@ func TestScanner_Scan(t *testing.T) {
 		{s: `.23`, tok: influxql.NUMBER, lit: `.23`},
 		{s: `+.23`, tok: influxql.NUMBER, lit: `+.23`},
 		{s: `-.23`, tok: influxql.NUMBER, lit: `-.23`},
-		{s: `.`, tok: influxql.ILLEGAL, lit: `.`},
+		{s: `.`, tok: influxql.DOT},
 		{s: `-.`, tok: influxql.SUB, lit: ``},
 		{s: `+.`, tok: influxql.ADD, lit: ``},
 		{s: `10.3s`, tok: influxql.NUMBER, lit: `10.3`},
@@ -120,6 +120,7 @@ func TestScanner_Scan(t *testing.T) {
 		{s: `INSERT`, tok: influxql.INSERT},
 		{s: `INTO`, tok: influxql.INTO},
 		{s: `KEYS`, tok: influxql.KEYS},
+		{s: `KILL`, tok: influxql.KILL},
 		{s: `LIMIT`, tok: influxql.LIMIT},
 		{s: `LIST`, tok: influxql.LIST},
 		{s: `MEASUREMENT`, tok: influxql.MEASUREMENT},
@@ -136,6 +137,7 @@ func TestScanner_Scan(t *testing.T) {
 		{s: `REVOKE`, tok: influxql.REVOKE},
 		{s: `SELECT`, tok: influxql.SELECT},
 		{s: `SERIES`, tok: influxql.SERIES},
+		{s: `SHOW`, tok: influxql.SHOW},
 		{s: `TAG`, tok: influxql.TAG},
 		{s: `TO`, tok: influxql.TO},
 		{s: `USER`, tok: influxql.USER},
@@ -159,6 +161,34 @@ func TestScanner_Scan(t *testing.T) {
 	}
 }
 
+// Ensure the scanner can scan regular expressions.
+func TestScanner_ScanRegex(t *testing.T) {
+	var tests = []struct {
+		s   string
+		tok influxql.Token
+		lit string
+	}{
+		{s: `/^cpu.*/`, tok: influxql.REGEX, lit: `^cpu.*`},
+		{s: `/a\/b/`, tok: influxql.REGEX, lit: `a/b`},
+		{s: `/a\.b/`, tok: influxql.REGEX, lit: `a\.b`},
+		{s: `/cpu`, tok: influxql.BADREGEX, lit: `cpu`},
+		{s: "/cpu\nmem/", tok: influxql.BADREGEX, lit: `cpu`},
+	}
+
+	for i, tt := range tests {
+		s := influxql.NewScanner(strings.NewReader(tt.s))
+		if tok, _, _ := s.Scan(); tok != influxql.DIV {
+			t.Fatalf("%d. %q unexpected opening token: %s", i, tt.s, tok)
+		}
+		tok, _, lit := s.ScanRegex()
+		if tt.tok != tok {
+			t.Errorf("%d. %q token mismatch: exp=%q got=%q <%q>", i, tt.s, tt.tok, tok, lit)
+		} else if tt.lit != lit {
+			t.Errorf("%d. %q literal mismatch: exp=%q got=%q", i, tt.s, tt.lit, lit)
+		}
+	}
+}
+
 // Ensure the scanner can scan a series of tokens correctly.
 func TestScanner_Scan_Multi(t *testing.T) {
 	type result struct {
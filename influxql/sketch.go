@@ -0,0 +1,350 @@
+package influxql
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+)
+
+// hllPrecision is the number of hash bits used to select a HyperLogLog register.
+// 2^12 registers use 4KB per sketch and have a standard error of about 1.6%.
+const hllPrecision = 12
+
+// hyperLogLog represents a HyperLogLog sketch for estimating the number of
+// distinct values in a set. Sketches with the same precision can be merged.
+type hyperLogLog struct {
+	registers []uint8
+}
+
+// newHyperLogLog returns a new, empty HyperLogLog sketch.
+func newHyperLogLog() *hyperLogLog {
+	return &hyperLogLog{registers: make([]uint8, 1<<hllPrecision)}
+}
+
+// add adds a value to the sketch.
+func (h *hyperLogLog) add(v interface{}) {
+	x := hashValue(v)
+	i := x >> (64 - hllPrecision)
+
+	// Count the leading zeros of the remaining bits. A sentinel bit is set
+	// so the rank cannot exceed the number of remaining bits.
+	w := x<<hllPrecision | 1<<(hllPrecision-1)
+	rank := uint8(1)
+	for ; w&(1<<63) == 0; w <<= 1 {
+		rank++
+	}
+
+	if rank > h.registers[i] {
+		h.registers[i] = rank
+	}
+}
+
+// merge combines another sketch into h.
+func (h *hyperLogLog) merge(other *hyperLogLog) {
+	for i, r := range other.registers {
+		if r > h.registers[i] {
+			h.registers[i] = r
+		}
+	}
+}
+
+// count returns the estimated number of distinct values added to the sketch.
+func (h *hyperLogLog) count() float64 {
+	m := float64(len(h.registers))
+
+	var sum float64
+	var zeros int
+	for _, r := range h.registers {
+		sum += 1 / float64(uint64(1)<<r)
+		if r == 0 {
+			zeros++
+		}
+	}
+	estimate := (0.7213 / (1 + 1.079/m)) * m * m / sum
+
+	// Use linear counting for small cardinalities where the estimate is biased.
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return math.Floor(estimate + 0.5)
+}
+
+// MarshalBinary encodes the sketch to a binary format.
+func (h *hyperLogLog) MarshalBinary() ([]byte, error) {
+	return append([]byte(nil), h.registers...), nil
+}
+
+// UnmarshalBinary decodes the sketch from a binary format.
+func (h *hyperLogLog) UnmarshalBinary(data []byte) error {
+	if len(data) != 1<<hllPrecision {
+		return fmt.Errorf("invalid hyperloglog sketch size: %d", len(data))
+	}
+	h.registers = append([]uint8(nil), data...)
+	return nil
+}
+
+// hashValue returns a 64-bit hash of a field value.
+// Values of different types never hash from the same bytes.
+func hashValue(v interface{}) uint64 {
+	h := fnv.New64a()
+	switch v := v.(type) {
+	case float64:
+		var buf [9]byte
+		buf[0] = 'n'
+		binary.BigEndian.PutUint64(buf[1:], math.Float64bits(v))
+		h.Write(buf[:])
+	case string:
+		h.Write([]byte{'s'})
+		h.Write([]byte(v))
+	case bool:
+		if v {
+			h.Write([]byte{'b', 1})
+		} else {
+			h.Write([]byte{'b', 0})
+		}
+	default:
+		h.Write([]byte(fmt.Sprintf("%T%v", v, v)))
+	}
+
+	// Mix the bits since FNV leaves the high bits poorly distributed for
+	// short inputs and the sketch selects registers by the high bits.
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// tDigestCompression bounds the number of centroids kept by a t-digest.
+// Higher values are more accurate but use more memory.
+const tDigestCompression = 100
+
+// tDigest represents a t-digest sketch for estimating quantiles of a set of
+// numbers. Values are grouped into centroids which are kept small near the
+// tails so extreme quantiles stay accurate. Sketches can be merged.
+type tDigest struct {
+	centroids []centroid // sorted by mean once compressed
+	unsorted  int        // number of centroids added since the last compression
+	count     float64    // total weight
+	min, max  float64
+}
+
+// centroid represents the mean of a group of values in a t-digest.
+type centroid struct {
+	mean  float64
+	count float64
+}
+
+// newTDigest returns a new, empty t-digest.
+func newTDigest() *tDigest {
+	return &tDigest{min: math.Inf(1), max: math.Inf(-1)}
+}
+
+// add adds a value to the digest.
+func (d *tDigest) add(v float64) {
+	d.addCentroid(centroid{mean: v, count: 1})
+	if v < d.min {
+		d.min = v
+	}
+	if v > d.max {
+		d.max = v
+	}
+}
+
+// addCentroid adds a weighted centroid and compresses once enough are buffered.
+func (d *tDigest) addCentroid(c centroid) {
+	d.centroids = append(d.centroids, c)
+	d.count += c.count
+	if d.unsorted++; d.unsorted >= 10*tDigestCompression {
+		d.compress()
+	}
+}
+
+// merge combines another digest into d.
+func (d *tDigest) merge(other *tDigest) {
+	for _, c := range other.centroids {
+		d.addCentroid(c)
+	}
+	d.min = math.Min(d.min, other.min)
+	d.max = math.Max(d.max, other.max)
+}
+
+// compress sorts the centroids and merges neighbours while their combined
+// weight stays within the size allowed at their quantile.
+func (d *tDigest) compress() {
+	if d.unsorted == 0 {
+		return
+	}
+	d.unsorted = 0
+	sort.Sort(centroids(d.centroids))
+
+	var merged []centroid
+	var cum float64 // weight before the last merged centroid
+	for _, c := range d.centroids {
+		if n := len(merged); n > 0 {
+			last := &merged[n-1]
+			q := (cum + (last.count+c.count)/2) / d.count
+			if last.count+c.count <= 4*d.count*q*(1-q)/tDigestCompression {
+				last.count += c.count
+				last.mean += (c.mean - last.mean) * c.count / last.count
+				continue
+			}
+			cum += last.count
+		}
+		merged = append(merged, c)
+	}
+	d.centroids = merged
+}
+
+// quantile returns the estimated value at quantile q, between 0 and 1.
+// Returns NaN if the digest is empty.
+func (d *tDigest) quantile(q float64) float64 {
+	d.compress()
+	if len(d.centroids) == 0 {
+		return math.NaN()
+	}
+	q = math.Max(0, math.Min(1, q))
+
+	// Interpolate between the centers of the centroids around the rank.
+	// The ends are interpolated with the minimum and maximum values.
+	rank := q * d.count
+	prev, prevRank := d.min, float64(0)
+	var cum float64
+	for _, c := range d.centroids {
+		center := cum + c.count/2
+		if rank < center {
+			return interpolate(prev, c.mean, (rank-prevRank)/(center-prevRank))
+		}
+		prev, prevRank = c.mean, center
+		cum += c.count
+	}
+	return interpolate(prev, d.max, (rank-prevRank)/(d.count-prevRank))
+}
+
+// interpolate returns the value between a and b at fraction f.
+func interpolate(a, b, f float64) float64 {
+	return a + (b-a)*f
+}
+
+// MarshalBinary encodes the digest to a binary format.
+func (d *tDigest) MarshalBinary() ([]byte, error) {
+	d.compress()
+	buf := make([]byte, 16+16*len(d.centroids))
+	binary.BigEndian.PutUint64(buf[0:8], math.Float64bits(d.min))
+	binary.BigEndian.PutUint64(buf[8:16], math.Float64bits(d.max))
+	for i, c := range d.centroids {
+		b := buf[16+16*i:]
+		binary.BigEndian.PutUint64(b[0:8], math.Float64bits(c.mean))
+		binary.BigEndian.PutUint64(b[8:16], math.Float64bits(c.count))
+	}
+	return buf, nil
+}
+
+// UnmarshalBinary decodes the digest from a binary format.
+func (d *tDigest) UnmarshalBinary(data []byte) error {
+	if len(data) < 16 || len(data)%16 != 0 {
+		return fmt.Errorf("invalid t-digest size: %d", len(data))
+	}
+	*d = tDigest{
+		min:       math.Float64frombits(binary.BigEndian.Uint64(data[0:8])),
+		max:       math.Float64frombits(binary.BigEndian.Uint64(data[8:16])),
+		centroids: make([]centroid, 0, len(data)/16-1),
+	}
+	for b := data[16:]; len(b) > 0; b = b[16:] {
+		c := centroid{
+			mean:  math.Float64frombits(binary.BigEndian.Uint64(b[0:8])),
+			count: math.Float64frombits(binary.BigEndian.Uint64(b[8:16])),
+		}
+		d.centroids = append(d.centroids, c)
+		d.count += c.count
+	}
+	return nil
+}
+
+// centroids represents a list of centroids sortable by mean.
+type centroids []centroid
+
+func (a centroids) Len() int           { return len(a) }
+func (a centroids) Less(i, j int) bool { return a[i].mean < a[j].mean }
+func (a centroids) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+// mapHyperLogLog emits a HyperLogLog sketch of the values in an iterator.
+// Sketches are encoded to bytes so they can be sent by remote mappers.
+func mapHyperLogLog(itr Iterator, args []interface{}) interface{} {
+	h := newHyperLogLog()
+	for k, v := itr.Next(); k != 0; k, v = itr.Next() {
+		h.add(v)
+	}
+	buf, _ := h.MarshalBinary()
+	return buf
+}
+
+// reduceHyperLogLog merges the mapped sketches and returns the distinct count.
+// Values which are not valid sketches are ignored.
+func reduceHyperLogLog(values []interface{}, args []interface{}) interface{} {
+	h := newHyperLogLog()
+	for _, v := range values {
+		other := &hyperLogLog{}
+		if err := unmarshalSketch(v, other); err != nil {
+			continue
+		}
+		h.merge(other)
+	}
+	return h.count()
+}
+
+// mapTDigest emits a t-digest of the values in an iterator.
+// Returns nil if the iterator has no values.
+func mapTDigest(itr Iterator, args []interface{}) interface{} {
+	d := newTDigest()
+	for k, v := itr.Next(); k != 0; k, v = itr.Next() {
+		d.add(v.(float64))
+	}
+	if d.count == 0 {
+		return nil
+	}
+	buf, _ := d.MarshalBinary()
+	return buf
+}
+
+// reduceTDigest merges the mapped digests and returns the estimated value at
+// the percentile passed as the first argument. Percentiles are clamped to the
+// range 0 to 100. Values which are not valid digests are ignored.
+func reduceTDigest(values []interface{}, args []interface{}) interface{} {
+	d := newTDigest()
+	for _, v := range values {
+		other := &tDigest{}
+		if err := unmarshalSketch(v, other); err != nil {
+			continue
+		}
+		d.merge(other)
+	}
+	if d.count == 0 {
+		return nil
+	}
+	return d.quantile(args[0].(float64) / 100)
+}
+
+// unmarshalSketch decodes a mapped sketch. Sketches from remote mappers
+// arrive as base64 strings since they are decoded from JSON.
+func unmarshalSketch(v interface{}, sketch interface {
+	UnmarshalBinary([]byte) error
+}) error {
+	switch v := v.(type) {
+	case []byte:
+		return sketch.UnmarshalBinary(v)
+	case string:
+		buf, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return fmt.Errorf("decode sketch: %s", err)
+		}
+		return sketch.UnmarshalBinary(buf)
+	default:
+		return fmt.Errorf("invalid sketch type: %T", v)
+	}
+}
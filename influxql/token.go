@@ -21,6 +21,8 @@ const (
 	STRING       // "abc"
 	BADSTRING    // "abc
 	BADESCAPE    // \q
+	REGEX        // /^cpu.*/
+	BADREGEX     // /^cpu.*
 	TRUE         // true
 	FALSE        // false
 	literal_end
@@ -47,6 +49,7 @@ const (
 	RPAREN    // )
 	COMMA     // ,
 	SEMICOLON // ;
+	DOT       // .
 
 	keyword_beg
 	// Keywords
@@ -55,6 +58,7 @@ const (
 	AS
 	ASC
 	BY
+	CARDINALITY
 	CREATE
 	CONTINUOUS
 	DATABASE
@@ -73,7 +77,9 @@ const (
 	INNER
 	INSERT
 	INTO
+	KEY
 	KEYS
+	KILL
 	LIMIT
 	LIST
 	MEASUREMENT
@@ -91,6 +97,9 @@ const (
 	REVOKE
 	SELECT
 	SERIES
+	SET
+	SHARD
+	SHOW
 	TAG
 	TO
 	USER
@@ -110,6 +119,7 @@ var tokens = [...]string{
 	NUMBER:       "NUMBER",
 	DURATION_VAL: "DURATION_VAL",
 	STRING:       "STRING",
+	REGEX:        "REGEX",
 	TRUE:         "TRUE",
 	FALSE:        "FALSE",
 
@@ -132,12 +142,14 @@ var tokens = [...]string{
 	RPAREN:    ")",
 	COMMA:     ",",
 	SEMICOLON: ";",
+	DOT:       ".",
 
 	ALL:          "ALL",
 	ALTER:        "ALTER",
 	AS:           "AS",
 	ASC:          "ASC",
 	BY:           "BY",
+	CARDINALITY:  "CARDINALITY",
 	CREATE:       "CREATE",
 	CONTINUOUS:   "CONTINUOUS",
 	DATABASE:     "DATABASE",
@@ -156,7 +168,9 @@ var tokens = [...]string{
 	INNER:        "INNER",
 	INSERT:       "INSERT",
 	INTO:         "INTO",
+	KEY:          "KEY",
 	KEYS:         "KEYS",
+	KILL:         "KILL",
 	LIMIT:        "LIMIT",
 	LIST:         "LIST",
 	MEASUREMENT:  "MEASUREMENT",
@@ -174,6 +188,9 @@ var tokens = [...]string{
 	REVOKE:       "REVOKE",
 	SELECT:       "SELECT",
 	SERIES:       "SERIES",
+	SET:          "SET",
+	SHARD:        "SHARD",
+	SHOW:         "SHOW",
 	TAG:          "TAG",
 	TO:           "TO",
 	USER:         "USER",
@@ -0,0 +1,230 @@
+package influxdb
+
+import (
+	"net/url"
+	"sort"
+)
+
+// MetadataVersion is the format version of exported metadata. It must be
+// incremented whenever a change to the format breaks importing older exports.
+const MetadataVersion = 1
+
+// Metadata represents the metadata of a cluster as a JSON document. The order
+// of all lists is stable so exports of the same cluster can be diffed.
+type Metadata struct {
+	Version   int                 `json:"version"`
+	DataNodes []*DataNodeMetadata `json:"dataNodes,omitempty"`
+	Databases []*DatabaseMetadata `json:"databases,omitempty"`
+	Users     []*User             `json:"users,omitempty"`
+}
+
+// DataNodeMetadata represents a data node in exported metadata.
+type DataNodeMetadata struct {
+	ID  uint64 `json:"id"`
+	URL string `json:"url"`
+}
+
+// DatabaseMetadata represents a database in exported metadata.
+type DatabaseMetadata struct {
+	Name                   string                 `json:"name"`
+	DefaultRetentionPolicy string                 `json:"defaultRetentionPolicy,omitempty"`
+	Policies               []*RetentionPolicy     `json:"policies,omitempty"`
+	Measurements           []*MeasurementMetadata `json:"measurements,omitempty"`
+}
+
+// MeasurementMetadata represents a measurement and its series in exported metadata.
+type MeasurementMetadata struct {
+	Name        string            `json:"name"`
+	Unit        string            `json:"unit,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Owner       string            `json:"owner,omitempty"`
+	Fields      Fields            `json:"fields,omitempty"`
+	Series      []*SeriesMetadata `json:"series,omitempty"`
+}
+
+// SeriesMetadata represents a series in exported metadata.
+type SeriesMetadata struct {
+	ID   uint32            `json:"id"`
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+// ExportMetadata returns the data nodes, databases, retention policies,
+// shards, measurements, series and users stored in the metastore. Users are
+// exported with their password hashes.
+func (s *Server) ExportMetadata() (*Metadata, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if !s.opened() {
+		return nil, ErrServerClosed
+	}
+
+	md := &Metadata{Version: MetadataVersion}
+	if err := s.meta.view(func(tx *metatx) error {
+		for _, n := range tx.dataNodes() {
+			md.DataNodes = append(md.DataNodes, &DataNodeMetadata{ID: n.ID, URL: n.URL.String()})
+		}
+		for _, db := range tx.databases() {
+			md.Databases = append(md.Databases, tx.databaseMetadata(db))
+		}
+		md.Users = tx.users()
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return md, nil
+}
+
+// databaseMetadata returns the metadata of a database and its series.
+func (tx *metatx) databaseMetadata(db *database) *DatabaseMetadata {
+	md := &DatabaseMetadata{
+		Name:                   db.name,
+		DefaultRetentionPolicy: db.defaultRetentionPolicy,
+	}
+
+	// Sort policies by name.
+	names := make([]string, 0, len(db.policies))
+	for name := range db.policies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		md.Policies = append(md.Policies, db.policies[name])
+	}
+
+	// Measurements can have series without fields and fields without series.
+	names = names[:0]
+	for name := range db.measurements {
+		names = append(names, name)
+	}
+	for _, name := range tx.measurementNames(db.name) {
+		if db.measurements[name] == nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		mm := &MeasurementMetadata{Name: name}
+		if m := db.measurements[name]; m != nil {
+			mm.Unit, mm.Description, mm.Owner = m.Unit, m.Description, m.Owner
+			mm.Fields = m.Fields
+		}
+		for _, s := range tx.measurementSeries(db.name, name) {
+			mm.Series = append(mm.Series, &SeriesMetadata{ID: s.ID, Tags: s.Tags})
+		}
+		md.Measurements = append(md.Measurements, mm)
+	}
+
+	return md
+}
+
+// ImportMetadata replays exported metadata as broker commands. The databases
+// and users must not exist yet. Data nodes which already exist are skipped.
+// Shards are recreated for the same time ranges but get new ids and data
+// nodes. Series and fields are created in id order so they keep their ids
+// when imported into a new database.
+func (s *Server) ImportMetadata(md *Metadata) error {
+	if md.Version != MetadataVersion {
+		return ErrMetadataVersionUnsupported
+	}
+
+	// Create the data nodes.
+	for _, n := range md.DataNodes {
+		u, err := url.Parse(n.URL)
+		if err != nil {
+			return err
+		}
+		if err := s.CreateDataNode(u); err != nil && err != ErrDataNodeExists {
+			return err
+		}
+	}
+
+	// Create the users with their existing password hashes.
+	for _, u := range md.Users {
+		c := &createUserCommand{Username: u.Name, Hash: u.Hash, Admin: u.Admin}
+		if _, err := s.broadcast(createUserMessageType, c); err != nil {
+			return err
+		}
+	}
+
+	for _, db := range md.Databases {
+		if err := s.importDatabaseMetadata(db); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// importDatabaseMetadata creates a database from its exported metadata.
+func (s *Server) importDatabaseMetadata(md *DatabaseMetadata) error {
+	if err := s.CreateDatabase(md.Name); err != nil {
+		return err
+	}
+
+	// Create the retention policies and their shards.
+	for _, rp := range md.Policies {
+		if err := s.CreateRetentionPolicy(md.Name, rp); err != nil {
+			return err
+		}
+		for _, sh := range rp.Shards {
+			if err := s.CreateShardsIfNotExists(md.Name, rp.Name, sh.StartTime); err != nil {
+				return err
+			}
+		}
+	}
+	if md.DefaultRetentionPolicy != "" {
+		if err := s.SetDefaultRetentionPolicy(md.Name, md.DefaultRetentionPolicy); err != nil {
+			return err
+		}
+	}
+
+	// Create the fields of each measurement one at a time so they keep their
+	// ids and then set the metadata of the measurement and its fields.
+	var ids SeriesIDs
+	series := make(map[uint32]*SeriesMetadata)
+	names := make(map[uint32]string)
+	for _, m := range md.Measurements {
+		fields := make(Fields, len(m.Fields))
+		copy(fields, m.Fields)
+		sort.Sort(fieldsByID(fields))
+		for _, f := range fields {
+			c := &createFieldsIfNotExistsCommand{Database: md.Name, Measurement: m.Name, Fields: map[string]FieldType{f.Name: f.Type}}
+			if _, err := s.broadcast(createFieldsIfNotExistsMessageType, c); err != nil {
+				return err
+			}
+			if f.Unit != "" || f.Description != "" || f.Owner != "" {
+				u := &MeasurementUpdate{Field: f.Name, Unit: &f.Unit, Description: &f.Description, Owner: &f.Owner}
+				if err := s.UpdateMeasurement(md.Name, m.Name, u); err != nil {
+					return err
+				}
+			}
+		}
+		if m.Unit != "" || m.Description != "" || m.Owner != "" {
+			u := &MeasurementUpdate{Unit: &m.Unit, Description: &m.Description, Owner: &m.Owner}
+			if err := s.UpdateMeasurement(md.Name, m.Name, u); err != nil {
+				return err
+			}
+		}
+		for _, ms := range m.Series {
+			ids = append(ids, ms.ID)
+			series[ms.ID], names[ms.ID] = ms, m.Name
+		}
+	}
+
+	// Create the series of all measurements in id order.
+	sort.Sort(ids)
+	for _, id := range ids {
+		c := &createSeriesIfNotExistsCommand{Database: md.Name, Name: names[id], Tags: series[id].Tags}
+		if _, err := s.broadcast(createSeriesIfNotExistsMessageType, c); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type fieldsByID Fields
+
+func (p fieldsByID) Len() int           { return len(p) }
+func (p fieldsByID) Less(i, j int) bool { return p[i].ID < p[j].ID }
+func (p fieldsByID) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
@@ -1,7 +1,10 @@
 package influxdb
 
 import (
+	"bytes"
 	"encoding/binary"
+	"fmt"
+	"sort"
 	"time"
 	"unsafe"
 
@@ -22,8 +25,13 @@ func (m *metastore) open(path string) error {
 	}
 	m.db = db
 
-	// Initialize the metastore.
+	// Initialize the metastore and upgrade it to the current schema.
 	if err := m.init(); err != nil {
+		_ = db.Close()
+		return err
+	}
+	if err := m.update(func(tx *metatx) error { return tx.migrate() }); err != nil {
+		_ = db.Close()
 		return err
 	}
 
@@ -49,6 +57,16 @@ func (m *metastore) init() error {
 	})
 }
 
+// migrations upgrade the metastore schema. The migration at index i upgrades
+// a metastore from version i to version i+1. Migrations are only ever appended.
+var migrations = []func(tx *metatx) error{
+	// 1: Store the tag index and series keys of each database.
+	(*metatx).indexDatabases,
+}
+
+// metastoreVersion is the schema version written by this version of the server.
+var metastoreVersion = uint64(len(migrations))
+
 // view executes a function in the context of a read-only transaction.
 func (m *metastore) view(fn func(*metatx) error) error {
 	return m.db.View(func(tx *bolt.Tx) error { return fn(&metatx{tx}) })
@@ -101,6 +119,50 @@ func (tx *metatx) setID(v uint64) error {
 	return tx.Bucket([]byte("Server")).Put([]byte("id"), u64tob(v))
 }
 
+// maxShardID returns the highest shard id assigned by the server.
+// Metastores written before it was stored return zero.
+func (tx *metatx) maxShardID() (id uint64) {
+	if v := tx.Bucket([]byte("Server")).Get([]byte("maxShardID")); v != nil {
+		id = btou64(v)
+	}
+	return
+}
+
+// setMaxShardID sets the highest shard id assigned by the server.
+func (tx *metatx) setMaxShardID(v uint64) error {
+	return tx.Bucket([]byte("Server")).Put([]byte("maxShardID"), u64tob(v))
+}
+
+// version returns the schema version of the metastore.
+// Metastores written before the version was stored have a version of zero.
+func (tx *metatx) version() (v uint64) {
+	if b := tx.Bucket([]byte("Server")).Get([]byte("version")); b != nil {
+		v = btou64(b)
+	}
+	return
+}
+
+// setVersion sets the schema version of the metastore.
+func (tx *metatx) setVersion(v uint64) error {
+	return tx.Bucket([]byte("Server")).Put([]byte("version"), u64tob(v))
+}
+
+// migrate runs the migrations after the stored schema version in order. The
+// migrations run in the caller's transaction so a failed upgrade leaves the
+// metastore unchanged. Returns an error if the metastore has a newer schema.
+func (tx *metatx) migrate() error {
+	v := tx.version()
+	if v > metastoreVersion {
+		return ErrMetastoreVersionUnsupported
+	}
+	for ; v < metastoreVersion; v++ {
+		if err := migrations[v](tx); err != nil {
+			return fmt.Errorf("migration %d: %s", v+1, err)
+		}
+	}
+	return tx.setVersion(v)
+}
+
 // dataNodes returns a list of all data nodes from the metastore.
 func (tx *metatx) dataNodes() (a []*DataNode) {
 	c := tx.Bucket([]byte("DataNodes")).Cursor()
@@ -155,6 +217,14 @@ func (tx *metatx) saveDatabase(db *database) error {
 	if err != nil {
 		return err
 	}
+	_, err = b.CreateBucketIfNotExists([]byte("TagIndex"))
+	if err != nil {
+		return err
+	}
+	_, err = b.CreateBucketIfNotExists([]byte("IDToMeasurement"))
+	if err != nil {
+		return err
+	}
 	return b.Put([]byte("meta"), mustMarshalJSON(db))
 }
 
@@ -183,30 +253,310 @@ func (tx *metatx) createSeries(database, name string, tags map[string]string) (*
 	}
 
 	s := &Series{ID: uint32(id), Tags: tags}
-	idBytes := make([]byte, 4)
-	*(*uint32)(unsafe.Pointer(&idBytes[0])) = uint32(id)
+	if err := b.Put(seriesKey(s.ID), mustMarshalJSON(s)); err != nil {
+		return nil, err
+	}
 
-	if err := b.Put(idBytes, mustMarshalJSON(s)); err != nil {
+	// add the series to the tag index
+	if err := tx.indexSeries(db, name, s); err != nil {
 		return nil, err
 	}
 	return s, nil
 }
 
-// loops through all the measurements and series in a database
-func (tx *metatx) indexDatabase(db *database) {
-	// get the bucket that holds series data for the database
-	b := tx.Bucket([]byte("Databases")).Bucket([]byte(db.name)).Bucket([]byte("Series"))
-	c := b.Cursor()
+// deleteSeries removes a series of a measurement and its tag index keys.
+func (tx *metatx) deleteSeries(database, name string, s *Series) error {
+	db := tx.Bucket([]byte("Databases")).Bucket([]byte(database))
+	if b := db.Bucket([]byte("Series")).Bucket([]byte(name)); b != nil {
+		if err := b.Delete(seriesKey(s.ID)); err != nil {
+			return err
+		}
+	}
+
+	id := u32tob(s.ID)
+	if b := db.Bucket([]byte("TagIndex")).Bucket([]byte(name)); b != nil {
+		if err := b.Delete(append([]byte("s"), id...)); err != nil {
+			return err
+		}
+		for k, v := range s.Tags {
+			if err := b.Delete(append(tagIndexPrefix(k, v), id...)); err != nil {
+				return err
+			}
+		}
+	}
+	if err := db.Bucket([]byte("TagBytesToID")).Delete(marshalSeriesKey(name, s.Tags)); err != nil {
+		return err
+	}
+	return db.Bucket([]byte("IDToMeasurement")).Delete(id)
+}
+
+// indexSeries adds a series to the tag index of a database bucket.
+//
+// Each measurement has a bucket in the index with a key for every series and
+// a key for every tag of every series. Keys end with the big-endian series id
+// so the ids for a measurement or for a tag value can be read in order with a
+// cursor:
+//
+//	"s" + id                               all series in the measurement
+//	"t" + key + 0x00 + value + 0x00 + id   series with the tag value
+//
+// The index also maps series ids to their measurement name and the canonical
+// series key to the series id.
+func (tx *metatx) indexSeries(db *bolt.Bucket, name string, s *Series) error {
+	b, err := db.Bucket([]byte("TagIndex")).CreateBucketIfNotExists([]byte(name))
+	if err != nil {
+		return err
+	}
+	id := u32tob(s.ID)
+	if err := b.Put(append([]byte("s"), id...), nil); err != nil {
+		return err
+	}
+	for k, v := range s.Tags {
+		if err := b.Put(append(tagIndexPrefix(k, v), id...), nil); err != nil {
+			return err
+		}
+	}
+	if err := db.Bucket([]byte("TagBytesToID")).Put(marshalSeriesKey(name, s.Tags), id); err != nil {
+		return err
+	}
+	return db.Bucket([]byte("IDToMeasurement")).Put(id, []byte(name))
+}
 
+// indexDatabases builds the tag index for databases created by a version that
+// only stored series by id or did not store series keys. Databases which
+// already have an index are skipped.
+func (tx *metatx) indexDatabases() error {
+	var names [][]byte
+	c := tx.Bucket([]byte("Databases")).Cursor()
 	for k, _ := c.First(); k != nil; k, _ = c.Next() {
-		mc := b.Bucket(k).Cursor()
-		name := string(k)
-		for id, v := mc.First(); id != nil; id, v = mc.Next() {
-			var s *Series
-			mustUnmarshalJSON(v, &s)
-			db.addSeriesToIndex(name, s)
+		names = append(names, k)
+	}
+
+	for _, name := range names {
+		db := tx.Bucket([]byte("Databases")).Bucket(name)
+		if db.Bucket([]byte("TagIndex")) != nil && !isEmptyBucket(db.Bucket([]byte("TagBytesToID"))) {
+			continue
+		}
+		if _, err := db.CreateBucketIfNotExists([]byte("TagIndex")); err != nil {
+			return err
+		}
+		if _, err := db.CreateBucketIfNotExists([]byte("IDToMeasurement")); err != nil {
+			return err
+		}
+		if _, err := db.CreateBucketIfNotExists([]byte("TagBytesToID")); err != nil {
+			return err
 		}
+
+		// loop through all the measurements and series in the database
+		b := db.Bucket([]byte("Series"))
+		if err := b.ForEach(func(name, _ []byte) error {
+			return b.Bucket(name).ForEach(func(_, v []byte) error {
+				var s *Series
+				mustUnmarshalJSON(v, &s)
+				return tx.indexSeries(db, string(name), s)
+			})
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// measurementNames returns the names of the measurements with series in a database.
+func (tx *metatx) measurementNames(database string) (a []string) {
+	c := tx.Bucket([]byte("Databases")).Bucket([]byte(database)).Bucket([]byte("TagIndex")).Cursor()
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		a = append(a, string(k))
 	}
+	return
+}
+
+// measurementSeries returns the series of a measurement sorted by id.
+func (tx *metatx) measurementSeries(database, name string) (a []*Series) {
+	b := tx.Bucket([]byte("Databases")).Bucket([]byte(database)).Bucket([]byte("Series")).Bucket([]byte(name))
+	if b == nil {
+		return nil
+	}
+	_ = b.ForEach(func(_, v []byte) error {
+		var s *Series
+		mustUnmarshalJSON(v, &s)
+		a = append(a, s)
+		return nil
+	})
+	sort.Sort(seriesByID(a))
+	return
+}
+
+// series returns a series and its measurement name by id.
+// Returns nil if the series does not exist.
+func (tx *metatx) series(database string, id uint32) (name string, s *Series) {
+	db := tx.Bucket([]byte("Databases")).Bucket([]byte(database))
+	if v := db.Bucket([]byte("IDToMeasurement")).Get(u32tob(id)); v != nil {
+		name = string(v)
+		mustUnmarshalJSON(db.Bucket([]byte("Series")).Bucket(v).Get(seriesKey(id)), &s)
+	}
+	return
+}
+
+// seriesByTags returns the series of a measurement with an exact tagset.
+// Returns nil if the series does not exist.
+func (tx *metatx) seriesByTags(database, name string, tags map[string]string) *Series {
+	id := tx.seriesID(database, name, tags)
+	if id == 0 {
+		return nil
+	}
+	_, s := tx.series(database, id)
+	return s
+}
+
+// seriesID returns the id of the series of a measurement with an exact tagset
+// by looking up its series key. Returns zero if the series does not exist.
+func (tx *metatx) seriesID(database, name string, tags map[string]string) uint32 {
+	b := tx.Bucket([]byte("Databases")).Bucket([]byte(database)).Bucket([]byte("TagBytesToID"))
+	if v := b.Get(marshalSeriesKey(name, tags)); v != nil {
+		return btou32(v)
+	}
+	return 0
+}
+
+// seriesIDs returns the sorted ids from the tag index of a measurement for keys with a prefix.
+func (tx *metatx) seriesIDs(database, name string, prefix []byte) SeriesIDs {
+	b := tx.tagIndex(database, name)
+	if b == nil {
+		return nil
+	}
+
+	var ids SeriesIDs
+	c := b.Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		if len(k) == len(prefix)+4 {
+			ids = append(ids, btou32(k[len(prefix):]))
+		}
+	}
+	return ids
+}
+
+// seriesN returns the number of series in a database.
+func (tx *metatx) seriesN(database string) int {
+	return tx.Bucket([]byte("Databases")).Bucket([]byte(database)).Bucket([]byte("IDToMeasurement")).Stats().KeyN
+}
+
+// tagValueSeriesN returns the number of series of a measurement with a tag
+// value. At most max series are counted.
+func (tx *metatx) tagValueSeriesN(database, name, key, value string, max int) (n int) {
+	b := tx.tagIndex(database, name)
+	if b == nil {
+		return 0
+	}
+
+	prefix := tagIndexPrefix(key, value)
+	c := b.Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix) && n < max; k, _ = c.Next() {
+		if len(k) == len(prefix)+4 {
+			n++
+		}
+	}
+	return
+}
+
+// tagKeys returns the tag keys of a measurement in sorted order.
+func (tx *metatx) tagKeys(database, name string) (a []string) {
+	b := tx.tagIndex(database, name)
+	if b == nil {
+		return nil
+	}
+
+	// Skip over the values of each key.
+	c := b.Cursor()
+	for k, _ := c.Seek([]byte("t")); k != nil && k[0] == 't'; {
+		i := bytes.IndexByte(k, 0)
+		if i == -1 {
+			break
+		}
+		a = append(a, string(k[1:i]))
+		k, _ = c.Seek(append(k[:i:i], 1))
+	}
+	return
+}
+
+// tagValues returns the values of a tag key of a measurement in sorted order.
+func (tx *metatx) tagValues(database, name, key string) (a []string) {
+	b := tx.tagIndex(database, name)
+	if b == nil {
+		return nil
+	}
+
+	// Skip over the series ids of each value.
+	prefix := []byte("t" + key + "\x00")
+	c := b.Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix) && len(k) >= len(prefix)+5; {
+		value := k[len(prefix) : len(k)-5]
+		a = append(a, string(value))
+		k, _ = c.Seek(append(append(append([]byte{}, prefix...), value...), 0, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF))
+	}
+	return
+}
+
+// tagIndex returns the tag index bucket of a measurement.
+func (tx *metatx) tagIndex(database, name string) *bolt.Bucket {
+	return tx.Bucket([]byte("Databases")).Bucket([]byte(database)).Bucket([]byte("TagIndex")).Bucket([]byte(name))
+}
+
+// tagIndexPrefix returns the prefix of the tag index keys for a tag value.
+func tagIndexPrefix(key, value string) []byte {
+	return []byte("t" + key + "\x00" + value + "\x00")
+}
+
+// marshalSeriesKey returns the canonical key of a series. The key is the
+// measurement name followed by the tags sorted by key:
+//
+//	cpu,host=servera,region=uswest
+//
+// Commas, equal signs and backslashes in names, keys and values are escaped
+// with a backslash so every tagset has a distinct key.
+func marshalSeriesKey(name string, tags map[string]string) []byte {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	writeEscapedSeriesKey(&buf, name)
+	for _, k := range keys {
+		buf.WriteByte(',')
+		writeEscapedSeriesKey(&buf, k)
+		buf.WriteByte('=')
+		writeEscapedSeriesKey(&buf, tags[k])
+	}
+	return buf.Bytes()
+}
+
+// writeEscapedSeriesKey writes part of a series key with its separators escaped.
+func writeEscapedSeriesKey(buf *bytes.Buffer, s string) {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case ',', '=', '\\':
+			buf.WriteByte('\\')
+		}
+		buf.WriteByte(s[i])
+	}
+}
+
+// isEmptyBucket returns true if a bucket is missing or has no keys.
+func isEmptyBucket(b *bolt.Bucket) bool {
+	if b == nil {
+		return true
+	}
+	k, _ := b.Cursor().First()
+	return k == nil
+}
+
+// seriesKey returns the key of a series in a measurement's series bucket.
+func seriesKey(id uint32) []byte {
+	b := make([]byte, 4)
+	*(*uint32)(unsafe.Pointer(&b[0])) = id
+	return b
 }
 
 // user returns a user from the metastore by name.
@@ -247,3 +597,13 @@ func u64tob(v uint64) []byte {
 
 // btou64 converts an 8-byte slice into an int64.
 func btou64(b []byte) uint64 { return binary.BigEndian.Uint64(b) }
+
+// u32tob converts a uint32 into an 4-byte slice.
+func u32tob(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
+}
+
+// btou32 converts an 4-byte slice into an uint32.
+func btou32(b []byte) uint32 { return binary.BigEndian.Uint32(b) }
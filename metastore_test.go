@@ -0,0 +1,73 @@
+package influxdb
+
+import (
+	"testing"
+)
+
+// Ensure a new metastore is written with the current schema version.
+func TestMetastore_Open_Version(t *testing.T) {
+	m := mustOpenMetastore()
+	defer mustCloseMetastore(m)
+
+	var v uint64
+	_ = m.view(func(tx *metatx) error { v = tx.version(); return nil })
+	if v != metastoreVersion {
+		t.Fatalf("unexpected version: %d", v)
+	}
+}
+
+// Ensure a metastore without a schema version is migrated when it is opened.
+func TestMetastore_Open_Migrate(t *testing.T) {
+	m := mustOpenMetastore()
+	defer mustCloseMetastore(m)
+
+	db := mustCreateIndexedDatabase(m, "db0", DefaultIndexCacheSize)
+	mustCreateIndexedSeries(m, db, "cpu", map[string]string{"host": "servera"})
+
+	// Remove the version and the tag index to simulate an old metastore.
+	if err := m.update(func(tx *metatx) error {
+		if err := tx.Bucket([]byte("Server")).Delete([]byte("version")); err != nil {
+			return err
+		}
+		return tx.Bucket([]byte("Databases")).Bucket([]byte("db0")).DeleteBucket([]byte("TagIndex"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	mustReopenMetastore(m)
+
+	var v uint64
+	_ = m.view(func(tx *metatx) error { v = tx.version(); return nil })
+	if v != metastoreVersion {
+		t.Fatalf("unexpected version: %d", v)
+	}
+	db = mustReopenIndexedDatabase(m, "db0", DefaultIndexCacheSize)
+	if ids := db.SeriesIDs([]string{"cpu"}, []*TagFilter{{Key: "host", Value: "servera"}}); !ids.Equals(SeriesIDs{1}) {
+		t.Fatalf("unexpected ids: %v", ids)
+	}
+}
+
+// Ensure a metastore with a newer schema version can't be opened.
+func TestMetastore_Open_ErrMetastoreVersionUnsupported(t *testing.T) {
+	m := mustOpenMetastore()
+	defer mustCloseMetastore(m)
+
+	if err := m.update(func(tx *metatx) error { return tx.setVersion(metastoreVersion + 1) }); err != nil {
+		t.Fatal(err)
+	}
+	path := m.db.Path()
+	_ = m.close()
+
+	if err := m.open(path); err != ErrMetastoreVersionUnsupported {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// mustReopenMetastore closes and reopens a metastore. Panic on error.
+func mustReopenMetastore(m *metastore) {
+	path := m.db.Path()
+	if err := m.close(); err != nil {
+		panic(err.Error())
+	} else if err := m.open(path); err != nil {
+		panic(err.Error())
+	}
+}
@@ -1,9 +1,11 @@
 package influxdb
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -14,6 +16,7 @@ import (
 	"time"
 
 	"code.google.com/p/go.crypto/bcrypt"
+	"github.com/influxdb/influxdb/influxql"
 	"github.com/influxdb/influxdb/messaging"
 )
 
@@ -31,7 +34,8 @@ const (
 	// DefaultReplicaN represents the number of replicas data is written to.
 	DefaultReplicaN = 1
 
-	// DefaultShardDuration is the time period held by a shard.
+	// DefaultShardDuration is the time period held by a shard of a policy
+	// which keeps data forever or for at least six months.
 	DefaultShardDuration = 7 * (24 * time.Hour)
 
 	// DefaultShardRetention is the length of time before a shard is dropped.
@@ -63,13 +67,47 @@ const (
 
 	// Series messages
 	createSeriesIfNotExistsMessageType = messaging.MessageType(0x50)
+	dropSeriesMessageType              = messaging.MessageType(0x51)
+
+	// Measurement messages
+	createFieldsIfNotExistsMessageType = messaging.MessageType(0x60)
+	updateMeasurementMessageType       = messaging.MessageType(0x61)
 
 	// Write raw data messages (per-topic)
-	writeSeriesMessageType = messaging.MessageType(0x80)
+	writeSeriesMessageType      = messaging.MessageType(0x80)
+	writeSeriesBatchMessageType = messaging.MessageType(0x81)
 )
 
 // Server represents a collection of metadata and raw metric data.
 type Server struct {
+	// The maximum duration a query can run before it is killed.
+	// A zero duration disables the timeout.
+	QueryTimeout time.Duration
+
+	// Limits on the resources used by a single select statement.
+	// A zero value disables the limit.
+	MaxSeriesPerQuery  int // series read
+	MaxBucketsPerQuery int // GROUP BY time intervals
+	MaxPointsPerQuery  int // points scanned
+
+	// Limits on the number of series in a database. New series beyond a
+	// limit are rejected. Series are created by replicated commands so all
+	// data nodes must use the same limits. A zero value disables the limit.
+	MaxSeriesPerDatabase int // series in a database
+	MaxValuesPerTag      int // values of a tag key in a measurement
+
+	// The time after the last write to a series before SweepIdleSeries
+	// removes it once it has no data left. A zero value disables the sweep.
+	SeriesIdleTimeout time.Duration
+
+	// The maximum number of shards queried on other data nodes at once.
+	// A zero value disables the limit.
+	ConcurrentShardQueryLimit int
+
+	// Caches the values of GROUP BY time buckets which are fully in the past.
+	// A nil cache disables result caching.
+	QueryCache *influxql.ResultCache
+
 	mu   sync.RWMutex
 	id   uint64
 	path string
@@ -86,6 +124,13 @@ type Server struct {
 	databases        map[string]*database // databases by name
 	databasesByShard map[uint64]*database // databases by shard id
 	users            map[string]*User     // user by name
+	maxShardID       uint64               // highest shard id assigned
+
+	qmu        sync.Mutex               // query registry lock
+	queries    map[uint64]*runningQuery // running queries by id
+	maxQueryID uint64                   // highest query id assigned
+
+	shardQueries chan struct{} // remote shard query slots
 }
 
 // NewServer returns a new instance of Server.
@@ -97,6 +142,7 @@ func NewServer() *Server {
 		databasesByShard: make(map[uint64]*database),
 		users:            make(map[string]*User),
 		errors:           make(map[uint64]error),
+		queries:          make(map[uint64]*runningQuery),
 	}
 }
 
@@ -146,14 +192,16 @@ func (s *Server) Open(path string) error {
 		return fmt.Errorf("meta: %s", err)
 	}
 
+	// Set the server path. This is required for loading the shards.
+	s.path = path
+
 	// Load state from metastore.
 	if err := s.load(); err != nil {
+		s.path = ""
+		_ = s.meta.close()
 		return fmt.Errorf("load: %s", err)
 	}
 
-	// Set the server path.
-	s.path = path
-
 	return nil
 }
 
@@ -172,6 +220,13 @@ func (s *Server) Close() error {
 	// Close message processing.
 	s.setClient(nil)
 
+	// Close shards.
+	for _, db := range s.databases {
+		for _, sh := range db.shards {
+			_ = sh.close()
+		}
+	}
+
 	// Close metastore.
 	_ = s.meta.close()
 
@@ -184,26 +239,31 @@ func (s *Server) Close() error {
 // load reads the state of the server from the metastore.
 func (s *Server) load() error {
 	return s.meta.view(func(tx *metatx) error {
-		// Read server id.
+		// Read server id and the highest shard id assigned.
 		s.id = tx.id()
+		s.maxShardID = tx.maxShardID()
 
 		// Load databases.
 		s.databases = make(map[string]*database)
 		for _, db := range tx.databases() {
 			s.databases[db.name] = db
 
-			for sh := range db.shards {
-				s.databasesByShard[sh] = db
+			for _, sh := range db.shards {
+				s.databasesByShard[sh.ID] = db
+				if sh.ID > s.maxShardID {
+					s.maxShardID = sh.ID
+				}
+
+				// Open shard store.
+				if err := sh.open(s.shardPath(sh.ID)); err != nil {
+					return fmt.Errorf("open shard: id=%d, err=%s", sh.ID, err)
+				}
 			}
 
-			// load the index
-			log.Printf("Loading metadata index for %s\n", db.name)
-			err := s.meta.view(func(tx *metatx) error {
-				tx.indexDatabase(db)
-				return nil
-			})
-			if err != nil {
-				return err
+			// Attach the tag index. Series are read from it as they are used.
+			db.setIndex(newTagIndex(s.meta, db, DefaultIndexCacheSize))
+			for _, name := range tx.measurementNames(db.name) {
+				db.createMeasurementIfNotExists(name)
 			}
 		}
 
@@ -277,14 +337,14 @@ func (s *Server) broadcast(typ messaging.MessageType, c interface{}) (uint64, er
 	}
 
 	// Wait for the server to receive the message.
-	err = s.sync(index)
+	err = s.Sync(index)
 
 	return index, err
 }
 
-// sync blocks until a given index (or a higher index) has been seen.
+// Sync blocks until a given index (or a higher index) has been seen.
 // Returns any error associated with the command.
-func (s *Server) sync(index uint64) error {
+func (s *Server) Sync(index uint64) error {
 	for {
 		// Check if index has occurred. If so, retrieve the error and return.
 		s.mu.RLock()
@@ -475,6 +535,7 @@ func (s *Server) applyCreateDatabase(m *messaging.Message) (err error) {
 	// Create database entry.
 	db := newDatabase()
 	db.name = c.Name
+	db.setIndex(newTagIndex(s.meta, db, DefaultIndexCacheSize))
 
 	// Persist to metastore.
 	err = s.meta.mustUpdate(func(tx *metatx) error { return tx.saveDatabase(db) })
@@ -509,8 +570,18 @@ func (s *Server) applyDeleteDatabase(m *messaging.Message) (err error) {
 	// Remove from metastore.
 	err = s.meta.mustUpdate(func(tx *metatx) error { return tx.deleteDatabase(c.Name) })
 
+	// Close the database's shards.
+	db := s.databases[c.Name]
+	for _, sh := range db.shards {
+		_ = sh.close()
+		delete(s.databasesByShard, sh.ID)
+	}
+
 	// Delete the database entry.
 	delete(s.databases, c.Name)
+	if s.QueryCache != nil {
+		s.QueryCache.InvalidateDatabase(c.Name)
+	}
 	return
 }
 
@@ -518,13 +589,13 @@ type deleteDatabaseCommand struct {
 	Name string `json:"name"`
 }
 
-// shardByTimestamp returns a shard that owns a given timestamp for a database.
-func (s *Server) shardByTimestamp(database, policy string, id uint32, timestamp time.Time) (*Shard, error) {
+// shardByTimestamp returns the shard that owns a given timestamp for a series in a database.
+func (s *Server) shardByTimestamp(database, policy string, id uint32, tags map[string]string, timestamp time.Time) (*Shard, error) {
 	db := s.databases[database]
 	if db == nil {
 		return nil, ErrDatabaseNotFound
 	}
-	return db.shardByTimestamp(policy, id, timestamp)
+	return db.shardByTimestamp(policy, id, tags, timestamp)
 }
 
 // Shards returns a list of all shards for a database.
@@ -566,9 +637,9 @@ func (s *Server) CreateShardsIfNotExists(database, policy string, timestamp time
 
 // createShardIfNotExists returns the shard for a given retention policy, series, and timestamp.
 // If it doesn't exist, it will create all shards for the given timestamp
-func (s *Server) createShardIfNotExists(database, policy string, id uint32, timestamp time.Time) (*Shard, error) {
+func (s *Server) createShardIfNotExists(database, policy string, id uint32, tags map[string]string, timestamp time.Time) (*Shard, error) {
 	// Check if shard exists first.
-	sh, err := s.shardByTimestamp(database, policy, id, timestamp)
+	sh, err := s.shardByTimestamp(database, policy, id, tags, timestamp)
 	if err != nil {
 		return nil, err
 	} else if sh != nil {
@@ -581,7 +652,7 @@ func (s *Server) createShardIfNotExists(database, policy string, id uint32, time
 	}
 
 	// Lookup the shard again.
-	return s.shardByTimestamp(database, policy, id, timestamp)
+	return s.shardByTimestamp(database, policy, id, tags, timestamp)
 }
 
 func (s *Server) applyCreateShardIfNotExists(m *messaging.Message) (err error) {
@@ -610,35 +681,65 @@ func (s *Server) applyCreateShardIfNotExists(m *messaging.Message) (err error) {
 		}
 	}
 
-	// If no shards match then create a new one.
-	sh := newShard()
-	sh.ID = m.Index
-	sh.StartTime = c.Timestamp.Truncate(rp.Duration).UTC()
-	sh.EndTime = sh.StartTime.Add(rp.Duration).UTC()
+	// If no shards match then create a new one for each split. The first
+	// split is identified by the message index. The max shard id is restored
+	// if the shards can't be saved.
+	maxShardID := s.maxShardID
+	n := int(rp.SplitN)
+	if n < 1 {
+		n = 1
+	}
+	shards := make([]*Shard, 0, n)
+	for i := 0; i < n; i++ {
+		sh := newShard()
+		sh.ID = s.nextShardID(m.Index)
+		sh.StartTime, sh.EndTime = rp.shardGroupTimeRange(c.Timestamp)
+
+		// Open shard.
+		if err := sh.open(s.shardPath(sh.ID)); err != nil {
+			panic("unable to open shard: " + err.Error())
+		}
 
-	// Open shard.
-	if err := sh.open(s.shardPath(sh.ID)); err != nil {
-		panic("unable to open shard: " + err.Error())
+		// Add to lookups.
+		s.databasesByShard[sh.ID] = db
+		db.shards[sh.ID] = sh
+		rp.Shards = append(rp.Shards, sh)
+		shards = append(shards, sh)
 	}
 
 	// Persist to metastore if a shard was created.
 	if err = s.meta.mustUpdate(func(tx *metatx) error {
+		if err := tx.setMaxShardID(s.maxShardID); err != nil {
+			return err
+		}
 		return tx.saveDatabase(db)
 	}); err != nil {
-		_ = sh.close()
+		for _, sh := range shards {
+			delete(s.databasesByShard, sh.ID)
+			delete(db.shards, sh.ID)
+			_ = sh.close()
+		}
+		rp.Shards = rp.Shards[:len(rp.Shards)-len(shards)]
+		s.maxShardID = maxShardID
 		return
 	}
 
-	// Add to lookups.
-	s.databasesByShard[sh.ID] = db
-	db.shards[sh.ID] = sh
-	rp.Shards = append(rp.Shards, sh)
-
 	// TODO: Subscribe to shard if it matches the server's index.
 
 	return
 }
 
+// nextShardID returns the id for a new shard. Ids are at least the index of
+// the message creating the shard and are never reused, even after the shard is
+// removed, so every server assigns the same ids.
+func (s *Server) nextShardID(index uint64) uint64 {
+	if index <= s.maxShardID {
+		index = s.maxShardID + 1
+	}
+	s.maxShardID = index
+	return index
+}
+
 type createShardIfNotExistsCommand struct {
 	Database  string    `json:"name"`
 	Policy    string    `json:"policy"`
@@ -690,6 +791,21 @@ func (s *Server) Authenticate(username, password string) (*User, error) {
 	return u, nil
 }
 
+// authenticateNodeRequest returns the user that another data node sent a
+// request on behalf of. Returns an error if the body was not signed for the user.
+func (s *Server) authenticateNodeRequest(username, signature string, body []byte) (*User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	u := s.users[username]
+	if u == nil {
+		return nil, fmt.Errorf("user not found")
+	}
+	if !hmac.Equal([]byte(signature), []byte(u.sign(body))) {
+		return nil, fmt.Errorf("invalid signature")
+	}
+	return u, nil
+}
+
 // CreateUser creates a user on the server.
 func (s *Server) CreateUser(username, password string, admin bool) error {
 	c := &createUserCommand{Username: username, Password: password, Admin: admin}
@@ -711,10 +827,12 @@ func (s *Server) applyCreateUser(m *messaging.Message) (err error) {
 		return ErrUserExists
 	}
 
-	// Generate the hash of the password.
-	hash, err := HashPassword(c.Password)
-	if err != nil {
-		return err
+	// Generate the hash of the password unless the hash was provided.
+	hash := []byte(c.Hash)
+	if len(hash) == 0 {
+		if hash, err = HashPassword(c.Password); err != nil {
+			return err
+		}
 	}
 
 	// Create the user.
@@ -736,6 +854,7 @@ func (s *Server) applyCreateUser(m *messaging.Message) (err error) {
 type createUserCommand struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
+	Hash     string `json:"hash,omitempty"`
 	Admin    bool   `json:"admin,omitempty"`
 }
 
@@ -867,11 +986,13 @@ func (s *Server) RetentionPolicies(database string) ([]*RetentionPolicy, error)
 // CreateRetentionPolicy creates a retention policy for a database.
 func (s *Server) CreateRetentionPolicy(database string, rp *RetentionPolicy) error {
 	c := &createRetentionPolicyCommand{
-		Database: database,
-		Name:     rp.Name,
-		Duration: rp.Duration,
-		ReplicaN: rp.ReplicaN,
-		SplitN:   rp.SplitN,
+		Database:           database,
+		Name:               rp.Name,
+		Duration:           rp.Duration,
+		ShardGroupDuration: rp.ShardGroupDuration,
+		ReplicaN:           rp.ReplicaN,
+		SplitN:             rp.SplitN,
+		ShardKey:           rp.ShardKey,
 	}
 	_, err := s.broadcast(createRetentionPolicyMessageType, c)
 	return err
@@ -892,14 +1013,18 @@ func (s *Server) applyCreateRetentionPolicy(m *messaging.Message) error {
 		return ErrRetentionPolicyNameRequired
 	} else if db.policies[c.Name] != nil {
 		return ErrRetentionPolicyExists
+	} else if !validShardGroupDuration(c.ShardGroupDuration, c.Duration) {
+		return ErrInvalidShardGroupDuration
 	}
 
 	// Add policy to the database.
 	db.policies[c.Name] = &RetentionPolicy{
-		Name:     c.Name,
-		Duration: c.Duration,
-		ReplicaN: c.ReplicaN,
-		SplitN:   c.SplitN,
+		Name:               c.Name,
+		Duration:           c.Duration,
+		ShardGroupDuration: c.ShardGroupDuration,
+		ReplicaN:           c.ReplicaN,
+		SplitN:             c.SplitN,
+		ShardKey:           c.ShardKey,
 	}
 
 	// Persist to metastore.
@@ -911,24 +1036,35 @@ func (s *Server) applyCreateRetentionPolicy(m *messaging.Message) error {
 }
 
 type createRetentionPolicyCommand struct {
-	Database string        `json:"database"`
-	Name     string        `json:"name"`
-	Duration time.Duration `json:"duration"`
-	ReplicaN uint32        `json:"replicaN"`
-	SplitN   uint32        `json:"splitN"`
+	Database           string        `json:"database"`
+	Name               string        `json:"name"`
+	Duration           time.Duration `json:"duration"`
+	ShardGroupDuration time.Duration `json:"shardGroupDuration,omitempty"`
+	ReplicaN           uint32        `json:"replicaN"`
+	SplitN             uint32        `json:"splitN"`
+	ShardKey           []string      `json:"shardKey,omitempty"`
+}
+
+// validShardGroupDuration returns true if a shard group duration can be used
+// with a policy duration. A zero shard group duration uses the default.
+func validShardGroupDuration(d, policy time.Duration) bool {
+	return d >= 0 && (policy == 0 || d <= policy)
 }
 
 // UpdateRetentionPolicy updates an existing retention policy on a database.
+// The shard group duration is only changed if it is set and only applies to
+// shards created after the update.
 func (s *Server) UpdateRetentionPolicy(database, name string, rp *RetentionPolicy) error {
-	c := &updateRetentionPolicyCommand{Database: database, Name: name, NewName: rp.Name}
+	c := &updateRetentionPolicyCommand{Database: database, Name: name, NewName: rp.Name, ShardGroupDuration: rp.ShardGroupDuration}
 	_, err := s.broadcast(updateRetentionPolicyMessageType, c)
 	return err
 }
 
 type updateRetentionPolicyCommand struct {
-	Database string `json:"database"`
-	Name     string `json:"name"`
-	NewName  string `json:"newName"`
+	Database           string        `json:"database"`
+	Name               string        `json:"name"`
+	NewName            string        `json:"newName"`
+	ShardGroupDuration time.Duration `json:"shardGroupDuration,omitempty"`
 }
 
 func (s *Server) applyUpdateRetentionPolicy(m *messaging.Message) (err error) {
@@ -950,6 +1086,13 @@ func (s *Server) applyUpdateRetentionPolicy(m *messaging.Message) (err error) {
 	p := db.policies[c.Name]
 	if db.policies[c.Name] == nil {
 		return ErrRetentionPolicyNotFound
+	} else if !validShardGroupDuration(c.ShardGroupDuration, p.Duration) {
+		return ErrInvalidShardGroupDuration
+	}
+
+	// Update the shard group duration, if set.
+	if c.ShardGroupDuration != 0 {
+		p.ShardGroupDuration = c.ShardGroupDuration
 	}
 
 	// Update the policy name, if not blank.
@@ -993,6 +1136,9 @@ func (s *Server) applyDeleteRetentionPolicy(m *messaging.Message) (err error) {
 
 	// Remove retention policy.
 	delete(db.policies, c.Name)
+	if s.QueryCache != nil {
+		s.QueryCache.InvalidateDatabase(c.Database)
+	}
 
 	// Persist to metastore.
 	err = s.meta.mustUpdate(func(tx *metatx) error {
@@ -1030,7 +1176,11 @@ func (s *Server) applySetDefaultRetentionPolicy(m *messaging.Message) (err error
 	}
 
 	// Update default policy.
+	// Cached results of queries on the previous default are no longer valid.
 	db.defaultRetentionPolicy = c.Name
+	if s.QueryCache != nil {
+		s.QueryCache.InvalidateDatabase(c.Database)
+	}
 
 	// Persist to metastore.
 	err = s.meta.mustUpdate(func(tx *metatx) error {
@@ -1061,6 +1211,9 @@ func (s *Server) applyCreateSeriesIfNotExists(m *messaging.Message) error {
 	if _, series := db.MeasurementAndSeries(c.Name, c.Tags); series != nil {
 		return nil
 	}
+	if err := db.validateSeries(c.Name, c.Tags, s.MaxSeriesPerDatabase, s.MaxValuesPerTag); err != nil {
+		return err
+	}
 
 	// save to the metastore and add it to the in memory index
 	var series *Series
@@ -1083,6 +1236,111 @@ type createSeriesIfNotExistsCommand struct {
 	Tags     map[string]string `json:"tags"`
 }
 
+// SweepIdleSeries removes the series which haven't been written for the
+// series idle timeout and have no points left in the shards of any retention
+// policy. Only databases with all of their shards stored on this server are
+// swept since the points on other data nodes can't be checked.
+func (s *Server) SweepIdleSeries() error {
+	if s.SeriesIdleTimeout == 0 {
+		return nil
+	}
+
+	// Find the idle series in each database.
+	var commands []*dropSeriesCommand
+	s.mu.RLock()
+	for _, db := range s.databases {
+		ids, err := s.idleSeriesIDs(db, time.Now().Add(-s.SeriesIdleTimeout))
+		if err != nil {
+			s.mu.RUnlock()
+			return err
+		} else if len(ids) > 0 {
+			commands = append(commands, &dropSeriesCommand{Database: db.name, SeriesIDs: ids})
+		}
+	}
+	s.mu.RUnlock()
+
+	// Remove the series from every server.
+	for _, c := range commands {
+		if _, err := s.broadcast(dropSeriesMessageType, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// idleSeriesIDs returns the ids of the series in a database which were last
+// written before a time and have no points in any shard. Returns nil if a
+// shard is stored on another data node. The server's lock must be held by the caller.
+func (s *Server) idleSeriesIDs(db *database, before time.Time) (SeriesIDs, error) {
+	// Find the series with points.
+	ids := NewSeriesIDSet()
+	for _, rp := range db.policies {
+		for _, sh := range rp.Shards {
+			if !sh.HasDataNodeID(s.id) || sh.store == nil {
+				return nil, nil
+			}
+			other, err := sh.seriesIDs()
+			if err != nil {
+				return nil, err
+			}
+			ids.merge(other)
+		}
+	}
+
+	// Filter out the series without points which were written recently.
+	var a SeriesIDs
+	for _, id := range db.SeriesIDs(nil, nil) {
+		if !ids.Contains(id) && db.lastWrite(id).Before(before) {
+			a = append(a, id)
+		}
+	}
+	return a, nil
+}
+
+func (s *Server) applyDropSeries(m *messaging.Message) error {
+	var c dropSeriesCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Validate command.
+	db := s.databases[c.Database]
+	if db == nil {
+		return ErrDatabaseNotFound
+	}
+
+	// Find the series which still exist.
+	var a []*Series
+	for _, id := range c.SeriesIDs {
+		if series := db.SeriesByID(id); series != nil {
+			a = append(a, series)
+		}
+	}
+
+	// Remove the series from the metastore and then from the index.
+	if err := s.meta.mustUpdate(func(tx *metatx) error {
+		for _, series := range a {
+			if err := tx.deleteSeries(db.name, series.measurement.Name, series); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	for _, series := range a {
+		db.removeSeriesFromIndex(series.measurement.Name, series)
+	}
+
+	return nil
+}
+
+type dropSeriesCommand struct {
+	Database  string   `json:"database"`
+	SeriesIDs []uint32 `json:"seriesIDs"`
+}
+
 // WriteSeries writes series data to the database.
 func (s *Server) WriteSeries(database, retentionPolicy, name string, tags map[string]string, timestamp time.Time, values map[string]interface{}) error {
 	// Find the id for the series and tagset
@@ -1091,6 +1349,11 @@ func (s *Server) WriteSeries(database, retentionPolicy, name string, tags map[st
 		return err
 	}
 
+	// Create fields for any new values.
+	if err := s.createFieldsIfNotExists(database, name, values); err != nil {
+		return err
+	}
+
 	// If the retention policy is not set, use the default for this database.
 	if retentionPolicy == "" {
 		rp, err := s.DefaultRetentionPolicy(database)
@@ -1101,7 +1364,7 @@ func (s *Server) WriteSeries(database, retentionPolicy, name string, tags map[st
 	}
 
 	// Now write it into the shard.
-	sh, err := s.createShardIfNotExists(database, retentionPolicy, id, timestamp)
+	sh, err := s.createShardIfNotExists(database, retentionPolicy, id, tags, timestamp)
 	if err != nil {
 		return fmt.Errorf("create shard(%s/%s): %s", retentionPolicy, timestamp.Format(time.RFC3339Nano), err)
 	}
@@ -1124,28 +1387,132 @@ func (s *Server) WriteSeries(database, retentionPolicy, name string, tags map[st
 }
 
 func (s *Server) applyWriteSeries(m *messaging.Message) error {
+	// The lock is held during the write so shards cannot be closed underneath it.
 	s.mu.RLock()
+	defer s.mu.RUnlock()
 
 	// Retrieve the database.
 	db := s.databasesByShard[m.TopicID]
 	if db == nil {
-		s.mu.RUnlock()
 		return ErrDatabaseNotFound
 	}
 
 	// Retrieve the shard.
 	sh := db.shards[m.TopicID]
-	if sh == nil {
-		s.mu.RUnlock()
+	if sh == nil || sh.store == nil {
 		return ErrShardNotFound
 	}
-	s.mu.RUnlock()
 
 	// TODO: enable some way to specify if the data should be overwritten
 	overwrite := true
 
 	// Write to shard.
-	return sh.writeSeries(overwrite, m.Data)
+	if err := sh.writeSeries(overwrite, m.Data); err != nil {
+		return err
+	}
+	db.setLastWrite([][]byte{m.Data}, time.Now())
+	s.invalidateQueryCache(db.name, [][]byte{m.Data})
+	return nil
+}
+
+// invalidateQueryCache removes the cached query results holding encoded points.
+func (s *Server) invalidateQueryCache(database string, points [][]byte) {
+	if s.QueryCache == nil {
+		return
+	}
+	for _, p := range points {
+		s.QueryCache.Invalidate(database, unmarshalPointTimestamp(p))
+	}
+}
+
+// writePoints writes a batch of points to the database. Series, fields and
+// shards are created as needed and the points are then published as one
+// message per shard instead of one message per point.
+func (s *Server) writePoints(database, retentionPolicy string, points []*point) error {
+	// If the retention policy is not set, use the default for this database.
+	if retentionPolicy == "" {
+		rp, err := s.DefaultRetentionPolicy(database)
+		if err != nil {
+			return fmt.Errorf("failed to determine default retention policy: %s", err)
+		}
+		retentionPolicy = rp.Name
+	}
+
+	// Encode each point and group the encoded points by shard.
+	var shardIDs []uint64
+	data := make(map[uint64][][]byte)
+	for _, p := range points {
+		id, err := s.createSeriesIfNotExists(database, p.name, p.tags)
+		if err != nil {
+			return err
+		}
+		if err := s.createFieldsIfNotExists(database, p.name, p.values); err != nil {
+			return err
+		}
+
+		sh, err := s.createShardIfNotExists(database, retentionPolicy, id, p.tags, p.timestamp)
+		if err != nil {
+			return fmt.Errorf("create shard(%s/%s): %s", retentionPolicy, p.timestamp.Format(time.RFC3339Nano), err)
+		}
+
+		b, err := marshalPoint(id, p.timestamp, p.values)
+		if err != nil {
+			return err
+		}
+		if _, ok := data[sh.ID]; !ok {
+			shardIDs = append(shardIDs, sh.ID)
+		}
+		data[sh.ID] = append(data[sh.ID], b)
+	}
+
+	// Publish a "write series batch" message on each shard's topic.
+	for _, id := range shardIDs {
+		m := &messaging.Message{
+			Type:    writeSeriesBatchMessageType,
+			TopicID: id,
+			Data:    marshalPoints(data[id]),
+		}
+		if _, err := s.client.Publish(m); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) applyWriteSeriesBatch(m *messaging.Message) error {
+	// The lock is held during the write so shards cannot be closed underneath it.
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// Retrieve the database.
+	db := s.databasesByShard[m.TopicID]
+	if db == nil {
+		return ErrDatabaseNotFound
+	}
+
+	// Retrieve the shard.
+	sh := db.shards[m.TopicID]
+	if sh == nil || sh.store == nil {
+		return ErrShardNotFound
+	}
+
+	// Write all points to the shard in a single transaction.
+	if err := sh.writeSeriesBatch(true, m.Data); err != nil {
+		return err
+	}
+	points, _ := unmarshalPoints(m.Data)
+	db.setLastWrite(points, time.Now())
+	s.invalidateQueryCache(db.name, points)
+	return nil
+}
+
+// point represents a single point to be written by writePoints.
+type point struct {
+	name      string
+	tags      map[string]string
+	timestamp time.Time
+	values    map[string]interface{}
 }
 
 func (s *Server) createSeriesIfNotExists(database, name string, tags map[string]string) (uint32, error) {
@@ -1174,6 +1541,115 @@ func (s *Server) createSeriesIfNotExists(database, name string, tags map[string]
 	return series.ID, nil
 }
 
+// createFieldsIfNotExists creates any fields in values that do not exist on a measurement.
+func (s *Server) createFieldsIfNotExists(database, name string, values map[string]interface{}) error {
+	// Find the fields that don't exist locally first.
+	c := &createFieldsIfNotExistsCommand{Database: database, Measurement: name, Fields: make(map[string]FieldType)}
+	s.mu.RLock()
+	db := s.databases[database]
+	if db == nil {
+		s.mu.RUnlock()
+		return ErrDatabaseNotFound
+	}
+	m := db.measurements[name]
+	for k, v := range values {
+		if m == nil || m.field(k) == nil {
+			c.Fields[k] = fieldTypeOf(v)
+		} else if typ := fieldTypeOf(v); m.field(k).Type != typ {
+			s.mu.RUnlock()
+			return ErrFieldTypeConflict
+		}
+	}
+	s.mu.RUnlock()
+
+	// Ignore if all fields already exist.
+	if len(c.Fields) == 0 {
+		return nil
+	}
+
+	// Otherwise broadcast the new fields.
+	_, err := s.broadcast(createFieldsIfNotExistsMessageType, c)
+	return err
+}
+
+func (s *Server) applyCreateFieldsIfNotExists(m *messaging.Message) error {
+	var c createFieldsIfNotExistsCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Validate command.
+	db := s.databases[c.Database]
+	if db == nil {
+		return ErrDatabaseNotFound
+	}
+
+	// Create fields in a consistent order so ids match on every server.
+	names := make([]string, 0, len(c.Fields))
+	for name := range c.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	mm := db.createMeasurementIfNotExists(c.Measurement)
+	for _, name := range names {
+		if _, err := mm.createFieldIfNotExists(name, c.Fields[name]); err != nil {
+			return err
+		}
+	}
+
+	// Persist to metastore.
+	return s.meta.mustUpdate(func(tx *metatx) error {
+		return tx.saveDatabase(db)
+	})
+}
+
+type createFieldsIfNotExistsCommand struct {
+	Database    string               `json:"database"`
+	Measurement string               `json:"measurement"`
+	Fields      map[string]FieldType `json:"fields"`
+}
+
+// UpdateMeasurement sets the metadata of a measurement or one of its fields.
+func (s *Server) UpdateMeasurement(database, name string, u *MeasurementUpdate) error {
+	c := &updateMeasurementCommand{Database: database, Name: name, Update: u}
+	_, err := s.broadcast(updateMeasurementMessageType, c)
+	return err
+}
+
+func (s *Server) applyUpdateMeasurement(m *messaging.Message) error {
+	var c updateMeasurementCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Validate command.
+	db := s.databases[c.Database]
+	if db == nil {
+		return ErrDatabaseNotFound
+	}
+	mm := db.measurements[c.Name]
+	if mm == nil {
+		return ErrMeasurementNotFound
+	}
+
+	// Update the measurement and persist to metastore.
+	if err := mm.update(c.Update); err != nil {
+		return err
+	}
+	return s.meta.mustUpdate(func(tx *metatx) error {
+		return tx.saveDatabase(db)
+	})
+}
+
+type updateMeasurementCommand struct {
+	Database string             `json:"database"`
+	Name     string             `json:"name"`
+	Update   *MeasurementUpdate `json:"update"`
+}
+
 func (s *Server) MeasurementNames(database string) []string {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -1198,6 +1674,694 @@ func (s *Server) MeasurementSeriesIDs(database, measurement string) SeriesIDs {
 	return db.SeriesIDs([]string{measurement}, nil)
 }
 
+// ExecuteQuery executes an InfluxQL query against the server.
+// Returns a resultset for each statement in the query.
+// Stops on first execution error that occurs.
+func (s *Server) ExecuteQuery(q *influxql.Query, database string, user *User, closing <-chan struct{}) Results {
+	results := make(Results, len(q.Statements))
+	s.executeQuery(q, database, user, 0, closing, func(res *Result) {
+		results[res.StatementID] = res
+	})
+	return results
+}
+
+// StreamQuery executes an InfluxQL query against the server and sends results
+// over the returned channel as soon as they are available. Rows from select
+// statements are sent in chunks of up to chunkSize values so a statement can
+// produce multiple results. The channel is closed once the query completes and
+// the caller must read until then. Stops on first execution error that occurs.
+func (s *Server) StreamQuery(q *influxql.Query, database string, user *User, chunkSize int, closing <-chan struct{}) <-chan *Result {
+	ch := make(chan *Result, 0)
+	go func() {
+		defer close(ch)
+		s.executeQuery(q, database, user, chunkSize, closing, func(res *Result) { ch <- res })
+	}()
+	return ch
+}
+
+// executeQuery executes each statement in a query and passes the results to fn.
+// Statements after the first error are not executed. If chunkSize is greater
+// than zero then rows from select statements are passed to fn as they are read.
+func (s *Server) executeQuery(q *influxql.Query, database string, user *User, chunkSize int, closing <-chan struct{}, fn func(*Result)) {
+	// Register the query so it can be listed and killed.
+	rq := s.registerQuery(q, database, user)
+	defer s.unregisterQuery(rq)
+
+	// Kill the query if the client disconnects or the timeout elapses.
+	go s.watchQuery(rq, closing)
+
+	// Execute each statement.
+	var err error
+	for i, stmt := range q.Statements {
+		send := func(res *Result) {
+			res.StatementID = i
+			fn(res)
+		}
+
+		// Mark all statements after an error as not executed.
+		if err != nil {
+			send(&Result{Err: ErrNotExecuted})
+			continue
+		}
+
+		// Stop executing statements once the query has been killed.
+		if err = rq.error(); err != nil {
+			send(&Result{Err: err})
+			continue
+		}
+
+		var res *Result
+		switch stmt := stmt.(type) {
+		case *influxql.SelectStatement:
+			res = s.executeSelectStatement(stmt, database, user, rq, chunkSize, send)
+		case *influxql.ExplainStatement:
+			res = s.executeExplainStatement(stmt, database, user)
+		case *influxql.ShowQueriesStatement:
+			res = s.executeShowQueriesStatement(stmt, user)
+		case *influxql.ShowSeriesCardinalityStatement:
+			res = s.executeShowSeriesCardinalityStatement(stmt, database)
+		case *influxql.ShowTagValuesCardinalityStatement:
+			res = s.executeShowTagValuesCardinalityStatement(stmt, database)
+		case *influxql.ListMeasurementsStatement:
+			res = s.executeListMeasurementsStatement(stmt, database)
+		case *influxql.ListFieldKeysStatement:
+			res = s.executeListFieldKeysStatement(stmt, database)
+		case *influxql.AlterMeasurementStatement:
+			res = s.executeAlterMeasurementStatement(stmt, database)
+		case *influxql.KillQueryStatement:
+			res = s.executeKillQueryStatement(stmt, user)
+		default:
+			res = &Result{Err: ErrInvalidQuery}
+		}
+		send(res)
+		err = res.Err
+	}
+}
+
+// executeSelectStatement plans and executes a select statement against a database.
+// Statements with regex or multiple measurement sources are executed once for
+// each matching measurement. If the statement has a target then the rows are
+// written to the target measurement and the number of points written is
+// returned instead.
+//
+// If chunkSize is greater than zero and there is no target then each row is
+// sent as a separate result as soon as it is read. The last row is returned.
+func (s *Server) executeSelectStatement(stmt *influxql.SelectStatement, database string, user *User, q *runningQuery, chunkSize int, send func(*Result)) *Result {
+	// Ensure the user can write to the target before reading anything.
+	if stmt.Target != nil && !user.canWrite(database, stmt.Target.Database) {
+		return &Result{Err: ErrWriteAccessDenied}
+	}
+
+	// Plan statement execution for each measurement.
+	stmts, err := s.expandSelectStatement(stmt, database, user)
+	if err != nil {
+		return &Result{Err: err}
+	}
+	executors := make([]*influxql.Executor, len(stmts))
+	for i, stmt := range stmts {
+		if executors[i], err = s.planSelectStatement(stmt, database, user, true); err != nil {
+			return &Result{Err: err}
+		}
+	}
+	chunked := chunkSize > 0 && stmt.Target == nil
+
+	// Execute each plan in order.
+	// Chunked rows are sent once the next row is read so that the final row
+	// can be returned.
+	res := &Result{Rows: make([]*influxql.Row, 0)}
+	var points []*point
+	for i, e := range executors {
+		n := len(res.Rows)
+		if chunked {
+			e.ChunkSize = chunkSize
+		}
+		ch, err := e.Execute()
+		if err != nil {
+			return &Result{Err: err}
+		}
+
+		// Read all rows from channel. Execution is stopped if the query is killed.
+	loop:
+		for {
+			select {
+			case row, ok := <-ch:
+				if !ok {
+					break loop
+				} else if row.Err != nil {
+					e.Stop()
+					return &Result{Err: row.Err}
+				}
+
+				if chunked && len(res.Rows) > 0 {
+					send(res)
+					res = &Result{Rows: make([]*influxql.Row, 0)}
+				}
+				res.Rows = append(res.Rows, row)
+			case <-q.closing:
+				e.Stop()
+				return &Result{Err: q.error()}
+			}
+		}
+
+		// Convert the plan's rows to points if there is a target. Untimed rows
+		// are written at the min time of the plan that produced them.
+		if stmt.Target != nil {
+			min, _ := e.TimeRange()
+			untimed := stmts[i].Aggregated() && e.Interval() == 0
+			a, err := selectIntoPoints(stmt.Target, min, untimed, res.Rows[n:])
+			if err != nil {
+				return &Result{Err: err}
+			}
+			points = append(points, a...)
+		}
+	}
+
+	// Return the rows if there is no target.
+	if stmt.Target == nil {
+		return res
+	}
+
+	// Otherwise write the points to the target as a batch and return the point count.
+	target := database
+	if stmt.Target.Database != "" {
+		target = stmt.Target.Database
+	}
+	if err := s.writePoints(target, stmt.Target.RetentionPolicy, points); err != nil {
+		return &Result{Err: err}
+	}
+	return &Result{
+		Rows: []*influxql.Row{{
+			Name:    "result",
+			Columns: []string{"time", "written"},
+			Values:  [][]interface{}{{int64(0), len(points)}},
+		}},
+	}
+}
+
+// executeExplainStatement plans a select statement and returns a description
+// of the plan, including the shards it would read, without executing it.
+// Splits which can't store any series of the plan are not listed.
+// Statements with regex or multiple measurement sources describe the plan
+// for each matching measurement.
+func (s *Server) executeExplainStatement(stmt *influxql.ExplainStatement, database string, user *User) *Result {
+	// Plan statement execution for each measurement.
+	stmts, err := s.expandSelectStatement(stmt.Statement, database, user)
+	if err != nil {
+		return &Result{Err: err}
+	}
+	res := &Result{Rows: make([]*influxql.Row, 0)}
+	row := &influxql.Row{Name: "shards", Columns: []string{"id", "startTime", "endTime"}}
+	shardIDs := make(map[uint64]bool)
+	for _, stmt := range stmts {
+		e, err := s.planSelectStatement(stmt, database, user, false)
+		if err != nil {
+			return &Result{Err: err}
+		}
+		res.Rows = append(res.Rows, e.Explain()...)
+
+		// Describe the shards that overlap the plan's time range and can
+		// store one of the plan's series.
+		min, max := e.TimeRange()
+		s.mu.RLock()
+		if db, policy, err := s.selectSource(stmt, database); err == nil && db.policies[policy] != nil {
+			rp := db.policies[policy]
+			readable := make(map[uint64]bool)
+			for _, id := range e.SeriesIDs() {
+				if series := db.SeriesByID(id); series != nil {
+					for _, sh := range rp.seriesShardsByTimeRange(series, min, max) {
+						readable[sh.ID] = true
+					}
+				}
+			}
+			for _, sh := range rp.shardsByTimeRange(min, max) {
+				if readable[sh.ID] && !shardIDs[sh.ID] {
+					shardIDs[sh.ID] = true
+					row.Values = append(row.Values, []interface{}{sh.ID, sh.StartTime.UTC(), sh.EndTime.UTC()})
+				}
+			}
+		}
+		s.mu.RUnlock()
+	}
+
+	res.Rows = append(res.Rows, row)
+	return res
+}
+
+// executeShowQueriesStatement lists the running queries.
+// Non-admin users can only see their own queries.
+func (s *Server) executeShowQueriesStatement(stmt *influxql.ShowQueriesStatement, user *User) *Result {
+	s.qmu.Lock()
+	defer s.qmu.Unlock()
+
+	// Sort queries by id.
+	a := make(runningQueries, 0, len(s.queries))
+	for _, q := range s.queries {
+		if q.visibleTo(user) {
+			a = append(a, q)
+		}
+	}
+	sort.Sort(a)
+
+	// Add a row value for each query.
+	row := &influxql.Row{Name: "queries", Columns: []string{"id", "user", "database", "query", "startTime"}}
+	for _, q := range a {
+		row.Values = append(row.Values, []interface{}{q.id, q.user, q.database, q.text, q.startTime})
+	}
+
+	return &Result{Rows: []*influxql.Row{row}}
+}
+
+// executeShowSeriesCardinalityStatement counts the series in each measurement of a database.
+func (s *Server) executeShowSeriesCardinalityStatement(stmt *influxql.ShowSeriesCardinalityStatement, database string) *Result {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	db := s.databases[database]
+	if db == nil {
+		return &Result{Err: ErrDatabaseNotFound}
+	}
+
+	row := &influxql.Row{Name: "cardinality", Columns: []string{"measurement", "count"}}
+	for _, name := range db.measurementNamesBySource(stmt.Source) {
+		row.Values = append(row.Values, []interface{}{name, db.measurements[name].seriesN()})
+	}
+	return &Result{Rows: []*influxql.Row{row}}
+}
+
+// executeShowTagValuesCardinalityStatement counts the values of the tag keys
+// in each measurement of a database.
+func (s *Server) executeShowTagValuesCardinalityStatement(stmt *influxql.ShowTagValuesCardinalityStatement, database string) *Result {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	db := s.databases[database]
+	if db == nil {
+		return &Result{Err: ErrDatabaseNotFound}
+	}
+
+	row := &influxql.Row{Name: "cardinality", Columns: []string{"measurement", "key", "count"}}
+	for _, name := range db.measurementNamesBySource(stmt.Source) {
+		m := db.measurements[name]
+
+		keys := []string{stmt.TagKey}
+		if stmt.TagKey == "" {
+			keys = m.tagKeys()
+			sort.Strings(keys)
+		}
+		for _, k := range keys {
+			if n := m.tagValueN(k); n > 0 {
+				row.Values = append(row.Values, []interface{}{name, k, n})
+			}
+		}
+	}
+	return &Result{Rows: []*influxql.Row{row}}
+}
+
+// executeListMeasurementsStatement returns the measurements of a database
+// and their metadata, sorted by name.
+func (s *Server) executeListMeasurementsStatement(stmt *influxql.ListMeasurementsStatement, database string) *Result {
+	if stmt.Condition != nil {
+		return &Result{Err: ErrConditionNotSupported}
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	db := s.databases[database]
+	if db == nil {
+		return &Result{Err: ErrDatabaseNotFound}
+	}
+
+	row := &influxql.Row{Name: "measurements", Columns: []string{"name", "unit", "description", "owner"}}
+	for _, name := range db.names {
+		if stmt.Limit > 0 && len(row.Values) >= stmt.Limit {
+			break
+		}
+		m := db.measurements[name]
+		row.Values = append(row.Values, []interface{}{m.Name, m.Unit, m.Description, m.Owner})
+	}
+	return &Result{Rows: []*influxql.Row{row}}
+}
+
+// executeListFieldKeysStatement returns the fields of each measurement in
+// the source and their metadata, in the order the fields were created. The
+// limit applies to the fields of each measurement.
+func (s *Server) executeListFieldKeysStatement(stmt *influxql.ListFieldKeysStatement, database string) *Result {
+	if stmt.Condition != nil {
+		return &Result{Err: ErrConditionNotSupported}
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	db := s.databases[database]
+	if db == nil {
+		return &Result{Err: ErrDatabaseNotFound}
+	}
+
+	var rows []*influxql.Row
+	for _, name := range db.measurementNamesBySource(stmt.Source) {
+		fields := db.measurements[name].Fields
+		if len(fields) == 0 {
+			continue
+		} else if stmt.Limit > 0 && len(fields) > stmt.Limit {
+			fields = fields[:stmt.Limit]
+		}
+
+		row := &influxql.Row{Name: name, Columns: []string{"fieldKey", "unit", "description", "owner"}}
+		for _, f := range fields {
+			row.Values = append(row.Values, []interface{}{f.Name, f.Unit, f.Description, f.Owner})
+		}
+		rows = append(rows, row)
+	}
+	return &Result{Rows: rows}
+}
+
+// executeAlterMeasurementStatement sets the metadata of a measurement or one of its fields.
+func (s *Server) executeAlterMeasurementStatement(stmt *influxql.AlterMeasurementStatement, database string) *Result {
+	u := &MeasurementUpdate{
+		Field:       stmt.Field,
+		Unit:        stmt.Unit,
+		Description: stmt.Description,
+		Owner:       stmt.Owner,
+	}
+	return &Result{Err: s.UpdateMeasurement(database, stmt.Name, u)}
+}
+
+// executeKillQueryStatement kills a running query.
+// Non-admin users can only kill their own queries.
+func (s *Server) executeKillQueryStatement(stmt *influxql.KillQueryStatement, user *User) *Result {
+	s.qmu.Lock()
+	q := s.queries[stmt.QueryID]
+	s.qmu.Unlock()
+
+	if q == nil || !q.visibleTo(user) {
+		return &Result{Err: ErrQueryNotFound}
+	}
+	q.kill(ErrQueryKilled)
+	return &Result{}
+}
+
+// registerQuery adds a query to the list of running queries.
+func (s *Server) registerQuery(q *influxql.Query, database string, user *User) *runningQuery {
+	s.qmu.Lock()
+	defer s.qmu.Unlock()
+
+	s.maxQueryID++
+	rq := &runningQuery{
+		id:        s.maxQueryID,
+		database:  database,
+		text:      q.String(),
+		startTime: time.Now().UTC(),
+		closing:   make(chan struct{}, 0),
+		done:      make(chan struct{}, 0),
+	}
+	if user != nil {
+		rq.user = user.Name
+	}
+	s.queries[rq.id] = rq
+
+	return rq
+}
+
+// unregisterQuery removes a query from the list of running queries.
+func (s *Server) unregisterQuery(q *runningQuery) {
+	s.qmu.Lock()
+	defer s.qmu.Unlock()
+	delete(s.queries, q.id)
+	close(q.done)
+}
+
+// watchQuery kills a query when the client closes or the query times out.
+// Returns once the query completes.
+func (s *Server) watchQuery(q *runningQuery, closing <-chan struct{}) {
+	var timeout <-chan time.Time
+	if s.QueryTimeout > 0 {
+		t := time.NewTimer(s.QueryTimeout)
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	select {
+	case <-closing:
+		q.kill(ErrQueryKilled)
+	case <-timeout:
+		q.kill(ErrQueryTimeout)
+	case <-q.done:
+	}
+}
+
+// runningQuery represents a query that is currently executing.
+type runningQuery struct {
+	mu        sync.Mutex
+	id        uint64
+	user      string
+	database  string
+	text      string
+	startTime time.Time
+
+	err     error         // reason the query was killed
+	closing chan struct{} // close notification when killed
+	done    chan struct{} // close notification when complete
+}
+
+// kill marks the query as killed for a given reason and notifies the executor.
+// Has no effect if the query has already been killed.
+func (q *runningQuery) kill(err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.err == nil {
+		q.err = err
+		close(q.closing)
+	}
+}
+
+// error returns the reason the query was killed, if any.
+func (q *runningQuery) error() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.err
+}
+
+// visibleTo returns true if the user can see and kill the query.
+// All queries are visible when authentication is disabled or to admins.
+func (q *runningQuery) visibleTo(u *User) bool {
+	return u == nil || u.Admin || u.Name == q.user
+}
+
+// runningQueries represents a list of running queries, sortable by id.
+type runningQueries []*runningQuery
+
+func (p runningQueries) Len() int           { return len(p) }
+func (p runningQueries) Less(i, j int) bool { return p[i].id < p[j].id }
+func (p runningQueries) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// RunMapper runs a mapper against the local data of a single shard and calls
+// fn with each chunk of values emitted. This is used by other data nodes to
+// run mappers for the shards stored on this node. Execution stops if fn returns false.
+func (s *Server) RunMapper(database string, shardID uint64, spec *influxql.MapperSpec, fn func([]influxql.MapperValue) bool) error {
+	s.mu.RLock()
+	db := s.databases[database]
+	if db == nil {
+		s.mu.RUnlock()
+		return ErrDatabaseNotFound
+	}
+	sh := db.shards[shardID]
+	if sh == nil || sh.store == nil {
+		s.mu.RUnlock()
+		return ErrShardNotFound
+	}
+	s.mu.RUnlock()
+
+	return influxql.RunMapper(&dbi{server: s, db: db, shard: sh}, spec, DefaultChunkSize, fn)
+}
+
+// acquireShardQuery waits for a slot to query a shard on another data node.
+// Returns a function to release the slot.
+func (s *Server) acquireShardQuery() func() {
+	s.mu.Lock()
+	if s.shardQueries == nil && s.ConcurrentShardQueryLimit > 0 {
+		s.shardQueries = make(chan struct{}, s.ConcurrentShardQueryLimit)
+	}
+	ch := s.shardQueries
+	s.mu.Unlock()
+
+	if ch == nil {
+		return func() {}
+	}
+	ch <- struct{}{}
+	return func() { <-ch }
+}
+
+// expandSelectStatement returns a select statement for each measurement in
+// the statement's source. Regular expressions are matched against the
+// measurement names of the source's database. Returns an error if the user
+// cannot read from one of the sources.
+func (s *Server) expandSelectStatement(stmt *influxql.SelectStatement, database string, user *User) ([]*influxql.SelectStatement, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.databases[database] == nil {
+		return nil, ErrDatabaseNotFound
+	}
+	stmts := stmt.ExpandSources(func(name string) []string {
+		if name == "" {
+			name = database
+		}
+		if db := s.databases[name]; db != nil {
+			return db.Names()
+		}
+		return nil
+	})
+
+	// Ensure every source can be read by the user.
+	for _, stmt := range stmts {
+		if _, _, err := s.selectSource(stmt, database); err != nil {
+			return nil, err
+		} else if m, ok := stmt.Source.(*influxql.Measurement); ok && !user.canRead(database, m.Database) {
+			return nil, ErrReadAccessDenied
+		}
+	}
+	return stmts, nil
+}
+
+// selectSource returns the database and retention policy that a select
+// statement reads from. Statements read from the query's database and its
+// default retention policy unless the source is qualified with other names.
+// The server's lock must be held by the caller.
+func (s *Server) selectSource(stmt *influxql.SelectStatement, database string) (*database, string, error) {
+	var policy string
+	if m, ok := stmt.Source.(*influxql.Measurement); ok {
+		if m.Database != "" {
+			database = m.Database
+		}
+		policy = m.RetentionPolicy
+	}
+
+	db := s.databases[database]
+	if db == nil {
+		return nil, "", ErrDatabaseNotFound
+	}
+	if policy == "" {
+		policy = db.defaultRetentionPolicy
+	} else if db.policies[policy] == nil {
+		return nil, "", ErrRetentionPolicyNotFound
+	}
+	return db, policy, nil
+}
+
+// planSelectStatement creates an execution plan for a select statement.
+// Data is read from the database and retention policy of the statement's
+// source. The per-query limits are only applied if limit is true since plans
+// that are only explained never read any data. Mappers on other data nodes
+// are run on behalf of the user.
+func (s *Server) planSelectStatement(stmt *influxql.SelectStatement, database string, user *User, limit bool) (*influxql.Executor, error) {
+	s.mu.RLock()
+	db, policy, err := s.selectSource(stmt, database)
+	s.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	// Plan against the server's storage.
+	p := influxql.NewPlanner(&dbi{server: s, db: db, policy: policy, user: user, query: database})
+	if limit {
+		p.MaxSeriesN = s.MaxSeriesPerQuery
+		p.MaxBucketsN = s.MaxBucketsPerQuery
+		p.MaxPointsN = s.MaxPointsPerQuery
+		p.Cache, p.Database = s.QueryCache, db.name
+	}
+	return p.Plan(stmt)
+}
+
+// selectIntoPoints converts the rows from a SELECT INTO statement to points
+// on the target measurement. Row timestamps are in microseconds, or RFC3339 if
+// the query was run in a time zone. Untimed rows, such as aggregates without a
+// GROUP BY time(), are written at min instead.
+func selectIntoPoints(target *influxql.Target, min time.Time, untimed bool, rows []*influxql.Row) ([]*point, error) {
+	if untimed && min.IsZero() {
+		return nil, ErrSelectIntoTimeRequired
+	}
+
+	var points []*point
+	for _, row := range rows {
+		if len(row.Columns) == 0 || row.Columns[0] != "time" {
+			return nil, fmt.Errorf("select into: unexpected first column: %v", row.Columns)
+		}
+
+		for _, values := range row.Values {
+			// Read the timestamp from the time column unless the row is untimed.
+			timestamp := min.UTC()
+			if !untimed {
+				switch v := values[0].(type) {
+				case string:
+					ts, err := time.Parse(time.RFC3339Nano, v)
+					if err != nil {
+						return nil, err
+					}
+					timestamp = ts.UTC()
+				case int64:
+					timestamp = time.Unix(0, v*int64(time.Microsecond)).UTC()
+				default:
+					return nil, fmt.Errorf("select into: unexpected timestamp: %v", v)
+				}
+			}
+
+			// Map column names to field values. Empty values are skipped.
+			fields := make(map[string]interface{})
+			for i, v := range values[1:] {
+				if v != nil {
+					fields[row.Columns[i+1]] = v
+				}
+			}
+			if len(fields) == 0 {
+				continue
+			}
+
+			points = append(points, &point{name: target.Measurement, tags: row.Tags, timestamp: timestamp, values: fields})
+		}
+	}
+	return points, nil
+}
+
+// Result represents a resultset returned from a single statement.
+type Result struct {
+	StatementID int // index of the statement in the query
+	Rows        []*influxql.Row
+	Err         error
+}
+
+// MarshalJSON encodes the result into JSON.
+func (r *Result) MarshalJSON() ([]byte, error) {
+	// Define a struct that outputs "error" as a string.
+	var o struct {
+		Rows []*influxql.Row `json:"rows,omitempty"`
+		Err  string          `json:"error,omitempty"`
+	}
+
+	// Copy fields to output struct.
+	o.Rows = r.Rows
+	if r.Err != nil {
+		o.Err = r.Err.Error()
+	}
+
+	return json.Marshal(&o)
+}
+
+// Results represents a list of statement results.
+type Results []*Result
+
+// Error returns the first error from any statement.
+// Returns nil if no errors occurred on any statements.
+func (a Results) Error() error {
+	for _, r := range a {
+		if r.Err != nil {
+			return r.Err
+		}
+	}
+	return nil
+}
+
 // processor runs in a separate goroutine and processes all incoming broker messages.
 func (s *Server) processor(client MessagingClient, done chan struct{}) {
 	for {
@@ -1214,6 +2378,8 @@ func (s *Server) processor(client MessagingClient, done chan struct{}) {
 		switch m.Type {
 		case writeSeriesMessageType:
 			err = s.applyWriteSeries(m)
+		case writeSeriesBatchMessageType:
+			err = s.applyWriteSeriesBatch(m)
 		case createDataNodeMessageType:
 			err = s.applyCreateDataNode(m)
 		case deleteDataNodeMessageType:
@@ -1240,6 +2406,12 @@ func (s *Server) processor(client MessagingClient, done chan struct{}) {
 			err = s.applySetDefaultRetentionPolicy(m)
 		case createSeriesIfNotExistsMessageType:
 			err = s.applyCreateSeriesIfNotExists(m)
+		case dropSeriesMessageType:
+			err = s.applyDropSeries(m)
+		case createFieldsIfNotExistsMessageType:
+			err = s.applyCreateFieldsIfNotExists(m)
+		case updateMeasurementMessageType:
+			err = s.applyUpdateMeasurement(m)
 		}
 
 		// Sync high water mark and errors.
@@ -1288,6 +2460,29 @@ type User struct {
 	Admin bool   `json:"admin,omitempty"`
 }
 
+// canRead returns true if the user can read from the source database of a
+// query that was run against the query database. Reading from another database
+// requires an admin user. All reads are allowed when authentication is disabled.
+func (u *User) canRead(query, source string) bool {
+	return u == nil || u.Admin || source == "" || source == query
+}
+
+// canWrite returns true if the user can write to the target database of a
+// query that was run against the query database. Writing to another database
+// requires an admin user. All writes are allowed when authentication is disabled.
+func (u *User) canWrite(query, target string) bool {
+	return u == nil || u.Admin || target == "" || target == query
+}
+
+// sign returns the signature of a request body sent to another data node on
+// behalf of the user. Users are replicated to every node so the signature is
+// keyed by the user's password hash.
+func (u *User) sign(body []byte) string {
+	mac := hmac.New(sha256.New, []byte(u.Hash))
+	_, _ = mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
 // Authenticate returns nil if the password matches the user's password.
 // Returns an error if the password was incorrect.
 func (u *User) Authenticate(password string) error {
@@ -4,14 +4,19 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
 	"reflect"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"code.google.com/p/go.crypto/bcrypt"
 	"github.com/influxdb/influxdb"
+	"github.com/influxdb/influxdb/influxql"
 	"github.com/influxdb/influxdb/messaging"
 )
 
@@ -490,10 +495,12 @@ func TestServer_SetDefaultRetentionPolicy_ErrRetentionPolicyNotFound(t *testing.
 
 // Ensure the database can write data to the database.
 func TestServer_WriteSeries(t *testing.T) {
-	s := OpenServer(NewMessagingClient())
+	c := NewMessagingClient()
+	s := OpenServer(c)
 	defer s.Close()
 	s.CreateDatabase("foo")
 	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "myspace", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "myspace")
 	s.CreateUser("susy", "pass", false)
 
 	// Write series with one point to the database.
@@ -504,15 +511,968 @@ func TestServer_WriteSeries(t *testing.T) {
 
 	if err := s.WriteSeries("foo", "myspace", name, tags, timestamp, values); err != nil {
 		t.Fatal(err)
+	} else if err = s.Sync(c.index); err != nil {
+		t.Fatalf("sync error: %s", err)
+	}
+
+	// Retrieve the point back from the database.
+	results := s.ExecuteQuery(mustParseQuery(`SELECT sum(value) FROM cpu_load`), "foo", nil, nil)
+	if err := results.Error(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if s := mustMarshalJSON(results); s != `[{"rows":[{"name":"cpu_load","columns":["time","sum"],"values":[[0,23.2]]}]}]` {
+		t.Fatalf("unexpected results: %s", s)
+	}
+
+	// Ensure the point is still available after a restart.
+	s.Restart()
+	results = s.ExecuteQuery(mustParseQuery(`SELECT sum(value) FROM cpu_load`), "foo", nil, nil)
+	if s := mustMarshalJSON(results); s != `[{"rows":[{"name":"cpu_load","columns":["time","sum"],"values":[[0,23.2]]}]}]` {
+		t.Fatalf("unexpected results after restart: %s", s)
+	}
+}
+
+// Ensure the server can write the results of a query into another measurement.
+func TestServer_ExecuteQuery_SelectInto(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "rp_1y", Duration: 365 * 24 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+
+	// Write points for two hosts across two hours.
+	s.MustWriteSeries("foo", "raw", "cpu", map[string]string{"host": "servera"}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(10)})
+	s.MustWriteSeries("foo", "raw", "cpu", map[string]string{"host": "servera"}, "2000-01-01T00:30:00Z", map[string]interface{}{"value": float64(20)})
+	s.MustWriteSeries("foo", "raw", "cpu", map[string]string{"host": "servera"}, "2000-01-01T01:00:00Z", map[string]interface{}{"value": float64(30)})
+	s.MustWriteSeries("foo", "raw", "cpu", map[string]string{"host": "serverb"}, "2000-01-01T00:15:00Z", map[string]interface{}{"value": float64(1)})
+	s.MustWriteSeries("foo", "raw", "cpu", map[string]string{"host": "serverb"}, "2000-01-01T01:15:00Z", map[string]interface{}{"value": float64(2)})
+	if err := s.Sync(c.index); err != nil {
+		t.Fatalf("sync error: %s", err)
+	}
+
+	// Downsample into a measurement on another retention policy.
+	results := s.ExecuteQuery(mustParseQuery(`
+		SELECT sum(value) INTO "rp_1y"."cpu_1h"
+		FROM cpu
+		WHERE time >= "2000-01-01 00:00:00" AND time < "2000-01-01 02:00:00"
+		GROUP BY time(1h), host`), "foo", nil, nil)
+	if err := results.Error(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if s := mustMarshalJSON(results); s != `[{"rows":[{"name":"result","columns":["time","written"],"values":[[0,4]]}]}]` {
+		t.Fatalf("unexpected results: %s", s)
+	}
+	if err := s.Sync(c.index); err != nil {
+		t.Fatalf("sync error: %s", err)
+	}
+
+	// Read the downsampled points back from the target policy.
+	s.SetDefaultRetentionPolicy("foo", "rp_1y")
+	results = s.ExecuteQuery(mustParseQuery(`
+		SELECT sum(sum)
+		FROM cpu_1h
+		WHERE time >= "2000-01-01 00:00:00" AND time < "2000-01-01 02:00:00"
+		GROUP BY time(1h), host`), "foo", nil, nil)
+	if err := results.Error(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if s := mustMarshalJSON(results); s != `[{"rows":[`+
+		`{"name":"cpu_1h","tags":{"host":"servera"},"columns":["time","sum"],"values":[[946684800000000,30],[946688400000000,30]]},`+
+		`{"name":"cpu_1h","tags":{"host":"serverb"},"columns":["time","sum"],"values":[[946684800000000,1],[946688400000000,2]]}`+
+		`]}]` {
+		t.Fatalf("unexpected results: %s", s)
+	}
+}
+
+// Ensure the server writes aggregates without a time grouping at the query's min time.
+func TestServer_ExecuteQuery_SelectInto_NoGroupByTime(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.MustWriteSeries("foo", "raw", "cpu", nil, "2000-01-01T00:10:00Z", map[string]interface{}{"value": float64(10)})
+	s.MustWriteSeries("foo", "raw", "cpu", nil, "2000-01-01T00:20:00Z", map[string]interface{}{"value": float64(20)})
+	if err := s.Sync(c.index); err != nil {
+		t.Fatalf("sync error: %s", err)
+	}
+
+	// Sum without a time grouping.
+	results := s.ExecuteQuery(mustParseQuery(`SELECT sum(value) INTO cpu_total FROM cpu WHERE time >= "2000-01-01 00:00:00" AND time < "2000-01-01 01:00:00"`), "foo", nil, nil)
+	if err := results.Error(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := s.Sync(c.index); err != nil {
+		t.Fatalf("sync error: %s", err)
+	}
+
+	// The point should be written at the start of the time range.
+	results = s.ExecuteQuery(mustParseQuery(`SELECT sum FROM cpu_total WHERE time >= "2000-01-01 00:00:00" AND time < "2000-01-01 01:00:00"`), "foo", nil, nil)
+	if err := results.Error(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if s := mustMarshalJSON(results); s != `[{"rows":[{"name":"cpu_total","columns":["time","sum"],"values":[[946684800000000,30]]}]}]` {
+		t.Fatalf("unexpected results: %s", s)
+	}
+
+	// Without a lower time bound the points cannot be written.
+	results = s.ExecuteQuery(mustParseQuery(`SELECT sum(value) INTO cpu_total FROM cpu`), "foo", nil, nil)
+	if err := results.Error(); err != influxdb.ErrSelectIntoTimeRequired {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure the server keeps the timestamps of raw points written by SELECT INTO
+// without requiring a lower time bound.
+func TestServer_ExecuteQuery_SelectInto_Raw(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.MustWriteSeries("foo", "raw", "cpu", nil, "1970-01-01T00:00:10Z", map[string]interface{}{"value": float64(10)})
+	s.MustWriteSeries("foo", "raw", "cpu", nil, "1970-01-01T00:00:01Z", map[string]interface{}{"value": float64(20)})
+	if err := s.Sync(c.index); err != nil {
+		t.Fatalf("sync error: %s", err)
+	}
+
+	// Copy the raw points without a time range.
+	results := s.ExecuteQuery(mustParseQuery(`SELECT value INTO cpu_copy FROM cpu`), "foo", nil, nil)
+	if err := results.Error(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if s := mustMarshalJSON(results); s != `[{"rows":[{"name":"result","columns":["time","written"],"values":[[0,2]]}]}]` {
+		t.Fatalf("unexpected results: %s", s)
+	}
+	if err := s.Sync(c.index); err != nil {
+		t.Fatalf("sync error: %s", err)
 	}
 
-	t.Skip("pending")
+	results = s.ExecuteQuery(mustParseQuery(`SELECT value FROM cpu_copy`), "foo", nil, nil)
+	if err := results.Error(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if s := mustMarshalJSON(results); s != `[{"rows":[{"name":"cpu_copy","columns":["time","value"],"values":[[1000000,20],[10000000,10]]}]}]` {
+		t.Fatalf("unexpected results: %s", s)
+	}
+}
+
+// Ensure the server writes untimed SELECT INTO rows from every source.
+func TestServer_ExecuteQuery_SelectInto_MultipleSources(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.MustWriteSeries("foo", "raw", "cpu", map[string]string{"host": "servera"}, "2000-01-01T00:10:00Z", map[string]interface{}{"value": float64(10)})
+	s.MustWriteSeries("foo", "raw", "mem", map[string]string{"host": "serverb"}, "2000-01-01T00:20:00Z", map[string]interface{}{"value": float64(20)})
+	if err := s.Sync(c.index); err != nil {
+		t.Fatalf("sync error: %s", err)
+	}
+
+	results := s.ExecuteQuery(mustParseQuery(`SELECT sum(value) INTO total FROM cpu, mem WHERE time >= "2000-01-01 00:00:00" AND time < "2000-01-01 01:00:00" GROUP BY host`), "foo", nil, nil)
+	if err := results.Error(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if s := mustMarshalJSON(results); s != `[{"rows":[{"name":"result","columns":["time","written"],"values":[[0,2]]}]}]` {
+		t.Fatalf("unexpected results: %s", s)
+	}
+	if err := s.Sync(c.index); err != nil {
+		t.Fatalf("sync error: %s", err)
+	}
+
+	// Both points are written at the start of the time range.
+	results = s.ExecuteQuery(mustParseQuery(`SELECT sum FROM total WHERE time >= "2000-01-01 00:00:00" AND time < "2000-01-01 01:00:00" GROUP BY host`), "foo", nil, nil)
+	if err := results.Error(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if s := mustMarshalJSON(results); s != `[{"rows":[`+
+		`{"name":"total","tags":{"host":"servera"},"columns":["time","sum"],"values":[[946684800000000,10]]},`+
+		`{"name":"total","tags":{"host":"serverb"},"columns":["time","sum"],"values":[[946684800000000,20]]}`+
+		`]}]` {
+		t.Fatalf("unexpected results: %s", s)
+	}
+}
+
+// Ensure the server only lets admins write SELECT INTO results to another database.
+func TestServer_ExecuteQuery_SelectInto_WriteAccessDenied(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateDatabase("bar")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.CreateRetentionPolicy("bar", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.MustWriteSeries("foo", "raw", "cpu", nil, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(10)})
+	if err := s.Sync(c.index); err != nil {
+		t.Fatalf("sync error: %s", err)
+	}
+
+	q := `SELECT sum(value) INTO "bar"."raw"."cpu_total" FROM cpu WHERE time >= "2000-01-01 00:00:00" AND time < "2000-01-01 01:00:00"`
+	results := s.ExecuteQuery(mustParseQuery(q), "foo", &influxdb.User{Name: "susy"}, nil)
+	if err := results.Error(); err != influxdb.ErrWriteAccessDenied {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := s.Sync(c.index); err != nil {
+		t.Fatalf("sync error: %s", err)
+	}
+	if a := s.MeasurementNames("bar"); len(a) != 0 {
+		t.Fatalf("unexpected measurements: %v", a)
+	}
+
+	// Admins can write to any database.
+	results = s.ExecuteQuery(mustParseQuery(q), "foo", &influxdb.User{Name: "admin", Admin: true}, nil)
+	if err := results.Error(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+// Ensure the server executes a select statement once for each measurement
+// matched by a regex or listed in its source.
+func TestServer_ExecuteQuery_MultipleSources(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.MustWriteSeries("foo", "raw", "cpu.idle", nil, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(10)})
+	s.MustWriteSeries("foo", "raw", "cpu.load", nil, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(20)})
+	s.MustWriteSeries("foo", "raw", "mem", nil, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(30)})
+	if err := s.Sync(c.index); err != nil {
+		t.Fatalf("sync error: %s", err)
+	}
+
+	// Query by regex.
+	results := s.ExecuteQuery(mustParseQuery(`SELECT sum(value) FROM /^cpu/`), "foo", nil, nil)
+	if err := results.Error(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if s := mustMarshalJSON(results); s != `[{"rows":[`+
+		`{"name":"cpu.idle","columns":["time","sum"],"values":[[0,10]]},`+
+		`{"name":"cpu.load","columns":["time","sum"],"values":[[0,20]]}`+
+		`]}]` {
+		t.Fatalf("unexpected results: %s", s)
+	}
+
+	// Query by a list of measurements.
+	results = s.ExecuteQuery(mustParseQuery(`SELECT sum(value) FROM mem, cpu.idle`), "foo", nil, nil)
+	if err := results.Error(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if s := mustMarshalJSON(results); s != `[{"rows":[`+
+		`{"name":"mem","columns":["time","sum"],"values":[[0,30]]},`+
+		`{"name":"cpu.idle","columns":["time","sum"],"values":[[0,10]]}`+
+		`]}]` {
+		t.Fatalf("unexpected results: %s", s)
+	}
+
+	// A regex without matches returns no rows.
+	results = s.ExecuteQuery(mustParseQuery(`SELECT sum(value) FROM /^disk/`), "foo", nil, nil)
+	if err := results.Error(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if s := mustMarshalJSON(results); s != `[{}]` {
+		t.Fatalf("unexpected results: %s", s)
+	}
+}
 
-	// TODO: Execute a query and record all series found.
-	// q := mustParseQuery(`select myval from cpu_load`)
-	// if err := db.ExecuteQuery(q); err != nil {
-	// 	t.Fatal(err)
-	// }
+// Ensure the server can read from a retention policy and database named in the source.
+// Ensure writes to a closed bucket invalidate the server's cached results.
+func TestServer_ExecuteQuery_QueryCache(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.QueryCache = influxql.NewResultCache(100)
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.MustWriteSeries("foo", "raw", "cpu", nil, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(10)})
+	s.MustWriteSeries("foo", "raw", "cpu", nil, "2000-01-01T00:01:00Z", map[string]interface{}{"value": float64(20)})
+	if err := s.Sync(c.index); err != nil {
+		t.Fatalf("sync error: %s", err)
+	}
+	q := mustParseQuery(`SELECT sum(value) FROM cpu WHERE time >= "2000-01-01 00:00:00" AND time < "2000-01-01 00:03:00" GROUP BY time(1m)`)
+
+	// The closed buckets are cached. The last bucket in the time range is not.
+	results := s.ExecuteQuery(q, "foo", nil, nil)
+	if err := results.Error(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if v := mustMarshalJSON(results[0].Rows[0].Values); v != `[[946684800000000,10],[946684860000000,20],[946684920000000,0]]` {
+		t.Fatalf("unexpected values: %s", v)
+	} else if stats := s.QueryCache.Stats(); stats.N != 2 {
+		t.Fatalf("unexpected cached bucket count: %d", stats.N)
+	}
+
+	// Writing to a cached bucket removes it.
+	s.MustWriteSeries("foo", "raw", "cpu", nil, "2000-01-01T00:00:30Z", map[string]interface{}{"value": float64(5)})
+	if err := s.Sync(c.index); err != nil {
+		t.Fatalf("sync error: %s", err)
+	} else if stats := s.QueryCache.Stats(); stats.N != 1 {
+		t.Fatalf("unexpected cached bucket count: %d", stats.N)
+	}
+
+	results = s.ExecuteQuery(q, "foo", nil, nil)
+	if err := results.Error(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if v := mustMarshalJSON(results[0].Rows[0].Values); v != `[[946684800000000,15],[946684860000000,20],[946684920000000,0]]` {
+		t.Fatalf("unexpected values: %s", v)
+	}
+}
+
+func TestServer_ExecuteQuery_QualifiedSource(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateDatabase("bar")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "rp_1y", Duration: 365 * 24 * time.Hour})
+	s.CreateRetentionPolicy("bar", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.SetDefaultRetentionPolicy("bar", "raw")
+	s.MustWriteSeries("foo", "raw", "cpu", nil, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(10)})
+	s.MustWriteSeries("foo", "rp_1y", "cpu", nil, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(20)})
+	s.MustWriteSeries("bar", "raw", "cpu", nil, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(30)})
+	if err := s.Sync(c.index); err != nil {
+		t.Fatalf("sync error: %s", err)
+	}
+
+	for i, tt := range []struct {
+		q   string
+		exp string
+	}{
+		{q: `SELECT sum(value) FROM cpu`, exp: `[[0,10]]`},
+		{q: `SELECT sum(value) FROM "rp_1y".cpu`, exp: `[[0,20]]`},
+		{q: `SELECT sum(value) FROM "foo"."rp_1y"."cpu"`, exp: `[[0,20]]`},
+		{q: `SELECT sum(value) FROM "bar"."".cpu`, exp: `[[0,30]]`},
+	} {
+		results := s.ExecuteQuery(mustParseQuery(tt.q), "foo", nil, nil)
+		if err := results.Error(); err != nil {
+			t.Errorf("%d. %s: unexpected error: %s", i, tt.q, err)
+		} else if s := mustMarshalJSON(results[0].Rows[0].Values); s != tt.exp {
+			t.Errorf("%d. %s: unexpected values: %s", i, tt.q, s)
+		}
+	}
+
+	// Unknown retention policies return an error.
+	results := s.ExecuteQuery(mustParseQuery(`SELECT sum(value) FROM "no_such_policy".cpu`), "foo", nil, nil)
+	if err := results.Error(); err != influxdb.ErrRetentionPolicyNotFound {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Only admins can read from another database.
+	results = s.ExecuteQuery(mustParseQuery(`SELECT sum(value) FROM "bar"."raw".cpu`), "foo", &influxdb.User{Name: "susy"}, nil)
+	if err := results.Error(); err != influxdb.ErrReadAccessDenied {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	results = s.ExecuteQuery(mustParseQuery(`SELECT sum(value) FROM "bar"."raw".cpu`), "foo", &influxdb.User{Name: "admin", Admin: true}, nil)
+	if err := results.Error(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+// Ensure the server returns an error when writing a field with a different type.
+func TestServer_WriteSeries_ErrFieldTypeConflict(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "myspace", Duration: 1 * time.Hour})
+	timestamp := mustParseTime("2000-01-01T00:00:00Z")
+
+	// Create the field with a number.
+	if err := s.WriteSeries("foo", "myspace", "cpu_load", nil, timestamp, map[string]interface{}{"value": 23.2}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Write a string to the same field.
+	if err := s.WriteSeries("foo", "myspace", "cpu_load", nil, timestamp, map[string]interface{}{"value": "high"}); err != influxdb.ErrFieldTypeConflict {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// Ensure the field type is persisted across a restart.
+	s.Restart()
+	if err := s.WriteSeries("foo", "myspace", "cpu_load", nil, timestamp, map[string]interface{}{"value": "high"}); err != influxdb.ErrFieldTypeConflict {
+		t.Fatalf("unexpected error after restart: %s", err)
+	}
+}
+
+// Ensure the server rejects new series beyond the series and tag value limits.
+func TestServer_WriteSeries_SeriesLimits(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.MaxSeriesPerDatabase = 3
+	s.MaxValuesPerTag = 2
+	timestamp := mustParseTime("2000-01-01T00:00:00Z")
+	values := map[string]interface{}{"value": float64(1)}
+
+	s.MustWriteSeries("foo", "raw", "cpu", map[string]string{"host": "servera", "region": "uswest"}, "2000-01-01T00:00:00Z", values)
+	s.MustWriteSeries("foo", "raw", "cpu", map[string]string{"host": "serverb", "region": "uswest"}, "2000-01-01T00:00:00Z", values)
+
+	// Verify the limits are checked before and after a restart.
+	for i := 0; i < 2; i++ {
+		if err := s.WriteSeries("foo", "raw", "cpu", map[string]string{"host": "serverc", "region": "uswest"}, timestamp, values); err != influxdb.ErrMaxValuesPerTagExceeded {
+			t.Fatalf("%d. unexpected error: %s", i, err)
+		}
+
+		// Existing series and tag values can still be written.
+		if err := s.WriteSeries("foo", "raw", "cpu", map[string]string{"host": "servera", "region": "uswest"}, timestamp, values); err != nil {
+			t.Fatalf("%d. unexpected error: %s", i, err)
+		} else if err := s.Sync(c.index); err != nil {
+			t.Fatalf("%d. sync error: %s", i, err)
+		}
+		s.Restart()
+	}
+
+	// Verify a series with existing tag values is limited by the series count.
+	s.MustWriteSeries("foo", "raw", "cpu", map[string]string{"host": "servera", "region": "useast"}, "2000-01-01T00:00:00Z", values)
+	if err := s.WriteSeries("foo", "raw", "cpu", map[string]string{"host": "serverb", "region": "useast"}, timestamp, values); err != influxdb.ErrMaxSeriesPerDatabaseExceeded {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+// Ensure the server can count the series and tag values of measurements.
+func TestServer_ExecuteQuery_ShowCardinality(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	values := map[string]interface{}{"value": float64(1)}
+	s.MustWriteSeries("foo", "raw", "cpu", map[string]string{"host": "servera", "region": "uswest"}, "2000-01-01T00:00:00Z", values)
+	s.MustWriteSeries("foo", "raw", "cpu", map[string]string{"host": "serverb", "region": "uswest"}, "2000-01-01T00:00:00Z", values)
+	s.MustWriteSeries("foo", "raw", "cpu", map[string]string{"host": "serverc", "region": "useast"}, "2000-01-01T00:00:00Z", values)
+	s.MustWriteSeries("foo", "raw", "mem", map[string]string{"host": "servera"}, "2000-01-01T00:00:00Z", values)
+	if err := s.Sync(c.index); err != nil {
+		t.Fatalf("sync error: %s", err)
+	}
+
+	for i, tt := range []struct {
+		stmt string
+		res  string
+	}{
+		{
+			stmt: `SHOW SERIES CARDINALITY`,
+			res:  `{"rows":[{"name":"cardinality","columns":["measurement","count"],"values":[["cpu",3],["mem",1]]}]}`,
+		},
+		{
+			stmt: `SHOW SERIES CARDINALITY FROM /^m/`,
+			res:  `{"rows":[{"name":"cardinality","columns":["measurement","count"],"values":[["mem",1]]}]}`,
+		},
+		{
+			stmt: `SHOW TAG VALUES CARDINALITY`,
+			res:  `{"rows":[{"name":"cardinality","columns":["measurement","key","count"],"values":[["cpu","host",3],["cpu","region",2],["mem","host",1]]}]}`,
+		},
+		{
+			stmt: `SHOW TAG VALUES CARDINALITY FROM cpu WITH KEY = region`,
+			res:  `{"rows":[{"name":"cardinality","columns":["measurement","key","count"],"values":[["cpu","region",2]]}]}`,
+		},
+	} {
+		results := s.ExecuteQuery(mustParseQuery(tt.stmt), "foo", nil, nil)
+		if res := results[0]; res.Err != nil {
+			t.Fatalf("%d. unexpected error: %s", i, res.Err)
+		} else if s := mustMarshalJSON(res); s != tt.res {
+			t.Fatalf("%d. unexpected result: %s", i, s)
+		}
+	}
+}
+
+// Ensure the server can set the metadata of measurements and fields.
+func TestServer_ExecuteQuery_AlterMeasurement(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.MustWriteSeries("foo", "raw", "cpu", map[string]string{"host": "servera"}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(1), "idle": float64(2)})
+	s.MustWriteSeries("foo", "raw", "mem", map[string]string{"host": "servera"}, "2000-01-01T00:00:00Z", map[string]interface{}{"used": float64(1)})
+
+	for i, stmt := range []string{
+		`ALTER MEASUREMENT cpu SET unit = 'percent', description = 'CPU usage', owner = 'ops'`,
+		`ALTER MEASUREMENT cpu FIELD value SET unit = 'percent', description = 'busy time'`,
+		`ALTER MEASUREMENT mem SET unit = 'bytes'`,
+	} {
+		if res := s.ExecuteQuery(mustParseQuery(stmt), "foo", nil, nil)[0]; res.Err != nil {
+			t.Fatalf("%d. unexpected error: %s", i, res.Err)
+		}
+	}
+
+	// Unknown measurements and fields return an error.
+	if res := s.ExecuteQuery(mustParseQuery(`ALTER MEASUREMENT disk SET unit = 'bytes'`), "foo", nil, nil)[0]; res.Err != influxdb.ErrMeasurementNotFound {
+		t.Fatalf("unexpected error: %v", res.Err)
+	}
+	if res := s.ExecuteQuery(mustParseQuery(`ALTER MEASUREMENT cpu FIELD nice SET unit = 'percent'`), "foo", nil, nil)[0]; res.Err != influxdb.ErrFieldNotFound {
+		t.Fatalf("unexpected error: %v", res.Err)
+	}
+
+	// Verify the metadata is returned before and after a restart.
+	for _, restart := range []bool{false, true} {
+		if restart {
+			if err := s.Sync(c.index); err != nil {
+				t.Fatalf("sync error: %s", err)
+			}
+			s.Restart()
+		}
+
+		for i, tt := range []struct {
+			stmt string
+			res  string
+		}{
+			{
+				stmt: `LIST MEASUREMENTS`,
+				res:  `{"rows":[{"name":"measurements","columns":["name","unit","description","owner"],"values":[["cpu","percent","CPU usage","ops"],["mem","bytes","",""]]}]}`,
+			},
+			{
+				stmt: `LIST FIELD KEYS FROM cpu`,
+				res:  `{"rows":[{"name":"cpu","columns":["fieldKey","unit","description","owner"],"values":[["idle","","",""],["value","percent","busy time",""]]}]}`,
+			},
+		} {
+			results := s.ExecuteQuery(mustParseQuery(tt.stmt), "foo", nil, nil)
+			if res := results[0]; res.Err != nil {
+				t.Fatalf("%d. unexpected error: %s", i, res.Err)
+			} else if s := mustMarshalJSON(res); s != tt.res {
+				t.Fatalf("%d. unexpected result (restart=%v): %s", i, restart, s)
+			}
+		}
+	}
+}
+
+// Ensure the server can explain a select statement without executing it.
+func TestServer_ExecuteQuery_Explain(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+
+	// Write points far enough apart to be stored in separate shards.
+	s.MustWriteSeries("foo", "raw", "cpu", map[string]string{"host": "servera"}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(10)})
+	s.MustWriteSeries("foo", "raw", "cpu", map[string]string{"host": "serverb"}, "2000-01-01T05:00:00Z", map[string]interface{}{"value": float64(20)})
+	if err := s.Sync(c.index); err != nil {
+		t.Fatalf("sync error: %s", err)
+	}
+
+	// Explain a query that only covers the first point.
+	results := s.ExecuteQuery(mustParseQuery(`
+		EXPLAIN SELECT sum(value)
+		FROM cpu
+		WHERE time >= "2000-01-01 00:00:00" AND time < "2000-01-01 01:00:00"`), "foo", nil, nil)
+	if err := results.Error(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// Verify the plan reads both series but only touches one shard.
+	rows := results[0].Rows
+	if len(rows) != 3 {
+		t.Fatalf("unexpected row count: %d", len(rows))
+	} else if s := mustMarshalJSON(rows[1].Values); s != `[["sum","reducer","cpu","mapSum","reduceSum",2]]` {
+		t.Fatalf("unexpected processors: %s", s)
+	} else if rows[2].Name != "shards" || len(rows[2].Values) != 1 {
+		t.Fatalf("unexpected shards: %s", mustMarshalJSON(rows[2]))
+	} else if startTime := rows[2].Values[0][1].(time.Time); !startTime.Equal(mustParseTime("2000-01-01T00:00:00Z")) {
+		t.Fatalf("unexpected shard start time: %s", startTime)
+	}
+}
+
+// Ensure series with the same shard key values are stored in the same split
+// and that queries filtering on the shard key only read that split.
+func TestServer_ExecuteQuery_ShardKey(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour, SplitN: 4, ShardKey: []string{"customer"}})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	for _, customer := range []string{"a", "b", "c", "d", "e", "f"} {
+		for _, host := range []string{"servera", "serverb", "serverc"} {
+			s.MustWriteSeries("foo", "raw", "cpu", map[string]string{"customer": customer, "host": host}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(1)})
+		}
+	}
+	if err := s.Sync(c.index); err != nil {
+		t.Fatalf("sync error: %s", err)
+	}
+	s.Restart()
+
+	// Verify the policy and its splits.
+	if rp, _ := s.RetentionPolicy("foo", "raw"); !reflect.DeepEqual(rp.ShardKey, []string{"customer"}) {
+		t.Fatalf("unexpected shard key: %v", rp.ShardKey)
+	} else if len(rp.Shards) != 4 {
+		t.Fatalf("unexpected shard count: %d", len(rp.Shards))
+	}
+
+	for i, tt := range []struct {
+		cond   string
+		sum    string
+		shards int
+	}{
+		{cond: `customer = 'a'`, sum: `3`, shards: 1},
+		{cond: `customer = 'b' AND host = 'serverb'`, sum: `1`, shards: 1},
+		{cond: `host = 'servera'`, sum: `6`, shards: 4},
+	} {
+		stmt := `SELECT sum(value) FROM cpu WHERE time >= "2000-01-01 00:00:00" AND time < "2000-01-01 01:00:00" AND ` + tt.cond
+
+		// Verify that no values are lost by reading a single split.
+		results := s.ExecuteQuery(mustParseQuery(stmt), "foo", nil, nil)
+		if err := results.Error(); err != nil {
+			t.Fatalf("%d. unexpected error: %s", i, err)
+		} else if s := mustMarshalJSON(results[0].Rows[0].Values[0][1]); s != tt.sum {
+			t.Fatalf("%d. unexpected sum: %s", i, s)
+		}
+
+		// Verify the splits the plan reads.
+		results = s.ExecuteQuery(mustParseQuery(`EXPLAIN `+stmt), "foo", nil, nil)
+		if err := results.Error(); err != nil {
+			t.Fatalf("%d. unexpected error: %s", i, err)
+		} else if rows := results[0].Rows; len(rows[2].Values) != tt.shards {
+			t.Fatalf("%d. unexpected shards: %s", i, mustMarshalJSON(rows[2]))
+		}
+	}
+}
+
+// Ensure the server lists running queries.
+func TestServer_ExecuteQuery_ShowQueries(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+
+	// The query should list itself.
+	results := s.ExecuteQuery(mustParseQuery(`SHOW QUERIES`), "foo", &influxdb.User{Name: "susy"}, nil)
+	if err := results.Error(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if values := results[0].Rows[0].Values; len(values) != 1 {
+		t.Fatalf("unexpected query count: %d", len(values))
+	} else if s := mustMarshalJSON(values[0][:4]); s != `[1,"susy","foo","SHOW QUERIES"]` {
+		t.Fatalf("unexpected query: %s", s)
+	}
+}
+
+// Ensure the server can kill a running query.
+func TestServer_ExecuteQuery_KillQuery(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+
+	// Killing a non-existent query should return an error.
+	results := s.ExecuteQuery(mustParseQuery(`KILL QUERY 100`), "foo", nil, nil)
+	if err := results.Error(); err != influxdb.ErrQueryNotFound {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// Killing the current query should stop the remaining statements.
+	results = s.ExecuteQuery(mustParseQuery(`KILL QUERY 2; SHOW QUERIES; SHOW QUERIES`), "foo", nil, nil)
+	if results[0].Err != nil {
+		t.Fatalf("unexpected error: %s", results[0].Err)
+	} else if results[1].Err != influxdb.ErrQueryKilled {
+		t.Fatalf("unexpected error: %s", results[1].Err)
+	} else if results[2].Err != influxdb.ErrNotExecuted {
+		t.Fatalf("unexpected error: %s", results[2].Err)
+	}
+}
+
+// Ensure the server kills queries that run longer than the query timeout.
+func TestServer_ExecuteQuery_Timeout(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.MustWriteSeries("foo", "raw", "cpu", map[string]string{}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(10)})
+	if err := s.Sync(c.index); err != nil {
+		t.Fatalf("sync error: %s", err)
+	}
+	s.QueryTimeout = 1 * time.Nanosecond
+
+	results := s.ExecuteQuery(mustParseQuery(strings.Repeat(`SELECT sum(value) FROM cpu;`, 100)+`SHOW QUERIES`), "foo", nil, nil)
+	if err := results.Error(); err != influxdb.ErrQueryTimeout {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+// Ensure the server kills a query when the client closes.
+func TestServer_ExecuteQuery_Closing(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.MustWriteSeries("foo", "raw", "cpu", map[string]string{}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(10)})
+	if err := s.Sync(c.index); err != nil {
+		t.Fatalf("sync error: %s", err)
+	}
+
+	closing := make(chan struct{})
+	close(closing)
+	results := s.ExecuteQuery(mustParseQuery(strings.Repeat(`SELECT sum(value) FROM cpu;`, 100)+`SHOW QUERIES`), "foo", nil, closing)
+	if err := results.Error(); err != influxdb.ErrQueryKilled {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+// Ensure the server returns an error when a select statement exceeds a query limit.
+func TestServer_ExecuteQuery_MaxPoints(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.MustWriteSeries("foo", "raw", "cpu", map[string]string{}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(10)})
+	s.MustWriteSeries("foo", "raw", "cpu", map[string]string{}, "2000-01-01T00:00:10Z", map[string]interface{}{"value": float64(20)})
+	s.MustWriteSeries("foo", "raw", "cpu", map[string]string{}, "2000-01-01T00:00:20Z", map[string]interface{}{"value": float64(30)})
+	if err := s.Sync(c.index); err != nil {
+		t.Fatalf("sync error: %s", err)
+	}
+
+	// Verify the query succeeds within the limit.
+	s.MaxPointsPerQuery = 3
+	results := s.ExecuteQuery(mustParseQuery(`SELECT sum(value) FROM cpu`), "foo", nil, nil)
+	if res := results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"rows":[{"name":"cpu","columns":["time","sum"],"values":[[0,60]]}]}` {
+		t.Fatalf("unexpected row(0): %s", s)
+	}
+
+	// Verify the query fails once the limit is exceeded.
+	s.MaxPointsPerQuery = 2
+	results = s.ExecuteQuery(mustParseQuery(`SELECT sum(value) FROM cpu`), "foo", nil, nil)
+	if err := results.Error(); err == nil || err.Error() != "max points exceeded: limit is 2" {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+// Ensure the server does not apply query limits when explaining a select statement.
+func TestServer_ExecuteQuery_Explain_Limits(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.MustWriteSeries("foo", "raw", "cpu", map[string]string{"host": "servera"}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(10)})
+	s.MustWriteSeries("foo", "raw", "cpu", map[string]string{"host": "serverb"}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(20)})
+	if err := s.Sync(c.index); err != nil {
+		t.Fatalf("sync error: %s", err)
+	}
+	s.MaxSeriesPerQuery = 1
+	s.MaxBucketsPerQuery = 1
+
+	// Verify the statement exceeds the limits when executed.
+	stmt := `SELECT sum(value) FROM cpu WHERE time >= "2000-01-01 00:00:00" AND time < "2000-01-01 01:00:00" GROUP BY time(10m)`
+	if err := s.ExecuteQuery(mustParseQuery(stmt), "foo", nil, nil).Error(); err == nil {
+		t.Fatal("expected error")
+	}
+
+	// Verify the statement can still be explained.
+	results := s.ExecuteQuery(mustParseQuery(`EXPLAIN `+stmt), "foo", nil, nil)
+	if err := results.Error(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if s := mustMarshalJSON(results[0].Rows[1].Values); s != `[["sum","reducer","cpu","mapSum","reduceSum",2]]` {
+		t.Fatalf("unexpected processors: %s", s)
+	}
+}
+
+// Ensure the server runs mappers on the data nodes that own a shard and
+// fails over to another replica when a data node is unavailable.
+func TestServer_ExecuteQuery_RemoteShards(t *testing.T) {
+	// Create two servers with the same metadata but different data.
+	c0, c1 := NewMessagingClient(), NewMessagingClient()
+	s0, s1 := OpenServer(c0), OpenServer(c1)
+	defer s0.Close()
+	defer s1.Close()
+	for i, s := range []*Server{s0, s1} {
+		s.CreateDatabase("foo")
+		s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+		s.SetDefaultRetentionPolicy("foo", "raw")
+		s.MustWriteSeries("foo", "raw", "cpu", nil, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(1 * (1 + 9*i))})
+		s.MustWriteSeries("foo", "raw", "cpu", nil, "2000-01-01T01:30:00Z", map[string]interface{}{"value": float64(2 * (1 + 9*i))})
+	}
+	if err := s1.Sync(c1.index); err != nil {
+		t.Fatalf("sync error: %s", err)
+	}
+
+	// Register the second server and an unavailable server as data nodes.
+	h1 := NewHTTPServer(s1)
+	defer h1.Close()
+	h2 := NewHTTPServer(NewServer())
+	h2.Close()
+	s0.CreateDataNode(MustParseURL(h2.URL))
+	s0.CreateDataNode(MustParseURL(h1.URL))
+	if err := s0.Sync(c0.index); err != nil {
+		t.Fatalf("sync error: %s", err)
+	}
+	n1, n2 := s0.DataNodeByURL(MustParseURL(h1.URL)), s0.DataNodeByURL(MustParseURL(h2.URL))
+
+	// Move the first shard to the unavailable node with a replica on the second server.
+	rp, _ := s0.RetentionPolicy("foo", "raw")
+	rp.Shards[0].DataNodeIDs = []uint64{n2.ID, n1.ID}
+	s0.ConcurrentShardQueryLimit = 1
+
+	// Verify the remote shard is read from the replica and the local shard is read locally.
+	results := s0.ExecuteQuery(mustParseQuery(`SELECT value FROM cpu WHERE time >= "2000-01-01 00:00:00" AND time < "2000-01-01 02:00:00"`), "foo", nil, nil)
+	if err := results.Error(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if s := mustMarshalJSON(results); s != `[{"rows":[{"name":"cpu","columns":["time","value"],"values":[[946684800000000,10],[946690200000000,2]]}]}]` {
+		t.Fatalf("unexpected results: %s", s)
+	}
+
+	// Verify partial results from both shards are reduced together.
+	results = s0.ExecuteQuery(mustParseQuery(`SELECT sum(value) FROM cpu WHERE time >= "2000-01-01 00:00:00" AND time < "2000-01-01 02:00:00"`), "foo", nil, nil)
+	if err := results.Error(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if s := mustMarshalJSON(results); s != `[{"rows":[{"name":"cpu","columns":["time","sum"],"values":[[946684800000000,12]]}]}]` {
+		t.Fatalf("unexpected results: %s", s)
+	}
+
+	// Verify an error is returned once no replica is available.
+	rp.Shards[0].DataNodeIDs = []uint64{n2.ID}
+	results = s0.ExecuteQuery(mustParseQuery(`SELECT sum(value) FROM cpu WHERE time >= "2000-01-01 00:00:00" AND time < "2000-01-01 02:00:00"`), "foo", nil, nil)
+	if err := results.Error(); err == nil || !strings.HasPrefix(err.Error(), "remote mapper: shard") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure the server reads every series on a remote shard with a single request
+// which is signed for the user running the query.
+func TestServer_ExecuteQuery_RemoteShards_Authenticated(t *testing.T) {
+	c0, c1 := NewMessagingClient(), NewMessagingClient()
+	s0, s1 := OpenServer(c0), OpenServer(c1)
+	defer s0.Close()
+	defer s1.Close()
+
+	// Replicate the user to the second server with the same password hash.
+	s0.CreateUser("susy", "pass", false)
+	if err := s1.ImportMetadata(&influxdb.Metadata{Version: influxdb.MetadataVersion, Users: []*influxdb.User{s0.User("susy")}}); err != nil {
+		t.Fatalf("import error: %s", err)
+	}
+
+	// Create the same series on both servers. Only the second server's values
+	// are read once the shard is moved to it.
+	for _, s := range []*Server{s0, s1} {
+		s.CreateDatabase("foo")
+		s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+		s.SetDefaultRetentionPolicy("foo", "raw")
+	}
+	s1.MustWriteSeries("foo", "raw", "cpu", map[string]string{"host": "servera"}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(10)})
+	s1.MustWriteSeries("foo", "raw", "cpu", map[string]string{"host": "serverb"}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(20)})
+	s0.MustWriteSeries("foo", "raw", "cpu", map[string]string{"host": "servera"}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(0)})
+	s0.MustWriteSeries("foo", "raw", "cpu", map[string]string{"host": "serverb"}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(0)})
+	if err := s1.Sync(c1.index); err != nil {
+		t.Fatalf("sync error: %s", err)
+	}
+
+	// Serve the second server with authentication and count the requests.
+	var n int32
+	h := influxdb.NewHandler(s1.Server)
+	h.AuthenticationEnabled = true
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&n, 1)
+		h.ServeHTTP(w, r)
+	}))
+	defer hs.Close()
+	s0.CreateDataNode(MustParseURL(hs.URL))
+	if err := s0.Sync(c0.index); err != nil {
+		t.Fatalf("sync error: %s", err)
+	}
+
+	// Move the shard to the second server.
+	rp, _ := s0.RetentionPolicy("foo", "raw")
+	rp.Shards[0].DataNodeIDs = []uint64{s0.DataNodeByURL(MustParseURL(hs.URL)).ID}
+
+	// Verify both series are read from the second server with one request.
+	q := mustParseQuery(`SELECT sum(value) FROM cpu WHERE time >= "2000-01-01 00:00:00" AND time < "2000-01-01 01:00:00" GROUP BY host`)
+	results := s0.ExecuteQuery(q, "foo", s0.User("susy"), nil)
+	if err := results.Error(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if s := mustMarshalJSON(results); s != `[{"rows":[`+
+		`{"name":"cpu","tags":{"host":"servera"},"columns":["time","sum"],"values":[[946684800000000,10]]},`+
+		`{"name":"cpu","tags":{"host":"serverb"},"columns":["time","sum"],"values":[[946684800000000,20]]}`+
+		`]}]` {
+		t.Fatalf("unexpected results: %s", s)
+	} else if n := atomic.LoadInt32(&n); n != 1 {
+		t.Fatalf("unexpected request count: %d", n)
+	}
+
+	// Verify requests without a user are rejected.
+	results = s0.ExecuteQuery(q, "foo", nil, nil)
+	if err := results.Error(); err == nil || !strings.HasPrefix(err.Error(), "remote mapper: shard") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure the server cancels requests to remote shards when a query is killed.
+func TestServer_ExecuteQuery_RemoteShards_Killed(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.MustWriteSeries("foo", "raw", "cpu", nil, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(10)})
+
+	// Serve a data node which never responds until the request is canceled.
+	started, canceled := make(chan struct{}), make(chan struct{})
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = ioutil.ReadAll(r.Body)
+		close(started)
+		<-w.(http.CloseNotifier).CloseNotify()
+		close(canceled)
+	}))
+	defer hs.Close()
+	s.CreateDataNode(MustParseURL(hs.URL))
+	if err := s.Sync(c.index); err != nil {
+		t.Fatalf("sync error: %s", err)
+	}
+	rp, _ := s.RetentionPolicy("foo", "raw")
+	rp.Shards[0].DataNodeIDs = []uint64{s.DataNodeByURL(MustParseURL(hs.URL)).ID}
+
+	// Kill the query once the request is received.
+	closing := make(chan struct{})
+	go func() {
+		<-started
+		close(closing)
+	}()
+	results := s.ExecuteQuery(mustParseQuery(`SELECT sum(value) FROM cpu WHERE time >= "2000-01-01 00:00:00" AND time < "2000-01-01 01:00:00"`), "foo", nil, closing)
+	if err := results.Error(); err == nil {
+		t.Fatal("expected error")
+	}
+
+	select {
+	case <-canceled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("request not canceled")
+	}
+}
+
+// Ensure the server can stream chunked query results.
+func TestServer_StreamQuery(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.MustWriteSeries("foo", "raw", "cpu", map[string]string{}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(10)})
+	s.MustWriteSeries("foo", "raw", "cpu", map[string]string{}, "2000-01-01T00:00:10Z", map[string]interface{}{"value": float64(20)})
+	s.MustWriteSeries("foo", "raw", "cpu", map[string]string{}, "2000-01-01T00:00:20Z", map[string]interface{}{"value": float64(30)})
+	if err := s.Sync(c.index); err != nil {
+		t.Fatalf("sync error: %s", err)
+	}
+
+	// Read all chunks.
+	var a []string
+	for res := range s.StreamQuery(mustParseQuery(`SELECT value FROM cpu; SELECT sum(value) FROM cpu`), "foo", nil, 2, nil) {
+		a = append(a, fmt.Sprintf("%d:%s", res.StatementID, mustMarshalJSON(res)))
+	}
+
+	// Verify the first statement is split into chunks.
+	if !reflect.DeepEqual(a, []string{
+		`0:{"rows":[{"name":"cpu","columns":["time","value"],"values":[[946684800000000,10],[946684810000000,20]]}]}`,
+		`0:{"rows":[{"name":"cpu","columns":["time","value"],"values":[[946684820000000,30]]}]}`,
+		`1:{"rows":[{"name":"cpu","columns":["time","sum"],"values":[[0,60]]}]}`,
+	}) {
+		t.Fatalf("unexpected results: %s", strings.Join(a, "\n"))
+	}
 }
 
 func TestServer_CreateShardIfNotExist(t *testing.T) {
@@ -535,6 +1495,81 @@ func TestServer_CreateShardIfNotExist(t *testing.T) {
 	}
 }
 
+// Ensure shards are aligned to the shard group duration of their policy.
+func TestServer_CreateShardIfNotExist_ShardGroupDuration(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+
+	for i, tt := range []struct {
+		policy *influxdb.RetentionPolicy
+		start  string
+		end    string
+	}{
+		{policy: &influxdb.RetentionPolicy{Duration: 30 * time.Minute}, start: "2000-01-01T05:30:00Z", end: "2000-01-01T06:00:00Z"},
+		{policy: &influxdb.RetentionPolicy{Duration: 24 * time.Hour}, start: "2000-01-01T05:00:00Z", end: "2000-01-01T06:00:00Z"},
+		{policy: &influxdb.RetentionPolicy{Duration: 30 * 24 * time.Hour}, start: "2000-01-01T00:00:00Z", end: "2000-01-02T00:00:00Z"},
+		{policy: &influxdb.RetentionPolicy{}, start: "1999-12-27T00:00:00Z", end: "2000-01-03T00:00:00Z"},
+		{policy: &influxdb.RetentionPolicy{Duration: 24 * time.Hour, ShardGroupDuration: 2 * time.Hour}, start: "2000-01-01T04:00:00Z", end: "2000-01-01T06:00:00Z"},
+	} {
+		tt.policy.Name = fmt.Sprintf("rp%d", i)
+		if err := s.CreateRetentionPolicy("foo", tt.policy); err != nil {
+			t.Fatalf("%d. unexpected error: %s", i, err)
+		} else if err := s.CreateShardsIfNotExists("foo", tt.policy.Name, mustParseTime("2000-01-01T05:45:00Z")); err != nil {
+			t.Fatalf("%d. unexpected error: %s", i, err)
+		}
+
+		rp, _ := s.RetentionPolicy("foo", tt.policy.Name)
+		if sh := rp.Shards[0]; !sh.StartTime.Equal(mustParseTime(tt.start)) || !sh.EndTime.Equal(mustParseTime(tt.end)) {
+			t.Fatalf("%d. unexpected shard time range: %s - %s", i, sh.StartTime, sh.EndTime)
+		}
+	}
+}
+
+// Ensure a new shard group duration only applies to new shards and that new
+// shards don't overlap existing shards.
+func TestServer_UpdateRetentionPolicy_ShardGroupDuration(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar", Duration: 30 * 24 * time.Hour})
+	s.CreateShardsIfNotExists("foo", "bar", mustParseTime("2000-01-01T05:00:00Z"))
+
+	// Switch to weekly shards and create a shard for the next day.
+	if err := s.UpdateRetentionPolicy("foo", "bar", &influxdb.RetentionPolicy{ShardGroupDuration: 7 * 24 * time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+	s.CreateShardsIfNotExists("foo", "bar", mustParseTime("2000-01-02T05:00:00Z"))
+	s.Restart()
+
+	// Verify the new shard starts where the existing shard ends.
+	rp, _ := s.RetentionPolicy("foo", "bar")
+	if rp.ShardGroupDuration != 7*24*time.Hour {
+		t.Fatalf("unexpected shard group duration: %s", rp.ShardGroupDuration)
+	} else if len(rp.Shards) != 2 {
+		t.Fatalf("unexpected shard count: %d", len(rp.Shards))
+	} else if sh := rp.Shards[0]; !sh.EndTime.Equal(mustParseTime("2000-01-02T00:00:00Z")) {
+		t.Fatalf("unexpected end time: %s", sh.EndTime)
+	} else if sh := rp.Shards[1]; !sh.StartTime.Equal(mustParseTime("2000-01-02T00:00:00Z")) || !sh.EndTime.Equal(mustParseTime("2000-01-03T00:00:00Z")) {
+		t.Fatalf("unexpected shard time range: %s - %s", sh.StartTime, sh.EndTime)
+	}
+}
+
+// Ensure the server returns an error for a shard group duration longer than the policy.
+func TestServer_RetentionPolicy_ErrInvalidShardGroupDuration(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	if err := s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar", Duration: time.Hour, ShardGroupDuration: 2 * time.Hour}); err != influxdb.ErrInvalidShardGroupDuration {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar", Duration: time.Hour})
+	if err := s.UpdateRetentionPolicy("foo", "bar", &influxdb.RetentionPolicy{ShardGroupDuration: 2 * time.Hour}); err != influxdb.ErrInvalidShardGroupDuration {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
 func TestServer_Measurements(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
 	defer s.Close()
@@ -575,6 +1610,166 @@ func TestServer_Measurements(t *testing.T) {
 	}
 }
 
+// Ensure series are read from the tag index after a restart.
+func TestServer_Restart_TagIndex(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.MustWriteSeries("foo", "raw", "cpu", map[string]string{"host": "servera"}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(1)})
+	s.MustWriteSeries("foo", "raw", "cpu", map[string]string{"host": "serverb"}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(2)})
+	if err := s.Sync(c.index); err != nil {
+		t.Fatalf("sync error: %s", err)
+	}
+	s.Restart()
+
+	// Writing to existing series must not create new series.
+	s.MustWriteSeries("foo", "raw", "cpu", map[string]string{"host": "serverb"}, "2000-01-01T00:00:10Z", map[string]interface{}{"value": float64(3)})
+	if err := s.Sync(c.index); err != nil {
+		t.Fatalf("sync error: %s", err)
+	}
+	if ids := s.MeasurementSeriesIDs("foo", "cpu"); !ids.Equals(influxdb.SeriesIDs{1, 2}) {
+		t.Fatalf("unexpected series ids: %v", ids)
+	}
+
+	// Verify series are filtered by tag.
+	results := s.ExecuteQuery(mustParseQuery(`SELECT sum(value) FROM cpu WHERE host = 'serverb' AND time >= "2000-01-01 00:00:00" AND time < "2000-01-01 01:00:00"`), "foo", nil, nil)
+	if err := results.Error(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if s := mustMarshalJSON(results); s != `[{"rows":[{"name":"cpu","columns":["time","sum"],"values":[[946684800000000,5]]}]}]` {
+		t.Fatalf("unexpected results: %s", s)
+	}
+}
+
+// Ensure the server removes idle series which have no points left.
+func TestServer_SweepIdleSeries(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "tmp", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.MustWriteSeries("foo", "raw", "cpu", map[string]string{"host": "servera"}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(1)})
+	s.MustWriteSeries("foo", "tmp", "cpu", map[string]string{"host": "serverb"}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(2)})
+	s.MustWriteSeries("foo", "tmp", "cpu", map[string]string{"host": "serverc"}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(3)})
+	if err := s.Sync(c.index); err != nil {
+		t.Fatalf("sync error: %s", err)
+	}
+
+	// Drop the points of the last two series and read the tag value count.
+	if err := s.DeleteRetentionPolicy("foo", "tmp"); err != nil {
+		t.Fatal(err)
+	} else if err := s.ExecuteQuery(mustParseQuery(`SHOW TAG VALUES CARDINALITY`), "foo", nil, nil).Error(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Verify series are not removed before the idle timeout.
+	s.SeriesIdleTimeout = 1 * time.Hour
+	if err := s.SweepIdleSeries(); err != nil {
+		t.Fatal(err)
+	} else if ids := s.MeasurementSeriesIDs("foo", "cpu"); !ids.Equals(influxdb.SeriesIDs{1, 2, 3}) {
+		t.Fatalf("unexpected series ids: %v", ids)
+	}
+
+	// Verify the series without points are removed after the idle timeout.
+	s.SeriesIdleTimeout = 1 * time.Nanosecond
+	time.Sleep(1 * time.Millisecond)
+	if err := s.SweepIdleSeries(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if ids := s.MeasurementSeriesIDs("foo", "cpu"); !ids.Equals(influxdb.SeriesIDs{1}) {
+			t.Fatalf("%d. unexpected series ids: %v", i, ids)
+		}
+		results := s.ExecuteQuery(mustParseQuery(`SHOW TAG VALUES CARDINALITY`), "foo", nil, nil)
+		if err := results.Error(); err != nil {
+			t.Fatalf("%d. unexpected error: %s", i, err)
+		} else if s := mustMarshalJSON(results[0].Rows[0].Values); s != `[["cpu","host",1]]` {
+			t.Fatalf("%d. unexpected tag value counts: %s", i, s)
+		}
+		s.Restart()
+	}
+
+	// Writing a removed series must create a new series.
+	s.MustWriteSeries("foo", "raw", "cpu", map[string]string{"host": "serverb"}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(2)})
+	if ids := s.MeasurementSeriesIDs("foo", "cpu"); !ids.Equals(influxdb.SeriesIDs{1, 4}) {
+		t.Fatalf("unexpected series ids: %v", ids)
+	}
+}
+
+// Ensure the server can export its metadata and import it into a new server.
+func TestServer_ExportImportMetadata(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDataNode(MustParseURL("http://localhost:1000"))
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "tmp", Duration: 2 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.CreateUser("susy", "pass", true)
+	s.MustWriteSeries("foo", "raw", "cpu", map[string]string{"host": "servera"}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(1)})
+	s.MustWriteSeries("foo", "raw", "mem", map[string]string{"host": "servera"}, "2000-01-01T00:00:00Z", map[string]interface{}{"free": float64(1), "used": float64(1)})
+	s.MustWriteSeries("foo", "raw", "cpu", map[string]string{"host": "serverb"}, "2000-01-01T01:00:00Z", map[string]interface{}{"value": float64(1)})
+	s.UpdateMeasurement("foo", "cpu", &influxdb.MeasurementUpdate{Owner: stringptr("ops")})
+	s.UpdateMeasurement("foo", "mem", &influxdb.MeasurementUpdate{Field: "used", Unit: stringptr("bytes")})
+	if err := s.Sync(c.index); err != nil {
+		t.Fatalf("sync error: %s", err)
+	}
+	if md, _ := s.ExportMetadata(); md.Databases[0].Measurements[0].Owner != "ops" {
+		t.Fatalf("unexpected measurement metadata: %#v", md.Databases[0].Measurements[0])
+	}
+
+	// Import the metadata into a new server.
+	md, err := s.ExportMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := OpenServer(NewMessagingClient())
+	defer other.Close()
+	if err := other.ImportMetadata(md); err != nil {
+		t.Fatal(err)
+	}
+
+	// Verify the metadata matches except for the new shard ids.
+	exp := mustMarshalMetadataWithoutShardIDs(md)
+	md, err = other.ExportMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := mustMarshalMetadataWithoutShardIDs(md); got != exp {
+		t.Fatalf("unexpected metadata:\n\nexp=%s\n\ngot=%s", exp, got)
+	}
+	if _, err := other.Authenticate("susy", "pass"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// Verify the metadata can only be imported once.
+	if err := other.ImportMetadata(md); err != influxdb.ErrUserExists {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	md.Version++
+	if err := other.ImportMetadata(md); err != influxdb.ErrMetadataVersionUnsupported {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+// mustMarshalMetadataWithoutShardIDs encodes metadata to JSON after clearing
+// the shard ids. Panic on error.
+func mustMarshalMetadataWithoutShardIDs(md *influxdb.Metadata) string {
+	for _, db := range md.Databases {
+		for _, rp := range db.Policies {
+			for _, sh := range rp.Shards {
+				sh.ID = 0
+			}
+		}
+	}
+	return mustMarshalJSON(md)
+}
+
 func mustMarshalJSON(v interface{}) string {
 	b, err := json.Marshal(v)
 	if err != nil {
@@ -637,6 +1832,13 @@ func (s *Server) Restart() {
 	}
 }
 
+// MustWriteSeries writes series data to the server. Panic on error.
+func (s *Server) MustWriteSeries(database, retentionPolicy, name string, tags map[string]string, timestamp string, values map[string]interface{}) {
+	if err := s.WriteSeries(database, retentionPolicy, name, tags, mustParseTime(timestamp), values); err != nil {
+		panic(err.Error())
+	}
+}
+
 // Close shuts down the server and removes all temporary files.
 func (s *Server) Close() {
 	defer os.RemoveAll(s.Path())
@@ -694,6 +1896,15 @@ func mustParseTime(s string) time.Time {
 	return t
 }
 
+// mustParseQuery parses an InfluxQL query. Panic on error.
+func mustParseQuery(s string) *influxql.Query {
+	q, err := influxql.NewParser(strings.NewReader(s)).ParseQuery()
+	if err != nil {
+		panic(err.Error())
+	}
+	return q
+}
+
 // errstr is an ease-of-use function to convert an error to a string.
 func errstr(err error) string {
 	if err != nil {
@@ -702,5 +1913,8 @@ func errstr(err error) string {
 	return ""
 }
 
+// stringptr returns a pointer to s.
+func stringptr(s string) *string { return &s }
+
 func warn(v ...interface{})              { fmt.Fprintln(os.Stderr, v...) }
 func warnf(msg string, v ...interface{}) { fmt.Fprintf(os.Stderr, msg+"\n", v...) }
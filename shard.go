@@ -16,8 +16,11 @@ type Shard struct {
 	StartTime time.Time `json:"startTime,omitempty"`
 	EndTime   time.Time `json:"endTime,omitempty"`
 
-	replicaN    []uint64 // replication factor
-	dataNodeIDs []uint64 // owner nodes
+	// Data nodes which own a replica of the shard, in order of preference.
+	// If empty then the shard is stored on every node.
+	DataNodeIDs []uint64 `json:"dataNodeIDs,omitempty"`
+
+	replicaN []uint64 // replication factor
 
 	store *bolt.DB
 }
@@ -25,6 +28,19 @@ type Shard struct {
 // newShard returns a new initialized Shard instance.
 func newShard() *Shard { return &Shard{} }
 
+// HasDataNodeID returns true if the shard is stored on a data node.
+func (s *Shard) HasDataNodeID(id uint64) bool {
+	if len(s.DataNodeIDs) == 0 {
+		return true
+	}
+	for _, other := range s.DataNodeIDs {
+		if other == id {
+			return true
+		}
+	}
+	return false
+}
+
 // Duration returns the duration between the shard's start and end time.
 func (s *Shard) Duration() time.Duration { return s.EndTime.Sub(s.StartTime) }
 
@@ -61,21 +77,127 @@ func (s *Shard) init() error {
 
 // close shuts down the shard's store.
 func (s *Shard) close() error {
-	return s.store.Close()
+	if s.store == nil {
+		return nil
+	}
+	err := s.store.Close()
+	s.store = nil
+	return err
 }
 
 // writeSeries writes series data to a shard.
+// Points are stored in a bucket per series and keyed by timestamp.
 func (s *Shard) writeSeries(overwrite bool, data []byte) error {
-	id, timestamp, values, err := unmarshalPoint(data)
+	id, timestamp, _, err := unmarshalPoint(data)
+	if err != nil {
+		return err
+	}
+
+	return s.store.Update(func(tx *bolt.Tx) error {
+		b, err := tx.Bucket([]byte("values")).CreateBucketIfNotExists(u32tob(id))
+		if err != nil {
+			return err
+		}
+
+		// Ignore the point if it already exists and overwrites are disabled.
+		key := u64tob(uint64(timestamp.UnixNano()))
+		if !overwrite && b.Get(key) != nil {
+			return nil
+		}
+
+		return b.Put(key, data[12:])
+	})
+}
+
+// writeSeriesBatch writes a batch of encoded points to the shard in a single transaction.
+func (s *Shard) writeSeriesBatch(overwrite bool, data []byte) error {
+	points, err := unmarshalPoints(data)
 	if err != nil {
 		return err
 	}
 
-	// TODO: make this work
-	fmt.Println("writeSeries: ", id, timestamp, values)
 	return s.store.Update(func(tx *bolt.Tx) error {
-		return nil // TODO
+		for _, p := range points {
+			id, timestamp, _, err := unmarshalPoint(p)
+			if err != nil {
+				return err
+			}
+
+			b, err := tx.Bucket([]byte("values")).CreateBucketIfNotExists(u32tob(id))
+			if err != nil {
+				return err
+			}
+
+			// Ignore the point if it already exists and overwrites are disabled.
+			key := u64tob(uint64(timestamp.UnixNano()))
+			if !overwrite && b.Get(key) != nil {
+				continue
+			}
+
+			if err := b.Put(key, p[12:]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// shardCursor represents a cursor over the points of a single series in a shard.
+// The cursor holds a read-only transaction open until it is closed.
+type shardCursor struct {
+	tx  *bolt.Tx
+	cur *bolt.Cursor
+}
+
+// cursor returns a cursor for a series in the shard.
+// Returns nil if the series has no data in the shard.
+func (s *Shard) cursor(seriesID uint32) (*shardCursor, error) {
+	tx, err := s.store.Begin(false)
+	if err != nil {
+		return nil, err
+	}
+
+	// Find the bucket for the series.
+	b := tx.Bucket([]byte("values")).Bucket(u32tob(seriesID))
+	if b == nil {
+		_ = tx.Rollback()
+		return nil, nil
+	}
+
+	return &shardCursor{tx: tx, cur: b.Cursor()}, nil
+}
+
+// seek moves the cursor to a given timestamp and returns the point at or after it.
+// Returns a zero timestamp and nil data when there are no more points.
+func (c *shardCursor) seek(timestamp int64) (int64, []byte) {
+	return c.point(c.cur.Seek(u64tob(uint64(timestamp))))
+}
+
+// next moves the cursor to the next point.
+// Returns a zero timestamp and nil data when there are no more points.
+func (c *shardCursor) next() (int64, []byte) { return c.point(c.cur.Next()) }
+
+// point converts a bolt key/value pair to a timestamp and encoded values.
+func (c *shardCursor) point(k, v []byte) (int64, []byte) {
+	if k == nil {
+		return 0, nil
+	}
+	return int64(btou64(k)), v
+}
+
+// close releases the cursor's transaction.
+func (c *shardCursor) close() error { return c.tx.Rollback() }
+
+// seriesIDs returns the ids of the series with points in the shard.
+func (s *Shard) seriesIDs() (*SeriesIDSet, error) {
+	ids := NewSeriesIDSet()
+	err := s.store.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("values")).ForEach(func(k, _ []byte) error {
+			ids.Add(btou32(k))
+			return nil
+		})
 	})
+	return ids, err
 }
 
 func (s *Shard) deleteSeries(name string) error {
@@ -85,6 +207,10 @@ func (s *Shard) deleteSeries(name string) error {
 // Shards represents a list of shards.
 type Shards []*Shard
 
+func (p Shards) Len() int           { return len(p) }
+func (p Shards) Less(i, j int) bool { return p[i].StartTime.Before(p[j].StartTime) }
+func (p Shards) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
 // IDs returns a slice of all shard ids.
 func (p Shards) IDs() []uint64 {
 	ids := make([]uint64, len(p))
@@ -115,3 +241,40 @@ func unmarshalPoint(data []byte) (uint32, time.Time, map[string]interface{}, err
 	err := json.Unmarshal(data[12:], &v)
 	return id, timestamp, v, err
 }
+
+// unmarshalPointSeriesID returns the series id of an encoded point.
+func unmarshalPointSeriesID(data []byte) uint32 {
+	return *(*uint32)(unsafe.Pointer(&data[0]))
+}
+
+// unmarshalPointTimestamp returns the timestamp of an encoded point.
+func unmarshalPointTimestamp(data []byte) time.Time {
+	return time.Unix(0, *(*int64)(unsafe.Pointer(&data[4])))
+}
+
+// marshalPoints encodes a batch of encoded points. Each point is prefixed by its length.
+func marshalPoints(points [][]byte) []byte {
+	var b []byte
+	for _, p := range points {
+		b = append(b, u32tob(uint32(len(p)))...)
+		b = append(b, p...)
+	}
+	return b
+}
+
+// unmarshalPoints decodes a batch of points encoded by marshalPoints.
+func unmarshalPoints(data []byte) ([][]byte, error) {
+	var points [][]byte
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return nil, errors.New("point batch too short")
+		}
+		n := int(btou32(data[0:4]))
+		if len(data) < 4+n || n < 12 {
+			return nil, errors.New("point batch truncated")
+		}
+		points = append(points, data[4:4+n])
+		data = data[4+n:]
+	}
+	return points, nil
+}
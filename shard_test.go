@@ -0,0 +1,157 @@
+package influxdb
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// Ensure a shard can write points and read them back in time order.
+func TestShard_WriteSeries(t *testing.T) {
+	sh := mustOpenShard()
+	defer mustCloseShard(sh)
+
+	// Write points out of order.
+	mustWriteShardPoint(sh, true, 1, "2000-01-01T00:00:10Z", map[string]interface{}{"value": float64(20)})
+	mustWriteShardPoint(sh, true, 1, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(10)})
+	mustWriteShardPoint(sh, true, 2, "2000-01-01T00:00:05Z", map[string]interface{}{"value": float64(100)})
+
+	// Read points for the first series.
+	cur, err := sh.cursor(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cur.close()
+
+	if timestamp, data := cur.seek(0); timestamp != mustParseShardTime("2000-01-01T00:00:00Z") {
+		t.Fatalf("unexpected timestamp(0): %d", timestamp)
+	} else if string(data) != `{"value":10}` {
+		t.Fatalf("unexpected data(0): %s", data)
+	}
+	if timestamp, data := cur.next(); timestamp != mustParseShardTime("2000-01-01T00:00:10Z") {
+		t.Fatalf("unexpected timestamp(1): %d", timestamp)
+	} else if string(data) != `{"value":20}` {
+		t.Fatalf("unexpected data(1): %s", data)
+	}
+	if _, data := cur.next(); data != nil {
+		t.Fatalf("unexpected data(2): %s", data)
+	}
+}
+
+// Ensure a shard only overwrites existing points when requested.
+func TestShard_WriteSeries_Overwrite(t *testing.T) {
+	sh := mustOpenShard()
+	defer mustCloseShard(sh)
+
+	mustWriteShardPoint(sh, true, 1, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(10)})
+	mustWriteShardPoint(sh, false, 1, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(20)})
+	if s := mustReadShardPoint(sh, 1); s != `{"value":10}` {
+		t.Fatalf("unexpected data: %s", s)
+	}
+
+	mustWriteShardPoint(sh, true, 1, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(30)})
+	if s := mustReadShardPoint(sh, 1); s != `{"value":30}` {
+		t.Fatalf("unexpected data: %s", s)
+	}
+}
+
+// Ensure a shard can write a batch of points to multiple series.
+func TestShard_WriteSeriesBatch(t *testing.T) {
+	sh := mustOpenShard()
+	defer mustCloseShard(sh)
+
+	var points [][]byte
+	for _, p := range []struct {
+		seriesID uint32
+		values   map[string]interface{}
+	}{
+		{1, map[string]interface{}{"value": float64(10)}},
+		{2, map[string]interface{}{"value": float64(20)}},
+	} {
+		data, err := marshalPoint(p.seriesID, time.Unix(0, mustParseShardTime("2000-01-01T00:00:00Z")), p.values)
+		if err != nil {
+			t.Fatal(err)
+		}
+		points = append(points, data)
+	}
+
+	if err := sh.writeSeriesBatch(true, marshalPoints(points)); err != nil {
+		t.Fatal(err)
+	} else if s := mustReadShardPoint(sh, 1); s != `{"value":10}` {
+		t.Fatalf("unexpected data(1): %s", s)
+	} else if s := mustReadShardPoint(sh, 2); s != `{"value":20}` {
+		t.Fatalf("unexpected data(2): %s", s)
+	}
+
+	// A truncated batch should return an error.
+	if err := sh.writeSeriesBatch(true, marshalPoints(points)[:20]); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+// Ensure a shard returns a nil cursor for a series without data.
+func TestShard_Cursor_SeriesNotFound(t *testing.T) {
+	sh := mustOpenShard()
+	defer mustCloseShard(sh)
+
+	if cur, err := sh.cursor(100); err != nil {
+		t.Fatal(err)
+	} else if cur != nil {
+		t.Fatal("expected nil cursor")
+	}
+}
+
+// mustOpenShard opens a shard in a temporary file. Panic on error.
+func mustOpenShard() *Shard {
+	f, err := ioutil.TempFile("", "influxdb-shard-")
+	if err != nil {
+		panic(err.Error())
+	}
+	f.Close()
+	os.Remove(f.Name())
+
+	sh := newShard()
+	if err := sh.open(f.Name()); err != nil {
+		panic(err.Error())
+	}
+	return sh
+}
+
+// mustCloseShard closes a shard and removes its file.
+func mustCloseShard(sh *Shard) {
+	path := sh.store.Path()
+	_ = sh.close()
+	os.Remove(path)
+}
+
+// mustWriteShardPoint encodes and writes a point to a shard. Panic on error.
+func mustWriteShardPoint(sh *Shard, overwrite bool, seriesID uint32, timestamp string, values map[string]interface{}) {
+	data, err := marshalPoint(seriesID, time.Unix(0, mustParseShardTime(timestamp)), values)
+	if err != nil {
+		panic(err.Error())
+	}
+	if err := sh.writeSeries(overwrite, data); err != nil {
+		panic(err.Error())
+	}
+}
+
+// mustReadShardPoint returns the encoded values of the first point of a series.
+func mustReadShardPoint(sh *Shard, seriesID uint32) string {
+	cur, err := sh.cursor(seriesID)
+	if err != nil {
+		panic(err.Error())
+	}
+	defer cur.close()
+	_, data := cur.seek(0)
+	return string(data)
+}
+
+// mustParseShardTime parses an RFC3339 time and returns it in nanoseconds. Panic on error.
+func mustParseShardTime(s string) int64 {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		panic(err.Error())
+	}
+	return t.UnixNano()
+}